@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"fmt"
+	"github.com/shawnfeng/sutil/cache/codec"
 	"github.com/shawnfeng/sutil/cache/constants"
 	"github.com/shawnfeng/sutil/cache/redis"
 	"github.com/shawnfeng/sutil/slog/slog"
@@ -25,6 +26,8 @@ type Cache struct {
 	prefix        string
 	withNamespace bool
 	namespace     string
+	// 为nil时使用 CacheData 的 Marshal 和 Unmarshal
+	codec *codec.Codec
 }
 
 // redis 地址列表，key前缀，过期时间
@@ -74,10 +77,30 @@ func NewCacheByNamespace(ctx context.Context, namespace, prefix string, expire i
 	}, err
 }
 
+// SetCodec 写入缓存时使用 c 序列化 CacheData，读取时根据编码头自动识别，没有编码头的数据使用 CacheData.Unmarshal
+func (m *Cache) SetCodec(c *codec.Codec) {
+	m.codec = c
+}
+
+func (m *Cache) marshal(data CacheData) ([]byte, error) {
+	if m.codec != nil {
+		return m.codec.Marshal(data)
+	}
+	return data.Marshal()
+}
+
+func (m *Cache) unmarshal(sdata []byte, data CacheData) error {
+	// NOTE: CacheData 可能是二进制格式，开头恰好和编码头相同时按照编码头解码失败后再使用 CacheData.Unmarshal
+	if codec.HasHeader(sdata) && codec.Unmarshal(sdata, data) == nil {
+		return nil
+	}
+	return data.Unmarshal(sdata)
+}
+
 func (m *Cache) setData(key string, data CacheData) error {
 	fun := "Cache.setData -->"
 	expire := time.Duration(m.expire) * time.Second
-	sdata, merr := data.Marshal()
+	sdata, merr := m.marshal(data)
 	if merr != nil {
 		sdata = []byte(merr.Error())
 		merr = fmt.Errorf("%s marshal err, cache key:%s err:%s", fun, key, merr)
@@ -120,7 +143,7 @@ func (m *Cache) getData(key string, data CacheData) error {
 		return err
 	}
 
-	err = data.Unmarshal(sdata)
+	err = m.unmarshal(sdata, data)
 	if err != nil {
 		return fmt.Errorf("reply data unmarshal err:%s", err)
 	}
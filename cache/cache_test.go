@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/shawnfeng/sutil/cache/codec"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -88,3 +89,36 @@ func TestCache_Get(t *testing.T) {
 	//}
 	//assert.Equal(t, 1, test.ID)
 }
+
+type rawData struct {
+	data []byte
+}
+
+func (p *rawData) Marshal() ([]byte, error) {
+	return p.data, nil
+}
+
+func (p *rawData) Unmarshal(data []byte) error {
+	p.data = append([]byte(nil), data...)
+	return nil
+}
+
+func (p *rawData) Load(key string) error {
+	return nil
+}
+
+func TestCache_Unmarshal(t *testing.T) {
+	c := &Cache{}
+	// 第一个字节 >= 0x80 的二进制数据不能当成编码头
+	raw := []byte{0x82, 0x01, 0x04, 't', 'e', 's', 't'}
+	var got rawData
+	assert.NoError(t, c.unmarshal(raw, &got))
+	assert.Equal(t, raw, got.data)
+
+	c.SetCodec(codec.Msgpack)
+	data, err := c.marshal(&Test{ID: 2})
+	assert.NoError(t, err)
+	var v Test
+	assert.NoError(t, c.unmarshal(data, &v))
+	assert.Equal(t, 2, v.ID)
+}
@@ -0,0 +1,234 @@
+// Package codec 缓存数据的序列化方式，序列化后的数据前三个字节为编码头，
+// 记录序列化格式和压缩方式，读取时根据编码头解码，切换 Codec 时不需要清空缓存
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	ucodec "github.com/ugorji/go/codec"
+)
+
+type Format byte
+
+const (
+	FormatJSON Format = iota + 1
+	FormatProtobuf
+	FormatMsgpack
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatProtobuf:
+		return "protobuf"
+	case FormatMsgpack:
+		return "msgpack"
+	default:
+		return "unknown"
+	}
+}
+
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionSnappy
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionSnappy:
+		return "snappy"
+	default:
+		return "unknown"
+	}
+}
+
+// 编码头: 魔数 0xc5，版本号，第三个字节的4-6位为压缩方式，0-3位为序列化格式
+// json 等文本格式的第一个字节不会是 0x80-0xff，protobuf 等二进制格式的前两个字节
+// 同时和魔数、版本号相同并且格式合法的概率很低，可以和没有编码头的旧数据区分
+const (
+	headerMagic           = 0xc5
+	headerVersion         = 1
+	headerLen             = 3
+	headerCompressionMask = 0x70
+	headerFormatMask      = 0x0f
+)
+
+var (
+	ErrNoHeader           = errors.New("codec: data has no header")
+	ErrNotProtoMessage    = errors.New("codec: value is not proto.Message")
+	msgpackHandle         = &ucodec.MsgpackHandle{}
+	errUnknownFormat      = errors.New("codec: unknown format")
+	errUnknownCompression = errors.New("codec: unknown compression")
+)
+
+// Codec 序列化格式和压缩方式的组合
+type Codec struct {
+	format      Format
+	compression Compression
+}
+
+var (
+	JSON     = &Codec{format: FormatJSON}
+	Protobuf = &Codec{format: FormatProtobuf}
+	Msgpack  = &Codec{format: FormatMsgpack}
+)
+
+// Gzip 使用 gzip 压缩 c 序列化后的数据
+func Gzip(c *Codec) *Codec {
+	return &Codec{format: c.format, compression: CompressionGzip}
+}
+
+// Snappy 使用 snappy 压缩 c 序列化后的数据
+func Snappy(c *Codec) *Codec {
+	return &Codec{format: c.format, compression: CompressionSnappy}
+}
+
+func (c *Codec) Format() Format {
+	return c.format
+}
+
+func (c *Codec) Compression() Compression {
+	return c.compression
+}
+
+func (c *Codec) String() string {
+	if c.compression == CompressionNone {
+		return c.format.String()
+	}
+	return fmt.Sprintf("%s+%s", c.format, c.compression)
+}
+
+func (c *Codec) header() []byte {
+	return []byte{headerMagic, headerVersion, byte(c.compression)<<4 | byte(c.format)}
+}
+
+// Marshal 序列化 v，返回带编码头的数据
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	data, err := marshal(c.format, v)
+	if err != nil {
+		return nil, err
+	}
+	data, err = compress(c.compression, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(c.header(), data...), nil
+}
+
+// HasHeader data 是否由 Codec 序列化，要求魔数、版本号匹配并且序列化格式和压缩方式都是已知的
+func HasHeader(data []byte) bool {
+	if len(data) < headerLen || data[0] != headerMagic || data[1] != headerVersion {
+		return false
+	}
+	b := data[2]
+	if b&^(headerCompressionMask|headerFormatMask) != 0 {
+		return false
+	}
+	format := Format(b & headerFormatMask)
+	compression := Compression((b & headerCompressionMask) >> 4)
+	return format >= FormatJSON && format <= FormatMsgpack && compression <= CompressionSnappy
+}
+
+// Unmarshal 根据编码头反序列化 data 到 v，与当前使用的 Codec 无关，没有编码头时返回 ErrNoHeader
+func Unmarshal(data []byte, v interface{}) error {
+	if !HasHeader(data) {
+		return ErrNoHeader
+	}
+	format := Format(data[2] & headerFormatMask)
+	compression := Compression((data[2] & headerCompressionMask) >> 4)
+	payload, err := decompress(compression, data[headerLen:])
+	if err != nil {
+		return err
+	}
+	return unmarshal(format, payload, v)
+}
+
+func marshal(format Format, v interface{}) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		return json.Marshal(v)
+	case FormatProtobuf:
+		msg, ok := v.(proto.Message)
+		if !ok {
+			return nil, ErrNotProtoMessage
+		}
+		return proto.Marshal(msg)
+	case FormatMsgpack:
+		var data []byte
+		err := ucodec.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+		return data, err
+	default:
+		return nil, errUnknownFormat
+	}
+}
+
+func unmarshal(format Format, data []byte, v interface{}) error {
+	switch format {
+	case FormatJSON:
+		return json.Unmarshal(data, v)
+	case FormatProtobuf:
+		msg, ok := v.(proto.Message)
+		if !ok {
+			return ErrNotProtoMessage
+		}
+		return proto.Unmarshal(data, msg)
+	case FormatMsgpack:
+		return ucodec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+	default:
+		return errUnknownFormat
+	}
+}
+
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, errUnknownCompression
+	}
+}
+
+func decompress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	default:
+		return nil, errUnknownCompression
+	}
+}
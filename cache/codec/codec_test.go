@@ -0,0 +1,58 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+)
+
+type testValue struct {
+	ID   int64  `json:"id" codec:"id"`
+	Name string `json:"name" codec:"name"`
+}
+
+func TestCodec(t *testing.T) {
+	value := &testValue{ID: 1, Name: "test"}
+	for _, c := range []*Codec{JSON, Msgpack, Gzip(JSON), Snappy(JSON), Gzip(Msgpack), Snappy(Msgpack)} {
+		data, err := c.Marshal(value)
+		assert.NoError(t, err, c.String())
+		assert.True(t, HasHeader(data), c.String())
+
+		var got testValue
+		err = Unmarshal(data, &got)
+		assert.NoError(t, err, c.String())
+		assert.Equal(t, *value, got, c.String())
+	}
+}
+
+func TestCodecProtobuf(t *testing.T) {
+	value := &wrappers.StringValue{Value: "test"}
+	for _, c := range []*Codec{Protobuf, Snappy(Protobuf)} {
+		data, err := c.Marshal(value)
+		assert.NoError(t, err)
+
+		var got wrappers.StringValue
+		err = Unmarshal(data, &got)
+		assert.NoError(t, err)
+		assert.True(t, proto.Equal(value, &got))
+	}
+
+	_, err := Protobuf.Marshal(&testValue{})
+	assert.Equal(t, ErrNotProtoMessage, err)
+}
+
+func TestUnmarshalNoHeader(t *testing.T) {
+	assert.False(t, HasHeader([]byte(`{"id":1}`)))
+	// 第一个字节 >= 0x80 的 protobuf 数据不是编码头
+	raw, err := proto.Marshal(&wrappers.StringValue{Value: "test"})
+	assert.NoError(t, err)
+	assert.False(t, HasHeader(append([]byte{0x82, 0x01}, raw...)))
+	assert.False(t, HasHeader([]byte{headerMagic, headerVersion}))
+	assert.False(t, HasHeader([]byte{headerMagic, headerVersion, byte(FormatMsgpack + 1)}))
+	assert.False(t, HasHeader([]byte{headerMagic, headerVersion + 1, byte(FormatJSON)}))
+
+	var got testValue
+	assert.Equal(t, ErrNoHeader, Unmarshal([]byte(`{"id":1}`), &got))
+}
@@ -38,6 +38,8 @@ const (
 	ConfigerTypeSimple ConfigerType = iota
 	ConfigerTypeEtcd
 	ConfigerTypeApollo
+	// ConfigerTypeMemory 使用进程内的内存 redis，用于单元测试
+	ConfigerTypeMemory
 )
 
 func (c ConfigerType) String() string {
@@ -48,6 +50,8 @@ func (c ConfigerType) String() string {
 		return "etcd"
 	case ConfigerTypeApollo:
 		return "apollo"
+	case ConfigerTypeMemory:
+		return "memory"
 	default:
 		return "unkown"
 	}
@@ -0,0 +1,209 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+const clusterSlots = 16384
+
+// newRedisClient 根据部署方式创建 go-redis 客户端
+func newRedisClient(config *Config) redis.UniversalClient {
+	switch config.getMode() {
+	case ModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    config.masterName,
+			SentinelAddrs: config.getAddrs(),
+			DialTimeout:   3 * config.timeout,
+			ReadTimeout:   config.timeout,
+			WriteTimeout:  config.timeout,
+			PoolSize:      config.poolSize,
+			PoolTimeout:   2 * config.timeout,
+		})
+	case ModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        config.getAddrs(),
+			DialTimeout:  3 * config.timeout,
+			ReadTimeout:  config.timeout,
+			WriteTimeout: config.timeout,
+			PoolSize:     config.poolSize,
+			PoolTimeout:  2 * config.timeout,
+		})
+	case ModeSharded:
+		// NOTE: 第一个分片，其他分片由 newShardSet 创建
+		_, addr := parseShard(config.getAddrs()[0])
+		sconfig := *config
+		sconfig.mode = ModeSingle
+		sconfig.addr = addr
+		return newRedisClient(&sconfig)
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         config.addr,
+			DialTimeout:  3 * config.timeout,
+			ReadTimeout:  config.timeout,
+			WriteTimeout: config.timeout,
+			PoolSize:     config.poolSize,
+			PoolTimeout:  2 * config.timeout,
+		})
+	}
+}
+
+// HashTag 为 key 加上 hash tag，cluster 模式下 tag 相同的 key 在同一个 slot 中，可以用于多 key 命令、事务和脚本
+// key 的前缀中不包含 {}，不会影响 hash tag
+func HashTag(tag, key string) string {
+	return "{" + tag + "}" + key
+}
+
+// hashTagOf 返回 key 中用于计算 slot 的部分
+func hashTagOf(key string) string {
+	s := strings.IndexByte(key, '{')
+	if s < 0 {
+		return key
+	}
+	e := strings.IndexByte(key[s+1:], '}')
+	if e <= 0 {
+		return key
+	}
+	return key[s+1 : s+1+e]
+}
+
+// Slot 计算 key 在 cluster 中的 slot
+func Slot(key string) int {
+	return int(crc16(hashTagOf(key)) % clusterSlots)
+}
+
+var crc16Table = func() (table [256]uint16) {
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+// crc16 CRC16-CCITT(XMODEM)，与 redis cluster 一致
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+func (m *Client) isCluster() bool {
+	_, ok := m.client.(*redis.ClusterClient)
+	return ok
+}
+
+func firstKey(keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
+
+// groupKeys 按照 fn 的结果对 key 分组，返回每组 key 在原始列表中的下标
+func groupKeys(keys []string, fn func(key string) int) [][]int {
+	var groups [][]int
+	index := make(map[int]int)
+	for i, key := range keys {
+		g := fn(key)
+		gi, ok := index[g]
+		if !ok {
+			gi = len(groups)
+			index[g] = gi
+			groups = append(groups, nil)
+		}
+		groups[gi] = append(groups[gi], i)
+	}
+	return groups
+}
+
+// groupBySlot 按照 slot 对已经加上前缀的 key 分组
+func groupBySlot(keys []string) [][]int {
+	return groupKeys(keys, Slot)
+}
+
+// splitKeys 多 key 命令在 cluster 模式下按照 slot 拆分，在分片模式下按照分片拆分
+func (m *Client) splitKeys(fixKeys []string) ([][]int, bool) {
+	if m.shards != nil {
+		return groupKeys(fixKeys, m.shards.ring.index), true
+	}
+	if m.isCluster() {
+		return groupBySlot(fixKeys), true
+	}
+	return nil, false
+}
+
+// checkSameShard 不能拆分的多 key 命令在 key 不在同一个分片或者 slot 中时返回错误
+func (m *Client) checkSameShard(op string, fixKeys []string) error {
+	if groups, ok := m.splitKeys(fixKeys); ok && len(groups) > 1 {
+		return fmt.Errorf("redis: %s keys %v in different shards or slots", op, fixKeys)
+	}
+	return nil
+}
+
+func pickKeys(keys []string, group []int) []string {
+	picked := make([]string, len(group))
+	for i, idx := range group {
+		picked[i] = keys[idx]
+	}
+	return picked
+}
+
+// splitMGet 拆分执行 MGET，结果顺序与 keys 一致
+func (m *Client) splitMGet(ctx context.Context, fixKeys []string, groups [][]int) *redis.SliceCmd {
+	vals := make([]interface{}, len(fixKeys))
+	for _, group := range groups {
+		groupKeys := pickKeys(fixKeys, group)
+		r, err := m.route(groupKeys[0]).MGet(groupKeys...).Result()
+		if err != nil {
+			return redis.NewSliceResult(nil, err)
+		}
+		for i, idx := range group {
+			vals[idx] = r[i]
+		}
+	}
+	return redis.NewSliceResult(vals, nil)
+}
+
+// splitMSet 拆分执行 MSET，不同的组之间不保证原子性
+func (m *Client) splitMSet(ctx context.Context, fixPairs []interface{}, groups [][]int) *redis.StatusCmd {
+	var status string
+	for _, group := range groups {
+		groupPairs := make([]interface{}, 0, 2*len(group))
+		for _, idx := range group {
+			groupPairs = append(groupPairs, fixPairs[2*idx], fixPairs[2*idx+1])
+		}
+		key, _ := groupPairs[0].(string)
+		r, err := m.route(key).MSet(groupPairs...).Result()
+		if err != nil {
+			return redis.NewStatusResult("", err)
+		}
+		status = r
+	}
+	return redis.NewStatusResult(status, nil)
+}
+
+// splitDel 拆分执行 DEL，返回删除的总数
+func (m *Client) splitDel(ctx context.Context, fixKeys []string, groups [][]int) *redis.IntCmd {
+	var n int64
+	for _, group := range groups {
+		groupKeys := pickKeys(fixKeys, group)
+		r, err := m.route(groupKeys[0]).Del(groupKeys...).Result()
+		if err != nil {
+			return redis.NewIntResult(n, err)
+		}
+		n += r
+	}
+	return redis.NewIntResult(n, nil)
+}
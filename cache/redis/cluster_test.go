@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlot(t *testing.T) {
+	assert.Equal(t, 12182, Slot("foo"))
+	assert.Equal(t, 12739, Slot("123456789"))
+	assert.Equal(t, Slot("user1000"), Slot("base/report.{user1000}.following"))
+	assert.Equal(t, Slot("foo{}{bar}"), Slot("foo{}{bar}"))
+	assert.Equal(t, Slot("{user1000}"), Slot(HashTag("user1000", ".followers")))
+}
+
+func TestGroupBySlot(t *testing.T) {
+	keys := []string{"{a}.1", "{b}.1", "{a}.2"}
+	assert.Equal(t, [][]int{{0, 2}, {1}}, groupBySlot(keys))
+}
+
+func TestPipelineSplitBySlot(t *testing.T) {
+	ctx := context.Background()
+	client, closer := newMemoryTestClient(t)
+	defer closer()
+
+	// cluster 模式下多 key 命令按照 slot 拆分为多个子命令，Exec 后合并为一个结果
+	p := &Pipeline{namespace: "test/cluster", pipeline: client.Pipeline(), opts: &options{}, cluster: true}
+	set := p.MSet(ctx, "{a}.1", "1", "{b}.1", "2", "{a}.2", "3")
+	get := p.Get(ctx, "{b}.1")
+	mget := p.MGet(ctx, "{a}.1", "{b}.1", "{c}.1", "{a}.2")
+	del := p.Del(ctx, "{a}.1", "{b}.1")
+	cmds, err := p.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.Cmder{set, get, mget, del}, cmds)
+	assert.Equal(t, "OK", set.Val())
+	assert.Equal(t, "2", get.Val())
+	assert.Equal(t, []interface{}{"1", "2", nil, "3"}, mget.Val())
+	assert.Equal(t, int64(2), del.Val())
+	assert.Equal(t, 0, len(p.order))
+}
+
+func TestConfigCheck(t *testing.T) {
+	assert.NoError(t, (&Config{addr: "127.0.0.1:6379"}).check())
+	assert.Error(t, (&Config{}).check())
+	assert.Error(t, (&Config{mode: ModeSentinel, addr: "127.0.0.1:26379"}).check())
+	assert.NoError(t, (&Config{mode: ModeSentinel, masterName: "mymaster", addrs: splitAddrs("127.0.0.1:26379, 127.0.0.1:26380")}).check())
+	assert.NoError(t, (&Config{mode: ModeCluster, addr: "127.0.0.1:7000,127.0.0.1:7001"}).check())
+	assert.Equal(t, []string{"127.0.0.1:7000", "127.0.0.1:7001"}, (&Config{mode: ModeCluster, addr: "127.0.0.1:7000,127.0.0.1:7001"}).getAddrs())
+	assert.Error(t, (&Config{mode: "unknown", addr: "127.0.0.1:6379"}).check())
+}
@@ -0,0 +1,393 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+// GeoSearchQuery GEOSEARCH 的参数，需要 redis 6.2 以上版本
+type GeoSearchQuery struct {
+	// 以 Member 为中心搜索，为空时以 Longitude, Latitude 为中心
+	Member              string
+	Longitude, Latitude float64
+	// Radius 大于0时按照半径搜索，否则按照 BoxWidth, BoxHeight 的矩形搜索
+	Radius              float64
+	BoxWidth, BoxHeight float64
+	// m, km, ft, mi，默认为 km
+	Unit      string
+	WithCoord bool
+	WithDist  bool
+	WithHash  bool
+	Count     int
+	// Count 大于0时有效，找到 Count 个结果后立即返回
+	Any bool
+	// ASC 或者 DESC，默认不排序
+	Sort string
+}
+
+func (q *GeoSearchQuery) args(key string) []interface{} {
+	args := []interface{}{"geosearch", key}
+	if len(q.Member) > 0 {
+		args = append(args, "frommember", q.Member)
+	} else {
+		args = append(args, "fromlonlat", q.Longitude, q.Latitude)
+	}
+	unit := q.Unit
+	if len(unit) == 0 {
+		unit = "km"
+	}
+	if q.Radius > 0 {
+		args = append(args, "byradius", q.Radius, unit)
+	} else {
+		args = append(args, "bybox", q.BoxWidth, q.BoxHeight, unit)
+	}
+	if len(q.Sort) > 0 {
+		args = append(args, q.Sort)
+	}
+	if q.Count > 0 {
+		args = append(args, "count", q.Count)
+		if q.Any {
+			args = append(args, "any")
+		}
+	}
+	if q.WithCoord {
+		args = append(args, "withcoord")
+	}
+	if q.WithDist {
+		args = append(args, "withdist")
+	}
+	if q.WithHash {
+		args = append(args, "withhash")
+	}
+	return args
+}
+
+// GeoSearchCmd go-redis v6 没有 GEOSEARCH，使用通用命令执行并解析结果
+type GeoSearchCmd struct {
+	*redis.Cmd
+	q *GeoSearchQuery
+}
+
+func newGeoSearchCmd(key string, q *GeoSearchQuery) *GeoSearchCmd {
+	return &GeoSearchCmd{Cmd: redis.NewCmd(q.args(key)...), q: q}
+}
+
+func (cmd *GeoSearchCmd) Result() ([]redis.GeoLocation, error) {
+	v, err := cmd.Cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoLocations(v, cmd.q)
+}
+
+func (cmd *GeoSearchCmd) Val() []redis.GeoLocation {
+	locs, _ := cmd.Result()
+	return locs
+}
+
+// parseGeoLocations 解析 GEOSEARCH 的回复，回复的格式与 GEORADIUS 相同
+func parseGeoLocations(v interface{}, q *GeoSearchQuery) ([]redis.GeoLocation, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected geosearch reply %T", v)
+	}
+	locs := make([]redis.GeoLocation, 0, len(items))
+	for _, item := range items {
+		if name, ok := item.(string); ok {
+			locs = append(locs, redis.GeoLocation{Name: name})
+			continue
+		}
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) == 0 {
+			return nil, fmt.Errorf("redis: unexpected geosearch item %v", item)
+		}
+		var loc redis.GeoLocation
+		var err error
+		loc.Name = fmt.Sprint(fields[0])
+		fields = fields[1:]
+		if q.WithDist && len(fields) > 0 {
+			if loc.Dist, err = strconv.ParseFloat(fmt.Sprint(fields[0]), 64); err != nil {
+				return nil, err
+			}
+			fields = fields[1:]
+		}
+		if q.WithHash && len(fields) > 0 {
+			loc.GeoHash, _ = fields[0].(int64)
+			fields = fields[1:]
+		}
+		if q.WithCoord && len(fields) > 0 {
+			coord, ok := fields[0].([]interface{})
+			if !ok || len(coord) != 2 {
+				return nil, fmt.Errorf("redis: unexpected geosearch coord %v", fields[0])
+			}
+			if loc.Longitude, err = strconv.ParseFloat(fmt.Sprint(coord[0]), 64); err != nil {
+				return nil, err
+			}
+			if loc.Latitude, err = strconv.ParseFloat(fmt.Sprint(coord[1]), 64); err != nil {
+				return nil, err
+			}
+		}
+		locs = append(locs, loc)
+	}
+	return locs, nil
+}
+
+// BitFieldCmd go-redis v6 没有 BITFIELD，使用通用命令执行，溢出策略为 FAIL 且溢出时对应的值为0
+type BitFieldCmd struct {
+	*redis.Cmd
+}
+
+func newBitFieldCmd(key string, args ...interface{}) *BitFieldCmd {
+	return &BitFieldCmd{Cmd: redis.NewCmd(append([]interface{}{"bitfield", key}, args...)...)}
+}
+
+func (cmd *BitFieldCmd) Result() ([]int64, error) {
+	v, err := cmd.Cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected bitfield reply %T", v)
+	}
+	vals := make([]int64, len(items))
+	for i, item := range items {
+		vals[i], _ = item.(int64)
+	}
+	return vals, nil
+}
+
+func (cmd *BitFieldCmd) Val() []int64 {
+	vals, _ := cmd.Result()
+	return vals
+}
+
+// fixGeoRadiusQuery STORE 和 STOREDIST 的 key 也需要加前缀
+func (m *Client) fixGeoRadiusQuery(query *redis.GeoRadiusQuery) *redis.GeoRadiusQuery {
+	q := *query
+	if len(q.Store) > 0 {
+		q.Store = m.fixKey(q.Store)
+	}
+	if len(q.StoreDist) > 0 {
+		q.StoreDist = m.fixKey(q.StoreDist)
+	}
+	return &q
+}
+
+func (m *Client) GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "GeoAdd", k)
+	return m.route(k).GeoAdd(k, geoLocation...)
+}
+
+func (m *Client) GeoRadius(ctx context.Context, key string, longitude, latitude float64, query *redis.GeoRadiusQuery) *redis.GeoLocationCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "GeoRadius", k)
+	q := m.fixGeoRadiusQuery(query)
+	if len(q.Store) == 0 && len(q.StoreDist) == 0 {
+		return m.route(k).GeoRadiusRO(k, longitude, latitude, q)
+	}
+	return m.route(k).GeoRadius(k, longitude, latitude, q)
+}
+
+func (m *Client) GeoRadiusByMember(ctx context.Context, key, member string, query *redis.GeoRadiusQuery) *redis.GeoLocationCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "GeoRadiusByMember", k)
+	q := m.fixGeoRadiusQuery(query)
+	if len(q.Store) == 0 && len(q.StoreDist) == 0 {
+		return m.route(k).GeoRadiusByMemberRO(k, member, q)
+	}
+	return m.route(k).GeoRadiusByMember(k, member, q)
+}
+
+func (m *Client) GeoSearch(ctx context.Context, key string, query *GeoSearchQuery) *GeoSearchCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "GeoSearch", k)
+	cmd := newGeoSearchCmd(k, query)
+	_ = m.route(k).Process(cmd)
+	return cmd
+}
+
+func (m *Client) GeoDist(ctx context.Context, key string, member1, member2, unit string) *redis.FloatCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "GeoDist", k)
+	return m.route(k).GeoDist(k, member1, member2, unit)
+}
+
+func (m *Client) GeoPos(ctx context.Context, key string, members ...string) *redis.GeoPosCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "GeoPos", k)
+	return m.route(k).GeoPos(k, members...)
+}
+
+func (m *Client) GeoHash(ctx context.Context, key string, members ...string) *redis.StringSliceCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "GeoHash", k)
+	return m.route(k).GeoHash(k, members...)
+}
+
+func (m *Client) PFAdd(ctx context.Context, key string, els ...interface{}) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "PFAdd", k)
+	return m.route(k).PFAdd(k, els...)
+}
+
+// PFCount 分片模式下多个 key 需要在同一个分片，cluster 模式下需要使用 HashTag 保证在同一个 slot 中，否则返回错误
+func (m *Client) PFCount(ctx context.Context, keys ...string) *redis.IntCmd {
+	fixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixKeys[i] = m.fixKey(key)
+	}
+	m.logSpan(ctx, "PFCount", strings.Join(fixKeys, "||"))
+	if err := m.checkSameShard("pfcount", fixKeys); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return m.route(firstKey(fixKeys)).PFCount(fixKeys...)
+}
+
+// PFMerge dest 和 keys 的要求与 PFCount 相同
+func (m *Client) PFMerge(ctx context.Context, dest string, keys ...string) *redis.StatusCmd {
+	d := m.fixKey(dest)
+	fixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixKeys[i] = m.fixKey(key)
+	}
+	m.logSpan(ctx, "PFMerge", d)
+	if err := m.checkSameShard("pfmerge", append([]string{d}, fixKeys...)); err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	return m.route(d).PFMerge(d, fixKeys...)
+}
+
+func (m *Client) BitCount(ctx context.Context, key string, bitCount *redis.BitCount) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "BitCount", k)
+	return m.route(k).BitCount(k, bitCount)
+}
+
+func (m *Client) BitPos(ctx context.Context, key string, bit int64, pos ...int64) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "BitPos", k)
+	return m.route(k).BitPos(k, bit, pos...)
+}
+
+// BitField args 为 GET/SET/INCRBY/OVERFLOW 子命令及其参数，如 "incrby", "u8", 0, 1
+func (m *Client) BitField(ctx context.Context, key string, args ...interface{}) *BitFieldCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "BitField", k)
+	cmd := newBitFieldCmd(k, args...)
+	_ = m.route(k).Process(cmd)
+	return cmd
+}
+
+func (m *Pipeline) fixGeoRadiusQuery(query *redis.GeoRadiusQuery) *redis.GeoRadiusQuery {
+	q := *query
+	if len(q.Store) > 0 {
+		q.Store = m.fixKey(q.Store)
+	}
+	if len(q.StoreDist) > 0 {
+		q.StoreDist = m.fixKey(q.StoreDist)
+	}
+	return &q
+}
+
+func (m *Pipeline) GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.GeoAdd", k)
+	return m.route(k).GeoAdd(k, geoLocation...)
+}
+
+func (m *Pipeline) GeoRadius(ctx context.Context, key string, longitude, latitude float64, query *redis.GeoRadiusQuery) *redis.GeoLocationCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.GeoRadius", k)
+	q := m.fixGeoRadiusQuery(query)
+	if len(q.Store) == 0 && len(q.StoreDist) == 0 {
+		return m.route(k).GeoRadiusRO(k, longitude, latitude, q)
+	}
+	return m.route(k).GeoRadius(k, longitude, latitude, q)
+}
+
+func (m *Pipeline) GeoRadiusByMember(ctx context.Context, key, member string, query *redis.GeoRadiusQuery) *redis.GeoLocationCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.GeoRadiusByMember", k)
+	q := m.fixGeoRadiusQuery(query)
+	if len(q.Store) == 0 && len(q.StoreDist) == 0 {
+		return m.route(k).GeoRadiusByMemberRO(k, member, q)
+	}
+	return m.route(k).GeoRadiusByMember(k, member, q)
+}
+
+func (m *Pipeline) GeoSearch(ctx context.Context, key string, query *GeoSearchQuery) *GeoSearchCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.GeoSearch", k)
+	cmd := newGeoSearchCmd(k, query)
+	_ = m.route(k).Process(cmd)
+	return cmd
+}
+
+func (m *Pipeline) GeoDist(ctx context.Context, key string, member1, member2, unit string) *redis.FloatCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.GeoDist", k)
+	return m.route(k).GeoDist(k, member1, member2, unit)
+}
+
+func (m *Pipeline) GeoPos(ctx context.Context, key string, members ...string) *redis.GeoPosCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.GeoPos", k)
+	return m.route(k).GeoPos(k, members...)
+}
+
+func (m *Pipeline) GeoHash(ctx context.Context, key string, members ...string) *redis.StringSliceCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.GeoHash", k)
+	return m.route(k).GeoHash(k, members...)
+}
+
+func (m *Pipeline) PFAdd(ctx context.Context, key string, els ...interface{}) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.PFAdd", k)
+	return m.route(k).PFAdd(k, els...)
+}
+
+func (m *Pipeline) PFCount(ctx context.Context, keys ...string) *redis.IntCmd {
+	fixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixKeys[i] = m.fixKey(key)
+	}
+	m.logSpan(ctx, "Pipeline.PFCount", strings.Join(fixKeys, "||"))
+	return m.route(firstKey(fixKeys)).PFCount(fixKeys...)
+}
+
+func (m *Pipeline) PFMerge(ctx context.Context, dest string, keys ...string) *redis.StatusCmd {
+	d := m.fixKey(dest)
+	fixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixKeys[i] = m.fixKey(key)
+	}
+	m.logSpan(ctx, "Pipeline.PFMerge", d)
+	return m.route(d).PFMerge(d, fixKeys...)
+}
+
+func (m *Pipeline) BitCount(ctx context.Context, key string, bitCount *redis.BitCount) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.BitCount", k)
+	return m.route(k).BitCount(k, bitCount)
+}
+
+func (m *Pipeline) BitPos(ctx context.Context, key string, bit int64, pos ...int64) *redis.IntCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.BitPos", k)
+	return m.route(k).BitPos(k, bit, pos...)
+}
+
+func (m *Pipeline) BitField(ctx context.Context, key string, args ...interface{}) *BitFieldCmd {
+	k := m.fixKey(key)
+	m.logSpan(ctx, "Pipeline.BitField", k)
+	cmd := newBitFieldCmd(k, args...)
+	_ = m.route(k).Process(cmd)
+	return cmd
+}
@@ -0,0 +1,32 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoSearchQueryArgs(t *testing.T) {
+	q := &GeoSearchQuery{Member: "m1", Radius: 5, Sort: "ASC", Count: 3, Any: true, WithDist: true}
+	assert.Equal(t, []interface{}{"geosearch", "k", "frommember", "m1", "byradius", 5.0, "km", "ASC", "count", 3, "any", "withdist"}, q.args("k"))
+
+	q = &GeoSearchQuery{Longitude: 116.4, Latitude: 39.9, BoxWidth: 2, BoxHeight: 3, Unit: "m", WithCoord: true}
+	assert.Equal(t, []interface{}{"geosearch", "k", "fromlonlat", 116.4, 39.9, "bybox", 2.0, 3.0, "m", "withcoord"}, q.args("k"))
+}
+
+func TestParseGeoLocations(t *testing.T) {
+	locs, err := parseGeoLocations([]interface{}{"a", "b"}, &GeoSearchQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.GeoLocation{{Name: "a"}, {Name: "b"}}, locs)
+
+	q := &GeoSearchQuery{WithDist: true, WithHash: true, WithCoord: true}
+	locs, err = parseGeoLocations([]interface{}{
+		[]interface{}{"a", "1.5", int64(42), []interface{}{"116.4", "39.9"}},
+	}, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.GeoLocation{{Name: "a", Dist: 1.5, GeoHash: 42, Longitude: 116.4, Latitude: 39.9}}, locs)
+
+	_, err = parseGeoLocations("bad", q)
+	assert.Error(t, err)
+}
@@ -23,18 +23,133 @@ const (
 	apolloConfigKeyPoolSize   = "poolsize"
 	apolloConfigKeyTimeout    = "timeout"
 	apolloConfigKeyUseWrapper = "usewrapper"
+	apolloConfigKeyMode       = "mode"
+	apolloConfigKeyMasterName = "mastername"
+	apolloConfigKeyAddrs      = "addrs"
+	apolloConfigKeyReplicas   = "replicas"
+	apolloConfigKeyReadPolicy = "readpolicy"
+
+	apolloConfigKeyHotKeySampleRate = "hotkeysamplerate"
+	apolloConfigKeyHotKeyTopK       = "hotkeytopk"
+	apolloConfigKeyHotKeyThreshold  = "hotkeythreshold"
+	apolloConfigKeyBigKeyBytes      = "bigkeybytes"
+
+	configAddrsSep = ","
 
 	defaultPoolSize          = 128
 	defaultTimeoutNumSeconds = 3
 	defaultUseWrapper        = true
 )
 
+// Mode redis 部署方式。只有 ApolloConfig 通过 mode、mastername、addrs 配置项支持全部的模式，
+// SimpleConfig 只支持 ModeSingle，MemoryConfig 支持 ModeSingle 和 ModeSharded，EtcdConfig 尚未实现
+type Mode string
+
+const (
+	ModeSingle   Mode = "single"
+	ModeSentinel Mode = "sentinel"
+	ModeCluster  Mode = "cluster"
+	// ModeSharded 客户端按照一致性哈希分片，addrs 为分片地址，格式为 name=addr 或者 addr
+	ModeSharded Mode = "sharded"
+)
+
+// ReadPolicy 读命令的路由策略
+type ReadPolicy string
+
+const (
+	// ReadPolicyMaster 只读主库
+	ReadPolicyMaster ReadPolicy = "master"
+	// ReadPolicyPreferReplica 优先读从库，没有可用的从库时读主库
+	ReadPolicyPreferReplica ReadPolicy = "prefer_replica"
+	// ReadPolicyRoundRobin 在主库和从库之间轮询
+	ReadPolicyRoundRobin ReadPolicy = "round_robin"
+)
+
 type Config struct {
 	addr       string
 	namespace  string
 	poolSize   int
 	timeout    time.Duration
 	useWrapper bool
+	// 为空时为 ModeSingle
+	mode Mode
+	// sentinel 模式的 master 名字
+	masterName string
+	// sentinel 模式为 sentinel 地址，cluster 模式为集群节点的种子地址，为空时使用 addr
+	addrs []string
+	// 从库地址，cluster 模式不支持
+	replicas []string
+	// 为空时为 ReadPolicyMaster
+	readPolicy ReadPolicy
+	// 热 key 和大 key 的采样配置，默认关闭
+	keyStats keyStatsConfig
+}
+
+func (m *Config) getReadPolicy() ReadPolicy {
+	if len(m.readPolicy) == 0 {
+		return ReadPolicyMaster
+	}
+	return m.readPolicy
+}
+
+func (m *Config) getMode() Mode {
+	if len(m.mode) == 0 {
+		return ModeSingle
+	}
+	return m.mode
+}
+
+func (m *Config) getAddrs() []string {
+	if len(m.addrs) > 0 {
+		return m.addrs
+	}
+	return splitAddrs(m.addr)
+}
+
+func splitAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, configAddrsSep) {
+		addr = strings.TrimSpace(addr)
+		if len(addr) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (m *Config) check() error {
+	switch m.getMode() {
+	case ModeSingle:
+		if len(m.addr) == 0 {
+			return fmt.Errorf("no addr for mode %s", ModeSingle)
+		}
+	case ModeSentinel:
+		if len(m.masterName) == 0 {
+			return fmt.Errorf("no master name for mode %s", ModeSentinel)
+		}
+		if len(m.getAddrs()) == 0 {
+			return fmt.Errorf("no addrs for mode %s", ModeSentinel)
+		}
+	case ModeCluster, ModeSharded:
+		if len(m.getAddrs()) == 0 {
+			return fmt.Errorf("no addrs for mode %s", m.mode)
+		}
+	default:
+		return fmt.Errorf("unknown mode %s", m.mode)
+	}
+
+	switch m.getReadPolicy() {
+	case ReadPolicyMaster, ReadPolicyPreferReplica, ReadPolicyRoundRobin:
+	default:
+		return fmt.Errorf("unknown read policy %s", m.readPolicy)
+	}
+	if len(m.replicas) > 0 && (m.getMode() == ModeCluster || m.getMode() == ModeSharded) {
+		return fmt.Errorf("replicas not supported for mode %s", m.mode)
+	}
+	if m.keyStats.sampleRate < 0 || m.keyStats.sampleRate > 1 {
+		return fmt.Errorf("invalid hot key sample rate %v", m.keyStats.sampleRate)
+	}
+	return nil
 }
 
 type KeyParts struct {
@@ -59,11 +174,14 @@ func NewConfiger(configType constants.ConfigerType) (Configer, error) {
 		return NewEtcdConfiger(), nil
 	case constants.ConfigerTypeApollo:
 		return NewApolloConfiger(), nil
+	case constants.ConfigerTypeMemory:
+		return NewMemoryConfiger(), nil
 	default:
 		return nil, fmt.Errorf("configType %d error", configType)
 	}
 }
 
+// SimpleConfig 使用固定的地址，只支持 ModeSingle，sentinel、cluster 和分片模式需要使用 ApolloConfig
 type SimpleConfig struct {
 }
 
@@ -109,6 +227,7 @@ func (m *SimpleConfig) Watch(ctx context.Context) <-chan *center.ChangeEvent {
 	return nil
 }
 
+// EtcdConfig 尚未实现，GetConfig 总是返回错误，sentinel、cluster 和分片模式需要使用 ApolloConfig
 type EtcdConfig struct {
 	etcdAddr []string
 }
@@ -218,12 +337,27 @@ func (m *ApolloConfig) GetConfig(ctx context.Context, namespace string) (*Config
 	fun := "ApolloConfig.GetConfig-->"
 	slog.Infof(ctx, "%s get apollo config namespace:%s", fun, namespace)
 
-	addr, ok := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyAddr)
+	mode, ok := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyMode)
 	if !ok {
-		return nil, fmt.Errorf("%s no addr config found", fun)
+		mode = string(ModeSingle)
 	}
+	slog.Infof(ctx, "%s got config mode:%s", fun, mode)
+
+	addr, _ := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyAddr)
 	slog.Infof(ctx, "%s got config addr:%s", fun, addr)
 
+	addrs, _ := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyAddrs)
+	masterName, _ := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyMasterName)
+	if Mode(mode) != ModeSingle {
+		slog.Infof(ctx, "%s got config addrs:%s mastername:%s", fun, addrs, masterName)
+	}
+
+	replicas, _ := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyReplicas)
+	readPolicy, _ := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyReadPolicy)
+	if len(replicas) > 0 {
+		slog.Infof(ctx, "%s got config replicas:%s readpolicy:%s", fun, replicas, readPolicy)
+	}
+
 	poolSize, ok := m.getConfigIntItemWithFallback(ctx, namespace, apolloConfigKeyPoolSize)
 	if !ok {
 		poolSize = defaultPoolSize
@@ -246,13 +380,37 @@ func (m *ApolloConfig) GetConfig(ctx context.Context, namespace string) (*Config
 	}
 	slog.Infof(ctx, "%s got config usewrapper:%v", fun, useWrapper)
 
-	return &Config{
+	hotKeySampleRate, _ := m.getConfigStringItemWithFallback(ctx, namespace, apolloConfigKeyHotKeySampleRate)
+	hotKeyTopK, _ := m.getConfigIntItemWithFallback(ctx, namespace, apolloConfigKeyHotKeyTopK)
+	hotKeyThreshold, _ := m.getConfigIntItemWithFallback(ctx, namespace, apolloConfigKeyHotKeyThreshold)
+	bigKeyBytes, _ := m.getConfigIntItemWithFallback(ctx, namespace, apolloConfigKeyBigKeyBytes)
+	if len(hotKeySampleRate) > 0 || bigKeyBytes > 0 {
+		slog.Infof(ctx, "%s got config hotkeysamplerate:%s hotkeytopk:%d hotkeythreshold:%d bigkeybytes:%d",
+			fun, hotKeySampleRate, hotKeyTopK, hotKeyThreshold, bigKeyBytes)
+	}
+
+	config := &Config{
 		addr:       addr,
 		namespace:  namespace,
 		poolSize:   poolSize,
 		timeout:    time.Duration(timeout) * time.Second,
 		useWrapper: useWrapper,
-	}, nil
+		mode:       Mode(mode),
+		masterName: masterName,
+		addrs:      splitAddrs(addrs),
+		replicas:   splitAddrs(replicas),
+		readPolicy: ReadPolicy(readPolicy),
+		keyStats: keyStatsConfig{
+			sampleRate:   parseSampleRate(hotKeySampleRate),
+			topK:         hotKeyTopK,
+			hotThreshold: int64(hotKeyThreshold),
+			bigKeyBytes:  bigKeyBytes,
+		},
+	}
+	if err := config.check(); err != nil {
+		return nil, fmt.Errorf("%s %v", fun, err)
+	}
+	return config, nil
 }
 
 func (m *ApolloConfig) ParseKey(ctx context.Context, key string) (*KeyParts, error) {
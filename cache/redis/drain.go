@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/shawnfeng/sutil/slog/slog"
+	"github.com/shawnfeng/sutil/smetric"
+)
+
+const (
+	defaultDrainTimeout = 10 * time.Second
+	drainCheckInterval  = 20 * time.Millisecond
+
+	reloadResultSuccess = "success"
+	reloadResultFail    = "fail"
+	drainResultDrained  = "drained"
+	drainResultTimeout  = "timeout"
+)
+
+var (
+	metricReloadTotal = []string{"palfish", "redis", "reload", "total"}
+	metricDrainTotal  = []string{"palfish", "redis", "drain", "total"}
+)
+
+// inflight 统计实例上正在执行的命令数，配置变更时旧实例等待正在执行的命令结束后再关闭
+// NOTE: 发布订阅的连接不统计，旧实例关闭时直接断开
+type inflight struct {
+	n int64
+}
+
+func (m *inflight) add(delta int64) {
+	if m != nil {
+		atomic.AddInt64(&m.n, delta)
+	}
+}
+
+func (m *inflight) count() int64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&m.n)
+}
+
+// track 通过 go-redis 的 hook 统计 client 上执行的命令和 pipeline
+func (m *inflight) track(client redis.UniversalClient) {
+	client.WrapProcess(func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			m.add(1)
+			defer m.add(-1)
+			return old(cmd)
+		}
+	})
+	if c, ok := client.(interface {
+		WrapProcessPipeline(fn func(oldProcess func([]redis.Cmder) error) func([]redis.Cmder) error)
+	}); ok {
+		c.WrapProcessPipeline(func(old func([]redis.Cmder) error) func([]redis.Cmder) error {
+			return func(cmds []redis.Cmder) error {
+				m.add(1)
+				defer m.add(-1)
+				return old(cmds)
+			}
+		})
+	}
+}
+
+// wait 等待正在执行的命令结束，超过 timeout 时返回 false
+func (m *inflight) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		// NOTE: 至少等待一个周期，刚刚通过 GetInstance 拿到旧实例的请求可能还没有开始执行
+		time.Sleep(drainCheckInterval)
+		if m.count() <= 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
+
+// trackInflight 统计主库、从库和所有分片上正在执行的命令
+func (m *Client) trackInflight() {
+	m.inflight = &inflight{}
+	m.inflight.track(m.client)
+	if m.replicas != nil {
+		for _, r := range m.replicas.replicas {
+			m.inflight.track(r.client.client)
+		}
+	}
+	if m.shards != nil {
+		// NOTE: 第一个分片就是 m.client
+		for _, s := range m.shards.shards[1:] {
+			m.inflight.track(s.client)
+		}
+	}
+}
+
+// drainAndClose 等待正在执行的命令结束或者超过 timeout 后关闭，返回是否在 timeout 之前结束
+func (m *Client) drainAndClose(ctx context.Context, timeout time.Duration) (bool, error) {
+	drained := m.inflight.wait(timeout)
+	return drained, m.Close(ctx)
+}
+
+// SetDrainTimeout 配置变更后旧实例等待正在执行的命令结束的最长时间，需要在 Watch 之前调用
+func (m *InstanceManager) SetDrainTimeout(timeout time.Duration) {
+	m.drainTimeout = timeout
+}
+
+// drainInstance 新实例已经开始服务，旧实例等待正在执行的命令结束后关闭
+func (m *InstanceManager) drainInstance(ctx context.Context, key string, instance interface{}) {
+	fun := "InstanceManager.drainInstance-->"
+	client, ok := instance.(*Client)
+	if !ok {
+		slog.Errorf(ctx, "%s instance:%#v should be cache.redis.redis.Client", fun, instance)
+		return
+	}
+	st := time.Now()
+	slog.Infof(ctx, "%s draining instance:%s inflight:%d", fun, key, client.inflight.count())
+	drained, err := client.drainAndClose(ctx, m.drainTimeout)
+	if err != nil {
+		slog.Errorf(ctx, "%s close instance:%s err:%v", fun, key, err)
+	}
+	result := drainResultDrained
+	if !drained {
+		result = drainResultTimeout
+		slog.Warnf(ctx, "%s instance:%s closed with inflight:%d after timeout:%v", fun, key, client.inflight.count(), m.drainTimeout)
+	} else {
+		slog.Infof(ctx, "%s instance:%s drained in %v", fun, key, time.Since(st))
+	}
+	smetric.DefaultMetrics.IncrCounterCreateIfAbsent(metricDrainTotal, 1, []smetric.Label{
+		{Name: "namespace", Value: client.namespace},
+		{Name: "result", Value: result},
+	})
+}
+
+func statReload(namespace, result string) {
+	smetric.DefaultMetrics.IncrCounterCreateIfAbsent(metricReloadTotal, 1, []smetric.Label{
+		{Name: "namespace", Value: namespace},
+		{Name: "result", Value: result},
+	})
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDrainTestClient(t *testing.T) (*Client, *memoryServer) {
+	server, err := newMemoryServer()
+	assert.NoError(t, err)
+	c := &Client{
+		client:    redis.NewClient(&redis.Options{Addr: server.addr()}),
+		namespace: "test/drain",
+		opts:      &options{},
+	}
+	c.trackInflight()
+	return c, server
+}
+
+func TestDrainAndClose(t *testing.T) {
+	ctx := context.Background()
+	c, server := newDrainTestClient(t)
+	defer server.close()
+
+	assert.NoError(t, c.Set(ctx, "a", "1", 0).Err())
+	p := c.Pipeline()
+	p.Get(ctx, "a")
+	_, err := p.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), c.inflight.count())
+
+	// 模拟一个正在执行的命令
+	c.inflight.add(1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		c.inflight.add(-1)
+	}()
+	st := time.Now()
+	drained, err := c.drainAndClose(ctx, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, drained)
+	assert.True(t, time.Since(st) >= 100*time.Millisecond)
+	assert.Error(t, c.Get(ctx, "a").Err())
+
+	c, server2 := newDrainTestClient(t)
+	defer server2.close()
+	c.inflight.add(1)
+	drained, err = c.drainAndClose(ctx, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, drained)
+}
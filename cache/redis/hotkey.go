@@ -0,0 +1,315 @@
+package redis
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog/slog"
+	"github.com/shawnfeng/sutil/smetric"
+)
+
+const (
+	countMinDepth = 4
+	countMinWidth = 2048
+
+	defaultHotKeyTopK       = 10
+	defaultKeyStatsInterval = time.Minute
+	// 一个统计周期内最多记录的大 key 数量，超过时只保留最大的
+	maxBigKeys = 100
+)
+
+// NOTE: key 的数量没有上限，不能作为 metrics 的 label，具体的 key 只打印在日志中和通过 HotKeys、BigKeys 获取
+var (
+	// 按照 namespace 和排名上报热 key 的访问次数
+	metricHotKeyCount = []string{"palfish", "redis", "hotkey", "count"}
+	metricBigKeyTotal = []string{"palfish", "redis", "bigkey", "total"}
+	// 按照 namespace 上报一个统计周期内最大的值的大小
+	metricBigKeyBytes = []string{"palfish", "redis", "bigkey", "bytes"}
+)
+
+// keyStatsConfig 热 key 和大 key 的采样配置
+type keyStatsConfig struct {
+	// 访问的采样比例，(0, 1]，为0时不统计热 key
+	sampleRate float64
+	// 每个统计周期上报访问次数最多的 topK 个 key
+	topK int
+	// 一个统计周期内估计的访问次数不小于该值时打印告警，为0时不告警
+	hotThreshold int64
+	// 值的大小不小于该值时记为大 key，为0时不统计大 key
+	bigKeyBytes int
+}
+
+func (m keyStatsConfig) enabled() bool {
+	return m.sampleRate > 0 || m.bigKeyBytes > 0
+}
+
+// countMinSketch 估计 key 的访问次数，估计值不小于真实值
+type countMinSketch struct {
+	counts [countMinDepth][countMinWidth]uint32
+}
+
+// add 增加 key 的计数并返回估计值
+func (m *countMinSketch) add(key string) uint32 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	min := ^uint32(0)
+	for i := 0; i < countMinDepth; i++ {
+		idx := (h1 + uint32(i)*h2) % countMinWidth
+		m.counts[i][idx]++
+		if m.counts[i][idx] < min {
+			min = m.counts[i][idx]
+		}
+	}
+	return min
+}
+
+// HotKey 热 key 及其在一个统计周期内估计的访问次数
+type HotKey struct {
+	Key   string
+	Count int64
+}
+
+// topK 保存估计访问次数最多的 k 个 key
+type topK struct {
+	k    int
+	keys map[string]uint32
+}
+
+func newTopK(k int) *topK {
+	return &topK{k: k, keys: make(map[string]uint32, k)}
+}
+
+func (m *topK) update(key string, count uint32) {
+	if _, ok := m.keys[key]; ok || len(m.keys) < m.k {
+		m.keys[key] = count
+		return
+	}
+	// k 比较小，直接遍历找最小值
+	minKey, minCount := "", ^uint32(0)
+	for k, c := range m.keys {
+		if c < minCount {
+			minKey, minCount = k, c
+		}
+	}
+	if count > minCount {
+		delete(m.keys, minKey)
+		m.keys[key] = count
+	}
+}
+
+// list 按照访问次数降序返回，scale 为采样比例的倒数
+func (m *topK) list(scale float64) []HotKey {
+	keys := make([]HotKey, 0, len(m.keys))
+	for k, c := range m.keys {
+		keys = append(keys, HotKey{Key: k, Count: int64(float64(c) * scale)})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	return keys
+}
+
+// keyStats 按照 namespace 采样 key 的访问次数和值的大小，周期性上报 metrics 并打印告警
+type keyStats struct {
+	namespace string
+	config    keyStatsConfig
+
+	mu      sync.Mutex
+	sketch  *countMinSketch
+	top     *topK
+	bigKeys map[string]int
+	// 上一个统计周期的结果
+	lastHot []HotKey
+	lastBig map[string]int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newKeyStats(ctx context.Context, namespace string, config keyStatsConfig) *keyStats {
+	if !config.enabled() {
+		return nil
+	}
+	if config.topK <= 0 {
+		config.topK = defaultHotKeyTopK
+	}
+	m := &keyStats{
+		namespace: namespace,
+		config:    config,
+		sketch:    &countMinSketch{},
+		top:       newTopK(config.topK),
+		bigKeys:   make(map[string]int),
+		stop:      make(chan struct{}),
+	}
+	go m.watch(ctx, defaultKeyStatsInterval)
+	return m
+}
+
+// access 按照采样比例记录一次 key 的访问
+func (m *keyStats) access(key string) {
+	if m == nil || m.config.sampleRate <= 0 {
+		return
+	}
+	if m.config.sampleRate < 1 && rand.Float64() >= m.config.sampleRate {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.top.update(key, m.sketch.add(key))
+}
+
+// value 记录 key 对应值的大小
+func (m *keyStats) value(command, key string, size int) {
+	if m == nil || m.config.bigKeyBytes <= 0 || size < m.config.bigKeyBytes {
+		return
+	}
+	smetric.DefaultMetrics.IncrCounterCreateIfAbsent(metricBigKeyTotal, 1, []smetric.Label{
+		{Name: "namespace", Value: m.namespace},
+		{Name: "command", Value: command},
+	})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bigKeys[key]; !ok && len(m.bigKeys) >= maxBigKeys {
+		minKey, minSize := "", 0
+		for k, s := range m.bigKeys {
+			if minKey == "" || s < minSize {
+				minKey, minSize = k, s
+			}
+		}
+		if size <= minSize {
+			return
+		}
+		delete(m.bigKeys, minKey)
+	}
+	if size > m.bigKeys[key] {
+		m.bigKeys[key] = size
+	}
+}
+
+func (m *keyStats) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.report(ctx)
+		}
+	}
+}
+
+// report 上报并重置当前统计周期的结果
+func (m *keyStats) report(ctx context.Context) {
+	fun := "keyStats.report -->"
+	m.mu.Lock()
+	hot := m.top.list(1 / m.sampleScale())
+	big := m.bigKeys
+	m.sketch = &countMinSketch{}
+	m.top = newTopK(m.config.topK)
+	m.bigKeys = make(map[string]int)
+	m.lastHot, m.lastBig = hot, big
+	m.mu.Unlock()
+
+	// 每个排名都上报，本周期没有对应热 key 的排名置为0，避免保留上一个周期的值
+	if m.config.sampleRate > 0 {
+		for i := 0; i < m.config.topK; i++ {
+			var count int64
+			if i < len(hot) {
+				count = hot[i].Count
+			}
+			smetric.DefaultMetrics.SetGaugeCreateIfAbsent(metricHotKeyCount, float64(count), []smetric.Label{
+				{Name: "namespace", Value: m.namespace},
+				{Name: "rank", Value: strconv.Itoa(i + 1)},
+			})
+		}
+	}
+	for _, k := range hot {
+		if m.config.hotThreshold > 0 && k.Count >= m.config.hotThreshold {
+			slog.Warnf(ctx, "%s namespace:%s hot key:%s count:%d in %v", fun, m.namespace, k.Key, k.Count, defaultKeyStatsInterval)
+		}
+	}
+
+	var maxSize int
+	for key, size := range big {
+		if size > maxSize {
+			maxSize = size
+		}
+		slog.Warnf(ctx, "%s namespace:%s big key:%s size:%d", fun, m.namespace, key, size)
+	}
+	if m.config.bigKeyBytes > 0 {
+		smetric.DefaultMetrics.SetGaugeCreateIfAbsent(metricBigKeyBytes, float64(maxSize), []smetric.Label{
+			{Name: "namespace", Value: m.namespace},
+		})
+	}
+}
+
+func (m *keyStats) sampleScale() float64 {
+	if m.config.sampleRate <= 0 || m.config.sampleRate > 1 {
+		return 1
+	}
+	return m.config.sampleRate
+}
+
+func (m *keyStats) close() {
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// HotKeys 返回上一个统计周期内访问次数最多的 key，没有开启采样时返回 nil
+func (m *Client) HotKeys() []HotKey {
+	if m.keyStats == nil {
+		return nil
+	}
+	m.keyStats.mu.Lock()
+	defer m.keyStats.mu.Unlock()
+	return m.keyStats.lastHot
+}
+
+// BigKeys 返回上一个统计周期内值的大小超过阈值的 key 及其最大的大小，没有开启统计时返回 nil
+func (m *Client) BigKeys() map[string]int {
+	if m.keyStats == nil {
+		return nil
+	}
+	m.keyStats.mu.Lock()
+	defer m.keyStats.mu.Unlock()
+	big := make(map[string]int, len(m.keyStats.lastBig))
+	for k, v := range m.keyStats.lastBig {
+		big[k] = v
+	}
+	return big
+}
+
+// valueSize 返回写入或者读出的值的大小，只统计 string 和 []byte
+func valueSize(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	default:
+		return 0
+	}
+}
+
+func parseSampleRate(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	sketch := &countMinSketch{}
+	for i := 0; i < 1000; i++ {
+		sketch.add(fmt.Sprintf("key%d", i))
+	}
+	var n uint32
+	for i := 0; i < 100; i++ {
+		n = sketch.add("hot")
+	}
+	assert.True(t, n >= 100 && n < 110, "estimate:%d", n)
+}
+
+func TestKeyStats(t *testing.T) {
+	ctx := context.Background()
+	stats := newKeyStats(ctx, "test/test", keyStatsConfig{sampleRate: 1, topK: 2, bigKeyBytes: 4})
+	defer stats.close()
+	assert.Nil(t, newKeyStats(ctx, "test/test", keyStatsConfig{}))
+
+	for i := 0; i < 10; i++ {
+		stats.access("a")
+	}
+	for i := 0; i < 5; i++ {
+		stats.access("b")
+	}
+	stats.access("c")
+	stats.value("Set", "big", 10)
+	stats.value("Set", "small", 3)
+	stats.report(ctx)
+
+	c := &Client{keyStats: stats}
+	assert.Equal(t, []HotKey{{Key: "a", Count: 10}, {Key: "b", Count: 5}}, c.HotKeys())
+	assert.Equal(t, map[string]int{"big": 10}, c.BigKeys())
+	assert.Nil(t, (&Client{}).HotKeys())
+}
+
+func TestKeyStatsBigKeyLimit(t *testing.T) {
+	ctx := context.Background()
+	stats := newKeyStats(ctx, "test/test", keyStatsConfig{bigKeyBytes: 1})
+	defer stats.close()
+
+	// 超过上限时只保留最大的 maxBigKeys 个
+	for i := 1; i <= maxBigKeys+10; i++ {
+		stats.value("Set", fmt.Sprintf("key%d", i), i)
+	}
+	stats.report(ctx)
+	big := (&Client{keyStats: stats}).BigKeys()
+	assert.Equal(t, maxBigKeys, len(big))
+	assert.NotContains(t, big, "key10")
+	assert.Equal(t, maxBigKeys+10, big[fmt.Sprintf("key%d", maxBigKeys+10)])
+}
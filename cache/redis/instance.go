@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/shawnfeng/sutil/cache/constants"
 	"github.com/shawnfeng/sutil/sconf/center"
@@ -56,10 +57,14 @@ var DefaultInstanceManager = NewInstanceManager()
 type InstanceManager struct {
 	instances sync.Map
 	watchOnce sync.Once
+	// 配置变更后旧实例等待正在执行的命令结束的最长时间
+	drainTimeout time.Duration
 }
 
 func NewInstanceManager() *InstanceManager {
-	return &InstanceManager{}
+	return &InstanceManager{
+		drainTimeout: defaultDrainTimeout,
+	}
 }
 
 func (m *InstanceManager) buildKey(conf *InstanceConf) string {
@@ -76,7 +81,13 @@ func (m *InstanceManager) add(key string, client *Client) {
 }
 
 func (m *InstanceManager) newInstance(ctx context.Context, conf *InstanceConf) (*Client, error) {
-	return NewClientWithOptions(ctx, conf.Namespace, WithWrapper(conf.Wrapper), WithNoFixKey(conf.NoFixKey))
+	client, err := NewClientWithOptions(ctx, conf.Namespace, WithWrapper(conf.Wrapper), WithNoFixKey(conf.NoFixKey))
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: 实例创建和因配置变更重建时都预加载注册的脚本
+	preloadScripts(ctx, client)
+	return client, nil
 }
 
 func (m *InstanceManager) GetInstance(ctx context.Context, conf *InstanceConf) (*Client, error) {
@@ -135,18 +146,19 @@ func (m *InstanceManager) applyChange(ctx context.Context, key string, change *c
 		// NOTE: 只要 namespace 和 group 相同，即认为相关的配置发生了变化
 		//       为了逻辑简单，不论什么变化，都重新载入一次 instance，不对不同的 ChangeType 单独处理
 		if (keyParts.Group == conf.Group || keyParts.Group == constants.DefaultRouteGroup) && keyParts.Namespace == conf.Namespace {
-			slog.Infof(ctx, "%s update instance:%v", fun, v)
-			// NOTE: 关闭旧实例，重新载入新实例，若旧实例关闭失败打印日志
-			if err = m.closeInstance(ctx, v); err != nil {
-				slog.Errorf(ctx, "%s close instance err:%v", fun, err)
-			}
-
+			slog.Infof(ctx, "%s reload instance:%s", fun, sk)
+			// NOTE: 先用新实例替换旧实例，新的请求立即使用新实例，旧实例在后台等待正在执行的命令结束后关闭
+			//       新实例创建失败时删除旧实例，下次 GetInstance 时重新创建
 			in, err := m.newInstance(ctx, conf)
 			if err != nil {
+				slog.Errorf(ctx, "%s reload instance:%s err:%v", fun, sk, err)
+				statReload(conf.Namespace, reloadResultFail)
 				m.instances.Delete(k)
-				return
+			} else {
+				statReload(conf.Namespace, reloadResultSuccess)
+				m.instances.Store(k, in)
 			}
-			m.instances.Store(k, in)
+			go m.drainInstance(ctx, sk, v)
 		}
 
 		return
@@ -0,0 +1,497 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/sconf/center"
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+// MemoryScript 内存 redis 中 lua 脚本的 go 实现，call 对应脚本中的 redis.call，
+// 返回值中 nil 回复为 nil，状态回复为 string，数组为 []interface{}
+type MemoryScript func(call func(args ...interface{}) (interface{}, error), keys, argv []string) (interface{}, error)
+
+// memoryScripts 内存 redis 不能执行 lua，脚本通过 sha1 找到注册的 go 实现执行
+var memoryScripts = struct {
+	sync.RWMutex
+	scripts map[string]MemoryScript
+}{
+	scripts: make(map[string]MemoryScript),
+}
+
+// RegisterMemoryScript 为脚本 src 注册内存 redis 中使用的实现，没有注册实现的脚本在内存 redis 中执行时返回错误，
+// 内存 redis 只用于测试，实现应该注册在 _test.go 中，不随业务代码发布
+func RegisterMemoryScript(src string, fn MemoryScript) {
+	memoryScripts.Lock()
+	defer memoryScripts.Unlock()
+	memoryScripts.scripts[scriptHash(src)] = fn
+}
+
+func memoryScriptOf(hash string) MemoryScript {
+	memoryScripts.RLock()
+	defer memoryScripts.RUnlock()
+	return memoryScripts.scripts[hash]
+}
+
+func scriptHash(src string) string {
+	h := sha1.New()
+	_, _ = io.WriteString(h, src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MemoryConfig 使用进程内的内存 redis，用于单元测试，所有 namespace 共用同一个内存 redis，
+// 内存 redis 不支持 lua，EVAL/EVALSHA 只能执行通过 RegisterMemoryScript 注册过实现的脚本
+type MemoryConfig struct {
+	mu sync.Mutex
+	// 大于1时每个分片一个内存 redis，namespace 使用 ModeSharded
+	shards  int
+	servers []*memoryServer
+}
+
+func NewMemoryConfiger() *MemoryConfig {
+	return &MemoryConfig{}
+}
+
+// NewShardedMemoryConfiger 启动 shards 个内存 redis，按照分片模式访问，用于测试多 key 命令的拆分
+func NewShardedMemoryConfiger(shards int) *MemoryConfig {
+	return &MemoryConfig{shards: shards}
+}
+
+func (m *MemoryConfig) getServers(ctx context.Context) ([]*memoryServer, error) {
+	fun := "MemoryConfig.getServers-->"
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.servers) > 0 {
+		return m.servers, nil
+	}
+	n := m.shards
+	if n < 1 {
+		n = 1
+	}
+	var servers []*memoryServer
+	for i := 0; i < n; i++ {
+		server, err := newMemoryServer()
+		if err != nil {
+			slog.Errorf(ctx, "%s start memory redis err:%v", fun, err)
+			for _, s := range servers {
+				s.close()
+			}
+			return nil, err
+		}
+		slog.Infof(ctx, "%s memory redis listen on:%s", fun, server.addr())
+		servers = append(servers, server)
+	}
+	m.servers = servers
+	return servers, nil
+}
+
+func (m *MemoryConfig) Init(ctx context.Context) error {
+	fun := "MemoryConfig.Init-->"
+	slog.Infof(ctx, "%s start", fun)
+	_, err := m.getServers(ctx)
+	return err
+}
+
+func (m *MemoryConfig) GetConfig(ctx context.Context, namespace string) (*Config, error) {
+	fun := "MemoryConfig.GetConfig-->"
+	servers, err := m.getServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s %v", fun, err)
+	}
+	config := &Config{
+		addr:       servers[0].addr(),
+		namespace:  namespace,
+		timeout:    defaultTimeoutNumSeconds * time.Second,
+		poolSize:   defaultPoolSize,
+		useWrapper: defaultUseWrapper,
+	}
+	if len(servers) > 1 {
+		config.mode = ModeSharded
+		for i, server := range servers {
+			config.addrs = append(config.addrs, fmt.Sprintf("shard%d%s%s", i, shardNameSep, server.addr()))
+		}
+	}
+	return config, nil
+}
+
+func (m *MemoryConfig) ParseKey(ctx context.Context, key string) (*KeyParts, error) {
+	fun := "MemoryConfig.ParseKey-->"
+	return nil, fmt.Errorf("%s not implemented", fun)
+}
+
+func (m *MemoryConfig) Watch(ctx context.Context) <-chan *center.ChangeEvent {
+	fun := "MemoryConfig.Watch-->"
+	slog.Infof(ctx, "%s start", fun)
+	// noop
+	return nil
+}
+
+// Flush 清空内存 redis 中的数据
+func (m *MemoryConfig) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, server := range m.servers {
+		server.db.flush()
+	}
+}
+
+// Close 关闭内存 redis，之后的 GetConfig 会启动新的内存 redis
+func (m *MemoryConfig) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for _, server := range m.servers {
+		if cerr := server.close(); cerr != nil {
+			err = cerr
+		}
+	}
+	m.servers = nil
+	return err
+}
+
+// memoryServer 进程内监听本地端口的 redis 协议服务，客户端仍然使用 go-redis，
+// 因此 pipeline、事务和订阅的行为与真实的 redis 一致
+type memoryServer struct {
+	ln net.Listener
+	db *memoryDB
+
+	mu     sync.Mutex
+	conns  map[*memoryConn]struct{}
+	closed bool
+}
+
+func newMemoryServer() (*memoryServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &memoryServer{
+		ln:    ln,
+		db:    newMemoryDB(),
+		conns: make(map[*memoryConn]struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (m *memoryServer) addr() string {
+	return m.ln.Addr().String()
+}
+
+func (m *memoryServer) serve() {
+	for {
+		nc, err := m.ln.Accept()
+		if err != nil {
+			return
+		}
+		conn := &memoryConn{
+			db:   m.db,
+			conn: nc,
+			w:    bufio.NewWriter(nc),
+		}
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			nc.Close()
+			return
+		}
+		m.conns[conn] = struct{}{}
+		m.mu.Unlock()
+		go func() {
+			conn.serve()
+			m.mu.Lock()
+			delete(m.conns, conn)
+			m.mu.Unlock()
+		}()
+	}
+}
+
+func (m *memoryServer) close() error {
+	m.mu.Lock()
+	m.closed = true
+	for conn := range m.conns {
+		conn.conn.Close()
+	}
+	m.mu.Unlock()
+	return m.ln.Close()
+}
+
+// memoryConn 一个客户端连接，保存 MULTI 和 WATCH 以及订阅的状态
+type memoryConn struct {
+	db   *memoryDB
+	conn net.Conn
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	inMulti  bool
+	multiErr bool
+	queued   [][]string
+	// WATCH 时 key 的快照，EXEC 时与当前值比较
+	watched map[string]*memoryItem
+	subs    map[string]struct{}
+	psubs   map[string]struct{}
+}
+
+func (m *memoryConn) serve() {
+	defer m.unsubscribeAll()
+	r := bufio.NewReader(m.conn)
+	for {
+		args, err := readMemoryCommand(r)
+		if err != nil {
+			m.conn.Close()
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if strings.ToLower(args[0]) == "quit" {
+			m.write(memoryStatus("OK"))
+			m.conn.Close()
+			return
+		}
+		m.write(m.handle(args))
+	}
+}
+
+func (m *memoryConn) handle(args []string) interface{} {
+	name := strings.ToLower(args[0])
+	switch name {
+	case "multi":
+		if m.inMulti {
+			return errors.New("ERR MULTI calls can not be nested")
+		}
+		m.inMulti, m.multiErr, m.queued = true, false, nil
+		return memoryStatus("OK")
+	case "exec":
+		return m.exec()
+	case "discard":
+		if !m.inMulti {
+			return errors.New("ERR DISCARD without MULTI")
+		}
+		m.inMulti, m.queued, m.watched = false, nil, nil
+		return memoryStatus("OK")
+	case "watch":
+		if m.inMulti {
+			return errors.New("ERR WATCH inside MULTI is not allowed")
+		}
+		m.watch(args[1:])
+		return memoryStatus("OK")
+	case "unwatch":
+		m.watched = nil
+		return memoryStatus("OK")
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe":
+		m.subscribe(name, args[1:])
+		return memoryNoReply{}
+	}
+
+	subscribed := len(m.subs)+len(m.psubs) > 0
+	if subscribed && name != "ping" {
+		return fmt.Errorf("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+	}
+	if m.inMulti {
+		if _, ok := memoryCommands[name]; !ok {
+			m.multiErr = true
+			return fmt.Errorf("ERR unknown command '%s'", args[0])
+		}
+		m.queued = append(m.queued, args)
+		return memoryStatus("QUEUED")
+	}
+	if subscribed {
+		return []interface{}{"pong", ""}
+	}
+	if name == "xread" || name == "xreadgroup" {
+		return m.blockingRead(args)
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	return m.db.exec(args)
+}
+
+func (m *memoryConn) watch(keys []string) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	if m.watched == nil {
+		m.watched = make(map[string]*memoryItem)
+	}
+	for _, key := range keys {
+		var snapshot *memoryItem
+		if item := m.db.get(key); item != nil {
+			snapshot = item.clone()
+		}
+		m.watched[key] = snapshot
+	}
+}
+
+// exec 执行 MULTI 后排队的命令，WATCH 的 key 与快照不同时放弃执行
+func (m *memoryConn) exec() interface{} {
+	if !m.inMulti {
+		return errors.New("ERR EXEC without MULTI")
+	}
+	queued, watched, multiErr := m.queued, m.watched, m.multiErr
+	m.inMulti, m.queued, m.watched, m.multiErr = false, nil, nil, false
+	if multiErr {
+		return errors.New("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	for key, snapshot := range watched {
+		item := m.db.get(key)
+		if (item == nil) != (snapshot == nil) || (item != nil && !reflect.DeepEqual(item, snapshot)) {
+			return memoryNilArray{}
+		}
+	}
+	replies := make([]interface{}, len(queued))
+	for i, args := range queued {
+		replies[i] = m.db.exec(args)
+	}
+	return replies
+}
+
+// subscribe 处理 (P)SUBSCRIBE 和 (P)UNSUBSCRIBE，回复中的订阅数包括 channel 和 pattern
+func (m *memoryConn) subscribe(name string, channels []string) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	if m.subs == nil {
+		m.subs = make(map[string]struct{})
+		m.psubs = make(map[string]struct{})
+	}
+	subs, all := m.subs, m.db.channels
+	if strings.HasPrefix(name, "p") {
+		subs, all = m.psubs, m.db.patterns
+	}
+	unsubscribe := strings.HasSuffix(name, "unsubscribe")
+	if unsubscribe && len(channels) == 0 {
+		for ch := range subs {
+			channels = append(channels, ch)
+		}
+	}
+	for _, ch := range channels {
+		if unsubscribe {
+			delete(subs, ch)
+			delete(all[ch], m)
+		} else {
+			subs[ch] = struct{}{}
+			if all[ch] == nil {
+				all[ch] = make(map[*memoryConn]struct{})
+			}
+			all[ch][m] = struct{}{}
+		}
+		m.write([]interface{}{name, ch, int64(len(m.subs) + len(m.psubs))})
+	}
+}
+
+func (m *memoryConn) unsubscribeAll() {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	for ch := range m.subs {
+		delete(m.db.channels[ch], m)
+	}
+	for pattern := range m.psubs {
+		delete(m.db.patterns[pattern], m)
+	}
+	m.subs, m.psubs = nil, nil
+}
+
+// memoryNoReply subscribe 等命令已经自行写入回复
+type memoryNoReply struct{}
+
+func (m *memoryConn) write(r interface{}) {
+	if _, ok := r.(memoryNoReply); ok {
+		return
+	}
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	writeMemoryReply(m.w, r)
+	_ = m.w.Flush()
+}
+
+func writeMemoryReply(w *bufio.Writer, r interface{}) {
+	switch v := r.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case memoryNilArray:
+		w.WriteString("*-1\r\n")
+	case memoryStatus:
+		w.WriteString("+" + string(v) + "\r\n")
+	case error:
+		w.WriteString("-" + strings.Replace(v.Error(), "\r\n", " ", -1) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			writeMemoryReply(w, s)
+		}
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			if e == nil {
+				w.WriteString("$-1\r\n")
+				continue
+			}
+			writeMemoryReply(w, e)
+		}
+	default:
+		writeMemoryReply(w, fmt.Errorf("ERR unsupported reply type %T", r))
+	}
+}
+
+// readMemoryCommand 读取一条命令，支持 RESP 数组和 inline 两种格式
+func readMemoryCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readMemoryLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multibulk length %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readMemoryLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readMemoryLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
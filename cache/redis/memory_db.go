@@ -0,0 +1,2029 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errMemoryWrongType   = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errMemorySyntax      = errors.New("ERR syntax error")
+	errMemoryNotInteger  = errors.New("ERR value is not an integer or out of range")
+	errMemoryNotFloat    = errors.New("ERR value is not a valid float")
+	errMemoryNoSuchKey   = errors.New("ERR no such key")
+	errMemoryOutOfRange  = errors.New("ERR index out of range")
+	errMemoryNoScript    = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	errMemoryMinMaxFloat = errors.New("ERR min or max is not a float")
+)
+
+// memoryStatus 状态回复，如 OK
+type memoryStatus string
+
+// memoryNilArray 空数组回复，EXEC 因 WATCH 的 key 变更而放弃时返回
+type memoryNilArray struct{}
+
+type memoryKind int
+
+const (
+	memoryKindString memoryKind = iota + 1
+	memoryKindHash
+	memoryKindList
+	memoryKindSet
+	memoryKindZSet
+	memoryKindStream
+)
+
+func (k memoryKind) String() string {
+	switch k {
+	case memoryKindString:
+		return "string"
+	case memoryKindHash:
+		return "hash"
+	case memoryKindList:
+		return "list"
+	case memoryKindSet:
+		return "set"
+	case memoryKindZSet:
+		return "zset"
+	case memoryKindStream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+type memoryItem struct {
+	kind   memoryKind
+	str    string
+	hash   map[string]string
+	list   []string
+	set    map[string]struct{}
+	zset   map[string]float64
+	stream *memoryStream
+	expire time.Time
+}
+
+func newMemoryItem(kind memoryKind) *memoryItem {
+	item := &memoryItem{kind: kind}
+	switch kind {
+	case memoryKindHash:
+		item.hash = make(map[string]string)
+	case memoryKindSet:
+		item.set = make(map[string]struct{})
+	case memoryKindZSet:
+		item.zset = make(map[string]float64)
+	case memoryKindStream:
+		item.stream = newMemoryStream()
+	}
+	return item
+}
+
+func (m *memoryItem) empty() bool {
+	switch m.kind {
+	case memoryKindHash:
+		return len(m.hash) == 0
+	case memoryKindList:
+		return len(m.list) == 0
+	case memoryKindSet:
+		return len(m.set) == 0
+	case memoryKindZSet:
+		return len(m.zset) == 0
+	default:
+		return false
+	}
+}
+
+func (m *memoryItem) clone() *memoryItem {
+	c := &memoryItem{kind: m.kind, str: m.str, expire: m.expire}
+	if m.hash != nil {
+		c.hash = make(map[string]string, len(m.hash))
+		for k, v := range m.hash {
+			c.hash[k] = v
+		}
+	}
+	if m.list != nil {
+		c.list = append([]string(nil), m.list...)
+	}
+	if m.set != nil {
+		c.set = make(map[string]struct{}, len(m.set))
+		for k := range m.set {
+			c.set[k] = struct{}{}
+		}
+	}
+	if m.zset != nil {
+		c.zset = make(map[string]float64, len(m.zset))
+		for k, v := range m.zset {
+			c.zset[k] = v
+		}
+	}
+	if m.stream != nil {
+		c.stream = m.stream.clone()
+	}
+	return c
+}
+
+type memoryZMember struct {
+	member string
+	score  float64
+}
+
+// sortedZSet 按照 score, member 升序排列
+func (m *memoryItem) sortedZSet() []memoryZMember {
+	members := make([]memoryZMember, 0, len(m.zset))
+	for member, score := range m.zset {
+		members = append(members, memoryZMember{member: member, score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].member < members[j].member
+	})
+	return members
+}
+
+type memoryCommand func(db *memoryDB, args []string) interface{}
+
+// memoryDB 内存 redis 的数据，所有命令在 mu 保护下串行执行，脚本的执行也是原子的
+type memoryDB struct {
+	mu      sync.Mutex
+	items   map[string]*memoryItem
+	scripts map[string]bool
+	// channel -> 订阅的连接
+	channels map[string]map[*memoryConn]struct{}
+	// pattern -> PSUBSCRIBE 订阅的连接
+	patterns map[string]map[*memoryConn]struct{}
+}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{
+		items:    make(map[string]*memoryItem),
+		scripts:  make(map[string]bool),
+		channels: make(map[string]map[*memoryConn]struct{}),
+		patterns: make(map[string]map[*memoryConn]struct{}),
+	}
+}
+
+func (m *memoryDB) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = make(map[string]*memoryItem)
+}
+
+// get 返回未过期的 key，过期的 key 在访问时删除
+func (m *memoryDB) get(key string) *memoryItem {
+	item, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+	if !item.expire.IsZero() && !time.Now().Before(item.expire) {
+		delete(m.items, key)
+		return nil
+	}
+	return item
+}
+
+func (m *memoryDB) getKind(key string, kind memoryKind) (*memoryItem, error) {
+	item := m.get(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.kind != kind {
+		return nil, errMemoryWrongType
+	}
+	return item, nil
+}
+
+// getOrCreate 返回 kind 类型的 key，不存在时创建
+func (m *memoryDB) getOrCreate(key string, kind memoryKind) (*memoryItem, error) {
+	item, err := m.getKind(key, kind)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		item = newMemoryItem(kind)
+		m.items[key] = item
+	}
+	return item, nil
+}
+
+// cleanup 集合类型的 key 为空时删除
+func (m *memoryDB) cleanup(key string, item *memoryItem) {
+	if item != nil && item.empty() {
+		delete(m.items, key)
+	}
+}
+
+func (m *memoryDB) keys(pattern string) []string {
+	var keys []string
+	for key := range m.items {
+		if m.get(key) != nil && memoryMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exec 执行一条命令，调用方需要持有 mu
+func (m *memoryDB) exec(args []string) interface{} {
+	if len(args) == 0 {
+		return errors.New("ERR empty command")
+	}
+	name := strings.ToLower(args[0])
+	cmd, ok := memoryCommands[name]
+	if !ok {
+		return fmt.Errorf("ERR unknown command '%s'", args[0])
+	}
+	if arity := memoryArity[name]; len(args) < arity {
+		return fmt.Errorf("ERR wrong number of arguments for '%s' command", name)
+	}
+	return cmd(m, args[1:])
+}
+
+var memoryCommands map[string]memoryCommand
+
+// memoryArity 命令最少的参数个数，包括命令名
+var memoryArity = map[string]int{
+	"get": 2, "set": 3, "setnx": 3, "setex": 4, "psetex": 4, "getset": 3, "mget": 2, "mset": 3, "msetnx": 3,
+	"incr": 2, "incrby": 3, "decr": 2, "decrby": 3, "incrbyfloat": 3, "append": 3, "strlen": 2,
+	"setbit": 4, "getbit": 3, "bitcount": 2,
+	"del": 2, "unlink": 2, "exists": 2, "type": 2, "expire": 3, "pexpire": 3, "expireat": 3, "pexpireat": 3,
+	"ttl": 2, "pttl": 2, "persist": 2, "keys": 2, "scan": 2, "rename": 3,
+	"hset": 4, "hsetnx": 4, "hmset": 4, "hget": 3, "hmget": 3, "hgetall": 2, "hdel": 3, "hexists": 3, "hlen": 2,
+	"hkeys": 2, "hvals": 2, "hincrby": 4, "hincrbyfloat": 4, "hscan": 3,
+	"lpush": 3, "rpush": 3, "lpushx": 3, "rpushx": 3, "lpop": 2, "rpop": 2, "llen": 2, "lrange": 4, "lindex": 3,
+	"lset": 4, "lrem": 4, "ltrim": 4, "rpoplpush": 3,
+	"sadd": 3, "srem": 3, "smembers": 2, "sismember": 3, "scard": 2, "spop": 2, "srandmember": 2, "sscan": 3,
+	"sinter": 2, "sunion": 2, "sdiff": 2,
+	"zadd": 4, "zincrby": 4, "zrem": 3, "zscore": 3, "zcard": 2, "zcount": 4, "zrange": 4, "zrevrange": 4,
+	"zrangebyscore": 4, "zrevrangebyscore": 4, "zrank": 3, "zrevrank": 3, "zremrangebyrank": 4,
+	"zremrangebyscore": 4, "zscan": 3,
+	"eval": 3, "evalsha": 3, "script": 2,
+	"ping": 1, "echo": 2, "select": 2, "dbsize": 1, "flushdb": 1, "flushall": 1, "client": 2, "info": 1, "time": 1,
+	"publish": 3, "xadd": 5, "xlen": 2, "xrange": 4, "xrevrange": 4, "xdel": 3, "xtrim": 4, "xgroup": 2, "xread": 4,
+	"xreadgroup": 7, "xack": 4, "xpending": 3, "xclaim": 6,
+}
+
+func init() {
+	memoryCommands = map[string]memoryCommand{
+		// strings
+		"get":         memoryGet,
+		"set":         memorySet,
+		"setnx":       memorySetNX,
+		"setex":       memorySetEX,
+		"psetex":      memoryPSetEX,
+		"getset":      memoryGetSet,
+		"mget":        memoryMGet,
+		"mset":        memoryMSet,
+		"msetnx":      memoryMSetNX,
+		"incr":        memoryIncr,
+		"incrby":      memoryIncrBy,
+		"decr":        memoryDecr,
+		"decrby":      memoryDecrBy,
+		"incrbyfloat": memoryIncrByFloat,
+		"append":      memoryAppend,
+		"strlen":      memoryStrLen,
+		"setbit":      memorySetBit,
+		"getbit":      memoryGetBit,
+		"bitcount":    memoryBitCount,
+		// keys
+		"del":       memoryDel,
+		"unlink":    memoryDel,
+		"exists":    memoryExists,
+		"type":      memoryType,
+		"expire":    memoryExpire(time.Second, false),
+		"pexpire":   memoryExpire(time.Millisecond, false),
+		"expireat":  memoryExpire(time.Second, true),
+		"pexpireat": memoryExpire(time.Millisecond, true),
+		"ttl":       memoryTTL(time.Second),
+		"pttl":      memoryTTL(time.Millisecond),
+		"persist":   memoryPersist,
+		"keys":      memoryKeys,
+		"scan":      memoryScan,
+		"rename":    memoryRename,
+		// hashes
+		"hset":         memoryHSet,
+		"hsetnx":       memoryHSetNX,
+		"hmset":        memoryHMSet,
+		"hget":         memoryHGet,
+		"hmget":        memoryHMGet,
+		"hgetall":      memoryHGetAll,
+		"hdel":         memoryHDel,
+		"hexists":      memoryHExists,
+		"hlen":         memoryHLen,
+		"hkeys":        memoryHKeys,
+		"hvals":        memoryHVals,
+		"hincrby":      memoryHIncrBy,
+		"hincrbyfloat": memoryHIncrByFloat,
+		"hscan":        memoryHScan,
+		// lists
+		"lpush":     memoryPush(true, false),
+		"rpush":     memoryPush(false, false),
+		"lpushx":    memoryPush(true, true),
+		"rpushx":    memoryPush(false, true),
+		"lpop":      memoryPop(true),
+		"rpop":      memoryPop(false),
+		"llen":      memoryLLen,
+		"lrange":    memoryLRange,
+		"lindex":    memoryLIndex,
+		"lset":      memoryLSet,
+		"lrem":      memoryLRem,
+		"ltrim":     memoryLTrim,
+		"rpoplpush": memoryRPopLPush,
+		// sets
+		"sadd":        memorySAdd,
+		"srem":        memorySRem,
+		"smembers":    memorySMembers,
+		"sismember":   memorySIsMember,
+		"scard":       memorySCard,
+		"spop":        memorySPop,
+		"srandmember": memorySRandMember,
+		"sscan":       memorySScan,
+		"sinter":      memorySetOp("inter"),
+		"sunion":      memorySetOp("union"),
+		"sdiff":       memorySetOp("diff"),
+		// sorted sets
+		"zadd":             memoryZAdd,
+		"zincrby":          memoryZIncrBy,
+		"zrem":             memoryZRem,
+		"zscore":           memoryZScore,
+		"zcard":            memoryZCard,
+		"zcount":           memoryZCount,
+		"zrange":           memoryZRange(false),
+		"zrevrange":        memoryZRange(true),
+		"zrangebyscore":    memoryZRangeByScore(false),
+		"zrevrangebyscore": memoryZRangeByScore(true),
+		"zrank":            memoryZRank(false),
+		"zrevrank":         memoryZRank(true),
+		"zremrangebyrank":  memoryZRemRangeByRank,
+		"zremrangebyscore": memoryZRemRangeByScore,
+		"zscan":            memoryZScan,
+		// streams
+		"xadd":       memoryXAdd,
+		"xlen":       memoryXLen,
+		"xrange":     memoryXRange(false),
+		"xrevrange":  memoryXRange(true),
+		"xdel":       memoryXDel,
+		"xtrim":      memoryXTrim,
+		"xgroup":     memoryXGroup,
+		"xread":      memoryXRead,
+		"xreadgroup": memoryXReadGroup,
+		"xack":       memoryXAck,
+		"xpending":   memoryXPending,
+		"xclaim":     memoryXClaim,
+		// scripting
+		"eval":    memoryEval,
+		"evalsha": memoryEvalSha,
+		"script":  memoryScript,
+		// server
+		"ping":     memoryPing,
+		"echo":     func(db *memoryDB, args []string) interface{} { return args[0] },
+		"select":   memoryOK,
+		"client":   memoryOK,
+		"info":     func(db *memoryDB, args []string) interface{} { return "" },
+		"dbsize":   memoryDBSize,
+		"flushdb":  memoryFlush,
+		"flushall": memoryFlush,
+		"publish":  memoryPublish,
+		"time":     memoryTime,
+	}
+}
+
+func memoryOK(db *memoryDB, args []string) interface{} {
+	return memoryStatus("OK")
+}
+
+func memoryPing(db *memoryDB, args []string) interface{} {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return memoryStatus("PONG")
+}
+
+func memoryTime(db *memoryDB, args []string) interface{} {
+	now := time.Now()
+	return []interface{}{strconv.FormatInt(now.Unix(), 10), strconv.FormatInt(int64(now.Nanosecond()/1000), 10)}
+}
+
+func memoryDBSize(db *memoryDB, args []string) interface{} {
+	return int64(len(db.keys("*")))
+}
+
+func memoryFlush(db *memoryDB, args []string) interface{} {
+	db.items = make(map[string]*memoryItem)
+	return memoryStatus("OK")
+}
+
+func memoryBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func memoryFormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func memoryParseFloat(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errMemoryNotFloat
+	}
+	return f, nil
+}
+
+func memoryParseInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errMemoryNotInteger
+	}
+	return n, nil
+}
+
+// memoryRange 将 redis 的 start, stop 下标转换为 [start, stop) 的切片下标
+func memoryRange(start, stop, n int64) (int64, int64) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// ---- strings ----
+
+func memoryGet(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindString)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return nil
+	}
+	return item.str
+}
+
+func memorySet(db *memoryDB, args []string) interface{} {
+	key, value := args[0], args[1]
+	var expire time.Time
+	var nx, xx, keepTTL bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px":
+			if i+1 >= len(args) {
+				return errMemorySyntax
+			}
+			n, err := memoryParseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return errors.New("ERR invalid expire time in set")
+			}
+			unit := time.Second
+			if strings.ToLower(args[i]) == "px" {
+				unit = time.Millisecond
+			}
+			expire = time.Now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return errMemorySyntax
+		}
+	}
+	old := db.get(key)
+	if (nx && old != nil) || (xx && old == nil) {
+		return nil
+	}
+	if keepTTL && old != nil {
+		expire = old.expire
+	}
+	db.items[key] = &memoryItem{kind: memoryKindString, str: value, expire: expire}
+	return memoryStatus("OK")
+}
+
+func memorySetNX(db *memoryDB, args []string) interface{} {
+	if db.get(args[0]) != nil {
+		return int64(0)
+	}
+	db.items[args[0]] = &memoryItem{kind: memoryKindString, str: args[1]}
+	return int64(1)
+}
+
+func memorySetEX(db *memoryDB, args []string) interface{} {
+	return memorySet(db, []string{args[0], args[2], "ex", args[1]})
+}
+
+func memoryPSetEX(db *memoryDB, args []string) interface{} {
+	return memorySet(db, []string{args[0], args[2], "px", args[1]})
+}
+
+func memoryGetSet(db *memoryDB, args []string) interface{} {
+	old := memoryGet(db, args[:1])
+	if _, ok := old.(error); ok {
+		return old
+	}
+	db.items[args[0]] = &memoryItem{kind: memoryKindString, str: args[1]}
+	return old
+}
+
+func memoryMGet(db *memoryDB, args []string) interface{} {
+	vals := make([]interface{}, len(args))
+	for i, key := range args {
+		if item := db.get(key); item != nil && item.kind == memoryKindString {
+			vals[i] = item.str
+		}
+	}
+	return vals
+}
+
+func memoryMSet(db *memoryDB, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return errors.New("ERR wrong number of arguments for 'mset' command")
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.items[args[i]] = &memoryItem{kind: memoryKindString, str: args[i+1]}
+	}
+	return memoryStatus("OK")
+}
+
+func memoryMSetNX(db *memoryDB, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return errors.New("ERR wrong number of arguments for 'msetnx' command")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if db.get(args[i]) != nil {
+			return int64(0)
+		}
+	}
+	memoryMSet(db, args)
+	return int64(1)
+}
+
+func memoryIncrBy(db *memoryDB, args []string) interface{} {
+	by, err := memoryParseInt(args[1])
+	if err != nil {
+		return err
+	}
+	return memoryIncrInt(db, args[0], by)
+}
+
+func memoryIncrInt(db *memoryDB, key string, by int64) interface{} {
+	item, err := db.getKind(key, memoryKindString)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if item != nil {
+		if n, err = memoryParseInt(item.str); err != nil {
+			return err
+		}
+	} else {
+		item = newMemoryItem(memoryKindString)
+		db.items[key] = item
+	}
+	n += by
+	item.str = strconv.FormatInt(n, 10)
+	return n
+}
+
+func memoryIncr(db *memoryDB, args []string) interface{} {
+	return memoryIncrInt(db, args[0], 1)
+}
+
+func memoryDecr(db *memoryDB, args []string) interface{} {
+	return memoryIncrInt(db, args[0], -1)
+}
+
+func memoryDecrBy(db *memoryDB, args []string) interface{} {
+	by, err := memoryParseInt(args[1])
+	if err != nil {
+		return err
+	}
+	return memoryIncrInt(db, args[0], -by)
+}
+
+func memoryIncrByFloat(db *memoryDB, args []string) interface{} {
+	by, err := memoryParseFloat(args[1])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindString)
+	if err != nil {
+		return err
+	}
+	var f float64
+	if item != nil {
+		if f, err = memoryParseFloat(item.str); err != nil {
+			return err
+		}
+	} else {
+		item = newMemoryItem(memoryKindString)
+		db.items[args[0]] = item
+	}
+	item.str = memoryFormatFloat(f + by)
+	return item.str
+}
+
+func memoryAppend(db *memoryDB, args []string) interface{} {
+	item, err := db.getOrCreate(args[0], memoryKindString)
+	if err != nil {
+		return err
+	}
+	item.str += args[1]
+	return int64(len(item.str))
+}
+
+func memoryStrLen(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindString)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return int64(0)
+	}
+	return int64(len(item.str))
+}
+
+func memorySetBit(db *memoryDB, args []string) interface{} {
+	offset, err := memoryParseInt(args[1])
+	if err != nil || offset < 0 {
+		return errors.New("ERR bit offset is not an integer or out of range")
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return errors.New("ERR bit is not an integer or out of range")
+	}
+	item, err := db.getOrCreate(args[0], memoryKindString)
+	if err != nil {
+		return err
+	}
+	buf := []byte(item.str)
+	idx := int(offset / 8)
+	if idx >= len(buf) {
+		buf = append(buf, make([]byte, idx+1-len(buf))...)
+	}
+	mask := byte(1) << uint(7-offset%8)
+	old := int64(0)
+	if buf[idx]&mask != 0 {
+		old = 1
+	}
+	if args[2] == "1" {
+		buf[idx] |= mask
+	} else {
+		buf[idx] &^= mask
+	}
+	item.str = string(buf)
+	return old
+}
+
+func memoryGetBit(db *memoryDB, args []string) interface{} {
+	offset, err := memoryParseInt(args[1])
+	if err != nil || offset < 0 {
+		return errors.New("ERR bit offset is not an integer or out of range")
+	}
+	item, err := db.getKind(args[0], memoryKindString)
+	if err != nil {
+		return err
+	}
+	if item == nil || int(offset/8) >= len(item.str) {
+		return int64(0)
+	}
+	if item.str[offset/8]&(byte(1)<<uint(7-offset%8)) != 0 {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func memoryBitCount(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindString)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return int64(0)
+	}
+	start, stop := int64(0), int64(-1)
+	if len(args) >= 3 {
+		if start, err = memoryParseInt(args[1]); err != nil {
+			return err
+		}
+		if stop, err = memoryParseInt(args[2]); err != nil {
+			return err
+		}
+	}
+	from, to := memoryRange(start, stop, int64(len(item.str)))
+	var n int64
+	for _, b := range []byte(item.str[from:to]) {
+		for ; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// ---- keys ----
+
+func memoryDel(db *memoryDB, args []string) interface{} {
+	var n int64
+	for _, key := range args {
+		if db.get(key) != nil {
+			delete(db.items, key)
+			n++
+		}
+	}
+	return n
+}
+
+func memoryExists(db *memoryDB, args []string) interface{} {
+	var n int64
+	for _, key := range args {
+		if db.get(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func memoryType(db *memoryDB, args []string) interface{} {
+	item := db.get(args[0])
+	if item == nil {
+		return memoryStatus("none")
+	}
+	return memoryStatus(item.kind.String())
+}
+
+func memoryExpire(unit time.Duration, at bool) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		n, err := memoryParseInt(args[1])
+		if err != nil {
+			return err
+		}
+		item := db.get(args[0])
+		if item == nil {
+			return int64(0)
+		}
+		var expire time.Time
+		if at {
+			expire = time.Unix(0, 0).Add(time.Duration(n) * unit)
+		} else {
+			expire = time.Now().Add(time.Duration(n) * unit)
+		}
+		if !expire.After(time.Now()) {
+			delete(db.items, args[0])
+			return int64(1)
+		}
+		item.expire = expire
+		return int64(1)
+	}
+}
+
+func memoryTTL(unit time.Duration) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		item := db.get(args[0])
+		if item == nil {
+			return int64(-2)
+		}
+		if item.expire.IsZero() {
+			return int64(-1)
+		}
+		// 与 redis 相同，向上取整
+		d := time.Until(item.expire)
+		return int64((d + unit - 1) / unit)
+	}
+}
+
+func memoryPersist(db *memoryDB, args []string) interface{} {
+	item := db.get(args[0])
+	if item == nil || item.expire.IsZero() {
+		return int64(0)
+	}
+	item.expire = time.Time{}
+	return int64(1)
+}
+
+func memoryKeys(db *memoryDB, args []string) interface{} {
+	return db.keys(args[0])
+}
+
+// memoryScanArgs 解析 SCAN 类命令的 MATCH/COUNT 参数，内存实现一次返回全部结果，cursor 总是 0
+func memoryScanArgs(args []string) (match string, err error) {
+	match = "*"
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", errMemorySyntax
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			match = args[i+1]
+		case "count", "type":
+		default:
+			return "", errMemorySyntax
+		}
+	}
+	return match, nil
+}
+
+func memoryScan(db *memoryDB, args []string) interface{} {
+	match, err := memoryScanArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	return []interface{}{"0", db.keys(match)}
+}
+
+func memoryRename(db *memoryDB, args []string) interface{} {
+	item := db.get(args[0])
+	if item == nil {
+		return errMemoryNoSuchKey
+	}
+	delete(db.items, args[0])
+	db.items[args[1]] = item
+	return memoryStatus("OK")
+}
+
+// ---- hashes ----
+
+func memoryHSet(db *memoryDB, args []string) interface{} {
+	if len(args)%2 != 1 {
+		return errors.New("ERR wrong number of arguments for 'hset' command")
+	}
+	item, err := db.getOrCreate(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := item.hash[args[i]]; !ok {
+			n++
+		}
+		item.hash[args[i]] = args[i+1]
+	}
+	return n
+}
+
+func memoryHSetNX(db *memoryDB, args []string) interface{} {
+	item, err := db.getOrCreate(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	if _, ok := item.hash[args[1]]; ok {
+		return int64(0)
+	}
+	item.hash[args[1]] = args[2]
+	return int64(1)
+}
+
+func memoryHMSet(db *memoryDB, args []string) interface{} {
+	if r := memoryHSet(db, args); r != nil {
+		if err, ok := r.(error); ok {
+			return err
+		}
+	}
+	return memoryStatus("OK")
+}
+
+func memoryHGet(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return nil
+	}
+	if v, ok := item.hash[args[1]]; ok {
+		return v
+	}
+	return nil
+}
+
+func memoryHMGet(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	vals := make([]interface{}, len(args)-1)
+	if item == nil {
+		return vals
+	}
+	for i, field := range args[1:] {
+		if v, ok := item.hash[field]; ok {
+			vals[i] = v
+		}
+	}
+	return vals
+}
+
+func memoryHFields(item *memoryItem, match string) []string {
+	var fields []string
+	if item == nil {
+		return fields
+	}
+	for field := range item.hash {
+		if memoryMatch(match, field) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func memoryHGetAll(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	vals := []string{}
+	for _, field := range memoryHFields(item, "*") {
+		vals = append(vals, field, item.hash[field])
+	}
+	return vals
+}
+
+func memoryHDel(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	var n int64
+	for _, field := range args[1:] {
+		if _, ok := item.hash[field]; ok {
+			delete(item.hash, field)
+			n++
+		}
+	}
+	db.cleanup(args[0], item)
+	return n
+}
+
+func memoryZeroOrErr(err error) interface{} {
+	if err != nil {
+		return err
+	}
+	return int64(0)
+}
+
+func memoryHExists(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	_, ok := item.hash[args[1]]
+	return memoryBool(ok)
+}
+
+func memoryHLen(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	return int64(len(item.hash))
+}
+
+func memoryHKeys(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	return memoryHFields(item, "*")
+}
+
+func memoryHVals(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	vals := []string{}
+	for _, field := range memoryHFields(item, "*") {
+		vals = append(vals, item.hash[field])
+	}
+	return vals
+}
+
+func memoryHIncrBy(db *memoryDB, args []string) interface{} {
+	by, err := memoryParseInt(args[2])
+	if err != nil {
+		return err
+	}
+	item, err := db.getOrCreate(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if v, ok := item.hash[args[1]]; ok {
+		if n, err = memoryParseInt(v); err != nil {
+			return errors.New("ERR hash value is not an integer")
+		}
+	}
+	n += by
+	item.hash[args[1]] = strconv.FormatInt(n, 10)
+	return n
+}
+
+func memoryHIncrByFloat(db *memoryDB, args []string) interface{} {
+	by, err := memoryParseFloat(args[2])
+	if err != nil {
+		return err
+	}
+	item, err := db.getOrCreate(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	var f float64
+	if v, ok := item.hash[args[1]]; ok {
+		if f, err = memoryParseFloat(v); err != nil {
+			return errors.New("ERR hash value is not a float")
+		}
+	}
+	item.hash[args[1]] = memoryFormatFloat(f + by)
+	return item.hash[args[1]]
+}
+
+func memoryHScan(db *memoryDB, args []string) interface{} {
+	match, err := memoryScanArgs(args[2:])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindHash)
+	if err != nil {
+		return err
+	}
+	vals := []string{}
+	for _, field := range memoryHFields(item, match) {
+		vals = append(vals, field, item.hash[field])
+	}
+	return []interface{}{"0", vals}
+}
+
+// ---- lists ----
+
+func memoryPush(left, exists bool) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		item, err := db.getKind(args[0], memoryKindList)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			if exists {
+				return int64(0)
+			}
+			item = newMemoryItem(memoryKindList)
+			db.items[args[0]] = item
+		}
+		for _, v := range args[1:] {
+			if left {
+				item.list = append([]string{v}, item.list...)
+			} else {
+				item.list = append(item.list, v)
+			}
+		}
+		return int64(len(item.list))
+	}
+}
+
+func memoryPop(left bool) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		item, err := db.getKind(args[0], memoryKindList)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			return nil
+		}
+		var v string
+		if left {
+			v, item.list = item.list[0], item.list[1:]
+		} else {
+			v, item.list = item.list[len(item.list)-1], item.list[:len(item.list)-1]
+		}
+		db.cleanup(args[0], item)
+		return v
+	}
+}
+
+func memoryLLen(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindList)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	return int64(len(item.list))
+}
+
+func memoryStartStop(args []string) (int64, int64, error) {
+	start, err := memoryParseInt(args[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	stop, err := memoryParseInt(args[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, stop, nil
+}
+
+func memoryLRange(db *memoryDB, args []string) interface{} {
+	start, stop, err := memoryStartStop(args[1:])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindList)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return []string{}
+	}
+	from, to := memoryRange(start, stop, int64(len(item.list)))
+	return append([]string{}, item.list[from:to]...)
+}
+
+func memoryListIndex(item *memoryItem, s string) (int, error) {
+	idx, err := memoryParseInt(s)
+	if err != nil {
+		return 0, err
+	}
+	if idx < 0 {
+		idx += int64(len(item.list))
+	}
+	if idx < 0 || idx >= int64(len(item.list)) {
+		return -1, nil
+	}
+	return int(idx), nil
+}
+
+func memoryLIndex(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindList)
+	if err != nil || item == nil {
+		return err
+	}
+	idx, err := memoryListIndex(item, args[1])
+	if err != nil {
+		return err
+	}
+	if idx < 0 {
+		return nil
+	}
+	return item.list[idx]
+}
+
+func memoryLSet(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindList)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return errMemoryNoSuchKey
+	}
+	idx, err := memoryListIndex(item, args[1])
+	if err != nil {
+		return err
+	}
+	if idx < 0 {
+		return errMemoryOutOfRange
+	}
+	item.list[idx] = args[2]
+	return memoryStatus("OK")
+}
+
+func memoryLRem(db *memoryDB, args []string) interface{} {
+	count, err := memoryParseInt(args[1])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindList)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	var n int64
+	remove := func(i int) bool {
+		if item.list[i] != args[2] || (count != 0 && n >= int64(math.Abs(float64(count)))) {
+			return false
+		}
+		n++
+		return true
+	}
+	kept := make([]string, 0, len(item.list))
+	if count >= 0 {
+		for i := range item.list {
+			if !remove(i) {
+				kept = append(kept, item.list[i])
+			}
+		}
+	} else {
+		for i := len(item.list) - 1; i >= 0; i-- {
+			if !remove(i) {
+				kept = append([]string{item.list[i]}, kept...)
+			}
+		}
+	}
+	item.list = kept
+	db.cleanup(args[0], item)
+	return n
+}
+
+func memoryLTrim(db *memoryDB, args []string) interface{} {
+	start, stop, err := memoryStartStop(args[1:])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindList)
+	if err != nil {
+		return err
+	}
+	if item != nil {
+		from, to := memoryRange(start, stop, int64(len(item.list)))
+		item.list = append([]string{}, item.list[from:to]...)
+		db.cleanup(args[0], item)
+	}
+	return memoryStatus("OK")
+}
+
+func memoryRPopLPush(db *memoryDB, args []string) interface{} {
+	if _, err := db.getKind(args[1], memoryKindList); err != nil {
+		return err
+	}
+	v := memoryPop(false)(db, args[:1])
+	if s, ok := v.(string); ok {
+		memoryPush(true, false)(db, []string{args[1], s})
+	}
+	return v
+}
+
+// ---- sets ----
+
+func memorySAdd(db *memoryDB, args []string) interface{} {
+	item, err := db.getOrCreate(args[0], memoryKindSet)
+	if err != nil {
+		return err
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := item.set[member]; !ok {
+			item.set[member] = struct{}{}
+			n++
+		}
+	}
+	return n
+}
+
+func memorySRem(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindSet)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := item.set[member]; ok {
+			delete(item.set, member)
+			n++
+		}
+	}
+	db.cleanup(args[0], item)
+	return n
+}
+
+func memorySetMembers(item *memoryItem, match string) []string {
+	members := []string{}
+	if item == nil {
+		return members
+	}
+	for member := range item.set {
+		if memoryMatch(match, member) {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+func memorySMembers(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindSet)
+	if err != nil {
+		return err
+	}
+	return memorySetMembers(item, "*")
+}
+
+func memorySIsMember(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindSet)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	_, ok := item.set[args[1]]
+	return memoryBool(ok)
+}
+
+func memorySCard(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindSet)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	return int64(len(item.set))
+}
+
+// memoryRandMembers 随机返回 count 个不同的成员
+func memoryRandMembers(item *memoryItem, count int) []string {
+	members := memorySetMembers(item, "*")
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	if count < len(members) {
+		members = members[:count]
+	}
+	return members
+}
+
+func memorySPop(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindSet)
+	if err != nil {
+		return err
+	}
+	count := int64(1)
+	if len(args) > 1 {
+		if count, err = memoryParseInt(args[1]); err != nil {
+			return err
+		}
+	}
+	members := memoryRandMembers(item, int(count))
+	for _, member := range members {
+		delete(item.set, member)
+	}
+	db.cleanup(args[0], item)
+	if len(args) > 1 {
+		return members
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return members[0]
+}
+
+func memorySRandMember(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindSet)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		members := memoryRandMembers(item, 1)
+		if len(members) == 0 {
+			return nil
+		}
+		return members[0]
+	}
+	count, err := memoryParseInt(args[1])
+	if err != nil {
+		return err
+	}
+	if count >= 0 {
+		return memoryRandMembers(item, int(count))
+	}
+	// count 为负数时允许重复
+	all := memorySetMembers(item, "*")
+	members := []string{}
+	for i := int64(0); i < -count && len(all) > 0; i++ {
+		members = append(members, all[rand.Intn(len(all))])
+	}
+	return members
+}
+
+func memorySScan(db *memoryDB, args []string) interface{} {
+	match, err := memoryScanArgs(args[2:])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindSet)
+	if err != nil {
+		return err
+	}
+	return []interface{}{"0", memorySetMembers(item, match)}
+}
+
+func memorySetOp(op string) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		var result map[string]struct{}
+		for i, key := range args {
+			item, err := db.getKind(key, memoryKindSet)
+			if err != nil {
+				return err
+			}
+			set := map[string]struct{}{}
+			if item != nil {
+				set = item.set
+			}
+			if i == 0 {
+				result = make(map[string]struct{}, len(set))
+				for member := range set {
+					result[member] = struct{}{}
+				}
+				continue
+			}
+			for member := range result {
+				_, ok := set[member]
+				if (op == "inter" && !ok) || (op == "diff" && ok) {
+					delete(result, member)
+				}
+			}
+			if op == "union" {
+				for member := range set {
+					result[member] = struct{}{}
+				}
+			}
+		}
+		return memorySetMembers(&memoryItem{set: result}, "*")
+	}
+}
+
+// ---- sorted sets ----
+
+func memoryZAdd(db *memoryDB, args []string) interface{} {
+	key := args[0]
+	var nx, xx, ch, incr bool
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+			continue
+		case "xx":
+			xx = true
+			continue
+		case "ch":
+			ch = true
+			continue
+		case "incr":
+			incr = true
+			continue
+		}
+		break
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (nx && xx) || (incr && len(pairs) != 2) {
+		return errMemorySyntax
+	}
+	scores := make([]float64, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := memoryParseFloat(pairs[j])
+		if err != nil {
+			return err
+		}
+		scores = append(scores, score)
+	}
+	item, err := db.getOrCreate(key, memoryKindZSet)
+	if err != nil {
+		return err
+	}
+	var added, changed int64
+	for j, score := range scores {
+		member := pairs[j*2+1]
+		old, exists := item.zset[member]
+		if (nx && exists) || (xx && !exists) {
+			if incr {
+				db.cleanup(key, item)
+				return nil
+			}
+			continue
+		}
+		if incr {
+			score += old
+		}
+		if !exists {
+			added++
+		} else if old != score {
+			changed++
+		}
+		item.zset[member] = score
+		if incr {
+			return memoryFormatFloat(score)
+		}
+	}
+	db.cleanup(key, item)
+	if ch {
+		return added + changed
+	}
+	return added
+}
+
+func memoryZIncrBy(db *memoryDB, args []string) interface{} {
+	return memoryZAdd(db, []string{args[0], "incr", args[1], args[2]})
+}
+
+func memoryZRem(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindZSet)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := item.zset[member]; ok {
+			delete(item.zset, member)
+			n++
+		}
+	}
+	db.cleanup(args[0], item)
+	return n
+}
+
+func memoryZScore(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindZSet)
+	if err != nil || item == nil {
+		return err
+	}
+	score, ok := item.zset[args[1]]
+	if !ok {
+		return nil
+	}
+	return memoryFormatFloat(score)
+}
+
+func memoryZCard(db *memoryDB, args []string) interface{} {
+	item, err := db.getKind(args[0], memoryKindZSet)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	return int64(len(item.zset))
+}
+
+// memoryScoreBound score 的区间边界，支持 -inf, +inf 和 ( 开头的开区间
+type memoryScoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func memoryParseBound(s string) (memoryScoreBound, error) {
+	var b memoryScoreBound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+	f, err := memoryParseFloat(s)
+	if err != nil {
+		return b, errMemoryMinMaxFloat
+	}
+	b.value = f
+	return b, nil
+}
+
+func memoryInBounds(score float64, min, max memoryScoreBound) bool {
+	if score < min.value || (min.exclusive && score == min.value) {
+		return false
+	}
+	if score > max.value || (max.exclusive && score == max.value) {
+		return false
+	}
+	return true
+}
+
+func memoryZByScore(item *memoryItem, min, max memoryScoreBound) []memoryZMember {
+	var members []memoryZMember
+	if item == nil {
+		return members
+	}
+	for _, m := range item.sortedZSet() {
+		if memoryInBounds(m.score, min, max) {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+func memoryZCount(db *memoryDB, args []string) interface{} {
+	min, err := memoryParseBound(args[1])
+	if err != nil {
+		return err
+	}
+	max, err := memoryParseBound(args[2])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindZSet)
+	if err != nil {
+		return err
+	}
+	return int64(len(memoryZByScore(item, min, max)))
+}
+
+func memoryZReply(members []memoryZMember, withScores bool) []string {
+	vals := []string{}
+	for _, m := range members {
+		vals = append(vals, m.member)
+		if withScores {
+			vals = append(vals, memoryFormatFloat(m.score))
+		}
+	}
+	return vals
+}
+
+func memoryReverse(members []memoryZMember) {
+	for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+		members[i], members[j] = members[j], members[i]
+	}
+}
+
+func memoryZRange(rev bool) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		start, stop, err := memoryStartStop(args[1:])
+		if err != nil {
+			return err
+		}
+		withScores := false
+		if len(args) > 3 {
+			if len(args) != 4 || strings.ToLower(args[3]) != "withscores" {
+				return errMemorySyntax
+			}
+			withScores = true
+		}
+		item, err := db.getKind(args[0], memoryKindZSet)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			return []string{}
+		}
+		members := item.sortedZSet()
+		if rev {
+			memoryReverse(members)
+		}
+		from, to := memoryRange(start, stop, int64(len(members)))
+		return memoryZReply(members[from:to], withScores)
+	}
+}
+
+func memoryZRangeByScore(rev bool) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		minArg, maxArg := args[1], args[2]
+		if rev {
+			minArg, maxArg = maxArg, minArg
+		}
+		min, err := memoryParseBound(minArg)
+		if err != nil {
+			return err
+		}
+		max, err := memoryParseBound(maxArg)
+		if err != nil {
+			return err
+		}
+		withScores := false
+		offset, count := int64(0), int64(-1)
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "withscores":
+				withScores = true
+			case "limit":
+				if i+2 >= len(args) {
+					return errMemorySyntax
+				}
+				if offset, count, err = memoryStartStop(args[i+1 : i+3]); err != nil {
+					return err
+				}
+				i += 2
+			default:
+				return errMemorySyntax
+			}
+		}
+		item, err := db.getKind(args[0], memoryKindZSet)
+		if err != nil {
+			return err
+		}
+		members := memoryZByScore(item, min, max)
+		if rev {
+			memoryReverse(members)
+		}
+		if offset < 0 || offset >= int64(len(members)) {
+			return []string{}
+		}
+		members = members[offset:]
+		if count >= 0 && count < int64(len(members)) {
+			members = members[:count]
+		}
+		return memoryZReply(members, withScores)
+	}
+}
+
+func memoryZRank(rev bool) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		item, err := db.getKind(args[0], memoryKindZSet)
+		if err != nil || item == nil {
+			return err
+		}
+		members := item.sortedZSet()
+		if rev {
+			memoryReverse(members)
+		}
+		for i, m := range members {
+			if m.member == args[1] {
+				return int64(i)
+			}
+		}
+		return nil
+	}
+}
+
+func memoryZRemRangeByRank(db *memoryDB, args []string) interface{} {
+	start, stop, err := memoryStartStop(args[1:])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindZSet)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	members := item.sortedZSet()
+	from, to := memoryRange(start, stop, int64(len(members)))
+	for _, m := range members[from:to] {
+		delete(item.zset, m.member)
+	}
+	db.cleanup(args[0], item)
+	return to - from
+}
+
+func memoryZRemRangeByScore(db *memoryDB, args []string) interface{} {
+	min, err := memoryParseBound(args[1])
+	if err != nil {
+		return err
+	}
+	max, err := memoryParseBound(args[2])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindZSet)
+	if err != nil || item == nil {
+		return memoryZeroOrErr(err)
+	}
+	members := memoryZByScore(item, min, max)
+	for _, m := range members {
+		delete(item.zset, m.member)
+	}
+	db.cleanup(args[0], item)
+	return int64(len(members))
+}
+
+func memoryZScan(db *memoryDB, args []string) interface{} {
+	match, err := memoryScanArgs(args[2:])
+	if err != nil {
+		return err
+	}
+	item, err := db.getKind(args[0], memoryKindZSet)
+	if err != nil {
+		return err
+	}
+	var members []memoryZMember
+	if item != nil {
+		for _, m := range item.sortedZSet() {
+			if memoryMatch(match, m.member) {
+				members = append(members, m)
+			}
+		}
+	}
+	return []interface{}{"0", memoryZReply(members, true)}
+}
+
+// ---- scripting ----
+
+func memoryEval(db *memoryDB, args []string) interface{} {
+	hash := scriptHash(args[0])
+	db.scripts[hash] = true
+	return memoryRunScript(db, hash, args[1:])
+}
+
+func memoryEvalSha(db *memoryDB, args []string) interface{} {
+	hash := strings.ToLower(args[0])
+	if !db.scripts[hash] {
+		return errMemoryNoScript
+	}
+	return memoryRunScript(db, hash, args[1:])
+}
+
+func memoryRunScript(db *memoryDB, hash string, args []string) interface{} {
+	numKeys, err := memoryParseInt(args[0])
+	if err != nil || numKeys < 0 || numKeys > int64(len(args)-1) {
+		return errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	fn := memoryScriptOf(hash)
+	if fn == nil {
+		return fmt.Errorf("ERR script %s not supported by memory redis, use RegisterMemoryScript", hash)
+	}
+	keys, argv := args[1:1+numKeys], args[1+numKeys:]
+	call := func(cargs ...interface{}) (interface{}, error) {
+		sargs := make([]string, len(cargs))
+		for i, a := range cargs {
+			sargs[i] = memoryArgString(a)
+		}
+		r := memoryToScript(db.exec(sargs))
+		if err, ok := r.(error); ok {
+			return nil, err
+		}
+		return r, nil
+	}
+	r, err := fn(call, keys, argv)
+	if err != nil {
+		return fmt.Errorf("ERR Error running script: %v", err)
+	}
+	return memoryFromScript(r)
+}
+
+func memoryArgString(a interface{}) string {
+	switch v := a.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return memoryFormatFloat(v)
+	case float32:
+		return memoryFormatFloat(float64(v))
+	case time.Duration:
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// memoryToScript 将命令的回复转换为脚本中 call 的返回值，状态回复转换为 string，数组转换为 []interface{}
+func memoryToScript(r interface{}) interface{} {
+	switch v := r.(type) {
+	case memoryStatus:
+		return string(v)
+	case memoryNilArray:
+		return nil
+	case []string:
+		vals := make([]interface{}, len(v))
+		for i, s := range v {
+			vals[i] = s
+		}
+		return vals
+	case []interface{}:
+		vals := make([]interface{}, len(v))
+		for i, e := range v {
+			vals[i] = memoryToScript(e)
+		}
+		return vals
+	default:
+		return r
+	}
+}
+
+// memoryFromScript 将脚本返回值转换为回复，与 lua 相同，true 转换为 1，false 转换为 nil
+func memoryFromScript(r interface{}) interface{} {
+	switch v := r.(type) {
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float64:
+		// lua 中数字返回时截断为整数
+		return int64(v)
+	case []string:
+		return v
+	case []interface{}:
+		vals := make([]interface{}, len(v))
+		for i, e := range v {
+			vals[i] = memoryFromScript(e)
+		}
+		return vals
+	case nil, int64, string, error:
+		return v
+	default:
+		return fmt.Errorf("ERR unsupported script reply type %s", reflect.TypeOf(r))
+	}
+}
+
+func memoryScript(db *memoryDB, args []string) interface{} {
+	switch strings.ToLower(args[0]) {
+	case "load":
+		if len(args) != 2 {
+			return errMemorySyntax
+		}
+		hash := scriptHash(args[1])
+		db.scripts[hash] = true
+		return hash
+	case "exists":
+		vals := make([]interface{}, len(args)-1)
+		for i, hash := range args[1:] {
+			vals[i] = memoryBool(db.scripts[strings.ToLower(hash)])
+		}
+		return vals
+	case "flush":
+		db.scripts = make(map[string]bool)
+		return memoryStatus("OK")
+	default:
+		return errMemorySyntax
+	}
+}
+
+// ---- pubsub ----
+
+func memoryPublish(db *memoryDB, args []string) interface{} {
+	subs := db.channels[args[0]]
+	for conn := range subs {
+		conn.write([]interface{}{"message", args[0], args[1]})
+	}
+	n := int64(len(subs))
+	for pattern, psubs := range db.patterns {
+		if !memoryMatch(pattern, args[0]) {
+			continue
+		}
+		for conn := range psubs {
+			conn.write([]interface{}{"pmessage", pattern, args[0], args[1]})
+			n++
+		}
+	}
+	return n
+}
+
+// memoryMatch redis 的 glob 匹配，支持 * ? [...] 和 \ 转义
+func memoryMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if memoryMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return pattern == s
+			}
+			class := pattern[1 : end+1]
+			negate := strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,736 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errMemoryStreamID    = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errMemoryStreamIDOld = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errMemoryNoGroup     = errors.New("NOGROUP No such key or consumer group")
+	errMemoryBusyGroup   = errors.New("BUSYGROUP Consumer Group name already exists")
+)
+
+// memoryBlockInterval 阻塞读取时检查新消息的间隔
+const memoryBlockInterval = 5 * time.Millisecond
+
+type memoryStreamID struct {
+	ms, seq uint64
+}
+
+func (m memoryStreamID) String() string {
+	return strconv.FormatUint(m.ms, 10) + "-" + strconv.FormatUint(m.seq, 10)
+}
+
+func (m memoryStreamID) less(o memoryStreamID) bool {
+	return m.ms < o.ms || (m.ms == o.ms && m.seq < o.seq)
+}
+
+// parseMemoryStreamID 解析 id，只有毫秒部分时 seq 为 defSeq，- 和 + 为最小和最大的 id
+func parseMemoryStreamID(s string, defSeq uint64) (memoryStreamID, error) {
+	switch s {
+	case "-":
+		return memoryStreamID{}, nil
+	case "+":
+		return memoryStreamID{ms: math.MaxUint64, seq: math.MaxUint64}, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return memoryStreamID{}, errMemoryStreamID
+	}
+	seq := defSeq
+	if len(parts) == 2 {
+		if seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+			return memoryStreamID{}, errMemoryStreamID
+		}
+	}
+	return memoryStreamID{ms: ms, seq: seq}, nil
+}
+
+type memoryStreamEntry struct {
+	id     memoryStreamID
+	fields []string
+}
+
+func (m memoryStreamEntry) reply() []interface{} {
+	return []interface{}{m.id.String(), m.fields}
+}
+
+type memoryPendingEntry struct {
+	consumer  string
+	delivered time.Time
+	count     int64
+}
+
+type memoryStreamGroup struct {
+	lastID    memoryStreamID
+	pending   map[memoryStreamID]*memoryPendingEntry
+	consumers map[string]struct{}
+}
+
+type memoryStream struct {
+	entries []memoryStreamEntry
+	lastID  memoryStreamID
+	groups  map[string]*memoryStreamGroup
+}
+
+func newMemoryStream() *memoryStream {
+	return &memoryStream{groups: make(map[string]*memoryStreamGroup)}
+}
+
+func (m *memoryStream) clone() *memoryStream {
+	c := &memoryStream{
+		entries: append([]memoryStreamEntry(nil), m.entries...),
+		lastID:  m.lastID,
+		groups:  make(map[string]*memoryStreamGroup, len(m.groups)),
+	}
+	for name, g := range m.groups {
+		cg := &memoryStreamGroup{
+			lastID:    g.lastID,
+			pending:   make(map[memoryStreamID]*memoryPendingEntry, len(g.pending)),
+			consumers: make(map[string]struct{}, len(g.consumers)),
+		}
+		for id, p := range g.pending {
+			cp := *p
+			cg.pending[id] = &cp
+		}
+		for c := range g.consumers {
+			cg.consumers[c] = struct{}{}
+		}
+		c.groups[name] = cg
+	}
+	return c
+}
+
+// find 返回 id 对应的消息下标，不存在时返回 -1
+func (m *memoryStream) find(id memoryStreamID) int {
+	i := sort.Search(len(m.entries), func(i int) bool { return !m.entries[i].id.less(id) })
+	if i < len(m.entries) && m.entries[i].id == id {
+		return i
+	}
+	return -1
+}
+
+// after 返回 id 之后的消息，count 为0时不限制
+func (m *memoryStream) after(id memoryStreamID, count int64) []memoryStreamEntry {
+	i := sort.Search(len(m.entries), func(i int) bool { return id.less(m.entries[i].id) })
+	entries := m.entries[i:]
+	if count > 0 && int64(len(entries)) > count {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+func (m *memoryStream) trim(maxLen int64) int64 {
+	n := int64(len(m.entries)) - maxLen
+	if n <= 0 {
+		return 0
+	}
+	m.entries = append([]memoryStreamEntry(nil), m.entries[n:]...)
+	return n
+}
+
+func (m *memoryDB) getStream(key string) (*memoryStream, error) {
+	item, err := m.getKind(key, memoryKindStream)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	return item.stream, nil
+}
+
+func (m *memoryDB) getGroup(key, group string) (*memoryStream, *memoryStreamGroup, error) {
+	s, err := m.getStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s == nil || s.groups[group] == nil {
+		return nil, nil, errMemoryNoGroup
+	}
+	return s, s.groups[group], nil
+}
+
+// memoryMaxLen 解析 MAXLEN [~] n，返回剩余的参数
+func memoryMaxLen(args []string) (int64, []string, error) {
+	if len(args) == 0 || strings.ToLower(args[0]) != "maxlen" {
+		return -1, args, nil
+	}
+	args = args[1:]
+	if len(args) > 0 && (args[0] == "~" || args[0] == "=") {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return 0, nil, errMemorySyntax
+	}
+	n, err := memoryParseInt(args[0])
+	if err != nil || n < 0 {
+		return 0, nil, errMemoryNotInteger
+	}
+	return n, args[1:], nil
+}
+
+func memoryXAdd(db *memoryDB, args []string) interface{} {
+	key := args[0]
+	maxLen, args, err := memoryMaxLen(args[1:])
+	if err != nil {
+		return err
+	}
+	if len(args) < 3 || len(args)%2 != 1 {
+		return errors.New("ERR wrong number of arguments for 'xadd' command")
+	}
+	item, err := db.getKind(key, memoryKindStream)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		item = newMemoryItem(memoryKindStream)
+	}
+	s := item.stream
+
+	var id memoryStreamID
+	if args[0] == "*" {
+		now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		id = memoryStreamID{ms: now}
+		if now <= s.lastID.ms {
+			id = memoryStreamID{ms: s.lastID.ms, seq: s.lastID.seq + 1}
+		}
+	} else {
+		if id, err = parseMemoryStreamID(args[0], 0); err != nil {
+			return err
+		}
+		if !s.lastID.less(id) {
+			return errMemoryStreamIDOld
+		}
+	}
+
+	db.items[key] = item
+	s.entries = append(s.entries, memoryStreamEntry{id: id, fields: append([]string(nil), args[1:]...)})
+	s.lastID = id
+	if maxLen >= 0 {
+		s.trim(maxLen)
+	}
+	return id.String()
+}
+
+func memoryXLen(db *memoryDB, args []string) interface{} {
+	s, err := db.getStream(args[0])
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return int64(0)
+	}
+	return int64(len(s.entries))
+}
+
+func memoryXDel(db *memoryDB, args []string) interface{} {
+	s, err := db.getStream(args[0])
+	if err != nil || s == nil {
+		return memoryZeroOrErr(err)
+	}
+	var n int64
+	for _, arg := range args[1:] {
+		id, err := parseMemoryStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		if i := s.find(id); i >= 0 {
+			s.entries = append(s.entries[:i:i], s.entries[i+1:]...)
+			n++
+		}
+	}
+	return n
+}
+
+func memoryXTrim(db *memoryDB, args []string) interface{} {
+	maxLen, rest, err := memoryMaxLen(args[1:])
+	if err != nil {
+		return err
+	}
+	if maxLen < 0 || len(rest) > 0 {
+		return errMemorySyntax
+	}
+	s, err := db.getStream(args[0])
+	if err != nil || s == nil {
+		return memoryZeroOrErr(err)
+	}
+	return s.trim(maxLen)
+}
+
+func memoryXRange(rev bool) memoryCommand {
+	return func(db *memoryDB, args []string) interface{} {
+		startArg, endArg := args[1], args[2]
+		if rev {
+			startArg, endArg = endArg, startArg
+		}
+		start, err := parseMemoryStreamID(startArg, 0)
+		if err != nil {
+			return err
+		}
+		end, err := parseMemoryStreamID(endArg, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		count := int64(-1)
+		if len(args) == 5 && strings.ToLower(args[3]) == "count" {
+			if count, err = memoryParseInt(args[4]); err != nil {
+				return err
+			}
+		} else if len(args) != 3 {
+			return errMemorySyntax
+		}
+
+		s, err := db.getStream(args[0])
+		if err != nil {
+			return err
+		}
+		replies := []interface{}{}
+		if s == nil {
+			return replies
+		}
+		var entries []memoryStreamEntry
+		for _, e := range s.entries {
+			if !e.id.less(start) && !end.less(e.id) {
+				entries = append(entries, e)
+			}
+		}
+		if rev {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		for _, e := range entries {
+			if count >= 0 && int64(len(replies)) >= count {
+				break
+			}
+			replies = append(replies, e.reply())
+		}
+		return replies
+	}
+}
+
+func memoryXGroup(db *memoryDB, args []string) interface{} {
+	sub := strings.ToLower(args[0])
+	if len(args) < 3 {
+		return fmt.Errorf("ERR wrong number of arguments for 'xgroup|%s' command", sub)
+	}
+	key, group := args[1], args[2]
+	switch sub {
+	case "create":
+		if len(args) < 4 {
+			return errMemorySyntax
+		}
+		s, err := db.getStream(key)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			if len(args) < 5 || strings.ToLower(args[4]) != "mkstream" {
+				return errors.New("ERR The XGROUP subcommand requires the key to exist")
+			}
+			item := newMemoryItem(memoryKindStream)
+			db.items[key] = item
+			s = item.stream
+		}
+		if s.groups[group] != nil {
+			return errMemoryBusyGroup
+		}
+		lastID := s.lastID
+		if args[3] != "$" {
+			if lastID, err = parseMemoryStreamID(args[3], 0); err != nil {
+				return err
+			}
+		}
+		s.groups[group] = &memoryStreamGroup{
+			lastID:    lastID,
+			pending:   make(map[memoryStreamID]*memoryPendingEntry),
+			consumers: make(map[string]struct{}),
+		}
+		return memoryStatus("OK")
+	case "setid":
+		if len(args) < 4 {
+			return errMemorySyntax
+		}
+		s, g, err := db.getGroup(key, group)
+		if err != nil {
+			return err
+		}
+		lastID := s.lastID
+		if args[3] != "$" {
+			if lastID, err = parseMemoryStreamID(args[3], 0); err != nil {
+				return err
+			}
+		}
+		g.lastID = lastID
+		return memoryStatus("OK")
+	case "destroy":
+		s, err := db.getStream(key)
+		if err != nil || s == nil || s.groups[group] == nil {
+			return memoryZeroOrErr(err)
+		}
+		delete(s.groups, group)
+		return int64(1)
+	case "delconsumer":
+		if len(args) < 4 {
+			return errMemorySyntax
+		}
+		_, g, err := db.getGroup(key, group)
+		if err != nil {
+			return err
+		}
+		var n int64
+		for id, p := range g.pending {
+			if p.consumer == args[3] {
+				delete(g.pending, id)
+				n++
+			}
+		}
+		delete(g.consumers, args[3])
+		return n
+	default:
+		return errMemorySyntax
+	}
+}
+
+// memoryReadArgs XREAD 和 XREADGROUP 的参数
+type memoryReadArgs struct {
+	group, consumer string
+	count           int64
+	block           int64
+	noAck           bool
+	keys, ids       []string
+}
+
+func parseMemoryReadArgs(args []string, group bool) (*memoryReadArgs, error) {
+	a := &memoryReadArgs{block: -1}
+	if group {
+		if len(args) < 3 || strings.ToLower(args[0]) != "group" {
+			return nil, errMemorySyntax
+		}
+		a.group, a.consumer = args[1], args[2]
+		args = args[3:]
+	}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "count", "block":
+			if i+1 >= len(args) {
+				return nil, errMemorySyntax
+			}
+			n, err := memoryParseInt(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if strings.ToLower(args[i]) == "count" {
+				a.count = n
+			} else {
+				a.block = n
+			}
+			i++
+		case "noack":
+			a.noAck = true
+		case "streams":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, errors.New("ERR Unbalanced XREAD list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			a.keys, a.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			return a, nil
+		default:
+			return nil, errMemorySyntax
+		}
+	}
+	return nil, errMemorySyntax
+}
+
+func memoryXRead(db *memoryDB, args []string) interface{} {
+	a, err := parseMemoryReadArgs(args, false)
+	if err != nil {
+		return err
+	}
+	var replies []interface{}
+	for i, key := range a.keys {
+		s, err := db.getStream(key)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			continue
+		}
+		var id memoryStreamID
+		if a.ids[i] == "$" {
+			id = s.lastID
+		} else if id, err = parseMemoryStreamID(a.ids[i], 0); err != nil {
+			return err
+		}
+		if entries := s.after(id, a.count); len(entries) > 0 {
+			replies = append(replies, []interface{}{key, memoryEntriesReply(entries)})
+		}
+	}
+	if len(replies) == 0 {
+		return memoryNilArray{}
+	}
+	return replies
+}
+
+func memoryXReadGroup(db *memoryDB, args []string) interface{} {
+	a, err := parseMemoryReadArgs(args, true)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var replies []interface{}
+	for i, key := range a.keys {
+		s, g, err := db.getGroup(key, a.group)
+		if err != nil {
+			return err
+		}
+		g.consumers[a.consumer] = struct{}{}
+
+		if a.ids[i] == ">" {
+			entries := s.after(g.lastID, a.count)
+			if len(entries) == 0 {
+				continue
+			}
+			for _, e := range entries {
+				if !a.noAck {
+					g.pending[e.id] = &memoryPendingEntry{consumer: a.consumer, delivered: now, count: 1}
+				}
+			}
+			g.lastID = entries[len(entries)-1].id
+			replies = append(replies, []interface{}{key, memoryEntriesReply(entries)})
+			continue
+		}
+
+		// 读取 consumer 自己 pending 列表中的消息，不阻塞，没有消息时也返回空列表
+		start, err := parseMemoryStreamID(a.ids[i], 0)
+		if err != nil {
+			return err
+		}
+		ids := memoryPendingIDs(g, a.consumer)
+		entries := []interface{}{}
+		for _, id := range ids {
+			if !start.less(id) {
+				continue
+			}
+			if a.count > 0 && int64(len(entries)) >= a.count {
+				break
+			}
+			p := g.pending[id]
+			p.delivered = now
+			p.count++
+			if j := s.find(id); j >= 0 {
+				entries = append(entries, s.entries[j].reply())
+			} else {
+				entries = append(entries, []interface{}{id.String(), nil})
+			}
+		}
+		replies = append(replies, []interface{}{key, entries})
+	}
+	if len(replies) == 0 {
+		return memoryNilArray{}
+	}
+	return replies
+}
+
+func memoryEntriesReply(entries []memoryStreamEntry) []interface{} {
+	replies := make([]interface{}, len(entries))
+	for i, e := range entries {
+		replies[i] = e.reply()
+	}
+	return replies
+}
+
+// memoryPendingIDs 返回 consumer 的 pending 消息 id，consumer 为空时返回所有的，按照 id 升序
+func memoryPendingIDs(g *memoryStreamGroup, consumer string) []memoryStreamID {
+	var ids []memoryStreamID
+	for id, p := range g.pending {
+		if consumer == "" || p.consumer == consumer {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+func memoryXAck(db *memoryDB, args []string) interface{} {
+	s, err := db.getStream(args[0])
+	if err != nil || s == nil || s.groups[args[1]] == nil {
+		return memoryZeroOrErr(err)
+	}
+	g := s.groups[args[1]]
+	var n int64
+	for _, arg := range args[2:] {
+		id, err := parseMemoryStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		if g.pending[id] != nil {
+			delete(g.pending, id)
+			n++
+		}
+	}
+	return n
+}
+
+func memoryXPending(db *memoryDB, args []string) interface{} {
+	_, g, err := db.getGroup(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		ids := memoryPendingIDs(g, "")
+		if len(ids) == 0 {
+			return []interface{}{int64(0), nil, nil, memoryNilArray{}}
+		}
+		counts := make(map[string]int64)
+		for _, p := range g.pending {
+			counts[p.consumer]++
+		}
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		consumers := make([]interface{}, len(names))
+		for i, name := range names {
+			consumers[i] = []interface{}{name, strconv.FormatInt(counts[name], 10)}
+		}
+		return []interface{}{int64(len(ids)), ids[0].String(), ids[len(ids)-1].String(), consumers}
+	}
+
+	if len(args) != 5 && len(args) != 6 {
+		return errMemorySyntax
+	}
+	start, err := parseMemoryStreamID(args[2], 0)
+	if err != nil {
+		return err
+	}
+	end, err := parseMemoryStreamID(args[3], math.MaxUint64)
+	if err != nil {
+		return err
+	}
+	count, err := memoryParseInt(args[4])
+	if err != nil {
+		return err
+	}
+	consumer := ""
+	if len(args) == 6 {
+		consumer = args[5]
+	}
+	now := time.Now()
+	replies := []interface{}{}
+	for _, id := range memoryPendingIDs(g, consumer) {
+		if id.less(start) || end.less(id) {
+			continue
+		}
+		if int64(len(replies)) >= count {
+			break
+		}
+		p := g.pending[id]
+		idle := int64(now.Sub(p.delivered) / time.Millisecond)
+		replies = append(replies, []interface{}{id.String(), p.consumer, idle, p.count})
+	}
+	return replies
+}
+
+// memoryXClaim 只支持 JUSTID 选项，已经删除的消息从 pending 列表中移除
+func memoryXClaim(db *memoryDB, args []string) interface{} {
+	s, g, err := db.getGroup(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	consumer := args[2]
+	minIdle, err := memoryParseInt(args[3])
+	if err != nil {
+		return err
+	}
+	justID := false
+	var ids []memoryStreamID
+	for _, arg := range args[4:] {
+		if strings.ToLower(arg) == "justid" {
+			justID = true
+			continue
+		}
+		id, err := parseMemoryStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now()
+	g.consumers[consumer] = struct{}{}
+	replies := []interface{}{}
+	for _, id := range ids {
+		p := g.pending[id]
+		if p == nil || now.Sub(p.delivered) < time.Duration(minIdle)*time.Millisecond {
+			continue
+		}
+		j := s.find(id)
+		if j < 0 {
+			delete(g.pending, id)
+			continue
+		}
+		p.consumer = consumer
+		p.delivered = now
+		if justID {
+			replies = append(replies, id.String())
+			continue
+		}
+		p.count++
+		replies = append(replies, s.entries[j].reply())
+	}
+	return replies
+}
+
+// blockingRead 带 BLOCK 参数的 XREAD 和 XREADGROUP 在没有消息时轮询，直到有消息或者超时，轮询期间不持有锁
+func (m *memoryConn) blockingRead(args []string) interface{} {
+	name := strings.ToLower(args[0])
+	a, err := parseMemoryReadArgs(args[1:], name == "xreadgroup")
+	if err != nil {
+		return err
+	}
+
+	m.db.mu.Lock()
+	if a.block >= 0 && name == "xread" {
+		// NOTE: $ 表示调用时最新的 id，轮询前先固定下来
+		for i, key := range a.keys {
+			if a.ids[i] != "$" {
+				continue
+			}
+			var last memoryStreamID
+			if s, err := m.db.getStream(key); err == nil && s != nil {
+				last = s.lastID
+			}
+			args[len(args)-len(a.ids)+i] = last.String()
+		}
+	}
+	r := m.db.exec(args)
+	m.db.mu.Unlock()
+	if a.block < 0 {
+		return r
+	}
+
+	var deadline time.Time
+	if a.block > 0 {
+		deadline = time.Now().Add(time.Duration(a.block) * time.Millisecond)
+	}
+	for {
+		if _, ok := r.(memoryNilArray); !ok {
+			return r
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return r
+		}
+		time.Sleep(memoryBlockInterval)
+		m.db.mu.Lock()
+		r = m.db.exec(args)
+		m.db.mu.Unlock()
+	}
+}
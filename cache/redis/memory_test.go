@@ -0,0 +1,233 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/shawnfeng/sutil/cache/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemoryTestClient(t *testing.T) (*redis.Client, func()) {
+	server, err := newMemoryServer()
+	assert.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: server.addr()})
+	return client, func() {
+		client.Close()
+		server.close()
+	}
+}
+
+func TestMemoryStrings(t *testing.T) {
+	client, closer := newMemoryTestClient(t)
+	defer closer()
+
+	assert.NoError(t, client.Set("a", "1", 0).Err())
+	assert.Equal(t, "1", client.Get("a").Val())
+	assert.Equal(t, redis.Nil, client.Get("b").Err())
+	assert.False(t, client.SetNX("a", "2", time.Second).Val())
+	assert.Equal(t, int64(3), client.IncrBy("a", 2).Val())
+	assert.Equal(t, []interface{}{"3", nil}, client.MGet("a", "b").Val())
+	assert.Equal(t, "string", client.Type("a").Val())
+
+	assert.NoError(t, client.Set("c", "x", 50*time.Millisecond).Err())
+	assert.True(t, client.PTTL("c").Val() > 0)
+	assert.Equal(t, -time.Second, client.TTL("a").Val())
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, int64(0), client.Exists("c").Val())
+
+	assert.Equal(t, int64(0), client.SetBit("bits", 7, 1).Val())
+	assert.Equal(t, int64(1), client.GetBit("bits", 7).Val())
+	assert.Equal(t, int64(1), client.BitCount("bits", nil).Val())
+
+	assert.WithinDuration(t, time.Now(), client.Time().Val(), time.Second)
+}
+
+func TestMemoryCollections(t *testing.T) {
+	client, closer := newMemoryTestClient(t)
+	defer closer()
+
+	assert.NoError(t, client.HMSet("h", map[string]interface{}{"f1": "v1", "f2": 2}).Err())
+	assert.Equal(t, map[string]string{"f1": "v1", "f2": "2"}, client.HGetAll("h").Val())
+	assert.Equal(t, int64(5), client.HIncrBy("h", "f2", 3).Val())
+	assert.Equal(t, redis.Nil, client.HGet("h", "f3").Err())
+	assert.Error(t, client.Get("h").Err())
+
+	client.RPush("l", "a", "b", "c")
+	assert.Equal(t, []string{"b", "c"}, client.LRange("l", 1, -1).Val())
+	assert.Equal(t, "a", client.LPop("l").Val())
+
+	client.SAdd("s", "x", "y", "z")
+	assert.True(t, client.SIsMember("s", "y").Val())
+	assert.Equal(t, []string{"x", "y", "z"}, client.SMembers("s").Val())
+
+	client.ZAdd("z", redis.Z{Score: 2, Member: "b"}, redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 3, Member: "c"})
+	assert.Equal(t, []string{"a", "b", "c"}, client.ZRange("z", 0, -1).Val())
+	assert.Equal(t, []string{"c", "b"}, client.ZRevRangeByScore("z", redis.ZRangeBy{Min: "(1", Max: "+inf"}).Val())
+	assert.Equal(t, int64(1), client.ZRank("z", "b").Val())
+	assert.Equal(t, float64(3), client.ZScore("z", "c").Val())
+	assert.Equal(t, []redis.Z{{Score: 1, Member: "a"}}, client.ZRangeWithScores("z", 0, 0).Val())
+
+	keys, cursor, err := client.Scan(0, "[hl]", 10).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), cursor)
+	assert.Equal(t, []string{"h", "l"}, keys)
+}
+
+func TestMemoryPipelineAndTx(t *testing.T) {
+	client, closer := newMemoryTestClient(t)
+	defer closer()
+
+	pipe := client.Pipeline()
+	set := pipe.Set("k", "v", time.Minute)
+	get := pipe.Get("k")
+	ttl := pipe.TTL("k")
+	_, err := pipe.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, set.Err())
+	assert.Equal(t, "v", get.Val())
+	assert.Equal(t, time.Minute, ttl.Val())
+
+	err = client.Watch(func(tx *redis.Tx) error {
+		_, err := tx.Pipelined(func(p redis.Pipeliner) error {
+			p.Incr("n")
+			p.Incr("n")
+			return nil
+		})
+		return err
+	}, "n")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", client.Get("n").Val())
+
+	err = client.Watch(func(tx *redis.Tx) error {
+		client.Incr("n")
+		_, err := tx.Pipelined(func(p redis.Pipeliner) error {
+			p.Incr("n")
+			return nil
+		})
+		return err
+	}, "n")
+	assert.Equal(t, redis.TxFailedErr, err)
+}
+
+func TestMemoryScript(t *testing.T) {
+	client, closer := newMemoryTestClient(t)
+	defer closer()
+
+	src := "return redis.call('incrby', KEYS[1], ARGV[1])"
+	assert.Error(t, client.Eval(src, []string{"n"}, 2).Err())
+
+	RegisterMemoryScript(src, func(call func(args ...interface{}) (interface{}, error), keys, argv []string) (interface{}, error) {
+		return call("INCRBY", keys[0], argv[0])
+	})
+	assert.Equal(t, int64(2), client.Eval(src, []string{"n"}, 2).Val())
+	assert.Equal(t, int64(4), client.EvalSha(scriptHash(src), []string{"n"}, 2).Val())
+	assert.Error(t, client.EvalSha(scriptHash("return 1"), nil).Err())
+}
+
+func TestMemoryStream(t *testing.T) {
+	client, closer := newMemoryTestClient(t)
+	defer closer()
+
+	id1 := client.XAdd(&redis.XAddArgs{Stream: "s", ID: "1-1", Values: map[string]interface{}{"a": "1"}}).Val()
+	assert.Equal(t, "1-1", id1)
+	assert.Error(t, client.XAdd(&redis.XAddArgs{Stream: "s", ID: "1-0", Values: map[string]interface{}{"a": "0"}}).Err())
+	id2 := client.XAdd(&redis.XAddArgs{Stream: "s", Values: map[string]interface{}{"a": "2"}}).Val()
+	assert.Equal(t, int64(2), client.XLen("s").Val())
+	assert.Equal(t, "stream", client.Type("s").Val())
+	ms := client.XRange("s", "-", "+").Val()
+	assert.Equal(t, 2, len(ms))
+	assert.Equal(t, "2", ms[1].Values["a"])
+
+	assert.NoError(t, client.XGroupCreate("s", "g", "0").Err())
+	assert.Error(t, client.XGroupCreate("s", "g", "0").Err())
+	ss, err := client.XReadGroup(&redis.XReadGroupArgs{Group: "g", Consumer: "c1", Streams: []string{"s", ">"}, Count: 1}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, id1, ss[0].Messages[0].ID)
+
+	pending := client.XPending("s", "g").Val()
+	assert.Equal(t, int64(1), pending.Count)
+	assert.Equal(t, int64(1), pending.Consumers["c1"])
+	ids := client.XClaimJustID(&redis.XClaimArgs{Stream: "s", Group: "g", Consumer: "c2", Messages: []string{id1}}).Val()
+	assert.Equal(t, []string{id1}, ids)
+	ext := client.XPendingExt(&redis.XPendingExtArgs{Stream: "s", Group: "g", Start: "-", End: "+", Count: 10}).Val()
+	assert.Equal(t, "c2", ext[0].Consumer)
+	assert.Equal(t, int64(1), client.XAck("s", "g", id1).Val())
+
+	ss, err = client.XReadGroup(&redis.XReadGroupArgs{Group: "g", Consumer: "c1", Streams: []string{"s", ">"}, Count: 10, Block: time.Second}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, id2, ss[0].Messages[0].ID)
+
+	// 阻塞读取在超时前收到新消息
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		client.XAdd(&redis.XAddArgs{Stream: "s", Values: map[string]interface{}{"a": "3"}})
+	}()
+	ss, err = client.XReadGroup(&redis.XReadGroupArgs{Group: "g", Consumer: "c1", Streams: []string{"s", ">"}, Block: time.Second}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "3", ss[0].Messages[0].Values["a"])
+	_, err = client.XReadGroup(&redis.XReadGroupArgs{Group: "g", Consumer: "c1", Streams: []string{"s", ">"}, Block: 10 * time.Millisecond}).Result()
+	assert.Equal(t, redis.Nil, err)
+
+	assert.Equal(t, int64(2), client.XTrimApprox("s", 1).Val())
+	assert.Equal(t, int64(1), client.XLen("s").Val())
+}
+
+func TestMemoryPubSub(t *testing.T) {
+	client, closer := newMemoryTestClient(t)
+	defer closer()
+
+	pubsub := client.Subscribe("ch")
+	defer pubsub.Close()
+	_, err := pubsub.Receive()
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(1), client.Publish("ch", "hello").Val())
+	msg, err := pubsub.ReceiveMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", msg.Payload)
+
+	psub := client.PSubscribe("c*")
+	defer psub.Close()
+	_, err = psub.Receive()
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(2), client.Publish("ch", "world").Val())
+	msg, err = psub.ReceiveMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "c*", msg.Pattern)
+	assert.Equal(t, "ch", msg.Channel)
+	assert.Equal(t, "world", msg.Payload)
+}
+
+func TestMemoryConfiger(t *testing.T) {
+	ctx := context.Background()
+	configer, err := NewConfiger(constants.ConfigerTypeMemory)
+	assert.NoError(t, err)
+	assert.NoError(t, configer.Init(ctx))
+	defer configer.(*MemoryConfig).Close()
+
+	old := DefaultConfiger
+	DefaultConfiger = configer
+	defer func() { DefaultConfiger = old }()
+
+	client, err := NewClient(ctx, "test/memory", "")
+	assert.NoError(t, err)
+	defer client.Close(ctx)
+	assert.NoError(t, client.Set(ctx, "k", "v", 0).Err())
+	assert.Equal(t, "v", client.Get(ctx, "k").Val())
+	configer.(*MemoryConfig).Flush()
+	assert.Equal(t, redis.Nil, client.Get(ctx, "k").Err())
+}
+
+func TestMemoryMatch(t *testing.T) {
+	assert.True(t, memoryMatch("*", "base/report.a"))
+	assert.True(t, memoryMatch("base/*.a", "base/report.a"))
+	assert.True(t, memoryMatch("h?llo", "hello"))
+	assert.True(t, memoryMatch("h[a-e]llo", "hello"))
+	assert.False(t, memoryMatch("h[^e]llo", "hello"))
+	assert.True(t, memoryMatch(`a\*`, "a*"))
+	assert.False(t, memoryMatch("a*b", "acd"))
+}
@@ -8,6 +8,8 @@ type options struct {
 	noFixKey      bool
 	// if true key => #{namespace.wrapper.key} else key => #{namespace.key}
 	useWrapper bool
+	// 不为nil时覆盖配置中的热 key 和大 key 采样配置
+	keyStats *keyStatsConfig
 }
 
 type Option interface {
@@ -43,3 +45,21 @@ func (c useWrapperOption) apply(opts *options) {
 func WithUseWrapper(n bool) Option {
 	return useWrapperOption(n)
 }
+
+type keyStatsOption keyStatsConfig
+
+func (c keyStatsOption) apply(opts *options) {
+	config := keyStatsConfig(c)
+	opts.keyStats = &config
+}
+
+// WithKeyStats 开启热 key 和大 key 统计，sampleRate 为访问的采样比例，每分钟上报访问次数最多的 topK 个 key，
+// 估计访问次数不小于 hotThreshold 或者值的大小不小于 bigKeyBytes 时打印告警，参数为0时关闭对应的统计
+func WithKeyStats(sampleRate float64, topK int, hotThreshold int64, bigKeyBytes int) Option {
+	return keyStatsOption{
+		sampleRate:   sampleRate,
+		topK:         topK,
+		hotThreshold: hotThreshold,
+		bigKeyBytes:  bigKeyBytes,
+	}
+}
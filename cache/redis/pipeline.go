@@ -8,13 +8,182 @@ import (
 	"github.com/opentracing/opentracing-go/log"
 	"github.com/shawnfeng/sutil/cache/constants"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Pipeline cluster 模式下按照 key 所在的节点分别执行，MGet、MSet 和 Del 按照 slot 拆分，不同 slot 之间不保证原子性
 type Pipeline struct {
 	namespace  string
 	pipeline   redis.Pipeliner
 	opts       *options
+	// 分片模式下每个分片一个 pipeline，order 记录每个命令所在的分片，非分片模式时为nil
+	shards    *shardSet
+	pipelines []redis.Pipeliner
+	order     []int
+	keyStats  *keyStats
+	// cluster 模式下多 key 命令需要按照 slot 拆分
+	cluster bool
+	// 被拆分的多 key 命令，Exec 后合并子命令的结果
+	merges []pipelineMerge
+}
+
+// pipelineMerge 多 key 命令拆分为从 start 开始的 n 个子命令，Exec 后由 merge 将结果写入 cmd
+type pipelineMerge struct {
+	start int
+	n     int
+	cmd   redis.Cmder
+	merge func()
+}
+
+// route 返回 key 所在分片的 pipeline，order 记录每个加入的命令所在的分片，非分片模式时都为0
+func (m *Pipeline) route(key string) redis.Pipeliner {
+	m.keyStats.access(key)
+	if m.shards == nil {
+		m.order = append(m.order, 0)
+		return m.pipeline
+	}
+	idx := m.shards.ring.index(key)
+	if m.pipelines[idx] == nil {
+		m.pipelines[idx] = m.shards.shards[idx].client.Pipeline()
+	}
+	m.order = append(m.order, idx)
+	return m.pipelines[idx]
+}
+
+// splitKeys 多 key 命令在 cluster 模式下按照 slot 拆分，在分片模式下按照分片拆分，只有一组时不需要拆分
+func (m *Pipeline) splitKeys(fixKeys []string) ([][]int, bool) {
+	var groups [][]int
+	switch {
+	case m.shards != nil:
+		groups = groupKeys(fixKeys, m.shards.ring.index)
+	case m.cluster:
+		groups = groupBySlot(fixKeys)
+	default:
+		return nil, false
+	}
+	return groups, len(groups) > 1
+}
+
+func (m *Pipeline) addMerge(start int, cmd redis.Cmder, merge func()) {
+	m.merges = append(m.merges, pipelineMerge{
+		start: start,
+		n:     len(m.order) - start,
+		cmd:   cmd,
+		merge: merge,
+	})
+}
+
+// splitMGet 每组 key 加入一个 MGET，Exec 后按照 keys 的顺序合并结果
+func (m *Pipeline) splitMGet(fixKeys []string, groups [][]int) *redis.SliceCmd {
+	args := make([]interface{}, 0, len(fixKeys)+1)
+	args = append(args, "mget")
+	for _, key := range fixKeys {
+		args = append(args, key)
+	}
+	cmd := redis.NewSliceCmd(args...)
+	start := len(m.order)
+	subs := make([]*redis.SliceCmd, len(groups))
+	for i, group := range groups {
+		groupKeys := pickKeys(fixKeys, group)
+		subs[i] = m.route(groupKeys[0]).MGet(groupKeys...)
+	}
+	m.addMerge(start, cmd, func() {
+		vals := make([]interface{}, len(fixKeys))
+		for i, group := range groups {
+			r, err := subs[i].Result()
+			if err != nil {
+				*cmd = *redis.NewSliceResult(nil, err)
+				return
+			}
+			for j, idx := range group {
+				vals[idx] = r[j]
+			}
+		}
+		*cmd = *redis.NewSliceResult(vals, nil)
+	})
+	return cmd
+}
+
+// splitMSet 每组 key 加入一个 MSET，不同的组之间不保证原子性
+func (m *Pipeline) splitMSet(fixPairs []interface{}, groups [][]int) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(append([]interface{}{"mset"}, fixPairs...)...)
+	start := len(m.order)
+	subs := make([]*redis.StatusCmd, len(groups))
+	for i, group := range groups {
+		groupPairs := make([]interface{}, 0, 2*len(group))
+		for _, idx := range group {
+			groupPairs = append(groupPairs, fixPairs[2*idx], fixPairs[2*idx+1])
+		}
+		key, _ := groupPairs[0].(string)
+		subs[i] = m.route(key).MSet(groupPairs...)
+	}
+	m.addMerge(start, cmd, func() {
+		var status string
+		for _, sub := range subs {
+			r, err := sub.Result()
+			if err != nil {
+				*cmd = *redis.NewStatusResult("", err)
+				return
+			}
+			status = r
+		}
+		*cmd = *redis.NewStatusResult(status, nil)
+	})
+	return cmd
+}
+
+// splitDel 每组 key 加入一个 DEL，Exec 后返回删除的总数
+func (m *Pipeline) splitDel(fixKeys []string, groups [][]int) *redis.IntCmd {
+	args := make([]interface{}, 0, len(fixKeys)+1)
+	args = append(args, "del")
+	for _, key := range fixKeys {
+		args = append(args, key)
+	}
+	cmd := redis.NewIntCmd(args...)
+	start := len(m.order)
+	subs := make([]*redis.IntCmd, len(groups))
+	for i, group := range groups {
+		groupKeys := pickKeys(fixKeys, group)
+		subs[i] = m.route(groupKeys[0]).Del(groupKeys...)
+	}
+	m.addMerge(start, cmd, func() {
+		var n int64
+		for _, sub := range subs {
+			r, err := sub.Result()
+			if err != nil {
+				*cmd = *redis.NewIntResult(n, err)
+				return
+			}
+			n += r
+		}
+		*cmd = *redis.NewIntResult(n, nil)
+	})
+	return cmd
+}
+
+// mergeCmds 将拆分的子命令替换为合并后的命令，cmds 与 order 一一对应，nil 表示没有结果
+func (m *Pipeline) mergeCmds(cmds []redis.Cmder) []redis.Cmder {
+	if len(cmds) != len(m.order) {
+		return cmds
+	}
+	merged := make([]redis.Cmder, 0, len(cmds))
+	pos := 0
+	for _, g := range m.merges {
+		merged = append(merged, cmds[pos:g.start]...)
+		g.merge()
+		merged = append(merged, g.cmd)
+		pos = g.start + g.n
+	}
+	merged = append(merged, cmds[pos:]...)
+
+	result := merged[:0]
+	for _, cmd := range merged {
+		if cmd != nil {
+			result = append(result, cmd)
+		}
+	}
+	return result
 }
 
 func (m *Pipeline) fixKey(key string) string {
@@ -48,7 +217,7 @@ func (m *Pipeline) logSpan(ctx context.Context, op, key string) {
 func (m *Pipeline) Get(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.Get", k)
-	return m.pipeline.Get(k)
+	return m.route(k).Get(k)
 }
 
 func (m *Pipeline) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
@@ -58,13 +227,16 @@ func (m *Pipeline) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
 		fixKeys[k] = key
 	}
 	m.logSpan(ctx, "Pipeline.MGet", strings.Join(fixKeys, "||"))
-	return m.pipeline.MGet(fixKeys...)
+	if groups, ok := m.splitKeys(fixKeys); ok {
+		return m.splitMGet(fixKeys, groups)
+	}
+	return m.route(firstKey(fixKeys)).MGet(fixKeys...)
 }
 
 func (m *Pipeline) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.Set", k)
-	return m.pipeline.Set(k, value, expiration)
+	return m.route(k).Set(k, value, expiration)
 }
 
 func (m *Pipeline) MSet(ctx context.Context, pairs ...interface{}) *redis.StatusCmd {
@@ -80,25 +252,28 @@ func (m *Pipeline) MSet(ctx context.Context, pairs ...interface{}) *redis.Status
 		}
 	}
 	m.logSpan(ctx, "Pipeline.MSet", strings.Join(keys, "||"))
-	return m.pipeline.MSet(fixPairs...)
+	if groups, ok := m.splitKeys(keys); ok {
+		return m.splitMSet(fixPairs, groups)
+	}
+	return m.route(firstKey(keys)).MSet(fixPairs...)
 }
 
 func (m *Pipeline) GetBit(ctx context.Context, key string, offset int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.GetBit", k)
-	return m.pipeline.GetBit(k, offset)
+	return m.route(k).GetBit(k, offset)
 }
 
 func (m *Pipeline) SetBit(ctx context.Context, key string, offset int64, value int) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.SetBit", k)
-	return m.pipeline.SetBit(k, offset, value)
+	return m.route(k).SetBit(k, offset, value)
 }
 
 func (m *Pipeline) Exists(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Exists", k)
-	return m.pipeline.Exists(k)
+	return m.route(k).Exists(k)
 }
 
 func (m *Pipeline) Del(ctx context.Context, keys ...string) *redis.IntCmd {
@@ -108,349 +283,564 @@ func (m *Pipeline) Del(ctx context.Context, keys ...string) *redis.IntCmd {
 	}
 
 	m.logSpan(ctx, "Pipeline.Del", strings.Join(tkeys, ","))
-	return m.pipeline.Del(tkeys...)
+	if groups, ok := m.splitKeys(tkeys); ok {
+		return m.splitDel(tkeys, groups)
+	}
+	return m.route(firstKey(tkeys)).Del(tkeys...)
 }
 
 func (m *Pipeline) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.Expire", k)
-	return m.pipeline.Expire(k, expiration)
+	return m.route(k).Expire(k, expiration)
 }
 
 func (m *Pipeline) Incr(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.Incr", k)
-	return m.pipeline.Incr(k)
+	return m.route(k).Incr(k)
 }
 
 func (m *Pipeline) IncrBy(ctx context.Context, key string, value int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.IncrBy", k)
-	return m.pipeline.IncrBy(k, value)
+	return m.route(k).IncrBy(k, value)
 }
 
 func (m *Pipeline) Decr(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.Decr", k)
-	return m.pipeline.Decr(k)
+	return m.route(k).Decr(k)
 }
 
 func (m *Pipeline) DecrBy(ctx context.Context, key string, value int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.DecrBy", k)
-	return m.pipeline.DecrBy(k, value)
+	return m.route(k).DecrBy(k, value)
 }
 
 func (m *Pipeline) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.SetNX", k)
-	return m.pipeline.SetNX(k, value, expiration)
+	return m.route(k).SetNX(k, value, expiration)
 }
 
 func (m *Pipeline) HSet(ctx context.Context, key string, field string, value interface{}) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HSet", k)
-	return m.pipeline.HSet(k, field, value)
+	return m.route(k).HSet(k, field, value)
 }
 
 func (m *Pipeline) HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HDel", k)
-	return m.pipeline.HDel(k, fields...)
+	return m.route(k).HDel(k, fields...)
 }
 
 func (m *Pipeline) HExists(ctx context.Context, key string, field string) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HExists", k)
-	return m.pipeline.HExists(k, field)
+	return m.route(k).HExists(k, field)
 }
 
 func (m *Pipeline) HGet(ctx context.Context, key string, field string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HGet", k)
-	return m.pipeline.HGet(k, field)
+	return m.route(k).HGet(k, field)
 }
 
 func (m *Pipeline) HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HGetAll", k)
-	return m.pipeline.HGetAll(k)
+	return m.route(k).HGetAll(k)
 }
 
 func (m *Pipeline) HIncrBy(ctx context.Context, key string, field string, incr int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HIncrBy", k)
-	return m.pipeline.HIncrBy(k, field, incr)
+	return m.route(k).HIncrBy(k, field, incr)
 }
 
 func (m *Pipeline) HIncrByFloat(ctx context.Context, key string, field string, incr float64) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HIncrByFloat", k)
-	return m.pipeline.HIncrByFloat(k, field, incr)
+	return m.route(k).HIncrByFloat(k, field, incr)
 }
 
 func (m *Pipeline) HKeys(ctx context.Context, key string) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HKeys", k)
-	return m.pipeline.HKeys(k)
+	return m.route(k).HKeys(k)
 }
 
 func (m *Pipeline) HLen(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HLen", k)
-	return m.pipeline.HLen(k)
+	return m.route(k).HLen(k)
 }
 
 func (m *Pipeline) HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HMGet", k)
-	return m.pipeline.HMGet(k, fields...)
+	return m.route(k).HMGet(k, fields...)
 }
 
 func (m *Pipeline) HMSet(ctx context.Context, key string, fields map[string]interface{}) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HMSet", k)
-	return m.pipeline.HMSet(k, fields)
+	return m.route(k).HMSet(k, fields)
 }
 
 func (m *Pipeline) HSetNX(ctx context.Context, key string, field string, val interface{}) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HSetNX", k)
-	return m.pipeline.HSetNX(k, field, val)
+	return m.route(k).HSetNX(k, field, val)
 }
 
 func (m *Pipeline) HVals(ctx context.Context, key string) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.HVals", k)
-	return m.pipeline.HVals(k)
+	return m.route(k).HVals(k)
 }
 
 func (m *Pipeline) ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZAdd", k)
-	return m.pipeline.ZAdd(k, members...)
+	return m.route(k).ZAdd(k, members...)
 }
 
 func (m *Pipeline) ZAddNX(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZAddNX", k)
-	return m.pipeline.ZAddNX(k, members...)
+	return m.route(k).ZAddNX(k, members...)
 }
 
 func (m *Pipeline) ZAddNXCh(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZAddNXCh", k)
-	return m.pipeline.ZAddNXCh(k, members...)
+	return m.route(k).ZAddNXCh(k, members...)
 }
 
 func (m *Pipeline) ZAddXX(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZAddXX", k)
-	return m.pipeline.ZAddXX(k, members...)
+	return m.route(k).ZAddXX(k, members...)
 }
 
 func (m *Pipeline) ZAddXXCh(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZAddXXCh", k)
-	return m.pipeline.ZAddXXCh(k, members...)
+	return m.route(k).ZAddXXCh(k, members...)
 }
 
 func (m *Pipeline) ZAddCh(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZAddCh", k)
-	return m.pipeline.ZAddCh(k, members...)
+	return m.route(k).ZAddCh(k, members...)
 }
 
 func (m *Pipeline) ZCard(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZCard", k)
-	return m.pipeline.ZCard(k)
+	return m.route(k).ZCard(k)
 }
 
 func (m *Pipeline) ZCount(ctx context.Context, key, min, max string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZCount", k)
-	return m.pipeline.ZCount(k, min, max)
+	return m.route(k).ZCount(k, min, max)
 }
 
 func (m *Pipeline) ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRange", k)
-	return m.pipeline.ZRange(k, start, stop)
+	return m.route(k).ZRange(k, start, stop)
 }
 
 func (m *Pipeline) ZRangeByLex(ctx context.Context, key string, by redis.ZRangeBy) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRangeByLex", k)
-	return m.pipeline.ZRangeByLex(k, by)
+	return m.route(k).ZRangeByLex(k, by)
 }
 
 func (m *Pipeline) ZRangeByScore(ctx context.Context, key string, by redis.ZRangeBy) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRangeByScore", k)
-	return m.pipeline.ZRangeByScore(k, by)
+	return m.route(k).ZRangeByScore(k, by)
 }
 
 func (m *Pipeline) ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRangeWithScores", k)
-	return m.pipeline.ZRangeWithScores(k, start, stop)
+	return m.route(k).ZRangeWithScores(k, start, stop)
 }
 
 func (m *Pipeline) ZRevRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRevRange", k)
-	return m.pipeline.ZRevRange(k, start, stop)
+	return m.route(k).ZRevRange(k, start, stop)
 }
 
 func (m *Pipeline) ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRevRangeWithScores", k)
-	return m.pipeline.ZRevRangeWithScores(k, start, stop)
+	return m.route(k).ZRevRangeWithScores(k, start, stop)
 }
 
 func (m *Pipeline) ZRank(ctx context.Context, key string, member string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRank", k)
-	return m.pipeline.ZRank(k, member)
+	return m.route(k).ZRank(k, member)
 }
 
 func (m *Pipeline) ZRevRank(ctx context.Context, key string, member string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRevRank", k)
-	return m.pipeline.ZRevRank(k, member)
+	return m.route(k).ZRevRank(k, member)
 }
 
 func (m *Pipeline) ZRem(ctx context.Context, key string, members []interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZRem", k)
-	return m.pipeline.ZRem(k, members...)
+	return m.route(k).ZRem(k, members...)
 }
 
 func (m *Pipeline) ZIncr(ctx context.Context, key string, member redis.Z) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZIncr", k)
-	return m.pipeline.ZIncr(k, member)
+	return m.route(k).ZIncr(k, member)
 }
 
 func (m *Pipeline) ZIncrNX(ctx context.Context, key string, member redis.Z) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZIncrNX", k)
-	return m.pipeline.ZIncrNX(k, member)
+	return m.route(k).ZIncrNX(k, member)
 }
 
 func (m *Pipeline) ZIncrXX(ctx context.Context, key string, member redis.Z) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZIncrXX", k)
-	return m.pipeline.ZIncrXX(k, member)
+	return m.route(k).ZIncrXX(k, member)
 }
 
 func (m *Pipeline) ZIncrBy(ctx context.Context, key string, increment float64, member string) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZIncrBy", k)
-	return m.pipeline.ZIncrBy(k, increment, member)
+	return m.route(k).ZIncrBy(k, increment, member)
 }
 
 func (m *Pipeline) ZScore(ctx context.Context, key string, member string) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.ZScore", k)
-	return m.pipeline.ZScore(k, member)
+	return m.route(k).ZScore(k, member)
 }
 
 func (m *Pipeline) LIndex(ctx context.Context, key string, index int64) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LIndex", k)
-	return m.pipeline.LIndex(k, index)
+	return m.route(k).LIndex(k, index)
 }
 
 func (m *Pipeline) LInsert(ctx context.Context, key, op string, pivot, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LInsert", k)
-	return m.pipeline.LInsert(k, op, pivot, value)
+	return m.route(k).LInsert(k, op, pivot, value)
 }
 
 func (m *Pipeline) LLen(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LLen", k)
-	return m.pipeline.LLen(k)
+	return m.route(k).LLen(k)
 }
 
 func (m *Pipeline) LPop(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LPop", k)
-	return m.pipeline.LPop(k)
+	return m.route(k).LPop(k)
 }
 
 func (m *Pipeline) LPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LPush", k)
-	return m.pipeline.LPush(k, values...)
+	return m.route(k).LPush(k, values...)
 }
 
 func (m *Pipeline) LPushX(ctx context.Context, key string, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LPushX", k)
-	return m.pipeline.LPushX(k, value)
+	return m.route(k).LPushX(k, value)
 }
 
 func (m *Pipeline) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LRange", k)
-	return m.pipeline.LRange(k, start, stop)
+	return m.route(k).LRange(k, start, stop)
 }
 
 func (m *Pipeline) LRem(ctx context.Context, key string, count int64, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LRem", k)
-	return m.pipeline.LRem(k, count, value)
+	return m.route(k).LRem(k, count, value)
 }
 
 func (m *Pipeline) LSet(ctx context.Context, key string, index int64, value interface{}) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LSet", k)
-	return m.pipeline.LSet(k, index, value)
+	return m.route(k).LSet(k, index, value)
 }
 
 func (m *Pipeline) LTrim(ctx context.Context, key string, start, stop int64) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.LTrim", k)
-	return m.pipeline.LTrim(k, start, stop)
+	return m.route(k).LTrim(k, start, stop)
 }
 
 func (m *Pipeline) RPop(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.RPop", k)
-	return m.pipeline.RPop(k)
+	return m.route(k).RPop(k)
 }
 
 func (m *Pipeline) RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.RPush", k)
-	return m.pipeline.RPush(k, values...)
+	return m.route(k).RPush(k, values...)
 }
 
 func (m *Pipeline) RPushX(ctx context.Context, key string, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.RPushX", k)
-	return m.pipeline.RPushX(k, value)
+	return m.route(k).RPushX(k, value)
 }
 
 func (m *Pipeline) TTL(ctx context.Context, key string) *redis.DurationCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Pipeline.TTL", k)
-	return m.pipeline.TTL(k)
+	return m.route(k).TTL(k)
+}
+
+func (m *Pipeline) fixStreams(streams []string) []string {
+	// NOTE: streams 的前半部分是 key，后半部分是对应的 id
+	fixStreams := make([]string, len(streams))
+	copy(fixStreams, streams)
+	for i := 0; i < len(fixStreams)/2; i++ {
+		fixStreams[i] = m.fixKey(fixStreams[i])
+	}
+	return fixStreams
+}
+
+func (m *Pipeline) XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "Pipeline.XAdd", args.Stream)
+	return m.route(args.Stream).XAdd(&args)
+}
+
+func (m *Pipeline) XDel(ctx context.Context, stream string, ids ...string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XDel", k)
+	return m.route(k).XDel(k, ids...)
+}
+
+func (m *Pipeline) XLen(ctx context.Context, stream string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XLen", k)
+	return m.route(k).XLen(k)
+}
+
+func (m *Pipeline) XRange(ctx context.Context, stream, start, stop string) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XRange", k)
+	return m.route(k).XRange(k, start, stop)
+}
+
+func (m *Pipeline) XRangeN(ctx context.Context, stream, start, stop string, count int64) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XRangeN", k)
+	return m.route(k).XRangeN(k, start, stop, count)
+}
+
+func (m *Pipeline) XRevRange(ctx context.Context, stream, start, stop string) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XRevRange", k)
+	return m.route(k).XRevRange(k, start, stop)
+}
+
+func (m *Pipeline) XRevRangeN(ctx context.Context, stream, start, stop string, count int64) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XRevRangeN", k)
+	return m.route(k).XRevRangeN(k, start, stop, count)
+}
+
+func (m *Pipeline) XRead(ctx context.Context, a *redis.XReadArgs) *redis.XStreamSliceCmd {
+	args := *a
+	args.Streams = m.fixStreams(a.Streams)
+	m.logSpan(ctx, "Pipeline.XRead", strings.Join(args.Streams[:len(args.Streams)/2], "||"))
+	return m.route(firstKey(args.Streams)).XRead(&args)
+}
+
+func (m *Pipeline) XGroupCreate(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XGroupCreate", k)
+	return m.route(k).XGroupCreate(k, group, start)
+}
+
+func (m *Pipeline) XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XGroupCreateMkStream", k)
+	return m.route(k).XGroupCreateMkStream(k, group, start)
+}
+
+func (m *Pipeline) XGroupSetID(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XGroupSetID", k)
+	return m.route(k).XGroupSetID(k, group, start)
+}
+
+func (m *Pipeline) XGroupDestroy(ctx context.Context, stream, group string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XGroupDestroy", k)
+	return m.route(k).XGroupDestroy(k, group)
+}
+
+func (m *Pipeline) XGroupDelConsumer(ctx context.Context, stream, group, consumer string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XGroupDelConsumer", k)
+	return m.route(k).XGroupDelConsumer(k, group, consumer)
+}
+
+func (m *Pipeline) XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+	args := *a
+	args.Streams = m.fixStreams(a.Streams)
+	m.logSpan(ctx, "Pipeline.XReadGroup", strings.Join(args.Streams[:len(args.Streams)/2], "||"))
+	return m.route(firstKey(args.Streams)).XReadGroup(&args)
+}
+
+func (m *Pipeline) XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XAck", k)
+	return m.route(k).XAck(k, group, ids...)
+}
+
+func (m *Pipeline) XPending(ctx context.Context, stream, group string) *redis.XPendingCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XPending", k)
+	return m.route(k).XPending(k, group)
+}
+
+func (m *Pipeline) XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) *redis.XPendingExtCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "Pipeline.XPendingExt", args.Stream)
+	return m.route(args.Stream).XPendingExt(&args)
+}
+
+func (m *Pipeline) XClaim(ctx context.Context, a *redis.XClaimArgs) *redis.XMessageSliceCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "Pipeline.XClaim", args.Stream)
+	return m.route(args.Stream).XClaim(&args)
+}
+
+func (m *Pipeline) XClaimJustID(ctx context.Context, a *redis.XClaimArgs) *redis.StringSliceCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "Pipeline.XClaimJustID", args.Stream)
+	return m.route(args.Stream).XClaimJustID(&args)
+}
+
+func (m *Pipeline) XTrim(ctx context.Context, stream string, maxLen int64) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XTrim", k)
+	return m.route(k).XTrim(k, maxLen)
+}
+
+func (m *Pipeline) XTrimApprox(ctx context.Context, stream string, maxLen int64) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "Pipeline.XTrimApprox", k)
+	return m.route(k).XTrimApprox(k, maxLen)
 }
 
 func (m *Pipeline) Exec(ctx context.Context) ([]redis.Cmder, error){
-	return m.pipeline.Exec()
+	var cmds []redis.Cmder
+	var err error
+	if m.shards != nil {
+		cmds, err = m.execShards(ctx)
+	} else {
+		cmds, err = m.pipeline.Exec()
+	}
+	if m.shards != nil || len(m.merges) > 0 {
+		cmds = m.mergeCmds(cmds)
+	}
+	m.order = m.order[:0]
+	m.merges = nil
+	return cmds, err
+}
+
+// execShards 并发执行每个分片的 pipeline，按照命令加入的顺序返回结果，缺少的结果为 nil
+func (m *Pipeline) execShards(ctx context.Context) ([]redis.Cmder, error) {
+	results := make([][]redis.Cmder, len(m.pipelines))
+	errs := make([]error, len(m.pipelines))
+	var wg sync.WaitGroup
+	for i, p := range m.pipelines {
+		if p == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, p redis.Pipeliner) {
+			defer wg.Done()
+			results[i], errs[i] = p.Exec()
+		}(i, p)
+	}
+	wg.Wait()
+
+	cmds := make([]redis.Cmder, len(m.order))
+	cursors := make([]int, len(m.pipelines))
+	for i, idx := range m.order {
+		if cursors[idx] < len(results[idx]) {
+			cmds[i] = results[idx][cursors[idx]]
+		}
+		cursors[idx]++
+	}
+
+	var err error
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			break
+		}
+	}
+	return cmds, err
 }
 
 func (m *Pipeline) Discard(ctx context.Context) error {
+	m.order = m.order[:0]
+	m.merges = nil
+	if m.shards != nil {
+		var err error
+		for _, p := range m.pipelines {
+			if p != nil {
+				if derr := p.Discard(); derr != nil {
+					err = derr
+				}
+			}
+		}
+		return err
+	}
 	return m.pipeline.Discard()
 }
 
 func (m *Pipeline) Close() error {
+	if m.shards != nil {
+		var err error
+		for _, p := range m.pipelines {
+			if p != nil {
+				if cerr := p.Close(); cerr != nil {
+					err = cerr
+				}
+			}
+		}
+		return err
+	}
 	return m.pipeline.Close()
 }
-
-
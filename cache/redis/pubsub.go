@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// Message 订阅收到的消息，Channel 和 Pattern 已经去掉了 namespace 前缀
+type Message struct {
+	Channel string
+	Pattern string
+	Payload string
+}
+
+// PubSub 对 redis.PubSub 的封装，连接断开时由 go-redis 自动重连并重新订阅，
+// Client 被关闭后 Channel 返回的 chan 会被关闭
+type PubSub struct {
+	client *Client
+	pubsub *redis.PubSub
+
+	chOnce sync.Once
+	ch     chan *Message
+
+	closeOnce sync.Once
+	// done 在 Close 时关闭，避免转发消息的 goroutine 阻塞在没有读取方的 ch 上
+	done chan struct{}
+}
+
+func (m *Client) Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd {
+	k := m.fixKey(channel)
+	m.logSpan(ctx, "Publish", k)
+	return m.client.Publish(k, message)
+}
+
+func (m *Client) fixKeys(keys []string) []string {
+	fixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixKeys[i] = m.fixKey(key)
+	}
+	return fixKeys
+}
+
+func (m *Client) Subscribe(ctx context.Context, channels ...string) *PubSub {
+	fixChannels := m.fixKeys(channels)
+	m.logSpan(ctx, "Subscribe", strings.Join(fixChannels, ","))
+	return newPubSub(m, m.client.Subscribe(fixChannels...))
+}
+
+func (m *Client) PSubscribe(ctx context.Context, patterns ...string) *PubSub {
+	fixPatterns := m.fixKeys(patterns)
+	m.logSpan(ctx, "PSubscribe", strings.Join(fixPatterns, ","))
+	return newPubSub(m, m.client.PSubscribe(fixPatterns...))
+}
+
+func newPubSub(client *Client, pubsub *redis.PubSub) *PubSub {
+	return &PubSub{
+		client: client,
+		pubsub: pubsub,
+		done:   make(chan struct{}),
+	}
+}
+
+func (m *PubSub) Subscribe(ctx context.Context, channels ...string) error {
+	fixChannels := m.client.fixKeys(channels)
+	m.client.logSpan(ctx, "PubSub.Subscribe", strings.Join(fixChannels, ","))
+	return m.pubsub.Subscribe(fixChannels...)
+}
+
+func (m *PubSub) PSubscribe(ctx context.Context, patterns ...string) error {
+	fixPatterns := m.client.fixKeys(patterns)
+	m.client.logSpan(ctx, "PubSub.PSubscribe", strings.Join(fixPatterns, ","))
+	return m.pubsub.PSubscribe(fixPatterns...)
+}
+
+func (m *PubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	fixChannels := m.client.fixKeys(channels)
+	m.client.logSpan(ctx, "PubSub.Unsubscribe", strings.Join(fixChannels, ","))
+	return m.pubsub.Unsubscribe(fixChannels...)
+}
+
+func (m *PubSub) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	fixPatterns := m.client.fixKeys(patterns)
+	m.client.logSpan(ctx, "PubSub.PUnsubscribe", strings.Join(fixPatterns, ","))
+	return m.pubsub.PUnsubscribe(fixPatterns...)
+}
+
+// Channel 返回接收消息的chan，PubSub 或 Client 关闭后chan会被关闭
+func (m *PubSub) Channel() <-chan *Message {
+	m.chOnce.Do(func() {
+		m.ch = make(chan *Message, 100)
+		go func() {
+			defer close(m.ch)
+			for msg := range m.pubsub.Channel() {
+				pattern := msg.Pattern
+				if len(pattern) > 0 {
+					pattern = m.client.TrimKey(pattern)
+				}
+				select {
+				case m.ch <- &Message{
+					Channel: m.client.TrimKey(msg.Channel),
+					Pattern: pattern,
+					Payload: msg.Payload,
+				}:
+				case <-m.done:
+					return
+				}
+			}
+		}()
+	})
+	return m.ch
+}
+
+func (m *PubSub) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return m.pubsub.Close()
+}
@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubSubCloseWithoutReader(t *testing.T) {
+	ctx := context.Background()
+	c, server := newDrainTestClient(t)
+	defer server.close()
+
+	pubsub := c.Subscribe(ctx, "ch")
+	// 等待订阅完成后再发布
+	_, err := pubsub.pubsub.Receive()
+	assert.NoError(t, err)
+	ch := pubsub.Channel()
+	assert.Equal(t, int64(1), c.Publish(ctx, "ch", "first").Val())
+	select {
+	case msg := <-ch:
+		assert.Equal(t, "ch", msg.Channel)
+		assert.Equal(t, "first", msg.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	// 没有读取方时 ch 被写满，Close 后转发的 goroutine 也需要退出并关闭 ch
+	for i := 0; i < 300; i++ {
+		c.Publish(ctx, "ch", "x")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, pubsub.Close())
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("chan not closed")
+		}
+	}
+}
@@ -16,9 +16,17 @@ import (
 var RedisNil = fmt.Sprintf("redis: nil")
 
 type Client struct {
-	client    *redis.Client
+	client    redis.UniversalClient
 	namespace string
 	opts      *options
+	// 没有配置从库时为nil
+	replicas *replicaSet
+	// 非分片模式时为nil
+	shards *shardSet
+	// 没有开启热 key 和大 key 统计时为nil
+	keyStats *keyStats
+	// 正在执行的命令数，配置变更时用于等待旧实例的命令结束
+	inflight *inflight
 }
 
 func NewClient(ctx context.Context, namespace string, wrapper string) (*Client, error) {
@@ -29,14 +37,7 @@ func NewClient(ctx context.Context, namespace string, wrapper string) (*Client,
 		return nil, err
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr:         config.addr,
-		DialTimeout:  3 * config.timeout,
-		ReadTimeout:  config.timeout,
-		WriteTimeout: config.timeout,
-		PoolSize:     config.poolSize,
-		PoolTimeout:  2 * config.timeout,
-	})
+	client := newRedisClient(config)
 
 	pong, err := client.Ping().Result()
 	if err != nil {
@@ -47,11 +48,16 @@ func NewClient(ctx context.Context, namespace string, wrapper string) (*Client,
 		noFixKey:   false,
 		useWrapper: config.useWrapper,
 	}
-	return &Client{
+	c := &Client{
 		client:    client,
 		namespace: namespace,
 		opts:      opts,
-	}, err
+	}
+	c.keyStats = newKeyStats(ctx, namespace, config.keyStats)
+	c.replicas = newReplicaSet(ctx, config, c)
+	c.shards = newShardSet(config, client)
+	c.trackInflight()
+	return c, err
 }
 
 func NewClientWithOptions(ctx context.Context, namespace string, opts ...Option) (*Client, error) {
@@ -62,14 +68,7 @@ func NewClientWithOptions(ctx context.Context, namespace string, opts ...Option)
 		return nil, err
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr:         config.addr,
-		DialTimeout:  3 * config.timeout,
-		ReadTimeout:  config.timeout,
-		WriteTimeout: config.timeout,
-		PoolSize:     config.poolSize,
-		PoolTimeout:  2 * config.timeout,
-	})
+	client := newRedisClient(config)
 
 	pong, err := client.Ping().Result()
 	if err != nil {
@@ -83,11 +82,20 @@ func NewClientWithOptions(ctx context.Context, namespace string, opts ...Option)
 	for _, o := range opts {
 		o.apply(opt)
 	}
-	return &Client{
+	c := &Client{
 		namespace: namespace,
 		opts:      opt,
 		client:    client,
-	}, err
+	}
+	statsConfig := config.keyStats
+	if opt.keyStats != nil {
+		statsConfig = *opt.keyStats
+	}
+	c.keyStats = newKeyStats(ctx, namespace, statsConfig)
+	c.replicas = newReplicaSet(ctx, config, c)
+	c.shards = newShardSet(config, client)
+	c.trackInflight()
+	return c, err
 }
 
 func NewDefaultClient(ctx context.Context, namespace, addr, wrapper string, poolSize int, useWrapper bool, timeout time.Duration) (*Client, error) {
@@ -148,7 +156,9 @@ func (m *Client) logSpan(ctx context.Context, op, key string) {
 func (m *Client) Get(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Get", k)
-	return m.client.Get(k)
+	cmd := m.route(k).Get(k)
+	m.keyStats.value("Get", k, len(cmd.Val()))
+	return cmd
 }
 
 func (m *Client) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
@@ -158,13 +168,20 @@ func (m *Client) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
 		fixKeys[k] = key
 	}
 	m.logSpan(ctx, "MGet", strings.Join(fixKeys, "||"))
+	if groups, ok := m.splitKeys(fixKeys); ok {
+		return m.splitMGet(ctx, fixKeys, groups)
+	}
+	for _, k := range fixKeys {
+		m.keyStats.access(k)
+	}
 	return m.client.MGet(fixKeys...)
 }
 
 func (m *Client) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Set", k)
-	return m.client.Set(k, value, expiration)
+	m.keyStats.value("Set", k, valueSize(value))
+	return m.route(k).Set(k, value, expiration)
 }
 
 func (m *Client) MSet(ctx context.Context, pairs ...interface{}) *redis.StatusCmd {
@@ -180,25 +197,28 @@ func (m *Client) MSet(ctx context.Context, pairs ...interface{}) *redis.StatusCm
 		}
 	}
 	m.logSpan(ctx, "MSet", strings.Join(keys, "||"))
+	if groups, ok := m.splitKeys(keys); ok {
+		return m.splitMSet(ctx, fixPairs, groups)
+	}
 	return m.client.MSet(fixPairs...)
 }
 
 func (m *Client) GetBit(ctx context.Context, key string, offset int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "GetBit", k)
-	return m.client.GetBit(k, offset)
+	return m.route(k).GetBit(k, offset)
 }
 
 func (m *Client) SetBit(ctx context.Context, key string, offset int64, value int) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SetBit", k)
-	return m.client.SetBit(k, offset, value)
+	return m.route(k).SetBit(k, offset, value)
 }
 
 func (m *Client) Exists(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Exists", k)
-	return m.client.Exists(k)
+	return m.route(k).Exists(k)
 }
 
 func (m *Client) Del(ctx context.Context, keys ...string) *redis.IntCmd {
@@ -208,464 +228,680 @@ func (m *Client) Del(ctx context.Context, keys ...string) *redis.IntCmd {
 	}
 
 	m.logSpan(ctx, "Del", strings.Join(tkeys, ","))
+	if groups, ok := m.splitKeys(tkeys); ok {
+		return m.splitDel(ctx, tkeys, groups)
+	}
 	return m.client.Del(tkeys...)
 }
 
 func (m *Client) Append(ctx context.Context, key, value string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Append", k)
-	return m.client.Append(k, value)
+	return m.route(k).Append(k, value)
 }
 
 func (m *Client) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Expire", k)
-	return m.client.Expire(k, expiration)
+	return m.route(k).Expire(k, expiration)
 }
 
 func (m *Client) Incr(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Incr", k)
-	return m.client.Incr(k)
+	return m.route(k).Incr(k)
 }
 
 func (m *Client) IncrBy(ctx context.Context, key string, value int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "IncrBy", k)
-	return m.client.IncrBy(k, value)
+	return m.route(k).IncrBy(k, value)
 }
 
 func (m *Client) Decr(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "Decr", k)
-	return m.client.Decr(k)
+	return m.route(k).Decr(k)
 }
 
 func (m *Client) DecrBy(ctx context.Context, key string, value int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "DecrBy", k)
-	return m.client.DecrBy(k, value)
+	return m.route(k).DecrBy(k, value)
 }
 
 func (m *Client) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SetNX", k)
-	return m.client.SetNX(k, value, expiration)
+	m.keyStats.value("SetNX", k, valueSize(value))
+	return m.route(k).SetNX(k, value, expiration)
 }
 
 func (m *Client) HSet(ctx context.Context, key string, field string, value interface{}) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HSet", k)
-	return m.client.HSet(k, field, value)
+	m.keyStats.value("HSet", k, valueSize(value))
+	return m.route(k).HSet(k, field, value)
 }
 
 func (m *Client) HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HDel", k)
-	return m.client.HDel(k, fields...)
+	return m.route(k).HDel(k, fields...)
 }
 
 func (m *Client) HExists(ctx context.Context, key string, field string) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HExists", k)
-	return m.client.HExists(k, field)
+	return m.route(k).HExists(k, field)
 }
 
 func (m *Client) HGet(ctx context.Context, key string, field string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HGet", k)
-	return m.client.HGet(k, field)
+	cmd := m.route(k).HGet(k, field)
+	m.keyStats.value("HGet", k, len(cmd.Val()))
+	return cmd
 }
 
 func (m *Client) HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HGetAll", k)
-	return m.client.HGetAll(k)
+	cmd := m.route(k).HGetAll(k)
+	if m.keyStats != nil {
+		size := 0
+		for f, v := range cmd.Val() {
+			size += len(f) + len(v)
+		}
+		m.keyStats.value("HGetAll", k, size)
+	}
+	return cmd
 }
 
 func (m *Client) HIncrBy(ctx context.Context, key string, field string, incr int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HIncrBy", k)
-	return m.client.HIncrBy(k, field, incr)
+	return m.route(k).HIncrBy(k, field, incr)
 }
 
 func (m *Client) HIncrByFloat(ctx context.Context, key string, field string, incr float64) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HIncrByFloat", k)
-	return m.client.HIncrByFloat(k, field, incr)
+	return m.route(k).HIncrByFloat(k, field, incr)
 }
 
 func (m *Client) HKeys(ctx context.Context, key string) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HKeys", k)
-	return m.client.HKeys(k)
+	return m.route(k).HKeys(k)
 }
 
 func (m *Client) HLen(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HLen", k)
-	return m.client.HLen(k)
+	return m.route(k).HLen(k)
 }
 
 func (m *Client) HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HMGet", k)
-	return m.client.HMGet(k, fields...)
+	return m.route(k).HMGet(k, fields...)
 }
 
 func (m *Client) HMSet(ctx context.Context, key string, fields map[string]interface{}) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HMSet", k)
-	return m.client.HMSet(k, fields)
+	if m.keyStats != nil {
+		size := 0
+		for f, v := range fields {
+			size += len(f) + valueSize(v)
+		}
+		m.keyStats.value("HMSet", k, size)
+	}
+	return m.route(k).HMSet(k, fields)
 }
 
 func (m *Client) HSetNX(ctx context.Context, key string, field string, val interface{}) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HSetNX", k)
-	return m.client.HSetNX(k, field, val)
+	return m.route(k).HSetNX(k, field, val)
 }
 
 func (m *Client) HVals(ctx context.Context, key string) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HVals", k)
-	return m.client.HVals(k)
+	return m.route(k).HVals(k)
 }
 
 func (m *Client) HScan(ctx context.Context, key string, cursor uint64, match string, count int64) *redis.ScanCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "HScan", k)
-	return m.client.HScan(k, cursor, match, count)
+	return m.route(k).HScan(k, cursor, match, count)
 }
 
 func (m *Client) ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZAdd", k)
-	return m.client.ZAdd(k, members...)
+	return m.route(k).ZAdd(k, members...)
 }
 
 func (m *Client) ZAddNX(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZAddNX", k)
-	return m.client.ZAddNX(k, members...)
+	return m.route(k).ZAddNX(k, members...)
 }
 
 func (m *Client) ZAddNXCh(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZAddNXCh", k)
-	return m.client.ZAddNXCh(k, members...)
+	return m.route(k).ZAddNXCh(k, members...)
 }
 
 func (m *Client) ZAddXX(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZAddXX", k)
-	return m.client.ZAddXX(k, members...)
+	return m.route(k).ZAddXX(k, members...)
 }
 
 func (m *Client) ZAddXXCh(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZAddXXCh", k)
-	return m.client.ZAddXXCh(k, members...)
+	return m.route(k).ZAddXXCh(k, members...)
 }
 
 func (m *Client) ZAddCh(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZAddCh", k)
-	return m.client.ZAddCh(k, members...)
+	return m.route(k).ZAddCh(k, members...)
 }
 
 func (m *Client) ZCard(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZCard", k)
-	return m.client.ZCard(k)
+	return m.route(k).ZCard(k)
 }
 
 func (m *Client) ZCount(ctx context.Context, key, min, max string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZCount", k)
-	return m.client.ZCount(k, min, max)
+	return m.route(k).ZCount(k, min, max)
 }
 
 func (m *Client) ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRange", k)
-	return m.client.ZRange(k, start, stop)
+	return m.route(k).ZRange(k, start, stop)
 }
 
 func (m *Client) ZRangeByLex(ctx context.Context, key string, by redis.ZRangeBy) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRangeByLex", k)
-	return m.client.ZRangeByLex(k, by)
+	return m.route(k).ZRangeByLex(k, by)
 }
 
 func (m *Client) ZRangeByScore(ctx context.Context, key string, by redis.ZRangeBy) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRangeByScore", k)
-	return m.client.ZRangeByScore(k, by)
+	return m.route(k).ZRangeByScore(k, by)
 }
 
 func (m *Client) ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRangeWithScores", k)
-	return m.client.ZRangeWithScores(k, start, stop)
+	return m.route(k).ZRangeWithScores(k, start, stop)
 }
 
 func (m *Client) ZRevRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRevRange", k)
-	return m.client.ZRevRange(k, start, stop)
+	return m.route(k).ZRevRange(k, start, stop)
 }
 
 func (m *Client) ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRevRangeWithScores", k)
-	return m.client.ZRevRangeWithScores(k, start, stop)
+	return m.route(k).ZRevRangeWithScores(k, start, stop)
 }
 
 func (m *Client) ZRank(ctx context.Context, key string, member string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRank", k)
-	return m.client.ZRank(k, member)
+	return m.route(k).ZRank(k, member)
 }
 
 func (m *Client) ZRevRank(ctx context.Context, key string, member string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRevRank", k)
-	return m.client.ZRevRank(k, member)
+	return m.route(k).ZRevRank(k, member)
 }
 
 func (m *Client) ZRem(ctx context.Context, key string, members []interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRem", k)
-	return m.client.ZRem(k, members...)
+	return m.route(k).ZRem(k, members...)
 }
 
 func (m *Client) ZRemRangeByScore(ctx context.Context, key, min, max string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRemRangeByScore", k)
-	return m.client.ZRemRangeByScore(k, min, max)
+	return m.route(k).ZRemRangeByScore(k, min, max)
 }
 
 func (m *Client) ZRemRangeByRank(ctx context.Context, key string, start int64, stop int64) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZRemRangeByRank", k)
-	return m.client.ZRemRangeByRank(k, start, stop)
+	return m.route(k).ZRemRangeByRank(k, start, stop)
 }
 
 
 func (m *Client) ZIncr(ctx context.Context, key string, member redis.Z) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZIncr", k)
-	return m.client.ZIncr(k, member)
+	return m.route(k).ZIncr(k, member)
 }
 
 func (m *Client) ZIncrNX(ctx context.Context, key string, member redis.Z) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZIncrNX", k)
-	return m.client.ZIncrNX(k, member)
+	return m.route(k).ZIncrNX(k, member)
 }
 
 func (m *Client) ZIncrXX(ctx context.Context, key string, member redis.Z) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZIncrXX", k)
-	return m.client.ZIncrXX(k, member)
+	return m.route(k).ZIncrXX(k, member)
 }
 
 func (m *Client) ZIncrBy(ctx context.Context, key string, increment float64, member string) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZIncrBy", k)
-	return m.client.ZIncrBy(k, increment, member)
+	return m.route(k).ZIncrBy(k, increment, member)
 }
 
 func (m *Client) ZScore(ctx context.Context, key string, member string) *redis.FloatCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZScore", k)
-	return m.client.ZScore(k, member)
+	return m.route(k).ZScore(k, member)
 }
 
 func (m *Client) ZScan(ctx context.Context, key string, cursor uint64, match string, count int64) *redis.ScanCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "ZScan", k)
-	return m.client.ZScan(k, cursor, match, count)
+	return m.route(k).ZScan(k, cursor, match, count)
 }
 
 func (m *Client) LIndex(ctx context.Context, key string, index int64) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LIndex", k)
-	return m.client.LIndex(k, index)
+	return m.route(k).LIndex(k, index)
 }
 
 func (m *Client) LInsert(ctx context.Context, key, op string, pivot, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LInsert", k)
-	return m.client.LInsert(k, op, pivot, value)
+	return m.route(k).LInsert(k, op, pivot, value)
 }
 
 func (m *Client) LLen(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LLen", k)
-	return m.client.LLen(k)
+	return m.route(k).LLen(k)
 }
 
 func (m *Client) LPop(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LPop", k)
-	return m.client.LPop(k)
+	return m.route(k).LPop(k)
 }
 
 func (m *Client) LPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LPush", k)
-	return m.client.LPush(k, values...)
+	return m.route(k).LPush(k, values...)
 }
 
 func (m *Client) LPushX(ctx context.Context, key string, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LPushX", k)
-	return m.client.LPushX(k, value)
+	return m.route(k).LPushX(k, value)
 }
 
 func (m *Client) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LRange", k)
-	return m.client.LRange(k, start, stop)
+	return m.route(k).LRange(k, start, stop)
 }
 
 func (m *Client) LRem(ctx context.Context, key string, count int64, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LRem", k)
-	return m.client.LRem(k, count, value)
+	return m.route(k).LRem(k, count, value)
 }
 
 func (m *Client) LSet(ctx context.Context, key string, index int64, value interface{}) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LSet", k)
-	return m.client.LSet(k, index, value)
+	return m.route(k).LSet(k, index, value)
 }
 
 func (m *Client) LTrim(ctx context.Context, key string, start, stop int64) *redis.StatusCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "LTrim", k)
-	return m.client.LTrim(k, start, stop)
+	return m.route(k).LTrim(k, start, stop)
 }
 
 func (m *Client) RPop(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "RPop", k)
-	return m.client.RPop(k)
+	return m.route(k).RPop(k)
 }
 
 func (m *Client) RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "RPush", k)
-	return m.client.RPush(k, values...)
+	return m.route(k).RPush(k, values...)
 }
 
 func (m *Client) RPushX(ctx context.Context, key string, value interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "RPushX", k)
-	return m.client.RPushX(k, value)
+	return m.route(k).RPushX(k, value)
 }
 
 func (m *Client) TTL(ctx context.Context, key string) *redis.DurationCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "TTL", k)
-	return m.client.TTL(k)
+	return m.route(k).TTL(k)
 }
 
 func (m *Client) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
 	m.logSpan(ctx, "ScriptLoad", script)
+	if m.shards != nil {
+		// NOTE: 分片模式下在所有分片上加载
+		for _, s := range m.shards.shards[1:] {
+			if err := s.client.ScriptLoad(script).Err(); err != nil {
+				return redis.NewStringResult("", err)
+			}
+		}
+	}
 	return m.client.ScriptLoad(script)
 }
 
 func (m *Client) ScriptExists(ctx context.Context, scriptHash string) *redis.BoolSliceCmd {
 	m.logSpan(ctx, "ScriptExists", scriptHash)
-	return m.client.ScriptExists(scriptHash)
+	cmd := m.client.ScriptExists(scriptHash)
+	if m.shards == nil || cmd.Err() != nil {
+		return cmd
+	}
+	// NOTE: 分片模式下所有分片都加载了脚本才算存在
+	exists := cmd.Val()
+	for _, s := range m.shards.shards[1:] {
+		c := s.client.ScriptExists(scriptHash)
+		if c.Err() != nil {
+			return c
+		}
+		for i, ok := range c.Val() {
+			if i < len(exists) {
+				exists[i] = exists[i] && ok
+			}
+		}
+	}
+	return redis.NewBoolSliceResult(exists, nil)
 }
 
 func (m *Client) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
 	m.logSpan(ctx, "Eval", script)
-	for i, key := range keys {
-		keys[i] = m.fixKey(key)
-	}
-	return m.client.Eval(script, keys, args...)
+	// NOTE: 不修改调用方的 keys，调用方可能用同一个 keys 重试
+	fixKeys := m.fixKeys(keys)
+	return m.route(firstKey(fixKeys)).Eval(script, fixKeys, args...)
 }
 
 func (m *Client) EvalSha(ctx context.Context, scriptHash string, keys []string, args ...interface{}) *redis.Cmd {
 	m.logSpan(ctx, "EvalSha", scriptHash)
-	for i, key := range keys {
-		keys[i] = m.fixKey(key)
-	}
-	return m.client.EvalSha(scriptHash, keys, args...)
+	// NOTE: 不修改调用方的 keys，调用方可能用同一个 keys 重试
+	fixKeys := m.fixKeys(keys)
+	return m.route(firstKey(fixKeys)).EvalSha(scriptHash, fixKeys, args...)
 }
 
 func (m *Client) SScan(ctx context.Context, key string, cursor uint64, match string, count int64) *redis.ScanCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SScan", k)
-	return m.client.SScan(k, cursor, match, count)
+	return m.route(k).SScan(k, cursor, match, count)
 }
 
 func (m *Client) SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SAdd", k)
-	return m.client.SAdd(k, members...)
+	return m.route(k).SAdd(k, members...)
 }
 
 func (m *Client) SPop(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SPop", k)
-	return m.client.SPop(k)
+	return m.route(k).SPop(k)
 }
 
 func (m *Client) SPopN(ctx context.Context, key string, count int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SPopN", k)
-	return m.client.SPopN(k, count)
+	return m.route(k).SPopN(k, count)
 }
 
 func (m *Client) SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SRem", k)
-	return m.client.SRem(k, members...)
+	return m.route(k).SRem(k, members...)
 }
 
 func (m *Client) SCard(ctx context.Context, key string) *redis.IntCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SCard", k)
-	return m.client.SCard(k)
+	return m.route(k).SCard(k)
 }
 
 func (m *Client) SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SIsMember", k)
-	return m.client.SIsMember(k, member)
+	return m.route(k).SIsMember(k, member)
 }
 
 func (m *Client) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SMembers", k)
-	return m.client.SMembers(k)
+	return m.route(k).SMembers(k)
 }
 
 func (m *Client) SRandMember(ctx context.Context, key string) *redis.StringCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SRandMember", k)
-	return m.client.SRandMember(k)
+	return m.route(k).SRandMember(k)
 }
 
 func (m *Client) SRandMemberN(ctx context.Context, key string, count int64) *redis.StringSliceCmd {
 	k := m.fixKey(key)
 	m.logSpan(ctx, "SRandMemberN", k)
-	return m.client.SRandMemberN(k, count)
+	return m.route(k).SRandMemberN(k, count)
+}
+
+func (m *Client) fixStreams(streams []string) []string {
+	// NOTE: streams 的前半部分是 key，后半部分是对应的 id
+	fixStreams := make([]string, len(streams))
+	copy(fixStreams, streams)
+	for i := 0; i < len(fixStreams)/2; i++ {
+		fixStreams[i] = m.fixKey(fixStreams[i])
+	}
+	return fixStreams
+}
+
+// TrimKey 与 fixKey 相反，去掉 namespace 和 wrapper 前缀，还原调用方传入的 key
+func (m *Client) TrimKey(key string) string {
+	if m.opts.noFixKey {
+		return key
+	}
+	prefix := m.fixKey("")
+	return strings.TrimPrefix(key, prefix)
+}
+
+func (m *Client) XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "XAdd", args.Stream)
+	return m.route(args.Stream).XAdd(&args)
+}
+
+func (m *Client) XDel(ctx context.Context, stream string, ids ...string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XDel", k)
+	return m.route(k).XDel(k, ids...)
+}
+
+func (m *Client) XLen(ctx context.Context, stream string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XLen", k)
+	return m.route(k).XLen(k)
+}
+
+func (m *Client) XRange(ctx context.Context, stream, start, stop string) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XRange", k)
+	return m.route(k).XRange(k, start, stop)
+}
+
+func (m *Client) XRangeN(ctx context.Context, stream, start, stop string, count int64) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XRangeN", k)
+	return m.route(k).XRangeN(k, start, stop, count)
+}
+
+func (m *Client) XRevRange(ctx context.Context, stream, start, stop string) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XRevRange", k)
+	return m.route(k).XRevRange(k, start, stop)
+}
+
+func (m *Client) XRevRangeN(ctx context.Context, stream, start, stop string, count int64) *redis.XMessageSliceCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XRevRangeN", k)
+	return m.route(k).XRevRangeN(k, start, stop, count)
+}
+
+func (m *Client) XRead(ctx context.Context, a *redis.XReadArgs) *redis.XStreamSliceCmd {
+	args := *a
+	args.Streams = m.fixStreams(a.Streams)
+	m.logSpan(ctx, "XRead", strings.Join(args.Streams[:len(args.Streams)/2], "||"))
+	return m.route(firstKey(args.Streams)).XRead(&args)
+}
+
+func (m *Client) XGroupCreate(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XGroupCreate", k)
+	return m.route(k).XGroupCreate(k, group, start)
+}
+
+func (m *Client) XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XGroupCreateMkStream", k)
+	return m.route(k).XGroupCreateMkStream(k, group, start)
+}
+
+func (m *Client) XGroupSetID(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XGroupSetID", k)
+	return m.route(k).XGroupSetID(k, group, start)
+}
+
+func (m *Client) XGroupDestroy(ctx context.Context, stream, group string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XGroupDestroy", k)
+	return m.route(k).XGroupDestroy(k, group)
+}
+
+func (m *Client) XGroupDelConsumer(ctx context.Context, stream, group, consumer string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XGroupDelConsumer", k)
+	return m.route(k).XGroupDelConsumer(k, group, consumer)
+}
+
+func (m *Client) XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+	args := *a
+	args.Streams = m.fixStreams(a.Streams)
+	m.logSpan(ctx, "XReadGroup", strings.Join(args.Streams[:len(args.Streams)/2], "||"))
+	return m.route(firstKey(args.Streams)).XReadGroup(&args)
+}
+
+func (m *Client) XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XAck", k)
+	return m.route(k).XAck(k, group, ids...)
+}
+
+func (m *Client) XPending(ctx context.Context, stream, group string) *redis.XPendingCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XPending", k)
+	return m.route(k).XPending(k, group)
+}
+
+func (m *Client) XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) *redis.XPendingExtCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "XPendingExt", args.Stream)
+	return m.route(args.Stream).XPendingExt(&args)
+}
+
+func (m *Client) XClaim(ctx context.Context, a *redis.XClaimArgs) *redis.XMessageSliceCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "XClaim", args.Stream)
+	return m.route(args.Stream).XClaim(&args)
+}
+
+func (m *Client) XClaimJustID(ctx context.Context, a *redis.XClaimArgs) *redis.StringSliceCmd {
+	args := *a
+	args.Stream = m.fixKey(a.Stream)
+	m.logSpan(ctx, "XClaimJustID", args.Stream)
+	return m.route(args.Stream).XClaimJustID(&args)
+}
+
+func (m *Client) XTrim(ctx context.Context, stream string, maxLen int64) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XTrim", k)
+	return m.route(k).XTrim(k, maxLen)
+}
+
+func (m *Client) XTrimApprox(ctx context.Context, stream string, maxLen int64) *redis.IntCmd {
+	k := m.fixKey(stream)
+	m.logSpan(ctx, "XTrimApprox", k)
+	return m.route(k).XTrimApprox(k, maxLen)
 }
 
 func (m *Client) Close(ctx context.Context) error {
+	fun := "Client.Close -->"
+	m.keyStats.close()
+	if m.shards != nil {
+		if err := m.shards.close(); err != nil {
+			slog.Warnf(ctx, "%s close shards err:%v", fun, err)
+		}
+	}
+	if m.replicas != nil {
+		if err := m.replicas.close(); err != nil {
+			slog.Warnf(ctx, "%s close replicas err:%v", fun, err)
+		}
+	}
 	return m.client.Close()
 }
 
 func (m *Client) Pipeline() *Pipeline {
-	return &Pipeline{
+	p := &Pipeline{
 		namespace: m.namespace,
-		pipeline:  m.client.Pipeline(),
 		opts:      m.opts,
+		keyStats:  m.keyStats,
+		cluster:   m.isCluster(),
+	}
+	if m.shards != nil {
+		p.shards = m.shards
+		p.pipelines = make([]redis.Pipeliner, len(m.shards.shards))
+	} else {
+		p.pipeline = m.client.Pipeline()
 	}
+	return p
 }
@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+const (
+	RoleMaster  = "master"
+	RoleReplica = "replica"
+
+	replicaCheckInterval = 5 * time.Second
+)
+
+type replica struct {
+	addr    string
+	client  *Client
+	healthy int32
+}
+
+func (m *replica) isHealthy() bool {
+	return atomic.LoadInt32(&m.healthy) == 1
+}
+
+// replicaSet 从库列表，后台定时 ping 从库，不可用的从库不参与读路由
+type replicaSet struct {
+	policy   ReadPolicy
+	replicas []*replica
+	next     uint32
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// newReplicaSet 从库与主库使用相同的 key 前缀，没有配置从库时返回nil
+func newReplicaSet(ctx context.Context, config *Config, master *Client) *replicaSet {
+	if len(config.replicas) == 0 || config.getReadPolicy() == ReadPolicyMaster {
+		return nil
+	}
+
+	m := &replicaSet{
+		policy: config.getReadPolicy(),
+		stop:   make(chan struct{}),
+	}
+	for _, addr := range config.replicas {
+		rconfig := *config
+		rconfig.mode = ModeSingle
+		rconfig.addr = addr
+		r := &replica{
+			addr: addr,
+			client: &Client{
+				client:    newRedisClient(&rconfig),
+				namespace: master.namespace,
+				opts:      master.opts,
+				keyStats:  master.keyStats,
+			},
+		}
+		m.replicas = append(m.replicas, r)
+	}
+	m.check(ctx)
+	go m.watch()
+	return m
+}
+
+func (m *replicaSet) check(ctx context.Context) {
+	fun := "replicaSet.check -->"
+	for _, r := range m.replicas {
+		var healthy int32
+		if err := r.client.client.Ping().Err(); err != nil {
+			slog.Warnf(ctx, "%s ping replica:%s err:%v", fun, r.addr, err)
+		} else {
+			healthy = 1
+		}
+		atomic.StoreInt32(&r.healthy, healthy)
+	}
+}
+
+func (m *replicaSet) watch() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check(context.Background())
+		}
+	}
+}
+
+// pick 按照读策略选择从库，返回nil时读主库
+func (m *replicaSet) pick() *Client {
+	n := uint32(len(m.replicas))
+	switch m.policy {
+	case ReadPolicyPreferReplica:
+		start := atomic.AddUint32(&m.next, 1)
+		for i := uint32(0); i < n; i++ {
+			if r := m.replicas[(start+i)%n]; r.isHealthy() {
+				return r.client
+			}
+		}
+	case ReadPolicyRoundRobin:
+		// NOTE: 下标为 n 时读主库
+		idx := atomic.AddUint32(&m.next, 1) % (n + 1)
+		if idx < n && m.replicas[idx].isHealthy() {
+			return m.replicas[idx].client
+		}
+	}
+	return nil
+}
+
+func (m *replicaSet) close() error {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	var err error
+	for _, r := range m.replicas {
+		if cerr := r.client.client.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reader 按照读策略返回执行读命令的 Client 和对应的角色，写命令需要使用 Client 本身
+func (m *Client) Reader() (*Client, string) {
+	if m.replicas == nil {
+		return m, RoleMaster
+	}
+	if r := m.replicas.pick(); r != nil {
+		return r, RoleReplica
+	}
+	return m, RoleMaster
+}
@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaSetPick(t *testing.T) {
+	r1 := &replica{addr: "r1", client: &Client{namespace: "r1"}, healthy: 1}
+	r2 := &replica{addr: "r2", client: &Client{namespace: "r2"}}
+	m := &replicaSet{policy: ReadPolicyPreferReplica, replicas: []*replica{r1, r2}}
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, r1.client, m.pick())
+	}
+
+	// 没有可用的从库时读主库
+	r1.healthy = 0
+	assert.Nil(t, m.pick())
+
+	r1.healthy, r2.healthy = 1, 1
+	m.policy = ReadPolicyRoundRobin
+	picked := map[*Client]int{}
+	for i := 0; i < 6; i++ {
+		picked[m.pick()]++
+	}
+	assert.Equal(t, map[*Client]int{nil: 2, r1.client: 2, r2.client: 2}, picked)
+}
+
+func TestClientReader(t *testing.T) {
+	master := &Client{namespace: "master"}
+	c, role := master.Reader()
+	assert.Equal(t, master, c)
+	assert.Equal(t, RoleMaster, role)
+
+	r := &replica{addr: "r1", client: &Client{namespace: "r1"}, healthy: 1}
+	master.replicas = &replicaSet{policy: ReadPolicyPreferReplica, replicas: []*replica{r}}
+	c, role = master.Reader()
+	assert.Equal(t, r.client, c)
+	assert.Equal(t, RoleReplica, role)
+}
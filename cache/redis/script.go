@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+// scriptRegistry 注册的 lua 脚本，实例创建或者重建时会预先加载到 redis 中
+var scriptRegistry = struct {
+	sync.RWMutex
+	scripts map[string]string
+}{
+	scripts: make(map[string]string),
+}
+
+// RegisterScript 注册 lua 脚本，hash 为脚本内容的 sha1
+func RegisterScript(hash, src string) {
+	scriptRegistry.Lock()
+	defer scriptRegistry.Unlock()
+	scriptRegistry.scripts[hash] = src
+}
+
+func registeredScripts() map[string]string {
+	scriptRegistry.RLock()
+	defer scriptRegistry.RUnlock()
+	scripts := make(map[string]string, len(scriptRegistry.scripts))
+	for hash, src := range scriptRegistry.scripts {
+		scripts[hash] = src
+	}
+	return scripts
+}
+
+// preloadScripts 将注册的脚本加载到 client 对应的 redis 中，加载失败只打印日志，
+// 执行时会在 NOSCRIPT 后回退到 EVAL
+func preloadScripts(ctx context.Context, client *Client) {
+	fun := "preloadScripts -->"
+	for hash, src := range registeredScripts() {
+		r, err := client.ScriptLoad(ctx, src).Result()
+		if err != nil {
+			slog.Warnf(ctx, "%s namespace:%s hash:%s err:%v", fun, client.namespace, hash, err)
+			continue
+		}
+		if r != hash {
+			slog.Warnf(ctx, "%s namespace:%s hash mismatch, expect:%s got:%s", fun, client.namespace, hash, r)
+		}
+	}
+}
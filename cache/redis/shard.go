@@ -0,0 +1,206 @@
+package redis
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// ketama 每个节点 40 次 md5，每次得到 4 个虚拟节点
+	ketamaHashesPerNode = 40
+	ketamaPointsPerHash = 4
+
+	shardNameSep = "="
+)
+
+// HashRing ketama 一致性哈希环，key 中的 hash tag 与 cluster 模式的规则相同
+type HashRing struct {
+	nodes  []string
+	points []uint32
+	owners []int
+}
+
+// NewHashRing nodes 为节点名字，节点名字不变时 key 的分布不变
+func NewHashRing(nodes []string) *HashRing {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	var points []point
+	for i, node := range nodes {
+		for j := 0; j < ketamaHashesPerNode; j++ {
+			digest := md5.Sum([]byte(node + "-" + strconv.Itoa(j)))
+			for k := 0; k < ketamaPointsPerHash; k++ {
+				points = append(points, point{hash: ketamaPoint(digest, k), owner: i})
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	r := &HashRing{
+		nodes:  nodes,
+		points: make([]uint32, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+func ketamaPoint(digest [md5.Size]byte, k int) uint32 {
+	return uint32(digest[3+k*4])<<24 | uint32(digest[2+k*4])<<16 | uint32(digest[1+k*4])<<8 | uint32(digest[k*4])
+}
+
+func (m *HashRing) index(key string) int {
+	if len(m.points) == 0 {
+		return -1
+	}
+	h := ketamaPoint(md5.Sum([]byte(hashTagOf(key))), 0)
+	i := sort.Search(len(m.points), func(i int) bool { return m.points[i] >= h })
+	if i == len(m.points) {
+		i = 0
+	}
+	return m.owners[i]
+}
+
+// Get 返回 key 所在的节点，没有节点时返回空字符串
+func (m *HashRing) Get(key string) string {
+	i := m.index(key)
+	if i < 0 {
+		return ""
+	}
+	return m.nodes[i]
+}
+
+// parseShard 分片配置为 name=addr 或者 addr，没有名字时使用地址作为名字
+func parseShard(s string) (name, addr string) {
+	parts := strings.SplitN(s, shardNameSep, 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return s, s
+}
+
+func shardNames(shards []string) []string {
+	names := make([]string, len(shards))
+	for i, s := range shards {
+		names[i], _ = parseShard(s)
+	}
+	return names
+}
+
+type shard struct {
+	name   string
+	client redis.UniversalClient
+}
+
+// shardSet 分片模式下的所有分片，第一个分片同时用于发布订阅等与 key 无关的命令
+type shardSet struct {
+	ring   *HashRing
+	shards []*shard
+}
+
+func newShardSet(config *Config, first redis.UniversalClient) *shardSet {
+	if config.getMode() != ModeSharded {
+		return nil
+	}
+	addrs := config.getAddrs()
+	m := &shardSet{
+		ring: NewHashRing(shardNames(addrs)),
+	}
+	for i, s := range addrs {
+		name, addr := parseShard(s)
+		client := first
+		if i > 0 {
+			sconfig := *config
+			sconfig.mode = ModeSingle
+			sconfig.addr = addr
+			client = newRedisClient(&sconfig)
+		}
+		m.shards = append(m.shards, &shard{name: name, client: client})
+	}
+	return m
+}
+
+func (m *shardSet) get(key string) *shard {
+	return m.shards[m.ring.index(key)]
+}
+
+func (m *shardSet) close() error {
+	var err error
+	// NOTE: 第一个分片由 Client 关闭
+	for _, s := range m.shards[1:] {
+		if cerr := s.client.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// route 返回执行 key 相关命令的客户端，分片模式下为 key 所在的分片
+func (m *Client) route(key string) redis.UniversalClient {
+	m.keyStats.access(key)
+	if m.shards == nil {
+		return m.client
+	}
+	return m.shards.get(key).client
+}
+
+// ShardMove key 在分片变更后需要从 From 迁移到 To
+type ShardMove struct {
+	From string
+	To   string
+}
+
+// MigrationSet 计算分片从 oldShards 变为 newShards 时需要迁移的 key，分片的格式与配置相同，
+// keys 为 redis 中完整的 key
+func MigrationSet(oldShards, newShards []string, keys []string) map[ShardMove][]string {
+	oldRing := NewHashRing(shardNames(oldShards))
+	newRing := NewHashRing(shardNames(newShards))
+	moves := make(map[ShardMove][]string)
+	for _, key := range keys {
+		from, to := oldRing.Get(key), newRing.Get(key)
+		if from != to {
+			move := ShardMove{From: from, To: to}
+			moves[move] = append(moves[move], key)
+		}
+	}
+	return moves
+}
+
+// MigrationSet 扫描当前所有分片中匹配 match 的 key，计算分片变为 newShards 时需要迁移的 key
+func (m *Client) MigrationSet(ctx context.Context, newShards []string, match string) (map[ShardMove][]string, error) {
+	if m.shards == nil {
+		return nil, fmt.Errorf("namespace %s is not sharded", m.namespace)
+	}
+
+	var keys []string
+	for _, s := range m.shards.shards {
+		iter := s.client.Scan(0, match, 0).Iterator()
+		for iter.Next() {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("scan shard %s err: %v", s.name, err)
+		}
+	}
+
+	oldShards := make([]string, len(m.shards.shards))
+	for i, s := range m.shards.shards {
+		oldShards[i] = s.name
+	}
+	return MigrationSet(oldShards, newShards, keys), nil
+}
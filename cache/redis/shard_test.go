@@ -0,0 +1,102 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := NewHashRing([]string{"s1", "s2", "s3"})
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		node := ring.Get(key)
+		assert.Equal(t, node, ring.Get(key))
+		counts[node]++
+	}
+	assert.Len(t, counts, 3)
+	for _, c := range counts {
+		assert.True(t, c > 500, "unbalanced ring: %v", counts)
+	}
+
+	assert.Equal(t, ring.Get("{user1000}.following"), ring.Get("{user1000}.followers"))
+	assert.Equal(t, "", NewHashRing(nil).Get("foo"))
+}
+
+func TestParseShard(t *testing.T) {
+	name, addr := parseShard("s1=127.0.0.1:6379")
+	assert.Equal(t, "s1", name)
+	assert.Equal(t, "127.0.0.1:6379", addr)
+	name, addr = parseShard("127.0.0.1:6380")
+	assert.Equal(t, "127.0.0.1:6380", name)
+	assert.Equal(t, "127.0.0.1:6380", addr)
+}
+
+func TestMigrationSet(t *testing.T) {
+	var keys []string
+	for i := 0; i < 3000; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i))
+	}
+	oldShards := []string{"s1=127.0.0.1:6379", "s2=127.0.0.1:6380", "s3=127.0.0.1:6381"}
+	newShards := append(oldShards, "s4=127.0.0.1:6382")
+	moves := MigrationSet(oldShards, newShards, keys)
+	moved := 0
+	for move, ks := range moves {
+		assert.Equal(t, "s4", move.To)
+		moved += len(ks)
+	}
+	assert.True(t, moved > 0 && moved < len(keys)/2, "moved %d", moved)
+	assert.Empty(t, MigrationSet(oldShards, oldShards, keys))
+}
+
+func TestShardedPFCount(t *testing.T) {
+	ctx := context.Background()
+	configer := NewShardedMemoryConfiger(3)
+	defer configer.Close()
+	old := DefaultConfiger
+	DefaultConfiger = configer
+	defer func() { DefaultConfiger = old }()
+
+	client, err := NewClient(ctx, "test/sharded", "")
+	assert.NoError(t, err)
+	defer client.Close(ctx)
+
+	// 找到两个在不同分片上的 key
+	first := "hll0"
+	var other string
+	for i := 1; other == ""; i++ {
+		key := fmt.Sprintf("hll%d", i)
+		if client.shards.ring.index(client.fixKey(key)) != client.shards.ring.index(client.fixKey(first)) {
+			other = key
+		}
+	}
+	// 跨分片的 key 在发送命令前就返回错误
+	assert.Contains(t, client.PFCount(ctx, first, other).Err().Error(), "different shards")
+	assert.Error(t, client.PFMerge(ctx, first, other).Err())
+	assert.Error(t, client.PFMerge(ctx, other, first).Err())
+}
+
+func TestShardedScriptExists(t *testing.T) {
+	ctx := context.Background()
+	configer := NewShardedMemoryConfiger(3)
+	defer configer.Close()
+	old := DefaultConfiger
+	DefaultConfiger = configer
+	defer func() { DefaultConfiger = old }()
+
+	client, err := NewClient(ctx, "test/sharded", "")
+	assert.NoError(t, err)
+	defer client.Close(ctx)
+
+	src := "return 'sharded script exists'"
+	hash := scriptHash(src)
+	// 只在部分分片上加载时不算存在
+	assert.NoError(t, client.shards.shards[1].client.ScriptLoad(src).Err())
+	assert.Equal(t, []bool{false}, client.ScriptExists(ctx, hash).Val())
+
+	assert.NoError(t, client.ScriptLoad(ctx, src).Err())
+	assert.Equal(t, []bool{true}, client.ScriptExists(ctx, hash).Val())
+}
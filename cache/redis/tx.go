@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis"
+)
+
+// TxFailedErr 被 WATCH 的 key 在 EXEC 之前被修改，事务没有执行
+var TxFailedErr = redis.TxFailedErr
+
+// Tx WATCH 之后的事务连接，读命令立即执行，写命令通过 TxPipelined 在 MULTI/EXEC 中原子地执行
+type Tx struct {
+	client *Client
+	tx     *redis.Tx
+}
+
+// Watch 监视 keys 并执行 fn，fn 返回后自动 UNWATCH
+// 分片模式下 keys 需要在同一个分片，cluster 模式下需要使用 HashTag 保证在同一个 slot 中
+func (m *Client) Watch(ctx context.Context, fn func(*Tx) error, keys ...string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("redis: watch without keys")
+	}
+	fixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixKeys[i] = m.fixKey(key)
+	}
+	if m.shards != nil {
+		for _, k := range fixKeys[1:] {
+			if m.shards.ring.index(k) != m.shards.ring.index(fixKeys[0]) {
+				return fmt.Errorf("redis: watch keys %v in different shards", keys)
+			}
+		}
+	}
+	m.logSpan(ctx, "Watch", firstKey(fixKeys))
+	// NOTE: Tx 使用独立的连接，不经过 client 的 hook，需要单独统计
+	m.inflight.add(1)
+	defer m.inflight.add(-1)
+	return m.route(firstKey(fixKeys)).Watch(func(tx *redis.Tx) error {
+		return fn(&Tx{client: m, tx: tx})
+	}, fixKeys...)
+}
+
+// TxPipelined 在 MULTI/EXEC 中执行 fn 加入的命令，被监视的 key 已经被修改时返回 TxFailedErr
+func (m *Tx) TxPipelined(ctx context.Context, fn func(*Pipeline) error) ([]redis.Cmder, error) {
+	p := &Pipeline{
+		namespace: m.client.namespace,
+		pipeline:  m.tx.TxPipeline(),
+		opts:      m.client.opts,
+		keyStats:  m.client.keyStats,
+	}
+	defer p.Close()
+	if err := fn(p); err != nil {
+		return nil, err
+	}
+	return p.Exec(ctx)
+}
+
+func (m *Tx) Get(ctx context.Context, key string) *redis.StringCmd {
+	k := m.client.fixKey(key)
+	m.client.logSpan(ctx, "Tx.Get", k)
+	return m.tx.Get(k)
+}
+
+func (m *Tx) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	fixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixKeys[i] = m.client.fixKey(key)
+	}
+	m.client.logSpan(ctx, "Tx.Exists", firstKey(fixKeys))
+	return m.tx.Exists(fixKeys...)
+}
+
+func (m *Tx) HGet(ctx context.Context, key, field string) *redis.StringCmd {
+	k := m.client.fixKey(key)
+	m.client.logSpan(ctx, "Tx.HGet", k)
+	return m.tx.HGet(k, field)
+}
+
+func (m *Tx) HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd {
+	k := m.client.fixKey(key)
+	m.client.logSpan(ctx, "Tx.HGetAll", k)
+	return m.tx.HGetAll(k)
+}
+
+func (m *Tx) ZScore(ctx context.Context, key, member string) *redis.FloatCmd {
+	k := m.client.fixKey(key)
+	m.client.logSpan(ctx, "Tx.ZScore", k)
+	return m.tx.ZScore(k, member)
+}
+
+func (m *Tx) SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd {
+	k := m.client.fixKey(key)
+	m.client.logSpan(ctx, "Tx.SIsMember", k)
+	return m.tx.SIsMember(k, member)
+}
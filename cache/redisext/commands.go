@@ -0,0 +1,261 @@
+package redisext
+
+import (
+	"context"
+
+	redis2 "github.com/go-redis/redis"
+	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+type (
+	GeoLocation    = redis2.GeoLocation
+	GeoPos         = redis2.GeoPos
+	GeoRadiusQuery = redis2.GeoRadiusQuery
+	GeoSearchQuery = redis.GeoSearchQuery
+	BitCount       = redis2.BitCount
+)
+
+// prefixGeoRadiusQuery STORE 和 STOREDIST 的 key 也需要加前缀
+func (m *RedisExt) prefixGeoRadiusQuery(query *GeoRadiusQuery) *GeoRadiusQuery {
+	q := *query
+	if len(q.Store) > 0 {
+		q.Store = m.prefixKey(q.Store)
+	}
+	if len(q.StoreDist) > 0 {
+		q.StoreDist = m.prefixKey(q.StoreDist)
+	}
+	return &q
+}
+
+func (m *RedisExt) GeoAdd(ctx context.Context, key string, locations ...*GeoLocation) (n int64, err error) {
+	command := "redisext.GeoAdd"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.GeoAdd(ctx, m.prefixKey(key), locations...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+// GeoRadius 设置了 Store 或者 StoreDist 时为写命令，只在主库执行
+func (m *RedisExt) GeoRadius(ctx context.Context, key string, longitude, latitude float64, query *GeoRadiusQuery) (locations []GeoLocation, err error) {
+	command := "redisext.GeoRadius"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	var client *redis.Client
+	if len(query.Store) > 0 || len(query.StoreDist) > 0 {
+		client, err = m.getRedisInstance(ctx)
+	} else {
+		client, err = m.getReadInstance(ctx, command)
+	}
+	if err == nil {
+		locations, err = client.GeoRadius(ctx, m.prefixKey(key), longitude, latitude, m.prefixGeoRadiusQuery(query)).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) GeoRadiusByMember(ctx context.Context, key, member string, query *GeoRadiusQuery) (locations []GeoLocation, err error) {
+	command := "redisext.GeoRadiusByMember"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	var client *redis.Client
+	if len(query.Store) > 0 || len(query.StoreDist) > 0 {
+		client, err = m.getRedisInstance(ctx)
+	} else {
+		client, err = m.getReadInstance(ctx, command)
+	}
+	if err == nil {
+		locations, err = client.GeoRadiusByMember(ctx, m.prefixKey(key), member, m.prefixGeoRadiusQuery(query)).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+// GeoSearch 需要 redis 6.2 以上版本
+func (m *RedisExt) GeoSearch(ctx context.Context, key string, query *GeoSearchQuery) (locations []GeoLocation, err error) {
+	command := "redisext.GeoSearch"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		locations, err = client.GeoSearch(ctx, m.prefixKey(key), query).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) GeoDist(ctx context.Context, key string, member1, member2, unit string) (dist float64, err error) {
+	command := "redisext.GeoDist"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		dist, err = client.GeoDist(ctx, m.prefixKey(key), member1, member2, unit).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) GeoPos(ctx context.Context, key string, members ...string) (pos []*GeoPos, err error) {
+	command := "redisext.GeoPos"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		pos, err = client.GeoPos(ctx, m.prefixKey(key), members...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) GeoHash(ctx context.Context, key string, members ...string) (hashes []string, err error) {
+	command := "redisext.GeoHash"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		hashes, err = client.GeoHash(ctx, m.prefixKey(key), members...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) PFAdd(ctx context.Context, key string, els ...interface{}) (n int64, err error) {
+	command := "redisext.PFAdd"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.PFAdd(ctx, m.prefixKey(key), els...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+// PFCount 多个 key 时返回并集的基数
+func (m *RedisExt) PFCount(ctx context.Context, keys ...string) (n int64, err error) {
+	command := "redisext.PFCount"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		var prefixKey = make([]string, len(keys))
+		for k, v := range keys {
+			prefixKey[k] = m.prefixKey(v)
+		}
+		n, err = client.PFCount(ctx, prefixKey...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) PFMerge(ctx context.Context, dest string, keys ...string) (s string, err error) {
+	command := "redisext.PFMerge"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		var prefixKey = make([]string, len(keys))
+		for k, v := range keys {
+			prefixKey[k] = m.prefixKey(v)
+		}
+		s, err = client.PFMerge(ctx, m.prefixKey(dest), prefixKey...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) BitCount(ctx context.Context, key string, bitCount *BitCount) (n int64, err error) {
+	command := "redisext.BitCount"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		n, err = client.BitCount(ctx, m.prefixKey(key), bitCount).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) BitPos(ctx context.Context, key string, bit int64, pos ...int64) (n int64, err error) {
+	command := "redisext.BitPos"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		n, err = client.BitPos(ctx, m.prefixKey(key), bit, pos...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+// BitField args 为 GET/SET/INCRBY/OVERFLOW 子命令及其参数，如 "incrby", "u8", 0, 1
+// 可能写入数据，只在主库执行
+func (m *RedisExt) BitField(ctx context.Context, key string, args ...interface{}) (vals []int64, err error) {
+	command := "redisext.BitField"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		vals, err = client.BitField(ctx, m.prefixKey(key), args...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
@@ -0,0 +1,445 @@
+package redisext
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	go_redis "github.com/go-redis/redis"
+	"github.com/shawnfeng/sutil/slog/slog"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+const (
+	defaultConsumerBatchSize       = 10
+	defaultConsumerBlock           = time.Second
+	defaultConsumerMinIdle         = time.Minute
+	defaultConsumerReclaimInterval = 30 * time.Second
+	defaultConsumerMaxDeliveries   = 16
+	defaultConsumerBackOffStep     = 10 * time.Millisecond
+	defaultConsumerBackOffCeil     = 5 * time.Second
+	defaultConsumerRetries         = 3
+
+	// 死信消息中附带的原始消息信息
+	DeadLetterFieldOriginID   = "_origin_id"
+	DeadLetterFieldDeliveries = "_deliveries"
+)
+
+// StreamHandler 处理消息，返回nil时消息会被ack，否则消息留在pending列表中等待重新投递
+type StreamHandler func(ctx context.Context, msg XMessage) error
+
+type consumerOptions struct {
+	// 每次XREADGROUP读取的最大消息数
+	batchSize int64
+	// XREADGROUP阻塞时间
+	block time.Duration
+	// pending消息空闲超过minIdle后会被重新认领
+	minIdle time.Duration
+	// 扫描pending列表的间隔
+	reclaimInterval time.Duration
+	// 投递次数达到maxDeliveries后进入死信
+	maxDeliveries int64
+	// 死信stream，为空时只ack不转存
+	deadLetterStream string
+	// 处理失败或者读取失败时退避的初始间隔和最大间隔
+	backOffStep time.Duration
+	backOffCeil time.Duration
+	// 处理失败后在当前consumer中原地重试的次数，超过后留在pending列表中等待重新认领
+	retries int
+}
+
+type ConsumerOption interface {
+	apply(*consumerOptions)
+}
+
+type consumerBatchSizeOption int64
+
+func (c consumerBatchSizeOption) apply(opts *consumerOptions) {
+	opts.batchSize = int64(c)
+}
+
+func WithConsumerBatchSize(n int64) ConsumerOption {
+	return consumerBatchSizeOption(n)
+}
+
+type consumerBlockOption time.Duration
+
+func (c consumerBlockOption) apply(opts *consumerOptions) {
+	opts.block = time.Duration(c)
+}
+
+func WithConsumerBlock(d time.Duration) ConsumerOption {
+	return consumerBlockOption(d)
+}
+
+type consumerMinIdleOption time.Duration
+
+func (c consumerMinIdleOption) apply(opts *consumerOptions) {
+	opts.minIdle = time.Duration(c)
+}
+
+func WithConsumerMinIdle(d time.Duration) ConsumerOption {
+	return consumerMinIdleOption(d)
+}
+
+type consumerReclaimIntervalOption time.Duration
+
+func (c consumerReclaimIntervalOption) apply(opts *consumerOptions) {
+	opts.reclaimInterval = time.Duration(c)
+}
+
+func WithConsumerReclaimInterval(d time.Duration) ConsumerOption {
+	return consumerReclaimIntervalOption(d)
+}
+
+type consumerMaxDeliveriesOption int64
+
+func (c consumerMaxDeliveriesOption) apply(opts *consumerOptions) {
+	opts.maxDeliveries = int64(c)
+}
+
+func WithConsumerMaxDeliveries(n int64) ConsumerOption {
+	return consumerMaxDeliveriesOption(n)
+}
+
+type consumerDeadLetterStreamOption string
+
+func (c consumerDeadLetterStreamOption) apply(opts *consumerOptions) {
+	opts.deadLetterStream = string(c)
+}
+
+func WithConsumerDeadLetterStream(stream string) ConsumerOption {
+	return consumerDeadLetterStreamOption(stream)
+}
+
+type consumerBackOffOption struct {
+	step, ceil time.Duration
+}
+
+func (c consumerBackOffOption) apply(opts *consumerOptions) {
+	opts.backOffStep = c.step
+	opts.backOffCeil = c.ceil
+}
+
+func WithConsumerBackOff(step, ceil time.Duration) ConsumerOption {
+	return consumerBackOffOption{step: step, ceil: ceil}
+}
+
+type consumerRetriesOption int
+
+func (c consumerRetriesOption) apply(opts *consumerOptions) {
+	opts.retries = int(c)
+}
+
+func WithConsumerRetries(n int) ConsumerOption {
+	return consumerRetriesOption(n)
+}
+
+// StreamConsumer 基于stream消费组的消费者
+//
+// 处理成功的消息会被ack；处理失败的消息留在pending列表中，空闲超过minIdle后
+// 被重新认领并再次处理，投递次数达到maxDeliveries后转入死信stream。
+// 其他已经退出的consumer遗留的pending消息也会以同样的方式被认领。
+type StreamConsumer struct {
+	redisExt *RedisExt
+	stream   string
+	group    string
+	consumer string
+	handler  StreamHandler
+	opts     *consumerOptions
+	// 处理失败和读取失败分别退避
+	backoff     *stime.BackOffCtrl
+	readBackoff *stime.BackOffCtrl
+}
+
+func NewStreamConsumer(redisExt *RedisExt, stream, group, consumer string, handler StreamHandler, opts ...ConsumerOption) *StreamConsumer {
+	opt := &consumerOptions{
+		batchSize:       defaultConsumerBatchSize,
+		block:           defaultConsumerBlock,
+		minIdle:         defaultConsumerMinIdle,
+		reclaimInterval: defaultConsumerReclaimInterval,
+		maxDeliveries:   defaultConsumerMaxDeliveries,
+		backOffStep:     defaultConsumerBackOffStep,
+		backOffCeil:     defaultConsumerBackOffCeil,
+		retries:         defaultConsumerRetries,
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	return &StreamConsumer{
+		redisExt:    redisExt,
+		stream:      stream,
+		group:       group,
+		consumer:    consumer,
+		handler:     handler,
+		opts:        opt,
+		backoff:     stime.NewBackOffCtrl(opt.backOffStep, opt.backOffCeil),
+		readBackoff: stime.NewBackOffCtrl(opt.backOffStep, opt.backOffCeil),
+	}
+}
+
+// Run 阻塞执行消费循环，直到ctx结束
+func (m *StreamConsumer) Run(ctx context.Context) error {
+	fun := "StreamConsumer.Run -->"
+	err := m.createGroup(ctx)
+	if err != nil {
+		slog.Errorf(ctx, "%s create group stream:%s group:%s err:%v", fun, m.stream, m.group, err)
+		return err
+	}
+
+	slog.Infof(ctx, "%s start consume stream:%s group:%s consumer:%s", fun, m.stream, m.group, m.consumer)
+	// ctx 结束时中断正在进行的退避
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.backoff.Reset()
+			m.readBackoff.Reset()
+		case <-stop:
+		}
+	}()
+
+	// 先处理自己重启前未ack的消息，每次从上一批的最后一个id之后继续读取，直到没有pending消息
+	for id := "0"; ctx.Err() == nil; {
+		if id = m.consume(ctx, id); len(id) == 0 {
+			break
+		}
+	}
+	lastReclaim := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Infof(ctx, "%s context done err:%v", fun, ctx.Err())
+			return nil
+		default:
+		}
+
+		if time.Since(lastReclaim) >= m.opts.reclaimInterval {
+			m.reclaim(ctx)
+			lastReclaim = time.Now()
+		}
+
+		m.consume(ctx, ">")
+	}
+}
+
+func (m *StreamConsumer) createGroup(ctx context.Context) error {
+	_, err := m.redisExt.XGroupCreateMkStream(ctx, m.stream, m.group, "0")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// consume 读取并处理一批消息，返回最后一条消息的id，没有读到消息时返回空
+func (m *StreamConsumer) consume(ctx context.Context, id string) string {
+	fun := "StreamConsumer.consume -->"
+	block := m.opts.block
+	if id != ">" {
+		// NOTE: 读取自己的pending列表时不需要阻塞
+		block = -1
+	}
+	ss, err := m.redisExt.XReadGroup(ctx, &XReadGroupArgs{
+		Group:    m.group,
+		Consumer: m.consumer,
+		Streams:  []string{m.stream, id},
+		Count:    m.opts.batchSize,
+		Block:    block,
+	})
+	if err == go_redis.Nil {
+		return ""
+	}
+	if err != nil {
+		slog.Errorf(ctx, "%s read stream:%s group:%s err:%v", fun, m.stream, m.group, err)
+		m.wait(ctx, m.readBackoff)
+		return ""
+	}
+	m.readBackoff.Reset()
+
+	var last string
+	for _, s := range ss {
+		for _, msg := range s.Messages {
+			m.process(ctx, msg)
+			last = msg.ID
+		}
+	}
+	return last
+}
+
+// reclaim 分页扫描整个pending列表，认领空闲超过minIdle的消息，投递次数过多的消息转入死信
+func (m *StreamConsumer) reclaim(ctx context.Context) {
+	fun := "StreamConsumer.reclaim -->"
+	for start := "-"; ctx.Err() == nil; {
+		ps, err := m.redisExt.XPendingExt(ctx, &XPendingExtArgs{
+			Stream: m.stream,
+			Group:  m.group,
+			Start:  start,
+			End:    "+",
+			Count:  m.opts.batchSize,
+		})
+		if err != nil {
+			slog.Errorf(ctx, "%s pending stream:%s group:%s err:%v", fun, m.stream, m.group, err)
+			return
+		}
+		m.reclaimPending(ctx, ps)
+		if int64(len(ps)) < m.opts.batchSize {
+			return
+		}
+		if start, err = nextStreamID(ps[len(ps)-1].ID); err != nil {
+			slog.Errorf(ctx, "%s stream:%s group:%s err:%v", fun, m.stream, m.group, err)
+			return
+		}
+	}
+}
+
+func (m *StreamConsumer) reclaimPending(ctx context.Context, ps []XPendingExt) {
+	fun := "StreamConsumer.reclaimPending -->"
+	var ids []string
+	for _, p := range ps {
+		if p.Idle < m.opts.minIdle {
+			continue
+		}
+		if p.RetryCount >= m.opts.maxDeliveries {
+			m.deadLetter(ctx, p)
+			continue
+		}
+		ids = append(ids, p.ID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	msgs, err := m.redisExt.XClaim(ctx, &XClaimArgs{
+		Stream:   m.stream,
+		Group:    m.group,
+		Consumer: m.consumer,
+		MinIdle:  m.opts.minIdle,
+		Messages: ids,
+	})
+	if err != nil {
+		slog.Errorf(ctx, "%s claim stream:%s group:%s ids:%v err:%v", fun, m.stream, m.group, ids, err)
+		return
+	}
+	slog.Infof(ctx, "%s claimed stream:%s group:%s consumer:%s count:%d", fun, m.stream, m.group, m.consumer, len(msgs))
+	for _, msg := range msgs {
+		m.process(ctx, msg)
+	}
+}
+
+// deadLetter 先认领消息，避免其他consumer同时处理或者转存，再写入死信stream并ack
+func (m *StreamConsumer) deadLetter(ctx context.Context, p XPendingExt) {
+	fun := "StreamConsumer.deadLetter -->"
+	ids, err := m.redisExt.XClaimJustID(ctx, &XClaimArgs{
+		Stream:   m.stream,
+		Group:    m.group,
+		Consumer: m.consumer,
+		MinIdle:  m.opts.minIdle,
+		Messages: []string{p.ID},
+	})
+	if err != nil {
+		slog.Errorf(ctx, "%s claim stream:%s id:%s err:%v", fun, m.stream, p.ID, err)
+		return
+	}
+	if len(ids) == 0 {
+		// NOTE: 已经被其他consumer认领或者ack
+		return
+	}
+
+	if len(m.opts.deadLetterStream) > 0 {
+		ms, err := m.redisExt.XRange(ctx, m.stream, p.ID, p.ID)
+		if err != nil {
+			slog.Errorf(ctx, "%s range stream:%s id:%s err:%v", fun, m.stream, p.ID, err)
+			return
+		}
+		values := map[string]interface{}{
+			DeadLetterFieldOriginID:   p.ID,
+			DeadLetterFieldDeliveries: p.RetryCount,
+		}
+		// NOTE: 消息可能已经被XDEL或XTRIM删除，此时只记录原始id
+		if len(ms) > 0 {
+			for k, v := range ms[0].Values {
+				values[k] = v
+			}
+		}
+		_, err = m.redisExt.XAdd(ctx, &XAddArgs{
+			Stream: m.opts.deadLetterStream,
+			Values: values,
+		})
+		if err != nil {
+			slog.Errorf(ctx, "%s add dead letter stream:%s id:%s err:%v", fun, m.opts.deadLetterStream, p.ID, err)
+			return
+		}
+	}
+
+	_, err = m.redisExt.XAck(ctx, m.stream, m.group, p.ID)
+	if err != nil {
+		slog.Errorf(ctx, "%s ack stream:%s id:%s err:%v", fun, m.stream, p.ID, err)
+		return
+	}
+	slog.Warnf(ctx, "%s dead letter stream:%s group:%s id:%s deliveries:%d", fun, m.stream, m.group, p.ID, p.RetryCount)
+}
+
+// nextStreamID 返回紧跟在id之后的消息id，用于分页时排除上一页的最后一条
+func nextStreamID(id string) (string, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid stream id: %s", id)
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream id: %s", id)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream id: %s", id)
+	}
+	if seq == ^uint64(0) {
+		return fmt.Sprintf("%d-0", ms+1), nil
+	}
+	return fmt.Sprintf("%d-%d", ms, seq+1), nil
+}
+
+// process 处理失败时退避后原地重试，重试次数用完或者ctx结束时消息留在pending列表中
+func (m *StreamConsumer) process(ctx context.Context, msg XMessage) {
+	fun := "StreamConsumer.process -->"
+	for attempt := 0; ; attempt++ {
+		err := m.handle(ctx, msg)
+		if err == nil {
+			break
+		}
+		slog.Warnf(ctx, "%s handle stream:%s id:%s attempt:%d err:%v", fun, m.stream, msg.ID, attempt, err)
+		if attempt >= m.opts.retries || !m.wait(ctx, m.backoff) {
+			return
+		}
+	}
+	m.backoff.Reset()
+
+	_, err := m.redisExt.XAck(ctx, m.stream, m.group, msg.ID)
+	if err != nil {
+		slog.Errorf(ctx, "%s ack stream:%s id:%s err:%v", fun, m.stream, msg.ID, err)
+	}
+}
+
+// wait 使用backoff退避，ctx结束时返回false，Run会在ctx结束时中断正在进行的退避
+func (m *StreamConsumer) wait(ctx context.Context, backoff *stime.BackOffCtrl) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	backoff.BackOff()
+	return ctx.Err() == nil
+}
+
+func (m *StreamConsumer) handle(ctx context.Context, msg XMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = fmt.Errorf("recover err: %v, stack: %s", r, string(buf))
+		}
+	}()
+	return m.handler(ctx, msg)
+}
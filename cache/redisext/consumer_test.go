@@ -0,0 +1,193 @@
+package redisext
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamConsumer_Run(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	client.Del(ctx, streamName)
+	id, err := client.XAdd(ctx, &XAddArgs{
+		Stream: streamName,
+		Values: map[string]interface{}{"hello": "world"},
+	})
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	var got []string
+	consumer := NewStreamConsumer(client, streamName, groupName, "c1", func(ctx context.Context, msg XMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, msg.ID)
+		// 第一次失败，原地重试后成功
+		if len(got) == 1 {
+			return errors.New("fail once")
+		}
+		cancel()
+		return nil
+	}, WithConsumerBlock(100*time.Millisecond), WithConsumerBackOff(time.Millisecond, 10*time.Millisecond))
+	err = consumer.Run(ctx)
+	assert.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, []string{id, id}, got)
+	mu.Unlock()
+
+	pending, err := client.XPending(context.Background(), streamName, groupName)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+	client.Del(context.Background(), streamName)
+}
+
+func TestStreamConsumer_DeadLetter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	deadLetterName := streamName + "_dead"
+	client.Del(ctx, streamName)
+	client.Del(ctx, deadLetterName)
+	id, err := client.XAdd(ctx, &XAddArgs{
+		Stream: streamName,
+		Values: map[string]interface{}{"hello": "world"},
+	})
+	assert.NoError(t, err)
+
+	consumer := NewStreamConsumer(client, streamName, groupName, "c1", func(ctx context.Context, msg XMessage) error {
+		return errors.New("always fail")
+	},
+		WithConsumerBlock(10*time.Millisecond),
+		WithConsumerMinIdle(time.Millisecond),
+		WithConsumerReclaimInterval(10*time.Millisecond),
+		WithConsumerMaxDeliveries(2),
+		WithConsumerRetries(0),
+		WithConsumerBackOff(time.Millisecond, 10*time.Millisecond),
+		WithConsumerDeadLetterStream(deadLetterName))
+	go func() {
+		for {
+			n, _ := client.XLen(ctx, deadLetterName)
+			if n > 0 {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	err = consumer.Run(ctx)
+	assert.NoError(t, err)
+
+	ms, err := client.XRange(context.Background(), deadLetterName, "-", "+")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ms))
+	assert.Equal(t, id, ms[0].Values[DeadLetterFieldOriginID])
+	client.Del(context.Background(), streamName)
+	client.Del(context.Background(), deadLetterName)
+}
+
+// 重启前未ack的消息超过一批时，启动时要全部重新处理
+func TestStreamConsumer_RunReplayPending(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	client.Del(ctx, streamName)
+	_, err := client.XGroupCreateMkStream(ctx, streamName, groupName, "0")
+	assert.NoError(t, err)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := client.XAdd(ctx, &XAddArgs{
+			Stream: streamName,
+			Values: map[string]interface{}{"i": i},
+		})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err = client.XReadGroup(ctx, &XReadGroupArgs{
+		Group:    groupName,
+		Consumer: "c1",
+		Streams:  []string{streamName, ">"},
+		Block:    -1,
+	})
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	var got []string
+	consumer := NewStreamConsumer(client, streamName, groupName, "c1", func(ctx context.Context, msg XMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, msg.ID)
+		if len(got) == len(ids) {
+			cancel()
+		}
+		return nil
+	}, WithConsumerBatchSize(1), WithConsumerBlock(10*time.Millisecond))
+	err = consumer.Run(ctx)
+	assert.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, ids, got)
+	mu.Unlock()
+	client.Del(context.Background(), streamName)
+}
+
+// pending列表的第一页都还没有空闲足够久时，也要认领后面空闲的消息
+func TestStreamConsumer_ReclaimPaging(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	client.Del(ctx, streamName)
+	_, err := client.XGroupCreateMkStream(ctx, streamName, groupName, "0")
+	assert.NoError(t, err)
+	var ids []string
+	for i := 0; i < 4; i++ {
+		id, err := client.XAdd(ctx, &XAddArgs{
+			Stream: streamName,
+			Values: map[string]interface{}{"i": i},
+		})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err = client.XReadGroup(ctx, &XReadGroupArgs{
+		Group:    groupName,
+		Consumer: "c0",
+		Streams:  []string{streamName, ">"},
+		Block:    -1,
+	})
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	// 重新认领前两条，刷新它们的空闲时间
+	_, err = client.XClaimJustID(ctx, &XClaimArgs{
+		Stream:   streamName,
+		Group:    groupName,
+		Consumer: "c0",
+		Messages: ids[:2],
+	})
+	assert.NoError(t, err)
+
+	var got []string
+	consumer := NewStreamConsumer(client, streamName, groupName, "c1", func(ctx context.Context, msg XMessage) error {
+		got = append(got, msg.ID)
+		return nil
+	}, WithConsumerBatchSize(2), WithConsumerMinIdle(50*time.Millisecond))
+	consumer.reclaim(ctx)
+	assert.Equal(t, ids[2:], got)
+	client.Del(ctx, streamName)
+}
+
+func TestNextStreamID(t *testing.T) {
+	id, err := nextStreamID("1-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1-3", id)
+	id, err = nextStreamID("1-18446744073709551615")
+	assert.NoError(t, err)
+	assert.Equal(t, "2-0", id)
+	_, err = nextStreamID("abc")
+	assert.Error(t, err)
+}
@@ -0,0 +1,25 @@
+package redisext
+
+import (
+	"context"
+
+	"github.com/shawnfeng/sutil/cache/redis"
+)
+
+// HotKeys 返回上一个统计周期内访问次数最多的 key，需要在 namespace 的配置中开启 hotkeysamplerate
+func (m *RedisExt) HotKeys(ctx context.Context) ([]redis.HotKey, error) {
+	client, err := m.getRedisInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.HotKeys(), nil
+}
+
+// BigKeys 返回上一个统计周期内值的大小超过阈值的 key，需要在 namespace 的配置中开启 bigkeybytes
+func (m *RedisExt) BigKeys(ctx context.Context) (map[string]int, error) {
+	client, err := m.getRedisInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.BigKeys(), nil
+}
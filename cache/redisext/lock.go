@@ -2,7 +2,7 @@ package redisext
 
 import (
 	"context"
-	"strconv"
+	"fmt"
 	"time"
 )
 
@@ -11,6 +11,7 @@ const (
 
 	InitState = "0"
 	Doing     = "1"
+	Failed    = "2"
 	Done      = "99"
 )
 
@@ -39,66 +40,127 @@ func (m *RedisExt) LockWithTimeout(ctx context.Context, key string, value interf
 
 // Unlock release lock with check lock value
 func (m *RedisExt) Unlock(ctx context.Context, key string, value interface{}) (bool, error) {
-	src := "if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('del', KEYS[1]) else return 0 end"
-	script := NewScript(src)
-	r, err := m.Eval(ctx, script, []string{key}, value)
+	r, err := m.Run(ctx, unlockScript, []string{key}, value)
 	if err != nil {
 		return false, err
 	}
 	return r.(int64) == 1, nil
 }
 
+var (
+	unlockScript = RegisterScript("if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('del', KEYS[1]) else return 0 end")
+
+	// KEYS[1]: 业务记录key
+	// ARGV[1]: 业务操作超时时间(ms) ARGV[2]: 业务记录过期时间(ms)，为0时不过期
+	// 返回: {是否可以继续执行(1/0), 加锁前的状态, 业务结果}
+	// 当前时间取 redis 服务端的 TIME，不依赖各个客户端的时钟
+	tryAcquireScript = RegisterScript(`
+redis.replicate_commands()
+local key = KEYS[1]
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local deadline = now + tonumber(ARGV[1])
+-- 兼容旧版本以字符串保存的记录
+if redis.call("TYPE", key).ok == "string" then
+	if redis.call("GET", key) == "99" then
+		return {0, "99", ""}
+	end
+	redis.call("DEL", key)
+end
+local state = redis.call("HGET", key, "state")
+if not state then
+	redis.call("HMSET", key, "state", "1", "deadline", deadline, "exp", ARGV[2])
+	if tonumber(ARGV[2]) > 0 then
+		redis.call("PEXPIRE", key, ARGV[2])
+	end
+	return {1, "0", ""}
+end
+if state == "99" then
+	return {0, state, redis.call("HGET", key, "result") or ""}
+end
+if state == "1" and now < tonumber(redis.call("HGET", key, "deadline")) then
+	return {0, state, ""}
+end
+redis.call("HMSET", key, "state", "1", "deadline", deadline)
+return {1, state, ""}`)
+
+	// KEYS[1]: 业务记录key ARGV[1]: 业务结果
+	confirmScript = RegisterScript(`
+local key = KEYS[1]
+if redis.call("TYPE", key).ok == "string" then
+	redis.call("DEL", key)
+end
+redis.call("HMSET", key, "state", "99", "result", ARGV[1])
+if redis.call("PTTL", key) < 0 then
+	local exp = redis.call("HGET", key, "exp")
+	if exp and tonumber(exp) > 0 then
+		redis.call("PEXPIRE", key, exp)
+	end
+end
+return 1`)
+
+	// KEYS[1]: 业务记录key
+	failScript = RegisterScript(`
+local key = KEYS[1]
+if redis.call("TYPE", key).ok ~= "hash" then
+	return 0
+end
+if redis.call("HGET", key, "state") == "99" then
+	return 0
+end
+redis.call("HSET", key, "state", "2")
+return 1`)
+)
+
 // TryAcquire 通过特殊的全局锁，保证以key为依据的业务操作是幂等的，可能的情况如下:
 //
 // 入口参数:
 // @timeout: 业务操作的超时时间，比如: 2min
-// @expiration: 业务记录过期时间，比如: 24h
+// @expiration: 业务记录过期时间，比如: 24h，为0时不过期
 //
 // 返回参数:
 // @canHandle: 是否可以继续执行该笔业务记录，
-// @state: 该笔业务记录的状态，InitState：初始状态 Done: 处理完成 Doing：正在处理中
+// @state: 该笔业务记录的状态，InitState：初始状态 Done: 处理完成 Doing：正在处理中 Failed: 处理失败
 // @err: 异常错误
 //
 // 特别说明:
-// 可以继续执行该笔业务记录的情况有: 记录为初始状态或者记录为Doing状态，且超过了timeout这个执行时间
+// 可以继续执行该笔业务记录的情况有: 记录为初始状态、记录为Failed状态，或者记录为Doing状态且超过了timeout这个执行时间
 func (m *RedisExt) TryAcquire(ctx context.Context, key string, expiration, timeout time.Duration) (canHandle bool, state string, err error) {
-	value := time.Now().Add(timeout).Second()
-	r, err := m.SetNX(ctx, key, value, expiration)
-	if err != nil {
-		return false, "unknown", err
-	}
-	// 加锁成功，可以继续业务处理
-	if r {
-		return true, InitState, nil
-	}
-
-	// 加锁失败，需要分情况判断
-	// 状态已经被确认，不需要重新处理业务，可以根据状态进行自己所需操作，如直接返回成功
-	s, err := m.Get(ctx, key)
-	if s == Done {
-		return false, Done, nil
-	}
+	canHandle, state, _, err = m.TryAcquireWithResult(ctx, key, expiration, timeout)
+	return
+}
 
-	// 当前时间小于业务处理过期时间，应该是有其他的进程/线程在处理，不需要处理业务，返回处理中
-	now := time.Now().Second()
-	result, _ := strconv.Atoi(s)
-	if now < result {
-		return false, Doing, nil
+// TryAcquireWithResult 同 TryAcquire，记录为Done状态时同时返回 ConfirmWithResult 保存的业务结果，
+// 重复的调用方可以直接使用该结果作为响应
+func (m *RedisExt) TryAcquireWithResult(ctx context.Context, key string, expiration, timeout time.Duration) (canHandle bool, state, result string, err error) {
+	r, err := m.Run(ctx, tryAcquireScript, []string{key}, int64(timeout/time.Millisecond), int64(expiration/time.Millisecond))
+	if err != nil {
+		return false, "unknown", "", err
 	}
 
-	// 锁处于处理中，当前时间大于业务处理过期时间，类似乐观锁
-	delta := now - result + int(timeout.Seconds())
-	r1, err := m.IncrBy(ctx, key, int64(delta))
-	if r1 == int64(result+delta) {
-		return true, Doing, nil
-	} else {
-		m.DecrBy(ctx, key, int64(delta))
+	vals, ok := r.([]interface{})
+	if !ok || len(vals) != 3 {
+		return false, "unknown", "", fmt.Errorf("unexpected script result: %v", r)
 	}
-	return false, Doing, nil
+	acquired, _ := vals[0].(int64)
+	state, _ = vals[1].(string)
+	result, _ = vals[2].(string)
+	return acquired == 1, state, result, nil
 }
 
 // Confirm 确定业务处理完成
 func (m *RedisExt) Confirm(ctx context.Context, key string) error {
-	_, err := m.Set(ctx, key, Done, 0)
+	return m.ConfirmWithResult(ctx, key, "")
+}
+
+// ConfirmWithResult 确定业务处理完成，并保存业务结果，之后的 TryAcquireWithResult 会返回该结果
+func (m *RedisExt) ConfirmWithResult(ctx context.Context, key, result string) error {
+	_, err := m.Run(ctx, confirmScript, []string{key}, result)
+	return err
+}
+
+// Fail 标记业务处理失败，之后的 TryAcquire 可以立即重新处理，不需要等待timeout
+func (m *RedisExt) Fail(ctx context.Context, key string) error {
+	_, err := m.Run(ctx, failScript, []string{key})
 	return err
 }
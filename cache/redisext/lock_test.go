@@ -91,3 +91,68 @@ func TestTryAcquire(t *testing.T) {
 	assert.Equal(t, false, canHandle3)
 	assert.Equal(t, Done, state3)
 }
+
+func TestTryAcquireWithResult(t *testing.T) {
+	orderID := "76c8c07d15c32fa90dd2b89f141246e9"
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	m := NewRedisExt("test/memory", "test")
+	m.Del(ctx, orderID)
+
+	canHandle, state, _, err := m.TryAcquireWithResult(ctx, orderID, time.Hour*24, time.Second*2)
+	assert.Nil(t, err)
+	assert.Equal(t, true, canHandle)
+	assert.Equal(t, InitState, state)
+
+	// retry immediately after failed
+	err = m.Fail(ctx, orderID)
+	assert.Nil(t, err)
+	canHandle, state, _, err = m.TryAcquireWithResult(ctx, orderID, time.Hour*24, time.Second*2)
+	assert.Nil(t, err)
+	assert.Equal(t, true, canHandle)
+	assert.Equal(t, Failed, state)
+
+	// duplicate callers get the result
+	err = m.ConfirmWithResult(ctx, orderID, `{"code":0}`)
+	assert.Nil(t, err)
+	canHandle, state, result, err := m.TryAcquireWithResult(ctx, orderID, time.Hour*24, time.Second*2)
+	assert.Nil(t, err)
+	assert.Equal(t, false, canHandle)
+	assert.Equal(t, Done, state)
+	assert.Equal(t, `{"code":0}`, result)
+
+	ttl, err := m.TTL(ctx, orderID)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+	m.Del(ctx, orderID)
+}
+
+func TestTryAcquireNoExpiration(t *testing.T) {
+	orderID := "76c8c07d15c32fa90dd2b89f141246ea"
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	m := NewRedisExt("test/memory", "test")
+	m.Del(ctx, orderID)
+
+	// expiration 为0时记录不过期，而不是被 PEXPIRE 0 删除
+	canHandle, state, err := m.TryAcquire(ctx, orderID, 0, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, true, canHandle)
+	assert.Equal(t, InitState, state)
+	canHandle, state, err = m.TryAcquire(ctx, orderID, 0, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, false, canHandle)
+	assert.Equal(t, Doing, state)
+
+	assert.Nil(t, m.ConfirmWithResult(ctx, orderID, "ok"))
+	canHandle, state, result, err := m.TryAcquireWithResult(ctx, orderID, 0, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, false, canHandle)
+	assert.Equal(t, Done, state)
+	assert.Equal(t, "ok", result)
+
+	ttl, err := m.TTL(ctx, orderID)
+	assert.Nil(t, err)
+	assert.True(t, ttl < 0)
+	m.Del(ctx, orderID)
+}
@@ -0,0 +1,333 @@
+package redisext
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+const (
+	defaultLockerRetryStep = 10 * time.Millisecond
+	defaultLockerRetryCeil = 500 * time.Millisecond
+	// 时钟漂移因子，参考 redlock 算法
+	lockerClockDriftFactor = 0.01
+
+	lockerFenceSuffix = ".fence"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("redisext: lock not acquired")
+	ErrLockNotHeld     = errors.New("redisext: lock not held")
+)
+
+var (
+	// 加锁成功时返回当前的 fencing token，失败时返回 -1
+	lockerAcquireScript = RegisterScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return tonumber(redis.call("GET", KEYS[2]) or "0")
+end
+return -1`)
+
+	// 仍然持有锁时将 fencing token 提升到不小于 ARGV[2]，返回提升后的值，不持有锁时返回 0
+	lockerFenceScript = RegisterScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local fence = tonumber(redis.call("GET", KEYS[2]) or "0")
+if fence < tonumber(ARGV[2]) then
+	fence = tonumber(ARGV[2])
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+return fence`)
+
+	lockerRenewScript = RegisterScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	lockerReleaseScript = RegisterScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+type lockerOptions struct {
+	// 获取锁失败后重试的退避起始值和最大值，实际等待时间会加入随机抖动
+	retryStep time.Duration
+	retryCeil time.Duration
+	// 续期间隔，默认为 ttl/3，小于等于0时不自动续期
+	renewInterval time.Duration
+}
+
+type LockerOption interface {
+	apply(*lockerOptions)
+}
+
+type lockerRetryOption struct {
+	step, ceil time.Duration
+}
+
+func (c lockerRetryOption) apply(opts *lockerOptions) {
+	opts.retryStep = c.step
+	opts.retryCeil = c.ceil
+}
+
+func WithLockerRetry(step, ceil time.Duration) LockerOption {
+	return lockerRetryOption{step: step, ceil: ceil}
+}
+
+type lockerRenewIntervalOption time.Duration
+
+func (c lockerRenewIntervalOption) apply(opts *lockerOptions) {
+	opts.renewInterval = time.Duration(c)
+}
+
+func WithLockerRenewInterval(d time.Duration) LockerOption {
+	return lockerRenewIntervalOption(d)
+}
+
+// Locker 带自动续期和 fencing token 的分布式锁
+//
+// 使用多个 RedisExt 创建时采用 redlock 算法，在多数实例上加锁成功才认为获取到锁。
+// 每次加锁成功都会得到一个单调递增的 fencing token，下游存储可以据此拒绝过期锁持有者的写入。
+// 同一个 Locker 不能被并发使用。
+type Locker struct {
+	redisExts []*RedisExt
+	key       string
+	ttl       time.Duration
+	opts      *lockerOptions
+
+	mu    sync.Mutex
+	token string
+	fence int64
+	stop  chan struct{}
+	lost  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewLocker 在当前 namespace 上创建锁
+func (m *RedisExt) NewLocker(key string, ttl time.Duration, opts ...LockerOption) *Locker {
+	return NewRedLocker([]*RedisExt{m}, key, ttl, opts...)
+}
+
+// NewRedLocker 在多个 namespace 上按 redlock 算法创建锁
+func NewRedLocker(redisExts []*RedisExt, key string, ttl time.Duration, opts ...LockerOption) *Locker {
+	opt := &lockerOptions{
+		retryStep:     defaultLockerRetryStep,
+		retryCeil:     defaultLockerRetryCeil,
+		renewInterval: ttl / 3,
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	return &Locker{
+		redisExts: redisExts,
+		key:       redis.HashTag(key, ""),
+		ttl:       ttl,
+		opts:      opt,
+	}
+}
+
+// fenceKey 与锁使用相同的 hash tag，cluster 模式下在同一个 slot 中
+func (m *Locker) fenceKey() string {
+	return m.key + lockerFenceSuffix
+}
+
+func (m *Locker) quorum() int {
+	return len(m.redisExts)/2 + 1
+}
+
+// Token 当前持有锁使用的随机 token
+func (m *Locker) Token() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token
+}
+
+// Fence 当前持有锁的 fencing token
+func (m *Locker) Fence() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fence
+}
+
+// Lost 续期失败导致锁丢失时会被关闭
+func (m *Locker) Lost() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lost
+}
+
+// TryAcquire 尝试获取一次锁，失败时返回 ErrLockNotAcquired，所有实例都返回错误时返回最后一个错误
+//
+// 加锁分为两个阶段：先在各个实例上加锁并读取当前的 fencing token，获取到多数实例后
+// 再将这些实例上的 token 设置为最大值加一。任意两个多数派至少有一个公共实例，
+// 因此后获取锁的持有者得到的 token 一定更大，部分实例加锁失败时不会改变 token。
+func (m *Locker) TryAcquire(ctx context.Context) (fence int64, err error) {
+	fun := "Locker.TryAcquire -->"
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		return 0, errors.New("redisext: lock already acquired")
+	}
+
+	token := uuid.New().String()
+	start := time.Now()
+	var answered int
+	var acquired []*RedisExt
+	var lastErr error
+	var maxFence int64
+	for _, redisExt := range m.redisExts {
+		r, err := redisExt.Run(ctx, lockerAcquireScript, []string{m.key, m.fenceKey()}, token, int64(m.ttl/time.Millisecond))
+		if err != nil {
+			slog.Warnf(ctx, "%s namespace:%s key:%s err:%v", fun, redisExt.namespace, m.key, err)
+			lastErr = err
+			continue
+		}
+		answered++
+		if n, ok := r.(int64); ok && n >= 0 {
+			acquired = append(acquired, redisExt)
+			if n > maxFence {
+				maxFence = n
+			}
+		}
+	}
+	if answered == 0 {
+		return 0, lastErr
+	}
+	if len(acquired) < m.quorum() {
+		m.release(ctx, token)
+		return 0, ErrLockNotAcquired
+	}
+
+	var success int
+	for _, redisExt := range acquired {
+		r, err := redisExt.Run(ctx, lockerFenceScript, []string{m.key, m.fenceKey()}, token, maxFence+1)
+		if err != nil {
+			slog.Warnf(ctx, "%s fence namespace:%s key:%s err:%v", fun, redisExt.namespace, m.key, err)
+			continue
+		}
+		if n, ok := r.(int64); ok && n > 0 {
+			success++
+			if n > fence {
+				fence = n
+			}
+		}
+	}
+
+	drift := time.Duration(float64(m.ttl)*lockerClockDriftFactor) + 2*time.Millisecond
+	validity := m.ttl - time.Since(start) - drift
+	if success < m.quorum() || validity <= 0 {
+		m.release(ctx, token)
+		return 0, ErrLockNotAcquired
+	}
+
+	m.token = token
+	m.fence = fence
+	m.stop = make(chan struct{})
+	m.lost = make(chan struct{})
+	if m.opts.renewInterval > 0 {
+		m.wg.Add(1)
+		go m.renew(token, m.stop, m.lost)
+	}
+	return fence, nil
+}
+
+// Acquire 获取锁，失败时按带随机抖动的指数退避重试，直到成功或者 ctx 结束。
+// 没有实例可以访问时直接返回 redis 的错误，不再重试
+func (m *Locker) Acquire(ctx context.Context) (fence int64, err error) {
+	backoff := m.opts.retryStep
+	for {
+		fence, err = m.TryAcquire(ctx)
+		if err != ErrLockNotAcquired {
+			return fence, err
+		}
+
+		// 在 [backoff/2, backoff) 之间随机等待
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > m.opts.retryCeil {
+			backoff = m.opts.retryCeil
+		}
+	}
+}
+
+func (m *Locker) renew(token string, stop, lost chan struct{}) {
+	fun := "Locker.renew -->"
+	defer m.wg.Done()
+	ctx := context.Background()
+	ticker := time.NewTicker(m.opts.renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var success int
+		for _, redisExt := range m.redisExts {
+			r, err := redisExt.Run(ctx, lockerRenewScript, []string{m.key}, token, int64(m.ttl/time.Millisecond))
+			if err != nil {
+				slog.Warnf(ctx, "%s namespace:%s key:%s err:%v", fun, redisExt.namespace, m.key, err)
+				continue
+			}
+			if n, ok := r.(int64); ok && n == 1 {
+				success++
+			}
+		}
+		if success < m.quorum() {
+			slog.Errorf(ctx, "%s lock lost, key:%s success:%d", fun, m.key, success)
+			close(lost)
+			return
+		}
+	}
+}
+
+func (m *Locker) release(ctx context.Context, token string) (released int) {
+	for _, redisExt := range m.redisExts {
+		r, err := redisExt.Run(ctx, lockerReleaseScript, []string{m.key}, token)
+		if err != nil {
+			slog.Warnf(ctx, "Locker.release --> namespace:%s key:%s err:%v", redisExt.namespace, m.key, err)
+			continue
+		}
+		if n, ok := r.(int64); ok && n == 1 {
+			released++
+		}
+	}
+	return
+}
+
+// Release 停止续期并释放锁，锁已经丢失时返回 ErrLockNotHeld
+func (m *Locker) Release(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop == nil {
+		return ErrLockNotHeld
+	}
+	close(m.stop)
+	m.wg.Wait()
+
+	released := m.release(ctx, m.token)
+	m.token = ""
+	m.fence = 0
+	m.stop = nil
+	if released < m.quorum() {
+		return ErrLockNotHeld
+	}
+	return nil
+}
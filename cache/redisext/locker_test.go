@@ -0,0 +1,133 @@
+package redisext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	m := NewRedisExt("test/memory", "test")
+	key := "locker"
+	m.Del(ctx, key)
+
+	l1 := m.NewLocker(key, 300*time.Millisecond)
+	fence1, err := l1.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, fence1 > 0)
+
+	l2 := m.NewLocker(key, 300*time.Millisecond)
+	_, err = l2.TryAcquire(ctx)
+	assert.Equal(t, ErrLockNotAcquired, err)
+
+	// 超过 ttl 后依然持有锁
+	time.Sleep(time.Second)
+	_, err = l2.TryAcquire(ctx)
+	assert.Equal(t, ErrLockNotAcquired, err)
+
+	err = l1.Release(ctx)
+	assert.NoError(t, err)
+	err = l1.Release(ctx)
+	assert.Equal(t, ErrLockNotHeld, err)
+
+	fence2, err := l2.Acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, fence2 > fence1)
+	assert.NoError(t, l2.Release(ctx))
+}
+
+func TestLocker_AcquireTimeout(t *testing.T) {
+	defer useMemoryRedis()()
+	m := NewRedisExt("test/memory", "test")
+	key := "locker_timeout"
+
+	l1 := m.NewLocker(key, time.Second)
+	_, err := l1.Acquire(context.Background())
+	assert.NoError(t, err)
+	defer l1.Release(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	l2 := m.NewLocker(key, time.Second)
+	_, err = l2.Acquire(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestLocker_RedisDown(t *testing.T) {
+	configer := redis.NewMemoryConfiger()
+	old := redis.DefaultConfiger
+	redis.DefaultConfiger = configer
+	defer func() { redis.DefaultConfiger = old }()
+	m := NewRedisExt("test/memory_down", "test")
+	// 先创建实例再关闭内存 redis
+	_, err := m.Exists(context.Background(), "locker")
+	assert.NoError(t, err)
+	assert.NoError(t, configer.Close())
+
+	// 没有实例可以访问时直接返回 redis 的错误，而不是一直重试
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = m.NewLocker("locker", time.Second).Acquire(ctx)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrLockNotAcquired, err)
+	assert.NotEqual(t, context.DeadlineExceeded, err)
+}
+
+func TestRedLocker(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	redisExts := []*RedisExt{
+		NewRedisExt("test/memory", "test1"),
+		NewRedisExt("test/memory", "test2"),
+		NewRedisExt("test/memory", "test3"),
+	}
+	key := "redlocker"
+
+	l1 := NewRedLocker(redisExts, key, time.Second)
+	_, err := l1.TryAcquire(ctx)
+	assert.NoError(t, err)
+
+	l2 := NewRedLocker(redisExts, key, time.Second)
+	_, err = l2.TryAcquire(ctx)
+	assert.Equal(t, ErrLockNotAcquired, err)
+
+	assert.NoError(t, l1.Release(ctx))
+}
+
+func TestRedLocker_Fence(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	redisExts := []*RedisExt{
+		NewRedisExt("test/memory", "fence1"),
+		NewRedisExt("test/memory", "fence2"),
+		NewRedisExt("test/memory", "fence3"),
+	}
+	key := "redlocker_fence"
+
+	// 第一个实例被单独锁住，只在另外两个实例上获取到锁
+	l0 := redisExts[0].NewLocker(key, time.Second)
+	fence0, err := l0.TryAcquire(ctx)
+	assert.NoError(t, err)
+	l1 := NewRedLocker(redisExts, key, time.Second)
+	fence1, err := l1.TryAcquire(ctx)
+	assert.NoError(t, err)
+
+	// 部分实例加锁失败不会改变 fencing token
+	l2 := NewRedLocker(redisExts, key, time.Second)
+	_, err = l2.TryAcquire(ctx)
+	assert.Equal(t, ErrLockNotAcquired, err)
+
+	assert.NoError(t, l0.Release(ctx))
+	assert.NoError(t, l1.Release(ctx))
+	fence2, err := l2.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, fence2 > fence1)
+	assert.True(t, fence2 > fence0)
+	assert.Equal(t, fence1+1, fence2)
+	assert.NoError(t, l2.Release(ctx))
+}
@@ -0,0 +1,287 @@
+package redisext
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/shawnfeng/sutil/cache/redis"
+)
+
+// 内存 redis(constants.ConfigerTypeMemory) 不能执行 lua，测试中注册与脚本等价的 go 实现，
+// TestMemoryScriptConformance 在真实 redis 上对比这些实现和脚本的行为
+func init() {
+	redis.RegisterMemoryScript(unlockScript.src, memoryCompareAndDel)
+	redis.RegisterMemoryScript(lockerReleaseScript.src, memoryCompareAndDel)
+	redis.RegisterMemoryScript(lockerRenewScript.src, memoryCompareAndPExpire)
+	redis.RegisterMemoryScript(lockerAcquireScript.src, memoryLockerAcquire)
+	redis.RegisterMemoryScript(lockerFenceScript.src, memoryLockerFence)
+	redis.RegisterMemoryScript(tryAcquireScript.src, memoryTryAcquire)
+	redis.RegisterMemoryScript(confirmScript.src, memoryConfirm)
+	redis.RegisterMemoryScript(failScript.src, memoryFail)
+	redis.RegisterMemoryScript(fixedWindowScript.src, memoryFixedWindow)
+	redis.RegisterMemoryScript(slidingWindowLogScript.src, memorySlidingWindowLog)
+	redis.RegisterMemoryScript(tokenBucketScript.src, memoryTokenBucket)
+}
+
+type memoryCall = func(args ...interface{}) (interface{}, error)
+
+func memoryCompareAndDel(call memoryCall, keys, argv []string) (interface{}, error) {
+	v, err := call("GET", keys[0])
+	if err != nil || v != argv[0] {
+		return int64(0), err
+	}
+	return call("DEL", keys[0])
+}
+
+func memoryCompareAndPExpire(call memoryCall, keys, argv []string) (interface{}, error) {
+	v, err := call("GET", keys[0])
+	if err != nil || v != argv[0] {
+		return int64(0), err
+	}
+	return call("PEXPIRE", keys[0], argv[1])
+}
+
+func memoryLockerAcquire(call memoryCall, keys, argv []string) (interface{}, error) {
+	ok, err := call("SET", keys[0], argv[0], "NX", "PX", argv[1])
+	if err != nil || ok == nil {
+		return int64(-1), err
+	}
+	v, err := call("GET", keys[1])
+	return memoryInt(v), err
+}
+
+func memoryLockerFence(call memoryCall, keys, argv []string) (interface{}, error) {
+	v, err := call("GET", keys[0])
+	if err != nil || v != argv[0] {
+		return int64(0), err
+	}
+	if v, err = call("GET", keys[1]); err != nil {
+		return int64(0), err
+	}
+	fence, next := memoryInt(v), memoryInt(argv[1])
+	if fence < next {
+		fence = next
+		if _, err = call("SET", keys[1], argv[1]); err != nil {
+			return int64(0), err
+		}
+	}
+	return fence, nil
+}
+
+// memoryDropLegacy 删除旧版本以字符串保存的记录，返回记录是否为 Done 状态
+func memoryDropLegacy(call memoryCall, key string) (bool, error) {
+	t, err := call("TYPE", key)
+	if err != nil || t != "string" {
+		return false, err
+	}
+	v, err := call("GET", key)
+	if err != nil {
+		return false, err
+	}
+	if v == Done {
+		return true, nil
+	}
+	_, err = call("DEL", key)
+	return false, err
+}
+
+func memoryTryAcquire(call memoryCall, keys, argv []string) (interface{}, error) {
+	key := keys[0]
+	t, err := call("TIME")
+	if err != nil {
+		return nil, err
+	}
+	tv, _ := t.([]interface{})
+	if len(tv) != 2 {
+		return nil, fmt.Errorf("unexpected TIME result: %v", t)
+	}
+	now := memoryInt(tv[0])*1000 + memoryInt(tv[1])/1000
+	deadline := now + memoryInt(argv[0])
+	done, err := memoryDropLegacy(call, key)
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		return []interface{}{int64(0), Done, ""}, nil
+	}
+	state, err := call("HGET", key, "state")
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		if _, err := call("HMSET", key, "state", Doing, "deadline", deadline, "exp", argv[1]); err != nil {
+			return nil, err
+		}
+		if memoryInt(argv[1]) > 0 {
+			_, err = call("PEXPIRE", key, argv[1])
+		}
+		return []interface{}{int64(1), InitState, ""}, err
+	}
+	if state == Done {
+		result, err := call("HGET", key, "result")
+		if result == nil {
+			result = ""
+		}
+		return []interface{}{int64(0), state, result}, err
+	}
+	if state == Doing {
+		v, err := call("HGET", key, "deadline")
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := v.(string); ok {
+			if d, _ := strconv.ParseInt(s, 10, 64); now < d {
+				return []interface{}{int64(0), state, ""}, nil
+			}
+		}
+	}
+	_, err = call("HMSET", key, "state", Doing, "deadline", deadline)
+	return []interface{}{int64(1), state, ""}, err
+}
+
+func memoryConfirm(call memoryCall, keys, argv []string) (interface{}, error) {
+	key := keys[0]
+	t, err := call("TYPE", key)
+	if err != nil {
+		return nil, err
+	}
+	if t == "string" {
+		if _, err := call("DEL", key); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := call("HMSET", key, "state", Done, "result", argv[0]); err != nil {
+		return nil, err
+	}
+	ttl, err := call("PTTL", key)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := ttl.(int64); n < 0 {
+		exp, err := call("HGET", key, "exp")
+		if err != nil {
+			return nil, err
+		}
+		if memoryInt(exp) > 0 {
+			if _, err := call("PEXPIRE", key, exp); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return int64(1), nil
+}
+
+func memoryFail(call memoryCall, keys, argv []string) (interface{}, error) {
+	key := keys[0]
+	t, err := call("TYPE", key)
+	if err != nil || t != "hash" {
+		return int64(0), err
+	}
+	state, err := call("HGET", key, "state")
+	if err != nil || state == Done {
+		return int64(0), err
+	}
+	_, err = call("HSET", key, "state", Failed)
+	return int64(1), err
+}
+
+func memoryInt(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	default:
+		return 0
+	}
+}
+
+func memoryFixedWindow(call memoryCall, keys, argv []string) (interface{}, error) {
+	limit, n := memoryInt(argv[0]), memoryInt(argv[1])
+	v, err := call("GET", keys[0])
+	if err != nil {
+		return nil, err
+	}
+	count := memoryInt(v)
+	if count+n > limit {
+		return []interface{}{int64(0), limit - count}, nil
+	}
+	v, err = call("INCRBY", keys[0], n)
+	if err != nil {
+		return nil, err
+	}
+	if count = memoryInt(v); count == n {
+		if _, err := call("PEXPIRE", keys[0], argv[2]); err != nil {
+			return nil, err
+		}
+	}
+	return []interface{}{int64(1), limit - count}, nil
+}
+
+func memorySlidingWindowLog(call memoryCall, keys, argv []string) (interface{}, error) {
+	limit, n, window, now := memoryInt(argv[0]), memoryInt(argv[1]), memoryInt(argv[2]), memoryInt(argv[3])
+	if _, err := call("ZREMRANGEBYSCORE", keys[0], "-inf", now-window); err != nil {
+		return nil, err
+	}
+	v, err := call("ZCARD", keys[0])
+	if err != nil {
+		return nil, err
+	}
+	count := memoryInt(v)
+	if count+n > limit {
+		idx := count + n - limit - 1
+		v, err := call("ZRANGE", keys[0], idx, idx, "WITHSCORES")
+		if err != nil {
+			return nil, err
+		}
+		oldest, _ := v.([]interface{})
+		if len(oldest) < 2 {
+			return []interface{}{int64(0), limit - count, int64(0)}, nil
+		}
+		score, _ := strconv.ParseFloat(oldest[1].(string), 64)
+		return []interface{}{int64(0), limit - count, int64(score) + window - now}, nil
+	}
+	for i := int64(1); i <= n; i++ {
+		if _, err := call("ZADD", keys[0], now, argv[4]+":"+strconv.FormatInt(i, 10)); err != nil {
+			return nil, err
+		}
+	}
+	_, err = call("PEXPIRE", keys[0], window)
+	return []interface{}{int64(1), limit - count - n, int64(0)}, err
+}
+
+func memoryTokenBucket(call memoryCall, keys, argv []string) (interface{}, error) {
+	capacity, n := float64(memoryInt(argv[0])), float64(memoryInt(argv[1]))
+	rate := float64(memoryInt(argv[2])) / float64(memoryInt(argv[3]))
+	now := float64(memoryInt(argv[4]))
+	v, err := call("HMGET", keys[0], "tokens", "ts")
+	if err != nil {
+		return nil, err
+	}
+	state, _ := v.([]interface{})
+	tokens, ts := capacity, now
+	if len(state) == 2 && state[0] != nil && state[1] != nil {
+		tokens, _ = strconv.ParseFloat(state[0].(string), 64)
+		ts, _ = strconv.ParseFloat(state[1].(string), 64)
+	}
+	if now > ts {
+		tokens = math.Min(capacity, tokens+(now-ts)*rate)
+		ts = now
+	}
+	allowed, retry := int64(0), int64(0)
+	if tokens >= n {
+		tokens -= n
+		allowed = 1
+	} else {
+		retry = int64(math.Ceil((n - tokens) / rate))
+	}
+	if _, err := call("HMSET", keys[0], "tokens", tokens, "ts", ts); err != nil {
+		return nil, err
+	}
+	if _, err := call("PEXPIRE", keys[0], int64(math.Ceil(capacity/rate))+1000); err != nil {
+		return nil, err
+	}
+	return []interface{}{allowed, int64(math.Floor(tokens)), retry}, nil
+}
@@ -0,0 +1,184 @@
+package redisext
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	go_redis "github.com/go-redis/redis"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+var memoryConfiger = redis.NewMemoryConfiger()
+
+// useMemoryRedis 测试期间使用内存 redis，实例会被 DefaultInstanceManager 缓存，因此内存 redis 不关闭
+func useMemoryRedis() func() {
+	old := redis.DefaultConfiger
+	redis.DefaultConfiger = memoryConfiger
+	return func() { redis.DefaultConfiger = old }
+}
+
+func TestMemoryTryAcquire(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+
+	m := NewRedisExt("test/memory", "test")
+	canHandle, state, err := m.TryAcquire(ctx, "order", time.Minute, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, canHandle)
+	assert.Equal(t, InitState, state)
+
+	canHandle, state, err = m.TryAcquire(ctx, "order", time.Minute, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, canHandle)
+	assert.Equal(t, Doing, state)
+
+	assert.NoError(t, m.ConfirmWithResult(ctx, "order", "ok"))
+	canHandle, state, result, err := m.TryAcquireWithResult(ctx, "order", time.Minute, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, canHandle)
+	assert.Equal(t, Done, state)
+	assert.Equal(t, "ok", result)
+
+	locker := m.NewLocker("memory_locker", time.Second)
+	fence, err := locker.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), fence)
+	_, err = m.NewLocker("memory_locker", time.Second).TryAcquire(ctx)
+	assert.Equal(t, ErrLockNotAcquired, err)
+	assert.NoError(t, locker.Release(ctx))
+}
+
+type scriptCase struct {
+	name   string
+	script *Script
+	setup  func(ctx context.Context, c *redis.Client)
+	keys   []string
+	argv   []interface{}
+}
+
+// scriptState 脚本执行后 key 的内容，ttl 只比较是否设置了过期时间
+type scriptState struct {
+	exists  int64
+	str     string
+	strErr  bool
+	hash    map[string]string
+	zset    []go_redis.Z
+	expires bool
+}
+
+func readScriptState(ctx context.Context, c *redis.Client, key string) scriptState {
+	var s scriptState
+	s.exists = c.Exists(ctx, key).Val()
+	str, err := c.Get(ctx, key).Result()
+	s.str, s.strErr = str, err != nil && err != go_redis.Nil
+	s.hash, _ = c.HGetAll(ctx, key).Result()
+	s.zset, _ = c.ZRangeWithScores(ctx, key, 0, -1).Result()
+	s.expires = c.TTL(ctx, key).Val() > 0
+	return s
+}
+
+// tryAcquire 写入的 deadline 是服务端当前时间加 timeout，比较前统一替换掉
+const scriptTimeout = 60000
+
+func scriptCases() []scriptCase {
+	const now = 1000000
+	// tryAcquire 使用服务端时间，已有记录的 deadline 需要基于当前时间设置
+	realNow := time.Now().UnixNano() / int64(time.Millisecond)
+	setLock := func(ctx context.Context, c *redis.Client) {
+		c.Set(ctx, "lock", "token", time.Minute)
+		c.Set(ctx, "fence", "3", 0)
+	}
+	setRecord := func(state string, deadline int64) func(ctx context.Context, c *redis.Client) {
+		return func(ctx context.Context, c *redis.Client) {
+			c.HMSet(ctx, "record", map[string]interface{}{"state": state, "deadline": deadline, "exp": 60000})
+		}
+	}
+	setLegacy := func(v string) func(ctx context.Context, c *redis.Client) {
+		return func(ctx context.Context, c *redis.Client) { c.Set(ctx, "record", v, 0) }
+	}
+	setLog := func(ctx context.Context, c *redis.Client) {
+		c.ZAdd(ctx, "log", go_redis.Z{Score: now - 2000, Member: "a:1"}, go_redis.Z{Score: now - 500, Member: "b:1"}, go_redis.Z{Score: now - 100, Member: "c:1"})
+	}
+	setBucket := func(ctx context.Context, c *redis.Client) {
+		c.HMSet(ctx, "bucket", map[string]interface{}{"tokens": 1.5, "ts": now - 300})
+	}
+	return []scriptCase{
+		{"unlock held", unlockScript, setLock, []string{"lock"}, []interface{}{"token"}},
+		{"unlock not held", unlockScript, setLock, []string{"lock"}, []interface{}{"other"}},
+		{"release held", lockerReleaseScript, setLock, []string{"lock"}, []interface{}{"token"}},
+		{"renew held", lockerRenewScript, setLock, []string{"lock"}, []interface{}{"token", 5000}},
+		{"renew not held", lockerRenewScript, nil, []string{"lock"}, []interface{}{"token", 5000}},
+		{"acquire free", lockerAcquireScript, nil, []string{"lock", "fence"}, []interface{}{"token", 5000}},
+		{"acquire held", lockerAcquireScript, setLock, []string{"lock", "fence"}, []interface{}{"other", 5000}},
+		{"fence raise", lockerFenceScript, setLock, []string{"lock", "fence"}, []interface{}{"token", 5}},
+		{"fence keep", lockerFenceScript, setLock, []string{"lock", "fence"}, []interface{}{"token", 2}},
+		{"fence not held", lockerFenceScript, setLock, []string{"lock", "fence"}, []interface{}{"other", 5}},
+		{"try acquire new", tryAcquireScript, nil, []string{"record"}, []interface{}{scriptTimeout, 3600000}},
+		{"try acquire no expiration", tryAcquireScript, nil, []string{"record"}, []interface{}{scriptTimeout, 0}},
+		{"try acquire doing", tryAcquireScript, setRecord(Doing, realNow+1000), []string{"record"}, []interface{}{scriptTimeout, 3600000}},
+		{"try acquire timeout", tryAcquireScript, setRecord(Doing, realNow-1000), []string{"record"}, []interface{}{scriptTimeout, 3600000}},
+		{"try acquire failed", tryAcquireScript, setRecord(Failed, realNow+1000), []string{"record"}, []interface{}{scriptTimeout, 3600000}},
+		{"try acquire legacy done", tryAcquireScript, setLegacy(Done), []string{"record"}, []interface{}{scriptTimeout, 3600000}},
+		{"try acquire legacy doing", tryAcquireScript, setLegacy(Doing), []string{"record"}, []interface{}{scriptTimeout, 3600000}},
+		{"confirm", confirmScript, setRecord(Doing, now), []string{"record"}, []interface{}{"ok"}},
+		{"confirm legacy", confirmScript, setLegacy(Doing), []string{"record"}, []interface{}{"ok"}},
+		{"fail doing", failScript, setRecord(Doing, now), []string{"record"}, nil},
+		{"fail done", failScript, setRecord(Done, now), []string{"record"}, nil},
+		{"fail missing", failScript, nil, []string{"record"}, nil},
+		{"fixed window first", fixedWindowScript, nil, []string{"window"}, []interface{}{3, 2, 1000}},
+		{"fixed window exceeded", fixedWindowScript, nil, []string{"window"}, []interface{}{3, 4, 1000}},
+		{"sliding window allowed", slidingWindowLogScript, setLog, []string{"log"}, []interface{}{4, 2, 1000, now, "req"}},
+		{"sliding window exceeded", slidingWindowLogScript, setLog, []string{"log"}, []interface{}{3, 2, 1000, now, "req"}},
+		{"token bucket new", tokenBucketScript, nil, []string{"bucket"}, []interface{}{10, 3, 5, 1000, now}},
+		{"token bucket refill", tokenBucketScript, setBucket, []string{"bucket"}, []interface{}{10, 3, 5, 1000, now}},
+		{"token bucket exceeded", tokenBucketScript, setBucket, []string{"bucket"}, []interface{}{10, 8, 5, 1000, now}},
+	}
+}
+
+// TestMemoryScriptConformance 在真实 redis 和内存 redis 上执行同样的脚本，比较返回值和执行后的数据，没有可用的 redis 时跳过
+func TestMemoryScriptConformance(t *testing.T) {
+	ctx := context.Background()
+	live, err := redis.NewClient(ctx, "base/report", "test")
+	if err == nil {
+		err = live.Exists(ctx, "conformance").Err()
+	}
+	if err != nil {
+		t.Skipf("redis not available: %v", err)
+	}
+	defer live.Close(ctx)
+
+	restore := useMemoryRedis()
+	memory, err := redis.NewClient(ctx, "test/memory", "test")
+	restore()
+	assert.NoError(t, err)
+	defer memory.Close(ctx)
+
+	keys := []string{"lock", "fence", "record", "window", "log", "bucket"}
+	run := func(c *redis.Client, sc scriptCase) (interface{}, error, map[string]scriptState) {
+		c.Del(ctx, keys...)
+		defer c.Del(ctx, keys...)
+		if sc.setup != nil {
+			sc.setup(ctx, c)
+		}
+		start := time.Now().UnixNano() / int64(time.Millisecond)
+		res, err := c.Eval(ctx, sc.script.src, sc.keys, sc.argv...).Result()
+		state := make(map[string]scriptState)
+		for _, key := range keys {
+			state[key] = readScriptState(ctx, c, key)
+		}
+		if d, err := strconv.ParseInt(state["record"].hash["deadline"], 10, 64); err == nil && d > start+scriptTimeout/2 {
+			state["record"].hash["deadline"] = "now+timeout"
+		}
+		return res, err, state
+	}
+	for _, sc := range scriptCases() {
+		wantRes, wantErr, wantState := run(live, sc)
+		gotRes, gotErr, gotState := run(memory, sc)
+		assert.Equal(t, wantErr, gotErr, sc.name)
+		assert.Equal(t, wantRes, gotRes, sc.name)
+		assert.Equal(t, wantState, gotState, sc.name)
+	}
+}
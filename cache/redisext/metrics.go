@@ -29,12 +29,52 @@ var (
 		Help:       "redisext requests error total",
 		LabelNames: []string{"namespace", "command"},
 	})
+
+	_metricReqRole = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "role_total",
+		Help:       "redisext read requests total by role",
+		LabelNames: []string{"namespace", "command", "role"},
+	})
+
+	_metricRateLimit = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "ratelimit_total",
+		Help:       "redisext rate limiter requests total by result",
+		LabelNames: []string{"namespace", "algorithm", "result"},
+	})
+
+	_metricTxRetry = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "tx_retry_total",
+		Help:       "redisext watch transactions retried on TxFailedErr",
+		LabelNames: []string{"namespace"},
+	})
 )
 
 func statReqDuration(namespace, command string, durationMS int64) {
 	_metricRequestDuration.With("namespace", namespace, "command", command).Observe(float64(durationMS))
 }
 
+func statReqRole(namespace, command, role string) {
+	_metricReqRole.With("namespace", namespace, "command", command, "role", role).Inc()
+}
+
+func statRateLimit(namespace, algorithm string, allowed bool) {
+	result := "allowed"
+	if !allowed {
+		result = "limited"
+	}
+	_metricRateLimit.With("namespace", namespace, "algorithm", algorithm, "result", result).Inc()
+}
+
+func statTxRetry(namespace string) {
+	_metricTxRetry.With("namespace", namespace).Inc()
+}
+
 func statReqErr(namespace, command string, err error) {
 	if err != nil && err != go_redis.Nil {
 		_metricReqErr.With("namespace", namespace, "command", command).Inc()
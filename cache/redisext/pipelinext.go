@@ -268,3 +268,74 @@ func (m *PipelineExt) Discard(ctx context.Context) (err error) {
 func (m *PipelineExt) Close(ctx context.Context) error {
 	return m.pipe.Close()
 }
+
+func (m *PipelineExt) prefixGeoRadiusQuery(query *GeoRadiusQuery) *GeoRadiusQuery {
+	q := *query
+	if len(q.Store) > 0 {
+		q.Store = m.prefixKey(q.Store)
+	}
+	if len(q.StoreDist) > 0 {
+		q.StoreDist = m.prefixKey(q.StoreDist)
+	}
+	return &q
+}
+
+func (m *PipelineExt) GeoAdd(ctx context.Context, key string, locations ...*GeoLocation) *go_redis.IntCmd {
+	return m.pipe.GeoAdd(ctx, m.prefixKey(key), locations...)
+}
+
+func (m *PipelineExt) GeoRadius(ctx context.Context, key string, longitude, latitude float64, query *GeoRadiusQuery) *go_redis.GeoLocationCmd {
+	return m.pipe.GeoRadius(ctx, m.prefixKey(key), longitude, latitude, m.prefixGeoRadiusQuery(query))
+}
+
+func (m *PipelineExt) GeoRadiusByMember(ctx context.Context, key, member string, query *GeoRadiusQuery) *go_redis.GeoLocationCmd {
+	return m.pipe.GeoRadiusByMember(ctx, m.prefixKey(key), member, m.prefixGeoRadiusQuery(query))
+}
+
+func (m *PipelineExt) GeoSearch(ctx context.Context, key string, query *GeoSearchQuery) *redis.GeoSearchCmd {
+	return m.pipe.GeoSearch(ctx, m.prefixKey(key), query)
+}
+
+func (m *PipelineExt) GeoDist(ctx context.Context, key string, member1, member2, unit string) *go_redis.FloatCmd {
+	return m.pipe.GeoDist(ctx, m.prefixKey(key), member1, member2, unit)
+}
+
+func (m *PipelineExt) GeoPos(ctx context.Context, key string, members ...string) *go_redis.GeoPosCmd {
+	return m.pipe.GeoPos(ctx, m.prefixKey(key), members...)
+}
+
+func (m *PipelineExt) GeoHash(ctx context.Context, key string, members ...string) *go_redis.StringSliceCmd {
+	return m.pipe.GeoHash(ctx, m.prefixKey(key), members...)
+}
+
+func (m *PipelineExt) PFAdd(ctx context.Context, key string, els ...interface{}) *go_redis.IntCmd {
+	return m.pipe.PFAdd(ctx, m.prefixKey(key), els...)
+}
+
+func (m *PipelineExt) PFCount(ctx context.Context, keys ...string) *go_redis.IntCmd {
+	var prefixKey = make([]string, len(keys))
+	for k, v := range keys {
+		prefixKey[k] = m.prefixKey(v)
+	}
+	return m.pipe.PFCount(ctx, prefixKey...)
+}
+
+func (m *PipelineExt) PFMerge(ctx context.Context, dest string, keys ...string) *go_redis.StatusCmd {
+	var prefixKey = make([]string, len(keys))
+	for k, v := range keys {
+		prefixKey[k] = m.prefixKey(v)
+	}
+	return m.pipe.PFMerge(ctx, m.prefixKey(dest), prefixKey...)
+}
+
+func (m *PipelineExt) BitCount(ctx context.Context, key string, bitCount *BitCount) *go_redis.IntCmd {
+	return m.pipe.BitCount(ctx, m.prefixKey(key), bitCount)
+}
+
+func (m *PipelineExt) BitPos(ctx context.Context, key string, bit int64, pos ...int64) *go_redis.IntCmd {
+	return m.pipe.BitPos(ctx, m.prefixKey(key), bit, pos...)
+}
+
+func (m *PipelineExt) BitField(ctx context.Context, key string, args ...interface{}) *redis.BitFieldCmd {
+	return m.pipe.BitField(ctx, m.prefixKey(key), args...)
+}
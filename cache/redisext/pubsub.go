@@ -0,0 +1,287 @@
+package redisext
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/scontext"
+	"github.com/shawnfeng/sutil/slog/slog"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+const (
+	resubscribeBackOffStep = 100 * time.Millisecond
+	resubscribeBackOffCeil = 5 * time.Second
+)
+
+// pubsubPayload 与 mq.Payload 相同，用于在发布订阅间传递 trace 信息
+type pubsubPayload struct {
+	Carrier opentracing.TextMapCarrier `json:"c"`
+	Value   string                     `json:"v"`
+	Head    interface{}                `json:"h"`
+	Control *pubsubPayloadControl      `json:"t"`
+}
+
+type pubsubPayloadControl struct {
+	Group string `json:"group"`
+}
+
+func (s *pubsubPayloadControl) GetControlRouteGroup() (string, bool) {
+	return s.Group, true
+}
+
+func (s *pubsubPayloadControl) SetControlRouteGroup(group string) error {
+	s.Group = group
+	return nil
+}
+
+func generatePubSubPayload(ctx context.Context, value interface{}) (*pubsubPayload, error) {
+	carrier := opentracing.TextMapCarrier(make(map[string]string))
+	span := opentracing.SpanFromContext(ctx)
+	if span != nil {
+		opentracing.GlobalTracer().Inject(
+			span.Context(),
+			opentracing.TextMap,
+			carrier)
+	}
+
+	msg, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	head := ctx.Value(scontext.ContextKeyHead)
+	control := new(pubsubPayloadControl)
+	group, ok := scontext.GetControlRouteGroup(ctx)
+	if ok {
+		control.Group = group
+	}
+	return &pubsubPayload{
+		Carrier: carrier,
+		Value:   string(msg),
+		Head:    head,
+		Control: control,
+	}, nil
+}
+
+// Message 订阅收到的消息，Channel 和 Pattern 为调用方订阅时使用的名字
+type Message struct {
+	Channel string
+	Pattern string
+	// Payload 原始消息内容
+	Payload string
+}
+
+// Unmarshal 解析 Publish 发布的消息到 value，返回的 ctx 中带有发布方的 trace 信息
+// 非 Publish 发布的消息按照 json 直接解析 Payload
+func (m *Message) Unmarshal(value interface{}) (context.Context, error) {
+	var payload pubsubPayload
+	err := json.Unmarshal([]byte(m.Payload), &payload)
+	if err != nil || payload.Carrier == nil {
+		return context.Background(), json.Unmarshal([]byte(m.Payload), value)
+	}
+
+	tracer := opentracing.GlobalTracer()
+	opName := "redisext.Subscribe"
+	spanCtx, err := tracer.Extract(opentracing.TextMap, payload.Carrier)
+	var span opentracing.Span
+	if err == nil {
+		span = tracer.StartSpan(opName, ext.RPCServerOption(spanCtx))
+	} else {
+		span = tracer.StartSpan(opName)
+	}
+	ctx := context.Background()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+	ctx = context.WithValue(ctx, scontext.ContextKeyHead, payload.Head)
+	ctx = context.WithValue(ctx, scontext.ContextKeyControl, payload.Control)
+
+	return ctx, json.Unmarshal([]byte(payload.Value), value)
+}
+
+// Publish 发布消息，message 会被json序列化，并携带 ctx 中的 trace 信息
+func (m *RedisExt) Publish(ctx context.Context, channel string, message interface{}) (n int64, err error) {
+	command := "redisext.Publish"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	payload, err := generatePubSubPayload(ctx, message)
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(payload)
+		if err == nil {
+			var client *redis.Client
+			client, err = m.getRedisInstance(ctx)
+			if err == nil {
+				n, err = client.Publish(ctx, m.prefixKey(channel), data).Result()
+			}
+		}
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+// Subscription 订阅，redis 实例因配置变更被重建时会自动在新实例上重新订阅
+type Subscription struct {
+	redisExt *RedisExt
+	names    []string
+	pattern  bool
+	ch       chan *Message
+
+	mu        sync.Mutex
+	pubsub    *redis.PubSub
+	closed    bool
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Subscribe 订阅 channels，ctx 结束或者调用 Close 后停止订阅
+func (m *RedisExt) Subscribe(ctx context.Context, channels ...string) (*Subscription, error) {
+	return m.subscribe(ctx, "redisext.Subscribe", false, channels)
+}
+
+// PSubscribe 按照 pattern 订阅，ctx 结束或者调用 Close 后停止订阅
+func (m *RedisExt) PSubscribe(ctx context.Context, patterns ...string) (*Subscription, error) {
+	return m.subscribe(ctx, "redisext.PSubscribe", true, patterns)
+}
+
+func (m *RedisExt) subscribe(ctx context.Context, command string, pattern bool, names []string) (sub *Subscription, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+
+	sub = &Subscription{
+		redisExt: m,
+		names:    names,
+		pattern:  pattern,
+		ch:       make(chan *Message, 100),
+		done:     make(chan struct{}),
+	}
+	pubsub, err := sub.subscribe(ctx)
+	statReqErr(m.namespace, command, err)
+	if err != nil {
+		return nil, err
+	}
+	go sub.run(ctx, pubsub)
+	return sub, nil
+}
+
+func (m *Subscription) subscribe(ctx context.Context) (*redis.PubSub, error) {
+	client, err := m.redisExt.getRedisInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixNames := make([]string, len(m.names))
+	for i, name := range m.names {
+		prefixNames[i] = m.redisExt.prefixKey(name)
+	}
+
+	var pubsub *redis.PubSub
+	if m.pattern {
+		pubsub = client.PSubscribe(ctx, prefixNames...)
+	} else {
+		pubsub = client.Subscribe(ctx, prefixNames...)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		pubsub.Close()
+		return nil, nil
+	}
+	m.pubsub = pubsub
+	return pubsub, nil
+}
+
+func (m *Subscription) run(ctx context.Context, pubsub *redis.PubSub) {
+	fun := "Subscription.run -->"
+	defer close(m.ch)
+
+	backoff := stime.NewBackOffCtrl(resubscribeBackOffStep, resubscribeBackOffCeil)
+	for {
+		if pubsub != nil {
+			m.forward(ctx, pubsub)
+		}
+
+		select {
+		case <-ctx.Done():
+			m.Close()
+			return
+		case <-m.done:
+			return
+		default:
+		}
+
+		// NOTE: chan 被关闭但是没有调用 Close，说明 redis 实例被关闭，需要在新实例上重新订阅
+		slog.Warnf(ctx, "%s pubsub closed, resubscribe names:%v", fun, m.names)
+		backoff.BackOff()
+		var err error
+		pubsub, err = m.subscribe(ctx)
+		if err != nil {
+			slog.Errorf(ctx, "%s resubscribe names:%v err:%v", fun, m.names, err)
+			continue
+		}
+		backoff.Reset()
+	}
+}
+
+func (m *Subscription) forward(ctx context.Context, pubsub *redis.PubSub) {
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.done:
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			pattern := msg.Pattern
+			if len(pattern) > 0 {
+				pattern = m.redisExt.trimPrefixKey(pattern)
+			}
+			select {
+			case m.ch <- &Message{
+				Channel: m.redisExt.trimPrefixKey(msg.Channel),
+				Pattern: pattern,
+				Payload: msg.Payload,
+			}:
+			case <-ctx.Done():
+				return
+			case <-m.done:
+				return
+			}
+		}
+	}
+}
+
+// Channel 返回接收消息的chan，订阅结束后chan会被关闭
+func (m *Subscription) Channel() <-chan *Message {
+	return m.ch
+}
+
+// Close 结束订阅
+func (m *Subscription) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.closed = true
+		close(m.done)
+		if m.pubsub != nil {
+			err = m.pubsub.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,109 @@
+package redisext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisExt_Publish(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	channel := "unittest_channel"
+
+	sub, err := client.Subscribe(ctx, channel)
+	assert.NoError(t, err)
+	defer sub.Close()
+	// 等待订阅生效
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = client.Publish(ctx, channel, map[string]string{"hello": "world"})
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-sub.Channel():
+		assert.Equal(t, channel, msg.Channel)
+		var value map[string]string
+		_, err := msg.Unmarshal(&value)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"hello": "world"}, value)
+	case <-time.After(time.Second):
+		t.Errorf("receive message timeout")
+	}
+}
+
+func TestRedisExt_PSubscribe(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+
+	sub, err := client.PSubscribe(ctx, "unittest_*")
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = client.Publish(ctx, "unittest_pattern", "hello")
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-sub.Channel():
+		assert.Equal(t, "unittest_pattern", msg.Channel)
+		assert.Equal(t, "unittest_*", msg.Pattern)
+	case <-time.After(time.Second):
+		t.Errorf("receive message timeout")
+	}
+
+	sub.Close()
+	_, ok := <-sub.Channel()
+	assert.False(t, ok)
+}
+
+// 没有人读取消息时，ctx 结束也要能停止订阅
+func TestSubscription_CancelWithFullBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	channel := "unittest_full_channel"
+
+	sub, err := client.Subscribe(ctx, channel)
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// 多发送几条，保证 forward 阻塞在写入 sub.ch 上
+	for i := 0; i < cap(sub.ch)+10; i++ {
+		_, err = client.Publish(context.Background(), channel, i)
+		assert.NoError(t, err)
+	}
+	for i := 0; len(sub.ch) < cap(sub.ch) && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, cap(sub.ch), len(sub.ch))
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-sub.done:
+	case <-time.After(time.Second):
+		t.Errorf("subscription not closed after ctx done")
+	}
+}
+
+func TestMessage_Unmarshal(t *testing.T) {
+	msg := &Message{Payload: `{"hello":"world"}`}
+	var value map[string]string
+	_, err := msg.Unmarshal(&value)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "world"}, value)
+
+	payload, err := generatePubSubPayload(context.Background(), 100)
+	assert.NoError(t, err)
+	msg = &Message{Payload: `{"c":{},"v":"100","h":null,"t":{"group":""}}`}
+	var n int
+	_, err = msg.Unmarshal(&n)
+	assert.NoError(t, err)
+	assert.Equal(t, payload.Value, "100")
+	assert.Equal(t, 100, n)
+}
@@ -0,0 +1,267 @@
+package redisext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRateLimited       = errors.New("redisext: rate limited")
+	ErrRateLimitExceeded = errors.New("redisext: n exceeds rate limit rate or burst")
+)
+
+// RateLimitAlgorithm 限流算法
+type RateLimitAlgorithm int
+
+const (
+	// FixedWindow 固定窗口计数，实现简单，窗口边界处可能出现两倍的突发流量
+	FixedWindow RateLimitAlgorithm = iota
+	// SlidingWindowLog 滑动窗口日志，记录窗口内每次请求的时间，精确但占用的内存与 Rate 成正比
+	SlidingWindowLog
+	// TokenBucket 令牌桶，按照速率补充令牌，允许不超过 Burst 的突发流量
+	TokenBucket
+)
+
+func (a RateLimitAlgorithm) String() string {
+	switch a {
+	case FixedWindow:
+		return "fixed_window"
+	case SlidingWindowLog:
+		return "sliding_window_log"
+	case TokenBucket:
+		return "token_bucket"
+	default:
+		return "unknown"
+	}
+}
+
+// Limit 每 Period 允许 Rate 次请求，Burst 为令牌桶的容量，为0时等于 Rate，其他算法忽略 Burst
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// RateLimitResult 限流结果，Remaining 为剩余的配额，不允许时 RetryAfter 为需要等待的时间
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+var (
+	// KEYS[1]: 当前窗口的key ARGV[1]: 配额 ARGV[2]: 请求数 ARGV[3]: 窗口长度(ms)
+	// 返回: {是否允许(1/0), 剩余配额}
+	fixedWindowScript = RegisterScript(`
+local limit = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+if count + n > limit then
+	return {0, limit - count}
+end
+count = redis.call("INCRBY", KEYS[1], n)
+if count == n then
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return {1, limit - count}`)
+
+	// KEYS[1]: 请求日志的key ARGV[1]: 配额 ARGV[2]: 请求数 ARGV[3]: 窗口长度(ms) ARGV[4]: 当前时间(ms) ARGV[5]: 请求id
+	// 返回: {是否允许(1/0), 剩余配额, 需要等待的时间(ms)}
+	slidingWindowLogScript = RegisterScript(`
+local limit = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + n > limit then
+	local idx = count + n - limit - 1
+	local oldest = redis.call("ZRANGE", KEYS[1], idx, idx, "WITHSCORES")
+	return {0, limit - count, tonumber(oldest[2]) + window - now}
+end
+for i = 1, n do
+	redis.call("ZADD", KEYS[1], now, ARGV[5] .. ":" .. i)
+end
+redis.call("PEXPIRE", KEYS[1], window)
+return {1, limit - count - n, 0}`)
+
+	// KEYS[1]: 令牌桶的key ARGV[1]: 容量 ARGV[2]: 请求数 ARGV[3]: 每个周期补充的令牌数 ARGV[4]: 周期(ms) ARGV[5]: 当前时间(ms)
+	// 返回: {是否允许(1/0), 剩余令牌, 需要等待的时间(ms)}
+	tokenBucketScript = RegisterScript(`
+local capacity = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local rate = tonumber(ARGV[3]) / tonumber(ARGV[4])
+local now = tonumber(ARGV[5])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+local allowed = 0
+local retry = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tokens, "ts", ts)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / rate) + 1000)
+return {allowed, math.floor(tokens), retry}`)
+)
+
+// RateLimiter 基于 redis 的分布式限流器，key 使用 RedisExt 的前缀
+type RateLimiter struct {
+	redisExt  *RedisExt
+	key       string
+	algorithm RateLimitAlgorithm
+	limit     Limit
+}
+
+// NewRateLimiter 在当前 namespace 上创建限流器
+func (m *RedisExt) NewRateLimiter(key string, algorithm RateLimitAlgorithm, limit Limit) *RateLimiter {
+	return &RateLimiter{
+		redisExt:  m,
+		key:       key,
+		algorithm: algorithm,
+		limit:     limit,
+	}
+}
+
+// Allow 请求一次配额
+func (m *RateLimiter) Allow(ctx context.Context) (*RateLimitResult, error) {
+	return m.AllowN(ctx, 1)
+}
+
+// maxN 单次请求允许的最大配额，令牌桶为 Burst，窗口算法为 Rate
+func (m *RateLimiter) maxN() int {
+	if m.algorithm == TokenBucket {
+		return m.limit.burst()
+	}
+	return m.limit.Rate
+}
+
+// AllowN 原子地请求 n 次配额，n 超过 Rate(令牌桶为 Burst) 时返回 ErrRateLimitExceeded
+func (m *RateLimiter) AllowN(ctx context.Context, n int) (result *RateLimitResult, err error) {
+	if m.limit.Rate <= 0 || m.limit.Period <= 0 {
+		return nil, fmt.Errorf("redisext: invalid rate limit %+v", m.limit)
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("redisext: invalid rate limit n %d", n)
+	}
+	if n > m.maxN() {
+		return nil, ErrRateLimitExceeded
+	}
+	defer func() {
+		if err == nil {
+			statRateLimit(m.redisExt.namespace, m.algorithm.String(), result.Allowed)
+		}
+	}()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	period := int64(m.limit.Period / time.Millisecond)
+	if period <= 0 {
+		period = 1
+	}
+	var r interface{}
+	switch m.algorithm {
+	case FixedWindow:
+		window := now / period
+		key := m.key + "." + strconv.FormatInt(window, 10)
+		r, err = m.redisExt.Run(ctx, fixedWindowScript, []string{key}, m.limit.Rate, n, period)
+		if err != nil {
+			return nil, err
+		}
+		if result, err = parseRateLimitResult(r); err != nil {
+			return nil, err
+		}
+		if !result.Allowed {
+			result.RetryAfter = time.Duration((window+1)*period-now) * time.Millisecond
+		}
+		return result, nil
+	case SlidingWindowLog:
+		r, err = m.redisExt.Run(ctx, slidingWindowLogScript, []string{m.key}, m.limit.Rate, n, period, now, uuid.New().String())
+	case TokenBucket:
+		r, err = m.redisExt.Run(ctx, tokenBucketScript, []string{m.key}, m.limit.burst(), n, m.limit.Rate, period, now)
+	default:
+		return nil, fmt.Errorf("redisext: unknown rate limit algorithm %d", m.algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRateLimitResult(r)
+}
+
+func parseRateLimitResult(r interface{}) (*RateLimitResult, error) {
+	vals, ok := r.([]interface{})
+	if !ok || len(vals) < 2 {
+		return nil, fmt.Errorf("redisext: unexpected rate limit result %v", r)
+	}
+	ints := make([]int64, len(vals))
+	for i, v := range vals {
+		if ints[i], ok = v.(int64); !ok {
+			return nil, fmt.Errorf("redisext: unexpected rate limit result %v", r)
+		}
+	}
+	result := &RateLimitResult{
+		Allowed:   ints[0] == 1,
+		Remaining: int(ints[1]),
+	}
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	if len(ints) > 2 && !result.Allowed {
+		result.RetryAfter = time.Duration(ints[2]) * time.Millisecond
+	}
+	return result, nil
+}
+
+// Wait 阻塞直到获得一次配额
+func (m *RateLimiter) Wait(ctx context.Context) error {
+	return m.WaitN(ctx, 1)
+}
+
+// WaitN 阻塞直到获得 n 次配额，ctx 的 deadline 早于需要等待的时间时直接返回 ErrRateLimited
+func (m *RateLimiter) WaitN(ctx context.Context, n int) error {
+	for {
+		result, err := m.AllowN(ctx, n)
+		if err != nil {
+			return err
+		}
+		if result.Allowed {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < result.RetryAfter {
+			return ErrRateLimited
+		}
+		timer := time.NewTimer(result.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package redisext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+
+	m := NewRedisExt("test/memory", "test")
+	for _, algorithm := range []RateLimitAlgorithm{FixedWindow, SlidingWindowLog, TokenBucket} {
+		limiter := m.NewRateLimiter("ratelimit."+algorithm.String(), algorithm, Limit{Rate: 3, Period: 200 * time.Millisecond})
+		r, err := limiter.AllowN(ctx, 2)
+		assert.NoError(t, err, algorithm.String())
+		assert.True(t, r.Allowed, algorithm.String())
+		assert.Equal(t, 1, r.Remaining, algorithm.String())
+
+		r, err = limiter.AllowN(ctx, 2)
+		assert.NoError(t, err, algorithm.String())
+		assert.False(t, r.Allowed, algorithm.String())
+		assert.True(t, r.RetryAfter > 0 && r.RetryAfter <= 200*time.Millisecond, "%s retry after:%v", algorithm, r.RetryAfter)
+
+		_, err = limiter.AllowN(ctx, 4)
+		assert.Equal(t, ErrRateLimitExceeded, err)
+		_, err = limiter.AllowN(ctx, 0)
+		assert.Error(t, err, algorithm.String())
+
+		assert.NoError(t, limiter.WaitN(ctx, 2), algorithm.String())
+		tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		assert.Equal(t, ErrRateLimited, limiter.WaitN(tctx, 3), algorithm.String())
+		cancel()
+	}
+
+	// 窗口算法忽略 Burst，n 不能超过 Rate
+	limit := Limit{Rate: 3, Period: time.Second, Burst: 5}
+	_, err := m.NewRateLimiter("ratelimit.burst.fixed", FixedWindow, limit).AllowN(ctx, 4)
+	assert.Equal(t, ErrRateLimitExceeded, err)
+	_, err = m.NewRateLimiter("ratelimit.burst.log", SlidingWindowLog, limit).AllowN(ctx, 4)
+	assert.Equal(t, ErrRateLimitExceeded, err)
+	r, err := m.NewRateLimiter("ratelimit.burst.bucket", TokenBucket, limit).AllowN(ctx, 5)
+	assert.NoError(t, err)
+	assert.True(t, r.Allowed)
+}
@@ -93,6 +93,17 @@ func (m *RedisExt) getRedisInstance(ctx context.Context) (client *redis.Client,
 	return redis.DefaultInstanceManager.GetInstance(ctx, conf)
 }
 
+// getReadInstance 读命令按照 namespace 配置的读策略路由到主库或者从库
+func (m *RedisExt) getReadInstance(ctx context.Context, command string) (client *redis.Client, err error) {
+	client, err = m.getRedisInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, role := client.Reader()
+	statReqRole(m.namespace, command, role)
+	return client, nil
+}
+
 func (m *RedisExt) getInstanceConf(ctx context.Context) *redis.InstanceConf {
 	return &redis.InstanceConf{
 		Group:     scontext.GetControlRouteGroupWithDefault(ctx, constants.DefaultRouteGroup),
@@ -110,7 +121,7 @@ func (m *RedisExt) Get(ctx context.Context, key string) (s string, err error) {
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		s, err = client.Get(ctx, m.prefixKey(key)).Result()
 	}
@@ -126,7 +137,7 @@ func (m *RedisExt) MGet(ctx context.Context, keys ...string) (v []interface{}, e
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		var prefixKey = make([]string, len(keys))
 		for k, v := range keys {
@@ -202,7 +213,7 @@ func (m *RedisExt) GetBit(ctx context.Context, key string, offset int64) (n int6
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		n, err = client.GetBit(ctx, m.prefixKey(key), offset).Result()
 	}
@@ -314,7 +325,7 @@ func (m *RedisExt) Exists(ctx context.Context, key string) (n int64, err error)
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		n, err = client.Exists(ctx, m.prefixKey(key)).Result()
 	}
@@ -395,7 +406,7 @@ func (m *RedisExt) HExists(ctx context.Context, key string, field string) (b boo
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		b, err = client.HExists(ctx, m.prefixKey(key), field).Result()
 	}
@@ -411,7 +422,7 @@ func (m *RedisExt) HGet(ctx context.Context, key string, field string) (s string
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		s, err = client.HGet(ctx, m.prefixKey(key), field).Result()
 	}
@@ -427,7 +438,7 @@ func (m *RedisExt) HGetAll(ctx context.Context, key string) (sm map[string]strin
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		sm, err = client.HGetAll(ctx, m.prefixKey(key)).Result()
 	}
@@ -475,7 +486,7 @@ func (m *RedisExt) HKeys(ctx context.Context, key string) (ss []string, err erro
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		ss, err = client.HKeys(ctx, m.prefixKey(key)).Result()
 	}
@@ -491,7 +502,7 @@ func (m *RedisExt) HLen(ctx context.Context, key string) (n int64, err error) {
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		n, err = client.HLen(ctx, m.prefixKey(key)).Result()
 	}
@@ -507,7 +518,7 @@ func (m *RedisExt) HMGet(ctx context.Context, key string, fields ...string) (vs
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		vs, err = client.HMGet(ctx, m.prefixKey(key), fields...).Result()
 	}
@@ -555,7 +566,7 @@ func (m *RedisExt) HVals(ctx context.Context, key string) (ss []string, err erro
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		ss, err = client.HVals(ctx, m.prefixKey(key)).Result()
 	}
@@ -668,7 +679,7 @@ func (m *RedisExt) ZCard(ctx context.Context, key string) (n int64, err error) {
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		n, err = client.ZCard(ctx, m.prefixKey(key)).Result()
 	}
@@ -684,7 +695,7 @@ func (m *RedisExt) ZCount(ctx context.Context, key, min, max string) (n int64, e
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		n, err = client.ZCount(ctx, m.prefixKey(key), min, max).Result()
 	}
@@ -700,7 +711,7 @@ func (m *RedisExt) ZRange(ctx context.Context, key string, start, stop int64) (s
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		ss, err = client.ZRange(ctx, m.prefixKey(key), start, stop).Result()
 	}
@@ -716,7 +727,7 @@ func (m *RedisExt) ZRangeByLex(ctx context.Context, key string, by ZRangeBy) (ss
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		ss, err = client.ZRangeByLex(ctx, m.prefixKey(key), toRedisZRangeBy(by)).Result()
 	}
@@ -732,7 +743,7 @@ func (m *RedisExt) ZRangeByScore(ctx context.Context, key string, by ZRangeBy) (
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		ss, err = client.ZRangeByScore(ctx, m.prefixKey(key), toRedisZRangeBy(by)).Result()
 	}
@@ -749,7 +760,7 @@ func (m *RedisExt) ZRangeWithScores(ctx context.Context, key string, start, stop
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
 	var rzs []redis2.Z
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		rzs, err = client.ZRangeWithScores(ctx, m.prefixKey(key), start, stop).Result()
 		zs = fromRedisZSlice(rzs)
@@ -766,7 +777,7 @@ func (m *RedisExt) ZRevRange(ctx context.Context, key string, start, stop int64)
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		ss, err = client.ZRevRange(ctx, m.prefixKey(key), start, stop).Result()
 	}
@@ -783,7 +794,7 @@ func (m *RedisExt) ZRevRangeWithScores(ctx context.Context, key string, start, s
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
 	var rzs []redis2.Z
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		rzs, err = client.ZRevRangeWithScores(ctx, m.prefixKey(key), start, stop).Result()
 		zs = fromRedisZSlice(rzs)
@@ -800,7 +811,7 @@ func (m *RedisExt) ZRank(ctx context.Context, key string, member string) (n int6
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		n, err = client.ZRank(ctx, m.prefixKey(key), member).Result()
 	}
@@ -816,7 +827,7 @@ func (m *RedisExt) ZRevRank(ctx context.Context, key string, member string) (n i
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		n, err = client.ZRevRank(ctx, m.prefixKey(key), member).Result()
 	}
@@ -944,7 +955,7 @@ func (m *RedisExt) ZScore(ctx context.Context, key string, member string) (f flo
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		f, err = client.ZScore(ctx, m.prefixKey(key), member).Result()
 	}
@@ -960,7 +971,7 @@ func (m *RedisExt) TTL(ctx context.Context, key string) (d time.Duration, err er
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		d, err = client.TTL(ctx, m.prefixKey(key)).Result()
 	}
@@ -993,7 +1004,7 @@ func (m *RedisExt) SScan(ctx context.Context, key string, cursor uint64, match s
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		keys, rcursor, err = client.SScan(ctx, m.prefixKey(key), cursor, match, count).Result()
 	}
@@ -1073,7 +1084,7 @@ func (m *RedisExt) SCard(ctx context.Context, key string) (i int64, err error) {
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		i, err = client.SCard(ctx, m.prefixKey(key)).Result()
 	}
@@ -1089,7 +1100,7 @@ func (m *RedisExt) SIsMember(ctx context.Context, key string, member interface{}
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		b, err = client.SIsMember(ctx, m.prefixKey(key), member).Result()
 	}
@@ -1105,7 +1116,7 @@ func (m *RedisExt) SMembers(ctx context.Context, key string) (s []string, err er
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		s, err = client.SMembers(ctx, m.prefixKey(key)).Result()
 	}
@@ -1121,7 +1132,7 @@ func (m *RedisExt) SRandMember(ctx context.Context, key string) (s string, err e
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		s, err = client.SRandMember(ctx, m.prefixKey(key)).Result()
 	}
@@ -1137,7 +1148,7 @@ func (m *RedisExt) SRandMemberN(ctx context.Context, key string, count int64) (s
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		s, err = client.SRandMemberN(ctx, m.prefixKey(key), count).Result()
 	}
@@ -1153,7 +1164,7 @@ func (m *RedisExt) ZScan(ctx context.Context, key string, cursor uint64, match s
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		keys, rcursor, err = client.ZScan(ctx, m.prefixKey(key), cursor, match, count).Result()
 	}
@@ -1169,7 +1180,7 @@ func (m *RedisExt) HScan(ctx context.Context, key string, cursor uint64, match s
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
-	client, err := m.getRedisInstance(ctx)
+	client, err := m.getReadInstance(ctx, command)
 	if err == nil {
 		keys, rcursor, err = client.HScan(ctx, m.prefixKey(key), cursor, match, count).Result()
 	}
@@ -5,8 +5,10 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"io"
+	"strings"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/cache/redis"
 	"github.com/shawnfeng/sutil/stime"
 )
 
@@ -24,6 +26,13 @@ func NewScript(src string) *Script {
 	}
 }
 
+// RegisterScript 创建并注册脚本，注册的脚本会在 redis 实例创建或者重建时预先加载
+func RegisterScript(src string) *Script {
+	script := NewScript(src)
+	redis.RegisterScript(script.hash, script.src)
+	return script
+}
+
 // Hash return hash of script
 func (s *Script) Hash() string {
 	return s.hash
@@ -58,11 +67,9 @@ func (m *RedisExt) ScriptExists(ctx context.Context, script *Script) (r bool, er
 	}()
 	client, err := m.getRedisInstance(ctx)
 	if err == nil {
-		result, err := client.ScriptExists(ctx, script.src).Result()
-		if err != nil {
-			return false, err
-		}
-		if len(result) > 0 {
+		var result []bool
+		result, err = client.ScriptExists(ctx, script.hash).Result()
+		if err == nil && len(result) > 0 {
 			r = result[0]
 		}
 	}
@@ -77,11 +84,9 @@ func (m *RedisExt) Eval(ctx context.Context, script *Script, keys []string, args
 	defer func() {
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
-		statReqErr(m.namespace,command, err)
+		statReqErr(m.namespace, command, err)
 	}()
-	for i, key := range keys {
-		keys[i] = m.prefixKey(key)
-	}
+	keys = m.prefixKeys(keys)
 	client, err := m.getRedisInstance(ctx)
 	if err == nil {
 		r, err = client.Eval(ctx, script.src, keys, args...).Result()
@@ -97,14 +102,41 @@ func (m *RedisExt) EvalSha(ctx context.Context, script *Script, keys []string, a
 	defer func() {
 		span.Finish()
 		statReqDuration(m.namespace, command, st.Millisecond())
-		statReqErr(m.namespace,command, err)
+		statReqErr(m.namespace, command, err)
 	}()
-	for i, key := range keys {
-		keys[i] = m.prefixKey(key)
+	keys = m.prefixKeys(keys)
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		r, err = client.EvalSha(ctx, script.hash, keys, args...).Result()
 	}
+	return r, err
+}
+
+// Run 优先使用 EVALSHA 执行脚本，redis 重启或者切换后脚本不存在时回退到 EVAL，EVAL 同时会缓存脚本
+func (m *RedisExt) Run(ctx context.Context, script *Script, keys []string, args ...interface{}) (r interface{}, err error) {
+	command := "redisext.Run"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+		statReqErr(m.namespace, command, err)
+	}()
+	keys = m.prefixKeys(keys)
 	client, err := m.getRedisInstance(ctx)
 	if err == nil {
 		r, err = client.EvalSha(ctx, script.hash, keys, args...).Result()
+		if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+			r, err = client.Eval(ctx, script.src, keys, args...).Result()
+		}
 	}
 	return r, err
 }
+
+func (m *RedisExt) prefixKeys(keys []string) []string {
+	prefixKeys := make([]string, len(keys))
+	for i, key := range keys {
+		prefixKeys[i] = m.prefixKey(key)
+	}
+	return prefixKeys
+}
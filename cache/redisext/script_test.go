@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/shawnfeng/sutil/cache/redis"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -35,3 +36,29 @@ func TestEval(t *testing.T) {
 	assert.Equal(t, r, int64(200))
 	assert.Equal(t, r1, "200")
 }
+
+func TestRun(t *testing.T) {
+	s := NewScript(`return redis.call("INCRBY", KEYS[1], ARGV[1])`)
+	redis.RegisterMemoryScript(s.src, func(call func(args ...interface{}) (interface{}, error), keys, argv []string) (interface{}, error) {
+		return call("INCRBY", keys[0], argv[0])
+	})
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	m := NewRedisExt("test/memory", "test")
+	m.Set(ctx, "key1", 100, 1*time.Second)
+
+	// NOSCRIPT 时回退到 EVAL
+	r, err := m.Run(ctx, s, []string{"key1"}, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), r)
+
+	exists, err := m.ScriptExists(ctx, s)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	keys := []string{"key1"}
+	r, err = m.Run(ctx, s, keys, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(300), r)
+	assert.Equal(t, []string{"key1"}, keys)
+}
@@ -0,0 +1,668 @@
+package redisext
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	go_redis "github.com/go-redis/redis"
+	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+type XMessage struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+type XStream struct {
+	Stream   string
+	Messages []XMessage
+}
+
+type XPending struct {
+	Count     int64
+	Lower     string
+	Higher    string
+	Consumers map[string]int64
+}
+
+type XPendingExt struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration
+	RetryCount int64
+}
+
+type XAddArgs struct {
+	Stream string
+	// MaxLen MAXLEN N
+	MaxLen int64
+	// MaxLenApprox MAXLEN ~ N
+	MaxLenApprox int64
+	// ID 为空时由redis生成
+	ID     string
+	Values map[string]interface{}
+}
+
+type XReadArgs struct {
+	// Streams 前半部分是stream key，后半部分是对应的起始id
+	Streams []string
+	Count   int64
+	// Block 小于0不阻塞，等于0一直阻塞
+	Block time.Duration
+}
+
+type XReadGroupArgs struct {
+	Group    string
+	Consumer string
+	// Streams 前半部分是stream key，后半部分是对应的起始id，">" 表示只读取未投递过的消息
+	Streams []string
+	Count   int64
+	// Block 小于0不阻塞，等于0一直阻塞
+	Block time.Duration
+	NoAck bool
+}
+
+type XPendingExtArgs struct {
+	Stream   string
+	Group    string
+	Start    string
+	End      string
+	Count    int64
+	Consumer string
+}
+
+type XClaimArgs struct {
+	Stream   string
+	Group    string
+	Consumer string
+	MinIdle  time.Duration
+	Messages []string
+}
+
+func fromRedisXMessage(rm go_redis.XMessage) XMessage {
+	return XMessage{
+		ID:     rm.ID,
+		Values: rm.Values,
+	}
+}
+
+func fromRedisXMessageSlice(rms []go_redis.XMessage) (ms []XMessage) {
+	for _, rm := range rms {
+		ms = append(ms, fromRedisXMessage(rm))
+	}
+	return
+}
+
+func fromRedisXPending(rp *go_redis.XPending) *XPending {
+	if rp == nil {
+		return nil
+	}
+	return &XPending{
+		Count:     rp.Count,
+		Lower:     rp.Lower,
+		Higher:    rp.Higher,
+		Consumers: rp.Consumers,
+	}
+}
+
+func fromRedisXPendingExtSlice(rps []go_redis.XPendingExt) (ps []XPendingExt) {
+	for _, rp := range rps {
+		ps = append(ps, XPendingExt{
+			ID:         rp.Id,
+			Consumer:   rp.Consumer,
+			Idle:       rp.Idle,
+			RetryCount: rp.RetryCount,
+		})
+	}
+	return
+}
+
+func (m *RedisExt) trimPrefixKey(key string) string {
+	if len(m.prefix) > 0 {
+		key = strings.TrimPrefix(key, m.prefix+".")
+	}
+	return key
+}
+
+func (m *RedisExt) prefixStreams(streams []string) []string {
+	prefixStreams := make([]string, len(streams))
+	copy(prefixStreams, streams)
+	for i := 0; i < len(prefixStreams)/2; i++ {
+		prefixStreams[i] = m.prefixKey(prefixStreams[i])
+	}
+	return prefixStreams
+}
+
+func (m *PipelineExt) prefixStreams(streams []string) []string {
+	prefixStreams := make([]string, len(streams))
+	copy(prefixStreams, streams)
+	for i := 0; i < len(prefixStreams)/2; i++ {
+		prefixStreams[i] = m.prefixKey(prefixStreams[i])
+	}
+	return prefixStreams
+}
+
+func (m *RedisExt) fromRedisXStreamSlice(client *redis.Client, rss []go_redis.XStream) (ss []XStream) {
+	for _, rs := range rss {
+		ss = append(ss, XStream{
+			Stream:   m.trimPrefixKey(client.TrimKey(rs.Stream)),
+			Messages: fromRedisXMessageSlice(rs.Messages),
+		})
+	}
+	return
+}
+
+func (m *RedisExt) XAdd(ctx context.Context, a *XAddArgs) (id string, err error) {
+	command := "redisext.XAdd"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		id, err = client.XAdd(ctx, &go_redis.XAddArgs{
+			Stream:       m.prefixKey(a.Stream),
+			MaxLen:       a.MaxLen,
+			MaxLenApprox: a.MaxLenApprox,
+			ID:           a.ID,
+			Values:       a.Values,
+		}).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XAdd(ctx context.Context, a *XAddArgs) *go_redis.StringCmd {
+	return p.pipe.XAdd(ctx, &go_redis.XAddArgs{
+		Stream:       p.prefixKey(a.Stream),
+		MaxLen:       a.MaxLen,
+		MaxLenApprox: a.MaxLenApprox,
+		ID:           a.ID,
+		Values:       a.Values,
+	})
+}
+
+func (m *RedisExt) XDel(ctx context.Context, stream string, ids ...string) (n int64, err error) {
+	command := "redisext.XDel"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.XDel(ctx, m.prefixKey(stream), ids...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XDel(ctx context.Context, stream string, ids ...string) *go_redis.IntCmd {
+	return p.pipe.XDel(ctx, p.prefixKey(stream), ids...)
+}
+
+func (m *RedisExt) XLen(ctx context.Context, stream string) (n int64, err error) {
+	command := "redisext.XLen"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		n, err = client.XLen(ctx, m.prefixKey(stream)).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XLen(ctx context.Context, stream string) *go_redis.IntCmd {
+	return p.pipe.XLen(ctx, p.prefixKey(stream))
+}
+
+func (m *RedisExt) XRange(ctx context.Context, stream, start, stop string) (ms []XMessage, err error) {
+	command := "redisext.XRange"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		var rms []go_redis.XMessage
+		rms, err = client.XRange(ctx, m.prefixKey(stream), start, stop).Result()
+		ms = fromRedisXMessageSlice(rms)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XRange(ctx context.Context, stream, start, stop string) *go_redis.XMessageSliceCmd {
+	return p.pipe.XRange(ctx, p.prefixKey(stream), start, stop)
+}
+
+func (m *RedisExt) XRangeN(ctx context.Context, stream, start, stop string, count int64) (ms []XMessage, err error) {
+	command := "redisext.XRangeN"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		var rms []go_redis.XMessage
+		rms, err = client.XRangeN(ctx, m.prefixKey(stream), start, stop, count).Result()
+		ms = fromRedisXMessageSlice(rms)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XRangeN(ctx context.Context, stream, start, stop string, count int64) *go_redis.XMessageSliceCmd {
+	return p.pipe.XRangeN(ctx, p.prefixKey(stream), start, stop, count)
+}
+
+func (m *RedisExt) XRevRange(ctx context.Context, stream, start, stop string) (ms []XMessage, err error) {
+	command := "redisext.XRevRange"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		var rms []go_redis.XMessage
+		rms, err = client.XRevRange(ctx, m.prefixKey(stream), start, stop).Result()
+		ms = fromRedisXMessageSlice(rms)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XRevRange(ctx context.Context, stream, start, stop string) *go_redis.XMessageSliceCmd {
+	return p.pipe.XRevRange(ctx, p.prefixKey(stream), start, stop)
+}
+
+func (m *RedisExt) XRevRangeN(ctx context.Context, stream, start, stop string, count int64) (ms []XMessage, err error) {
+	command := "redisext.XRevRangeN"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getReadInstance(ctx, command)
+	if err == nil {
+		var rms []go_redis.XMessage
+		rms, err = client.XRevRangeN(ctx, m.prefixKey(stream), start, stop, count).Result()
+		ms = fromRedisXMessageSlice(rms)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XRevRangeN(ctx context.Context, stream, start, stop string, count int64) *go_redis.XMessageSliceCmd {
+	return p.pipe.XRevRangeN(ctx, p.prefixKey(stream), start, stop, count)
+}
+
+// XRead 返回结果中的 Stream 为调用方传入的 key
+func (m *RedisExt) XRead(ctx context.Context, a *XReadArgs) (ss []XStream, err error) {
+	command := "redisext.XRead"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		var rss []go_redis.XStream
+		rss, err = client.XRead(ctx, &go_redis.XReadArgs{
+			Streams: m.prefixStreams(a.Streams),
+			Count:   a.Count,
+			Block:   a.Block,
+		}).Result()
+		ss = m.fromRedisXStreamSlice(client, rss)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XRead(ctx context.Context, a *XReadArgs) *go_redis.XStreamSliceCmd {
+	return p.pipe.XRead(ctx, &go_redis.XReadArgs{
+		Streams: p.prefixStreams(a.Streams),
+		Count:   a.Count,
+		Block:   a.Block,
+	})
+}
+
+func (m *RedisExt) XGroupCreate(ctx context.Context, stream, group, start string) (s string, err error) {
+	command := "redisext.XGroupCreate"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		s, err = client.XGroupCreate(ctx, m.prefixKey(stream), group, start).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XGroupCreate(ctx context.Context, stream, group, start string) *go_redis.StatusCmd {
+	return p.pipe.XGroupCreate(ctx, p.prefixKey(stream), group, start)
+}
+
+func (m *RedisExt) XGroupCreateMkStream(ctx context.Context, stream, group, start string) (s string, err error) {
+	command := "redisext.XGroupCreateMkStream"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		s, err = client.XGroupCreateMkStream(ctx, m.prefixKey(stream), group, start).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XGroupCreateMkStream(ctx context.Context, stream, group, start string) *go_redis.StatusCmd {
+	return p.pipe.XGroupCreateMkStream(ctx, p.prefixKey(stream), group, start)
+}
+
+func (m *RedisExt) XGroupSetID(ctx context.Context, stream, group, start string) (s string, err error) {
+	command := "redisext.XGroupSetID"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		s, err = client.XGroupSetID(ctx, m.prefixKey(stream), group, start).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XGroupSetID(ctx context.Context, stream, group, start string) *go_redis.StatusCmd {
+	return p.pipe.XGroupSetID(ctx, p.prefixKey(stream), group, start)
+}
+
+func (m *RedisExt) XGroupDestroy(ctx context.Context, stream, group string) (n int64, err error) {
+	command := "redisext.XGroupDestroy"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.XGroupDestroy(ctx, m.prefixKey(stream), group).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XGroupDestroy(ctx context.Context, stream, group string) *go_redis.IntCmd {
+	return p.pipe.XGroupDestroy(ctx, p.prefixKey(stream), group)
+}
+
+func (m *RedisExt) XGroupDelConsumer(ctx context.Context, stream, group, consumer string) (n int64, err error) {
+	command := "redisext.XGroupDelConsumer"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.XGroupDelConsumer(ctx, m.prefixKey(stream), group, consumer).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XGroupDelConsumer(ctx context.Context, stream, group, consumer string) *go_redis.IntCmd {
+	return p.pipe.XGroupDelConsumer(ctx, p.prefixKey(stream), group, consumer)
+}
+
+// XReadGroup 返回结果中的 Stream 为调用方传入的 key
+func (m *RedisExt) XReadGroup(ctx context.Context, a *XReadGroupArgs) (ss []XStream, err error) {
+	command := "redisext.XReadGroup"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		var rss []go_redis.XStream
+		rss, err = client.XReadGroup(ctx, &go_redis.XReadGroupArgs{
+			Group:    a.Group,
+			Consumer: a.Consumer,
+			Streams:  m.prefixStreams(a.Streams),
+			Count:    a.Count,
+			Block:    a.Block,
+			NoAck:    a.NoAck,
+		}).Result()
+		ss = m.fromRedisXStreamSlice(client, rss)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XReadGroup(ctx context.Context, a *XReadGroupArgs) *go_redis.XStreamSliceCmd {
+	return p.pipe.XReadGroup(ctx, &go_redis.XReadGroupArgs{
+		Group:    a.Group,
+		Consumer: a.Consumer,
+		Streams:  p.prefixStreams(a.Streams),
+		Count:    a.Count,
+		Block:    a.Block,
+		NoAck:    a.NoAck,
+	})
+}
+
+func (m *RedisExt) XAck(ctx context.Context, stream, group string, ids ...string) (n int64, err error) {
+	command := "redisext.XAck"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.XAck(ctx, m.prefixKey(stream), group, ids...).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XAck(ctx context.Context, stream, group string, ids ...string) *go_redis.IntCmd {
+	return p.pipe.XAck(ctx, p.prefixKey(stream), group, ids...)
+}
+
+func (m *RedisExt) XPending(ctx context.Context, stream, group string) (pending *XPending, err error) {
+	command := "redisext.XPending"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		var rp *go_redis.XPending
+		rp, err = client.XPending(ctx, m.prefixKey(stream), group).Result()
+		pending = fromRedisXPending(rp)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XPending(ctx context.Context, stream, group string) *go_redis.XPendingCmd {
+	return p.pipe.XPending(ctx, p.prefixKey(stream), group)
+}
+
+func (m *RedisExt) XPendingExt(ctx context.Context, a *XPendingExtArgs) (ps []XPendingExt, err error) {
+	command := "redisext.XPendingExt"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		var rps []go_redis.XPendingExt
+		rps, err = client.XPendingExt(ctx, &go_redis.XPendingExtArgs{
+			Stream:   m.prefixKey(a.Stream),
+			Group:    a.Group,
+			Start:    a.Start,
+			End:      a.End,
+			Count:    a.Count,
+			Consumer: a.Consumer,
+		}).Result()
+		ps = fromRedisXPendingExtSlice(rps)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XPendingExt(ctx context.Context, a *XPendingExtArgs) *go_redis.XPendingExtCmd {
+	return p.pipe.XPendingExt(ctx, &go_redis.XPendingExtArgs{
+		Stream:   p.prefixKey(a.Stream),
+		Group:    a.Group,
+		Start:    a.Start,
+		End:      a.End,
+		Count:    a.Count,
+		Consumer: a.Consumer,
+	})
+}
+
+func (m *RedisExt) XClaim(ctx context.Context, a *XClaimArgs) (ms []XMessage, err error) {
+	command := "redisext.XClaim"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		var rms []go_redis.XMessage
+		rms, err = client.XClaim(ctx, &go_redis.XClaimArgs{
+			Stream:   m.prefixKey(a.Stream),
+			Group:    a.Group,
+			Consumer: a.Consumer,
+			MinIdle:  a.MinIdle,
+			Messages: a.Messages,
+		}).Result()
+		ms = fromRedisXMessageSlice(rms)
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XClaim(ctx context.Context, a *XClaimArgs) *go_redis.XMessageSliceCmd {
+	return p.pipe.XClaim(ctx, &go_redis.XClaimArgs{
+		Stream:   p.prefixKey(a.Stream),
+		Group:    a.Group,
+		Consumer: a.Consumer,
+		MinIdle:  a.MinIdle,
+		Messages: a.Messages,
+	})
+}
+
+func (m *RedisExt) XClaimJustID(ctx context.Context, a *XClaimArgs) (ids []string, err error) {
+	command := "redisext.XClaimJustID"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		ids, err = client.XClaimJustID(ctx, &go_redis.XClaimArgs{
+			Stream:   m.prefixKey(a.Stream),
+			Group:    a.Group,
+			Consumer: a.Consumer,
+			MinIdle:  a.MinIdle,
+			Messages: a.Messages,
+		}).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XClaimJustID(ctx context.Context, a *XClaimArgs) *go_redis.StringSliceCmd {
+	return p.pipe.XClaimJustID(ctx, &go_redis.XClaimArgs{
+		Stream:   p.prefixKey(a.Stream),
+		Group:    a.Group,
+		Consumer: a.Consumer,
+		MinIdle:  a.MinIdle,
+		Messages: a.Messages,
+	})
+}
+
+func (m *RedisExt) XTrim(ctx context.Context, stream string, maxLen int64) (n int64, err error) {
+	command := "redisext.XTrim"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.XTrim(ctx, m.prefixKey(stream), maxLen).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XTrim(ctx context.Context, stream string, maxLen int64) *go_redis.IntCmd {
+	return p.pipe.XTrim(ctx, p.prefixKey(stream), maxLen)
+}
+
+func (m *RedisExt) XTrimApprox(ctx context.Context, stream string, maxLen int64) (n int64, err error) {
+	command := "redisext.XTrimApprox"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		n, err = client.XTrimApprox(ctx, m.prefixKey(stream), maxLen).Result()
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (p *PipelineExt) XTrimApprox(ctx context.Context, stream string, maxLen int64) *go_redis.IntCmd {
+	return p.pipe.XTrimApprox(ctx, p.prefixKey(stream), maxLen)
+}
@@ -0,0 +1,127 @@
+package redisext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	streamName = "unittest_stream"
+	groupName  = "unittest_group"
+)
+
+func TestRedisExt_XAdd(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	client.Del(ctx, streamName)
+	id, err := client.XAdd(ctx, &XAddArgs{
+		Stream: streamName,
+		Values: map[string]interface{}{"hello": "world"},
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id)
+	n, err := client.XLen(ctx, streamName)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	ms, err := client.XRange(ctx, streamName, "-", "+")
+	assert.NoError(t, err)
+	assert.Equal(t, []XMessage{{ID: id, Values: map[string]interface{}{"hello": "world"}}}, ms)
+	client.Del(ctx, streamName)
+}
+
+func TestRedisExt_XRead(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	client.Del(ctx, streamName)
+	id, err := client.XAdd(ctx, &XAddArgs{
+		Stream: streamName,
+		Values: map[string]interface{}{"hello": "world"},
+	})
+	assert.NoError(t, err)
+	ss, err := client.XRead(ctx, &XReadArgs{
+		Streams: []string{streamName, "0"},
+		Block:   -1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ss))
+	assert.Equal(t, streamName, ss[0].Stream)
+	assert.Equal(t, id, ss[0].Messages[0].ID)
+	client.Del(ctx, streamName)
+}
+
+func TestRedisExt_XReadGroup(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	client.Del(ctx, streamName)
+	_, err := client.XGroupCreateMkStream(ctx, streamName, groupName, "0")
+	assert.NoError(t, err)
+	id, err := client.XAdd(ctx, &XAddArgs{
+		Stream: streamName,
+		Values: map[string]interface{}{"hello": "world"},
+	})
+	assert.NoError(t, err)
+
+	ss, err := client.XReadGroup(ctx, &XReadGroupArgs{
+		Group:    groupName,
+		Consumer: "c1",
+		Streams:  []string{streamName, ">"},
+		Block:    -1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ss))
+	assert.Equal(t, streamName, ss[0].Stream)
+
+	pending, err := client.XPending(ctx, streamName, groupName)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pending.Count)
+
+	time.Sleep(10 * time.Millisecond)
+	ms, err := client.XClaim(ctx, &XClaimArgs{
+		Stream:   streamName,
+		Group:    groupName,
+		Consumer: "c2",
+		MinIdle:  time.Millisecond,
+		Messages: []string{id},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ms))
+
+	ps, err := client.XPendingExt(ctx, &XPendingExtArgs{
+		Stream: streamName,
+		Group:  groupName,
+		Start:  "-",
+		End:    "+",
+		Count:  10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "c2", ps[0].Consumer)
+
+	n, err := client.XAck(ctx, streamName, groupName, id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	client.Del(ctx, streamName)
+}
+
+func TestRedisExt_XTrim(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+	client := NewRedisExt("test/memory", "test")
+	client.Del(ctx, streamName)
+	for i := 0; i < 3; i++ {
+		_, err := client.XAdd(ctx, &XAddArgs{
+			Stream: streamName,
+			Values: map[string]interface{}{"i": i},
+		})
+		assert.NoError(t, err)
+	}
+	n, err := client.XTrim(ctx, streamName, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	client.Del(ctx, streamName)
+}
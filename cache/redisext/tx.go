@@ -0,0 +1,116 @@
+package redisext
+
+import (
+	"context"
+	"fmt"
+
+	go_redis "github.com/go-redis/redis"
+	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+// ErrTxFailed 被 WATCH 的 key 在 EXEC 之前被修改，重试次数用完后由 Watch 返回
+var ErrTxFailed = redis.TxFailedErr
+
+// TxExt WATCH 之后的事务，key 使用 RedisExt 的前缀
+type TxExt struct {
+	namespace string
+	prefix    string
+	tx        *redis.Tx
+}
+
+func (m *TxExt) prefixKey(key string) string {
+	if len(m.prefix) > 0 {
+		key = fmt.Sprintf("%s.%s", m.prefix, key)
+	}
+	return key
+}
+
+// Watch 乐观锁事务：监视 keys 后执行 fn，fn 中先读取数据，再通过 TxPipelined 提交写命令
+// 被监视的 key 在 EXEC 之前被修改时整体重试，最多重试 retries 次，仍然失败时返回 ErrTxFailed
+func (m *RedisExt) Watch(ctx context.Context, retries int, fn func(ctx context.Context, tx *TxExt) error, keys ...string) (err error) {
+	command := "redisext.Watch"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	client, err := m.getRedisInstance(ctx)
+	if err == nil {
+		var prefixKey = make([]string, len(keys))
+		for k, v := range keys {
+			prefixKey[k] = m.prefixKey(v)
+		}
+		for attempt := 0; attempt <= retries; attempt++ {
+			if err = m.watchAttempt(ctx, client, attempt, fn, prefixKey); err != ErrTxFailed {
+				break
+			}
+			if attempt < retries {
+				statTxRetry(m.namespace)
+			}
+		}
+	}
+	statReqErr(m.namespace, command, err)
+	return
+}
+
+func (m *RedisExt) watchAttempt(ctx context.Context, client *redis.Client, attempt int, fn func(ctx context.Context, tx *TxExt) error, keys []string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "redisext.Watch.attempt")
+	defer span.Finish()
+	span.SetTag("attempt", attempt)
+	err := client.Watch(ctx, func(tx *redis.Tx) error {
+		return fn(ctx, &TxExt{namespace: m.namespace, prefix: m.prefix, tx: tx})
+	}, keys...)
+	if err != nil {
+		span.SetTag("error", err.Error())
+	}
+	return err
+}
+
+// TxPipelined 在 MULTI/EXEC 中原子地执行 fn 加入的命令，被监视的 key 已经被修改时返回 ErrTxFailed
+func (m *TxExt) TxPipelined(ctx context.Context, fn func(pipe *PipelineExt) error) (cmds []go_redis.Cmder, err error) {
+	command := "TxExt.TxPipelined"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+	cmds, err = m.tx.TxPipelined(ctx, func(p *redis.Pipeline) error {
+		return fn(&PipelineExt{namespace: m.namespace, prefix: m.prefix, pipe: p})
+	})
+	if err != ErrTxFailed {
+		statReqErr(m.namespace, command, err)
+	}
+	return
+}
+
+func (m *TxExt) Get(ctx context.Context, key string) (s string, err error) {
+	return m.tx.Get(ctx, m.prefixKey(key)).Result()
+}
+
+func (m *TxExt) Exists(ctx context.Context, keys ...string) (n int64, err error) {
+	var prefixKey = make([]string, len(keys))
+	for k, v := range keys {
+		prefixKey[k] = m.prefixKey(v)
+	}
+	return m.tx.Exists(ctx, prefixKey...).Result()
+}
+
+func (m *TxExt) HGet(ctx context.Context, key, field string) (s string, err error) {
+	return m.tx.HGet(ctx, m.prefixKey(key), field).Result()
+}
+
+func (m *TxExt) HGetAll(ctx context.Context, key string) (sm map[string]string, err error) {
+	return m.tx.HGetAll(ctx, m.prefixKey(key)).Result()
+}
+
+func (m *TxExt) ZScore(ctx context.Context, key, member string) (f float64, err error) {
+	return m.tx.ZScore(ctx, m.prefixKey(key), member).Result()
+}
+
+func (m *TxExt) SIsMember(ctx context.Context, key string, member interface{}) (b bool, err error) {
+	return m.tx.SIsMember(ctx, m.prefixKey(key), member).Result()
+}
@@ -0,0 +1,63 @@
+package redisext
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	go_redis "github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func incrByWatch(ctx context.Context, m *RedisExt, key string, retries int) error {
+	return m.Watch(ctx, retries, func(ctx context.Context, tx *TxExt) error {
+		v, err := tx.Get(ctx, key)
+		if err != nil && err != go_redis.Nil {
+			return err
+		}
+		n, _ := strconv.Atoi(v)
+		_, err = tx.TxPipelined(ctx, func(pipe *PipelineExt) error {
+			pipe.Set(ctx, key, n+1, 0)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+
+	m := NewRedisExt("test/memory", "test")
+	_, _ = m.Del(ctx, "tx.counter")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, incrByWatch(ctx, m, "tx.counter", 100))
+		}()
+	}
+	wg.Wait()
+	v, err := m.Get(ctx, "tx.counter")
+	assert.NoError(t, err)
+	assert.Equal(t, "10", v)
+
+	// 监视的 key 在 EXEC 之前被修改
+	err = m.Watch(ctx, 0, func(ctx context.Context, tx *TxExt) error {
+		if _, err := m.Set(ctx, "tx.counter", 0, 0); err != nil {
+			return err
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe *PipelineExt) error {
+			pipe.Incr(ctx, "tx.counter")
+			return nil
+		})
+		return err
+	}, "tx.counter")
+	assert.Equal(t, ErrTxFailed, err)
+	v, err = m.Get(ctx, "tx.counter")
+	assert.NoError(t, err)
+	assert.Equal(t, "0", v)
+}
@@ -0,0 +1,118 @@
+package value
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/shawnfeng/sutil/cache"
+	"github.com/shawnfeng/sutil/cache/constants"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/slog/slog"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+const (
+	invalidateChannel = "__invalidate"
+
+	resubscribeBackOffStep = 100 * time.Millisecond
+	resubscribeBackOffCeil = 5 * time.Second
+)
+
+// invalidateMessage 本地缓存失效消息，Sender 为发送方 Cache 实例的 id，发送方自己收到时忽略
+type invalidateMessage struct {
+	Sender string `json:"s"`
+	Key    string `json:"k"`
+}
+
+// 失效消息统一通过默认分组的实例收发
+func (m *Cache) invalidateInstanceConf() *redis.InstanceConf {
+	return &redis.InstanceConf{
+		Group:     constants.DefaultRouteGroup,
+		Namespace: m.namespace,
+		Wrapper:   cache.WrapperTypeCache,
+	}
+}
+
+func (m *Cache) invalidateChannel() string {
+	if len(m.prefix) > 0 {
+		return m.prefix + "." + invalidateChannel
+	}
+	return invalidateChannel
+}
+
+// publishInvalidate 通知其他实例删除本地缓存，失败时只打印日志，依赖本地缓存的过期时间兜底
+func (m *Cache) publishInvalidate(ctx context.Context, lkey string) {
+	fun := "Cache.publishInvalidate -->"
+	data, err := json.Marshal(&invalidateMessage{Sender: m.id, Key: lkey})
+	if err != nil {
+		slog.Errorf(ctx, "%s marshal key:%s err:%v", fun, lkey, err)
+		return
+	}
+
+	client, err := redis.DefaultInstanceManager.GetInstance(ctx, m.invalidateInstanceConf())
+	if err != nil {
+		slog.Errorf(ctx, "%s get instance err, namespace: %s", fun, m.namespace)
+		return
+	}
+
+	err = client.Publish(ctx, m.invalidateChannel(), data).Err()
+	if err != nil {
+		slog.Errorf(ctx, "%s publish key:%s err:%v", fun, lkey, err)
+	}
+}
+
+// watchInvalidate 订阅失效消息，redis 实例因配置变更被重建时重新订阅，
+// 重新订阅前清空本地缓存，避免使用断开期间已经失效的数据
+func (m *Cache) watchInvalidate() {
+	fun := "Cache.watchInvalidate -->"
+	ctx := context.Background()
+	backoff := stime.NewBackOffCtrl(resubscribeBackOffStep, resubscribeBackOffCeil)
+	for {
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+
+		client, err := redis.DefaultInstanceManager.GetInstance(ctx, m.invalidateInstanceConf())
+		if err != nil {
+			slog.Errorf(ctx, "%s get instance err, namespace: %s", fun, m.namespace)
+			backoff.BackOff()
+			continue
+		}
+		backoff.Reset()
+
+		pubsub := client.Subscribe(ctx, m.invalidateChannel())
+		m.local.purge()
+		m.receiveInvalidate(ctx, pubsub)
+		pubsub.Close()
+
+		slog.Warnf(ctx, "%s pubsub closed, namespace: %s", fun, m.namespace)
+		backoff.BackOff()
+	}
+}
+
+func (m *Cache) receiveInvalidate(ctx context.Context, pubsub *redis.PubSub) {
+	fun := "Cache.receiveInvalidate -->"
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-m.done:
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var im invalidateMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &im); err != nil {
+				slog.Warnf(ctx, "%s unmarshal payload:%s err:%v", fun, msg.Payload, err)
+				continue
+			}
+			if im.Sender == m.id {
+				continue
+			}
+			m.local.del(im.Key)
+		}
+	}
+}
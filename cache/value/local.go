@@ -0,0 +1,219 @@
+package value
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+type LocalPolicy int
+
+const (
+	LocalPolicyLRU LocalPolicy = iota
+	LocalPolicyLFU
+)
+
+func (p LocalPolicy) String() string {
+	switch p {
+	case LocalPolicyLRU:
+		return "lru"
+	case LocalPolicyLFU:
+		return "lfu"
+	default:
+		return "unknown"
+	}
+}
+
+type localEntry struct {
+	key      string
+	data     []byte
+	expireAt time.Time
+
+	// lru
+	elem *list.Element
+	// lfu
+	freq  int64
+	seq   int64
+	index int
+}
+
+func (e *localEntry) size() int64 {
+	return int64(len(e.key) + len(e.data))
+}
+
+// localEntryHeap 按照访问频率排序，频率相同时淘汰较早访问的
+type localEntryHeap []*localEntry
+
+func (h localEntryHeap) Len() int { return len(h) }
+
+func (h localEntryHeap) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].freq < h[j].freq
+}
+
+func (h localEntryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *localEntryHeap) Push(x interface{}) {
+	e := x.(*localEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *localEntryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// localCache 进程内缓存，保存序列化后的数据，按照条目数和字节数限制容量
+type localCache struct {
+	policy     LocalPolicy
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	bytes   int64
+	seq     int64
+	entries map[string]*localEntry
+	lru     *list.List
+	lfu     localEntryHeap
+}
+
+func newLocalCache(policy LocalPolicy, maxEntries int, maxBytes int64, ttl time.Duration) *localCache {
+	return &localCache{
+		policy:     policy,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		entries:    make(map[string]*localEntry),
+		lru:        list.New(),
+	}
+}
+
+func (m *localCache) get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expireAt) {
+		m.remove(e)
+		return nil, false
+	}
+	m.touch(e)
+	return e.data, true
+}
+
+// set ttl 大于本地缓存的过期时间时使用本地缓存的过期时间
+func (m *localCache) set(key string, data []byte, ttl time.Duration) {
+	if ttl <= 0 || ttl > m.ttl {
+		ttl = m.ttl
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		m.remove(e)
+	}
+	e := &localEntry{
+		key:      key,
+		data:     data,
+		expireAt: time.Now().Add(ttl),
+	}
+	if m.maxBytes > 0 && e.size() > m.maxBytes {
+		return
+	}
+
+	// NOTE: 先淘汰再插入，避免 LFU 策略下新插入的数据因为访问频率最低被立即淘汰
+	for m.overflow(e.size()) {
+		m.evict()
+	}
+
+	m.entries[key] = e
+	m.bytes += e.size()
+	switch m.policy {
+	case LocalPolicyLFU:
+		m.seq++
+		e.freq = 1
+		e.seq = m.seq
+		heap.Push(&m.lfu, e)
+	default:
+		e.elem = m.lru.PushFront(e)
+	}
+}
+
+func (m *localCache) del(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		m.remove(e)
+	}
+}
+
+func (m *localCache) purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes = 0
+	m.entries = make(map[string]*localEntry)
+	m.lru.Init()
+	m.lfu = nil
+}
+
+func (m *localCache) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// overflow 再插入 size 字节的数据后是否超过容量上限
+func (m *localCache) overflow(size int64) bool {
+	if len(m.entries) == 0 {
+		return false
+	}
+	return (m.maxEntries > 0 && len(m.entries)+1 > m.maxEntries) ||
+		(m.maxBytes > 0 && m.bytes+size > m.maxBytes)
+}
+
+func (m *localCache) touch(e *localEntry) {
+	switch m.policy {
+	case LocalPolicyLFU:
+		m.seq++
+		e.freq++
+		e.seq = m.seq
+		heap.Fix(&m.lfu, e.index)
+	default:
+		m.lru.MoveToFront(e.elem)
+	}
+}
+
+func (m *localCache) evict() {
+	switch m.policy {
+	case LocalPolicyLFU:
+		m.remove(m.lfu[0])
+	default:
+		m.remove(m.lru.Back().Value.(*localEntry))
+	}
+}
+
+func (m *localCache) remove(e *localEntry) {
+	delete(m.entries, e.key)
+	m.bytes -= e.size()
+	switch m.policy {
+	case LocalPolicyLFU:
+		heap.Remove(&m.lfu, e.index)
+	default:
+		m.lru.Remove(e.elem)
+	}
+}
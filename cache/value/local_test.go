@@ -0,0 +1,67 @@
+package value
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalCacheLRU(t *testing.T) {
+	c := newLocalCache(LocalPolicyLRU, 2, 0, time.Minute)
+	c.set("a", []byte("1"), 0)
+	c.set("b", []byte("2"), 0)
+	_, ok := c.get("a")
+	assert.True(t, ok)
+
+	// b 最久未访问，被淘汰
+	c.set("c", []byte("3"), 0)
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	data, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), data)
+	assert.Equal(t, 2, c.len())
+}
+
+func TestLocalCacheLFU(t *testing.T) {
+	c := newLocalCache(LocalPolicyLFU, 2, 0, time.Minute)
+	c.set("a", []byte("1"), 0)
+	c.set("b", []byte("2"), 0)
+	c.get("a")
+	c.get("a")
+	c.get("b")
+
+	// b 访问次数最少，被淘汰
+	c.set("c", []byte("3"), 0)
+	_, ok := c.get("b")
+	assert.False(t, ok)
+	_, ok = c.get("a")
+	assert.True(t, ok)
+	_, ok = c.get("c")
+	assert.True(t, ok)
+}
+
+func TestLocalCacheBytesAndTTL(t *testing.T) {
+	c := newLocalCache(LocalPolicyLRU, 0, 9, time.Minute)
+	c.set("a", []byte("1234"), 0)
+	c.set("b", []byte("1234"), 0)
+	_, ok := c.get("a")
+	assert.False(t, ok)
+	_, ok = c.get("b")
+	assert.True(t, ok)
+
+	// 超过容量上限的数据不缓存
+	c.set("c", []byte("1234567890"), 0)
+	_, ok = c.get("c")
+	assert.False(t, ok)
+
+	c.set("d", []byte("1"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = c.get("d")
+	assert.False(t, ok)
+
+	c.purge()
+	assert.Equal(t, 0, c.len())
+	assert.Equal(t, int64(0), c.bytes)
+}
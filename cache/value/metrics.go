@@ -41,6 +41,20 @@ var (
 		Help:       "cache.value miss total",
 		LabelNames: []string{"namespace", "command"},
 	})
+	_metricTierHits = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "tier_hits_total",
+		Help:       "cache.value hits total by tier",
+		LabelNames: []string{"namespace", "command", "tier"},
+	})
+	_metricTierMiss = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "tier_miss_total",
+		Help:       "cache.value miss total by tier",
+		LabelNames: []string{"namespace", "command", "tier"},
+	})
 )
 
 func statReqDuration(namespace, command string, durationMS int64) {
@@ -53,3 +67,11 @@ func statReqErr(namespace, command string, err error) {
 	}
 	return
 }
+
+func statTierHit(namespace, command, tier string) {
+	_metricTierHits.With("namespace", namespace, "command", command, "tier", tier).Inc()
+}
+
+func statTierMiss(namespace, command, tier string) {
+	_metricTierMiss.With("namespace", namespace, "command", command, "tier", tier).Inc()
+}
@@ -0,0 +1,285 @@
+package value
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/slog/slog"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+type multiEntry struct {
+	key  interface{}
+	skey string
+	data []byte
+}
+
+// GetMulti 批量获取，valuesMap 为非nil的map，key 的类型与 keys 中元素的类型一致，value 为反序列化的类型，比如 map[int64]*Test
+// 不存在的 key 不会写入 valuesMap
+func (m *Cache) GetMulti(ctx context.Context, keys []interface{}, valuesMap interface{}) error {
+	fun := "Cache.GetMulti -->"
+	command := "cache.value.GetMulti"
+	span, ctx := opentracing.StartSpanFromContext(ctx, command)
+	st := stime.NewTimeStat()
+	defer func() {
+		span.Finish()
+		statReqDuration(m.namespace, command, st.Millisecond())
+	}()
+
+	mv := reflect.ValueOf(valuesMap)
+	if mv.Kind() != reflect.Map || mv.IsNil() {
+		err := fmt.Errorf("valuesMap should be a non-nil map, got: %T", valuesMap)
+		statReqErr(m.namespace, command, err)
+		return err
+	}
+
+	entries := make([]*multiEntry, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if _, err := mapKey(mv, key); err != nil {
+			statReqErr(m.namespace, command, err)
+			return err
+		}
+		skey, err := m.prefixKey(key)
+		if err != nil {
+			statReqErr(m.namespace, command, err)
+			return err
+		}
+		if seen[skey] {
+			continue
+		}
+		seen[skey] = true
+		entries = append(entries, &multiEntry{key: key, skey: skey})
+	}
+
+	misses := m.getMultiFromLocal(ctx, command, entries)
+	misses, err := m.getMultiFromCache(ctx, command, misses)
+	if err != nil {
+		statReqErr(m.namespace, command, err)
+		slog.Errorf(ctx, "%s cache keys: %v err: %v", fun, keys, err)
+		return fmt.Errorf("%s cache keys: %v err: %v", fun, keys, err)
+	}
+
+	if len(misses) > 0 {
+		err = m.loadMultiToCache(ctx, misses)
+		if err != nil {
+			statReqErr(m.namespace, command, err)
+			slog.Errorf(ctx, "%s loadMultiToCache err: %v", fun, err)
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if e.data == nil {
+			continue
+		}
+		err = setMapValue(mv, e.key, func(value interface{}) error {
+			return m.unmarshal(e.data, value)
+		})
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			statReqErr(m.namespace, command, err)
+			slog.Errorf(ctx, "%s unmarshal key: %v err: %v", fun, e.key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Cache) getMultiFromLocal(ctx context.Context, command string, entries []*multiEntry) (misses []*multiEntry) {
+	if m.local == nil {
+		return entries
+	}
+	for _, e := range entries {
+		if data, ok := m.local.get(m.localKey(ctx, e.skey)); ok {
+			e.data = data
+			statTierHit(m.namespace, command, tierLocal)
+			_metricHits.With("namespace", m.namespace, "command", command).Inc()
+			continue
+		}
+		statTierMiss(m.namespace, command, tierLocal)
+		misses = append(misses, e)
+	}
+	return
+}
+
+// getMultiFromCache 通过一次 pipeline 获取数据，开启 softTTL 时同时获取剩余的过期时间
+func (m *Cache) getMultiFromCache(ctx context.Context, command string, entries []*multiEntry) (misses []*multiEntry, err error) {
+	fun := "Cache.getMultiFromCache -->"
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	client, err := redis.DefaultInstanceManager.GetInstance(ctx, m.getInstanceConf(ctx))
+	if err != nil {
+		slog.Errorf(ctx, "%s get instance err, namespace: %s", fun, m.namespace)
+		return nil, err
+	}
+
+	skeys := make([]string, len(entries))
+	for i, e := range entries {
+		skeys[i] = e.skey
+	}
+
+	pipe := client.Pipeline()
+	defer pipe.Close()
+	mgetCmd := pipe.MGet(ctx, skeys...)
+	var ttlCmds []func() (time.Duration, error)
+	if m.softTTLEnabled() {
+		for _, skey := range skeys {
+			ttlCmds = append(ttlCmds, pipe.TTL(ctx, skey).Result)
+		}
+	}
+	_, _ = pipe.Exec(ctx)
+
+	vals, err := mgetCmd.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, e := range entries {
+		var data []byte
+		switch v := vals[i].(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		}
+		if data == nil {
+			statTierMiss(m.namespace, command, tierRedis)
+			_metricMiss.With("namespace", m.namespace, "command", command).Inc()
+			misses = append(misses, e)
+			continue
+		}
+
+		e.data = data
+		statTierHit(m.namespace, command, tierRedis)
+		_metricHits.With("namespace", m.namespace, "command", command).Inc()
+		stale := false
+		if ttlCmds != nil {
+			if ttl, err := ttlCmds[i](); err == nil {
+				stale = m.isStale(data, ttl)
+			}
+		}
+		if stale {
+			m.refresh(ctx, e.key, e.skey)
+		} else if m.local != nil {
+			m.local.set(m.localKey(ctx, e.skey), data, 0)
+		}
+	}
+	return misses, nil
+}
+
+// loadMultiToCache 加载未命中的数据，使用 LoadMultiFunc 时通过一次 pipeline 写入缓存
+func (m *Cache) loadMultiToCache(ctx context.Context, entries []*multiEntry) error {
+	fun := "Cache.loadMultiToCache -->"
+	if m.opts.loadMulti == nil {
+		for _, e := range entries {
+			data, err := m.rebuild(ctx, e.key, e.skey)
+			if err != nil {
+				return err
+			}
+			e.data = data
+		}
+		return nil
+	}
+
+	keys := make([]interface{}, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	values, err := m.opts.loadMulti(ctx, keys)
+	if err != nil {
+		slog.Warnf(ctx, "%s load err, cache keys:%v err:%v", fun, keys, err)
+		return err
+	}
+
+	client, err := redis.DefaultInstanceManager.GetInstance(ctx, m.getInstanceConf(ctx))
+	if err != nil {
+		slog.Errorf(ctx, "%s get instance err, namespace: %s", fun, m.namespace)
+		return err
+	}
+
+	// NOTE: LoadMultiFunc 返回的 key 类型可能与传入的不同，如 int 和 int64，统一转换为字符串后查找
+	loaded := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		sk, err := m.keyToString(k)
+		if err != nil {
+			slog.Warnf(ctx, "%s invalid loaded key:%v err:%v", fun, k, err)
+			continue
+		}
+		loaded[sk] = v
+	}
+
+	expires := make([]time.Duration, len(entries))
+	pipe := client.Pipeline()
+	defer pipe.Close()
+	for i, e := range entries {
+		sk, _ := m.keyToString(e.key)
+		value, ok := loaded[sk]
+		var lerr error
+		if !ok {
+			lerr = ErrNotFound
+		}
+		e.data, expires[i] = m.encodeValue(ctx, e.key, value, lerr)
+		pipe.Set(ctx, e.skey, e.data, expires[i])
+	}
+
+	_, rerr := pipe.Exec(ctx)
+	if rerr != nil {
+		slog.Errorf(ctx, "%s set err, cache keys:%v rerr:%v", fun, keys, rerr)
+	} else if m.local != nil {
+		for i, e := range entries {
+			m.local.set(m.localKey(ctx, e.skey), e.data, expires[i])
+		}
+	}
+	return nil
+}
+
+// mapKey 将 key 转换为 map 的 key 类型
+func mapKey(mv reflect.Value, key interface{}) (reflect.Value, error) {
+	kt := mv.Type().Key()
+	kv := reflect.ValueOf(key)
+	if !kv.IsValid() {
+		return kv, fmt.Errorf("invalid key: %v", key)
+	}
+	if kv.Type().AssignableTo(kt) {
+		return kv, nil
+	}
+	// NOTE: 只转换底层类型相同的key，避免 int 被转换为 string
+	if kv.Kind() == kt.Kind() && kv.Type().ConvertibleTo(kt) {
+		return kv.Convert(kt), nil
+	}
+	return kv, fmt.Errorf("key type %T not match valuesMap key type %v", key, kt)
+}
+
+// setMapValue 创建 map 的 value 并通过 decode 填充后写入 map
+func setMapValue(mv reflect.Value, key interface{}, decode func(value interface{}) error) error {
+	kv, err := mapKey(mv, key)
+	if err != nil {
+		return err
+	}
+
+	et := mv.Type().Elem()
+	if et.Kind() == reflect.Ptr {
+		v := reflect.New(et.Elem())
+		if err := decode(v.Interface()); err != nil {
+			return err
+		}
+		mv.SetMapIndex(kv, v)
+		return nil
+	}
+
+	v := reflect.New(et)
+	if err := decode(v.Interface()); err != nil {
+		return err
+	}
+	mv.SetMapIndex(kv, v.Elem())
+	return nil
+}
@@ -0,0 +1,115 @@
+package value
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMulti(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+
+	var loaded []interface{}
+	c := NewCache("test/memory", "multi", 60*time.Second, load, WithLoadMulti(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		loaded = append(loaded, keys...)
+		values := make(map[interface{}]interface{})
+		for _, key := range keys {
+			if key.(int64) != 3 {
+				values[key] = &Test{Id: key.(int64)}
+			}
+		}
+		return values, nil
+	}))
+	defer c.Close()
+	keys := []interface{}{int64(1), int64(2), int64(3)}
+	for _, key := range keys {
+		c.Del(ctx, key)
+	}
+
+	values := make(map[int64]*Test)
+	err := c.GetMulti(ctx, keys, values)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]*Test{1: {Id: 1}, 2: {Id: 2}}, values)
+	assert.Equal(t, keys, loaded)
+
+	// 全部命中缓存，包括不存在的 key
+	values = make(map[int64]*Test)
+	err = c.GetMulti(ctx, keys, values)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(values))
+	assert.Equal(t, 3, len(loaded))
+}
+
+func TestGetMultiSharded(t *testing.T) {
+	ctx := context.Background()
+	// 实例会被 DefaultInstanceManager 缓存，内存 redis 不关闭
+	configer := redis.NewShardedMemoryConfiger(3)
+	old := redis.DefaultConfiger
+	redis.DefaultConfiger = configer
+	defer func() { redis.DefaultConfiger = old }()
+
+	var loaded []interface{}
+	c := NewCache("test/sharded", "test", 60*time.Second, load, WithLoadMulti(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		loaded = append(loaded, keys...)
+		values := make(map[interface{}]interface{})
+		for _, key := range keys {
+			values[key] = &Test{Id: key.(int64)}
+		}
+		return values, nil
+	}))
+	defer c.Close()
+
+	// key 分布在不同的分片上，pipeline 中的 MGET 和 SET 需要按照分片拆分
+	var keys []interface{}
+	want := make(map[int64]*Test)
+	for i := int64(1); i <= 20; i++ {
+		keys = append(keys, i)
+		want[i] = &Test{Id: i}
+	}
+	values := make(map[int64]*Test)
+	assert.NoError(t, c.GetMulti(ctx, keys, values))
+	assert.Equal(t, want, values)
+	assert.Equal(t, keys, loaded)
+
+	values = make(map[int64]*Test)
+	assert.NoError(t, c.GetMulti(ctx, keys, values))
+	assert.Equal(t, want, values)
+	assert.Equal(t, len(keys), len(loaded))
+}
+
+func TestGetMultiKeyType(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+
+	// LoadMultiFunc 返回的 key 类型与传入的不同时也能对应上
+	c := NewCache("test/memory", "keytype", 60*time.Second, load, WithLoadMulti(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		values := make(map[interface{}]interface{})
+		for _, key := range keys {
+			values[int(key.(int64))] = &Test{Id: key.(int64)}
+		}
+		return values, nil
+	}))
+	defer c.Close()
+
+	values := make(map[int64]*Test)
+	assert.NoError(t, c.GetMulti(ctx, []interface{}{int64(1), int64(2)}, values))
+	assert.Equal(t, map[int64]*Test{1: {Id: 1}, 2: {Id: 2}}, values)
+}
+
+func TestSetMapValue(t *testing.T) {
+	c := &Cache{}
+	values := make(map[int64]Test)
+	err := setMapValue(reflect.ValueOf(values), int64(1), func(value interface{}) error {
+		return c.unmarshal([]byte(`{"Id":1}`), value)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Test{Id: 1}, values[1])
+
+	_, err = mapKey(reflect.ValueOf(map[string]*Test{}), 65)
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package value
+
+import (
+	"time"
+
+	"github.com/shawnfeng/sutil/cache/codec"
+)
+
+// cache.value Cache options
+type cacheOptions struct {
+	// 本地缓存淘汰策略
+	localPolicy LocalPolicy
+	// 本地缓存最大条目数和最大字节数，小于等于0表示不限制
+	localMaxEntries int
+	localMaxBytes   int64
+	// 本地缓存过期时间，小于等于0表示不使用本地缓存
+	localTTL time.Duration
+
+	// 分布式重建锁的过期时间和未获取到锁时等待其他实例重建的最长时间，过期时间小于等于0表示不使用
+	rebuildLockTTL  time.Duration
+	rebuildLockWait time.Duration
+	// 数据写入超过 softTTL 后认为过期，返回旧数据并在后台刷新，小于等于0表示不使用
+	softTTL time.Duration
+	// LoadFunc 返回 ErrNotFound 时的缓存时间
+	notFoundExpire time.Duration
+	// GetMulti 使用的批量加载函数，为nil时逐个调用 LoadFunc
+	loadMulti LoadMultiFunc
+	// 写入缓存时使用的序列化方式，为nil时使用没有编码头的json，读取时根据编码头自动识别
+	codec *codec.Codec
+}
+
+type CacheOption interface {
+	apply(*cacheOptions)
+}
+
+type localCacheOption struct {
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+}
+
+func (c localCacheOption) apply(opts *cacheOptions) {
+	opts.localMaxEntries = c.maxEntries
+	opts.localMaxBytes = c.maxBytes
+	opts.localTTL = c.ttl
+}
+
+// WithLocalCache 开启本地缓存，maxEntries 和 maxBytes 为本地缓存的容量上限，ttl 为本地缓存的过期时间
+func WithLocalCache(maxEntries int, maxBytes int64, ttl time.Duration) CacheOption {
+	return localCacheOption{maxEntries: maxEntries, maxBytes: maxBytes, ttl: ttl}
+}
+
+type localPolicyOption LocalPolicy
+
+func (c localPolicyOption) apply(opts *cacheOptions) {
+	opts.localPolicy = LocalPolicy(c)
+}
+
+// WithLocalPolicy 本地缓存淘汰策略，默认为 LocalPolicyLRU
+func WithLocalPolicy(p LocalPolicy) CacheOption {
+	return localPolicyOption(p)
+}
+
+type rebuildLockOption struct {
+	ttl, wait time.Duration
+}
+
+func (c rebuildLockOption) apply(opts *cacheOptions) {
+	opts.rebuildLockTTL = c.ttl
+	opts.rebuildLockWait = c.wait
+}
+
+// WithRebuildLock 缓存未命中时通过 redis 锁保证只有一个实例调用 LoadFunc，
+// 其他实例最多等待 wait 时间，超时后自己调用 LoadFunc
+func WithRebuildLock(ttl, wait time.Duration) CacheOption {
+	return rebuildLockOption{ttl: ttl, wait: wait}
+}
+
+type softTTLOption time.Duration
+
+func (c softTTLOption) apply(opts *cacheOptions) {
+	opts.softTTL = time.Duration(c)
+}
+
+// WithSoftTTL 数据写入超过 d 后返回旧数据，同时在后台刷新，d 需要小于 Cache 的过期时间
+func WithSoftTTL(d time.Duration) CacheOption {
+	return softTTLOption(d)
+}
+
+type notFoundExpireOption time.Duration
+
+func (c notFoundExpireOption) apply(opts *cacheOptions) {
+	opts.notFoundExpire = time.Duration(c)
+}
+
+// WithNotFoundExpire LoadFunc 返回 ErrNotFound 时的缓存时间，默认为 constants.CacheDirtyExpireTime
+func WithNotFoundExpire(d time.Duration) CacheOption {
+	return notFoundExpireOption(d)
+}
+
+type loadMultiOption LoadMultiFunc
+
+func (c loadMultiOption) apply(opts *cacheOptions) {
+	opts.loadMulti = LoadMultiFunc(c)
+}
+
+// WithLoadMulti GetMulti 未命中的 key 通过 fn 批量加载
+func WithLoadMulti(fn LoadMultiFunc) CacheOption {
+	return loadMultiOption(fn)
+}
+
+type codecOption struct {
+	codec *codec.Codec
+}
+
+func (c codecOption) apply(opts *cacheOptions) {
+	opts.codec = c.codec
+}
+
+// WithCodec 写入缓存时使用的序列化方式
+func WithCodec(c *codec.Codec) CacheOption {
+	return codecOption{codec: c}
+}
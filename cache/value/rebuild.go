@@ -0,0 +1,111 @@
+package value
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/cache/redis"
+	"github.com/shawnfeng/sutil/scontext"
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+const (
+	rebuildLockSuffix   = ".rebuild"
+	rebuildPollInterval = 20 * time.Millisecond
+
+	rebuildUnlockScript = "if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('del', KEYS[1]) else return 0 end"
+)
+
+func (m *Cache) softTTLEnabled() bool {
+	return m.opts.softTTL > 0 && m.opts.softTTL < m.expire
+}
+
+// rebuild 同一个 key 在进程内只有一个调用方执行 LoadFunc，开启重建锁时集群内只有一个实例执行
+func (m *Cache) rebuild(ctx context.Context, key interface{}, skey string) ([]byte, error) {
+	return m.flight.do(ctx, m.localKey(ctx, skey), func() ([]byte, error) {
+		return m.rebuildWithLock(ctx, key, skey)
+	})
+}
+
+// refresh 在后台刷新过期的数据，同一个 key 同时只有一个刷新任务
+func (m *Cache) refresh(ctx context.Context, key interface{}, skey string) {
+	// NOTE: 刷新在请求返回后执行，不能使用请求的ctx，只保留路由分组和trace
+	bctx := context.WithValue(context.Background(), scontext.ContextKeyControl, ctx.Value(scontext.ContextKeyControl))
+	m.flight.doAsync(m.localKey(ctx, skey), func() ([]byte, error) {
+		command := "cache.value.Refresh"
+		var span opentracing.Span
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			span = opentracing.StartSpan(command, opentracing.FollowsFrom(parent.Context()))
+		} else {
+			span = opentracing.StartSpan(command)
+		}
+		defer span.Finish()
+		rctx := opentracing.ContextWithSpan(bctx, span)
+
+		data, err := m.rebuildWithLock(rctx, key, skey)
+		statReqErr(m.namespace, command, err)
+		if err == nil && m.local != nil {
+			m.publishInvalidate(rctx, m.localKey(rctx, skey))
+		}
+		return data, err
+	})
+}
+
+func (m *Cache) rebuildWithLock(ctx context.Context, key interface{}, skey string) ([]byte, error) {
+	fun := "Cache.rebuildWithLock -->"
+	if m.opts.rebuildLockTTL <= 0 {
+		return m.loadValueToCache(ctx, key)
+	}
+
+	client, err := redis.DefaultInstanceManager.GetInstance(ctx, m.getInstanceConf(ctx))
+	if err != nil {
+		slog.Errorf(ctx, "%s get instance err, namespace: %s", fun, m.namespace)
+		return nil, err
+	}
+
+	lockKey := skey + rebuildLockSuffix
+	token := uuid.New().String()
+	ok, err := client.SetNX(ctx, lockKey, token, m.opts.rebuildLockTTL).Result()
+	if err != nil {
+		// NOTE: 锁不可用时降级为直接加载
+		slog.Warnf(ctx, "%s lock key: %s err: %v", fun, lockKey, err)
+		return m.loadValueToCache(ctx, key)
+	}
+	if ok {
+		defer func() {
+			if err := client.Eval(ctx, rebuildUnlockScript, []string{lockKey}, token).Err(); err != nil {
+				slog.Warnf(ctx, "%s unlock key: %s err: %v", fun, lockKey, err)
+			}
+		}()
+		return m.loadValueToCache(ctx, key)
+	}
+
+	// 等待持有锁的实例重建完成
+	timer := time.NewTimer(m.opts.rebuildLockWait)
+	defer timer.Stop()
+	ticker := time.NewTicker(rebuildPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			slog.Warnf(ctx, "%s wait rebuild timeout, key: %s", fun, skey)
+			return m.loadValueToCache(ctx, key)
+		case <-ticker.C:
+		}
+
+		data, err := client.Get(ctx, skey).Bytes()
+		if err == nil {
+			if m.local != nil {
+				m.local.set(m.localKey(ctx, skey), data, 0)
+			}
+			return data, nil
+		}
+		if err.Error() != redis.RedisNil {
+			return nil, err
+		}
+	}
+}
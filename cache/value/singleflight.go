@@ -0,0 +1,96 @@
+package value
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+var errFlightPanic = errors.New("cache.value: load panic")
+
+type flightCall struct {
+	// done 在 fn 执行完成后关闭
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// flightGroup 同一个 key 同时只有一个调用方执行，其他调用方等待并共享结果
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]*flightCall
+}
+
+func (g *flightGroup) start(key string) (c *flightCall, started bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.m == nil {
+		g.m = make(map[string]*flightCall)
+	}
+	if c, ok := g.m[key]; ok {
+		return c, false
+	}
+	c = &flightCall{done: make(chan struct{})}
+	g.m[key] = c
+	return c, true
+}
+
+func (g *flightGroup) call(key string, c *flightCall, fn func() ([]byte, error)) {
+	// NOTE: fn panic 时等待的调用方得到 errFlightPanic
+	c.err = errFlightPanic
+	defer func() {
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.data, c.err = fn()
+}
+
+// do 执行 fn，key 已经有调用方在执行时等待其结果，等待期间 ctx 结束时返回 ctx 的错误。
+// 执行方因为自己的 ctx 结束而失败时，等待方重新执行 fn，而不是共享这个错误
+func (g *flightGroup) do(ctx context.Context, key string, fn func() ([]byte, error)) ([]byte, error) {
+	for {
+		c, started := g.start(key)
+		if started {
+			g.call(key, c, fn)
+			return c.data, c.err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+		}
+		if isContextErr(c.err) && ctx.Err() == nil {
+			continue
+		}
+		return c.data, c.err
+	}
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// doAsync 在后台执行 fn，key 已经有调用方在执行时直接返回 false
+func (g *flightGroup) doAsync(key string, fn func() ([]byte, error)) bool {
+	c, started := g.start(key)
+	if !started {
+		return false
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				buf := make([]byte, 4096)
+				buf = buf[:runtime.Stack(buf, false)]
+				slog.Errorf(context.TODO(), "flightGroup.doAsync --> key:%s recover err: %v, stack: %s", key, err, string(buf))
+			}
+		}()
+		g.call(key, c, fn)
+	}()
+	return true
+}
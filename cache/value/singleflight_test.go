@@ -0,0 +1,91 @@
+package value
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := g.do(context.Background(), "key", func() ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return []byte("value"), nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("value"), data)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestFlightGroupAsync(t *testing.T) {
+	var g flightGroup
+	block := make(chan struct{})
+	assert.True(t, g.doAsync("key", func() ([]byte, error) {
+		<-block
+		return []byte("value"), nil
+	}))
+	assert.False(t, g.doAsync("key", func() ([]byte, error) {
+		return nil, nil
+	}))
+	close(block)
+
+	data, err := g.do(context.Background(), "key", func() ([]byte, error) {
+		return []byte("value"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), data)
+}
+
+func TestFlightGroupContext(t *testing.T) {
+	var g flightGroup
+	block := make(chan struct{})
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		_, err := g.do(leaderCtx, "key", func() ([]byte, error) {
+			select {
+			case <-leaderCtx.Done():
+				return nil, leaderCtx.Err()
+			case <-block:
+				return []byte("leader"), nil
+			}
+		})
+		leaderDone <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// 等待方的 ctx 结束时不再等待执行方
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := g.do(ctx, "key", func() ([]byte, error) {
+		return []byte("waiter"), nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 执行方的 ctx 被取消时，等待方重新执行而不是得到 context.Canceled
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancelLeader()
+	}()
+	data, err := g.do(context.Background(), "key", func() ([]byte, error) {
+		return []byte("waiter"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("waiter"), data)
+	assert.Equal(t, context.Canceled, <-leaderDone)
+	close(block)
+}
@@ -9,37 +9,89 @@
 package value
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/shawnfeng/sutil/cache"
+	"github.com/shawnfeng/sutil/cache/codec"
 	"github.com/shawnfeng/sutil/cache/constants"
 	"github.com/shawnfeng/sutil/cache/redis"
 	"github.com/shawnfeng/sutil/scontext"
 	"github.com/shawnfeng/sutil/slog/slog"
 	"github.com/shawnfeng/sutil/stime"
+	"sync"
 	"time"
 )
 
+const (
+	tierLocal = "local"
+	tierRedis = "redis"
+)
+
+// ErrNotFound LoadFunc 返回 ErrNotFound 时表示数据不存在，不存在的结果会被缓存，
+// 缓存时间通过 WithNotFoundExpire 设置，Get 命中时返回 ErrNotFound
+var ErrNotFound = errors.New("cache.value: not found")
+
+// notFoundData 数据不存在时缓存的内容，不是合法的json
+var notFoundData = []byte("\x00notfound")
+
+// dirtyData 加载失败时缓存的占位数据，过期时间为 constants.CacheDirtyExpireTime
+var dirtyData = []byte(`{}`)
+
 // key类型只支持int（包含有无符号，8，16，32，64位）和string
 type LoadFunc func(ctx context.Context, key interface{}) (value interface{}, err error)
 
+// LoadMultiFunc 批量加载，values 中不存在的 key 按照 ErrNotFound 处理
+type LoadMultiFunc func(ctx context.Context, keys []interface{}) (values map[interface{}]interface{}, err error)
+
 type Cache struct {
 	namespace string
 	prefix    string
 	load      LoadFunc
 	expire    time.Duration
+	opts      *cacheOptions
+
+	// 本地缓存，未开启时为nil
+	local     *localCache
+	flight    flightGroup
+	id        string
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func NewCache(namespace, prefix string, expire time.Duration, load LoadFunc) *Cache {
-	return &Cache{
+func NewCache(namespace, prefix string, expire time.Duration, load LoadFunc, opts ...CacheOption) *Cache {
+	opt := &cacheOptions{
+		notFoundExpire: constants.CacheDirtyExpireTime,
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+
+	m := &Cache{
 		namespace: namespace,
 		prefix:    prefix,
 		load:      load,
 		expire:    expire,
+		opts:      opt,
+		id:        uuid.New().String(),
+		done:      make(chan struct{}),
+	}
+	if opt.localTTL > 0 {
+		m.local = newLocalCache(opt.localPolicy, opt.localMaxEntries, opt.localMaxBytes, opt.localTTL)
+		go m.watchInvalidate()
 	}
+	return m
+}
+
+// Close 停止接收本地缓存失效消息
+func (m *Cache) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
 }
 
 func (m *Cache) getInstanceConf(ctx context.Context) *redis.InstanceConf {
@@ -61,10 +113,31 @@ func (m *Cache) Get(ctx context.Context, key, value interface{}) error {
 		statReqDuration(m.namespace, command, st.Millisecond())
 	}()
 
-	err := m.getValueFromCache(ctx, key, value)
+	skey, err := m.prefixKey(key)
+	if err != nil {
+		statReqErr(m.namespace, command, err)
+		return err
+	}
+
+	if m.local != nil {
+		if data, ok := m.local.get(m.localKey(ctx, skey)); ok {
+			statTierHit(m.namespace, command, tierLocal)
+			_metricHits.With("namespace", m.namespace, "command", command).Inc()
+			return m.unmarshal(data, value)
+		}
+		statTierMiss(m.namespace, command, tierLocal)
+	}
+
+	data, stale, err := m.getDataFromCache(ctx, skey)
 	if err == nil {
+		statTierHit(m.namespace, command, tierRedis)
 		_metricHits.With("namespace", m.namespace, "command", command).Inc()
-		return nil
+		if stale {
+			m.refresh(ctx, key, skey)
+		} else if m.local != nil {
+			m.local.set(m.localKey(ctx, skey), data, 0)
+		}
+		return m.unmarshal(data, value)
 	}
 
 	if err.Error() != redis.RedisNil {
@@ -72,22 +145,22 @@ func (m *Cache) Get(ctx context.Context, key, value interface{}) error {
 		slog.Errorf(ctx, "%s cache key: %v err: %v", fun, key, err)
 		return fmt.Errorf("%s cache key: %v err: %v", fun, key, err)
 	}
+	statTierMiss(m.namespace, command, tierRedis)
 	_metricMiss.With("namespace", m.namespace, "command", command).Inc()
 
-	data, err := m.loadValueToCache(ctx, key)
+	data, err = m.rebuild(ctx, key, skey)
 	if err != nil {
 		statReqErr(m.namespace, command, err)
 		slog.Errorf(ctx, "%s loadValueToCache key: %v err: %v", fun, key, err)
 		return err
 	}
 
-	err = json.Unmarshal(data, value)
-	if err != nil {
+	err = m.unmarshal(data, value)
+	if err != nil && err != ErrNotFound {
 		statReqErr(m.namespace, command, err)
-		return errors.New(string(data))
 	}
 
-	return nil
+	return err
 }
 
 func (m *Cache) Del(ctx context.Context, key interface{}) error {
@@ -121,6 +194,12 @@ func (m *Cache) Del(ctx context.Context, key interface{}) error {
 		return fmt.Errorf("del cache key: %v err: %s", key, err.Error())
 	}
 
+	if m.local != nil {
+		lkey := m.localKey(ctx, skey)
+		m.local.del(lkey)
+		m.publishInvalidate(ctx, lkey)
+	}
+
 	return nil
 }
 
@@ -135,6 +214,11 @@ func (m *Cache) Load(ctx context.Context, key interface{}) error {
 
 	_, err := m.loadValueToCache(ctx, key)
 	statReqErr(m.namespace, command, err)
+	if err == nil && m.local != nil {
+		// loadValueToCache 已经更新了本地缓存，只需要通知其他实例
+		skey, _ := m.prefixKey(key)
+		m.publishInvalidate(ctx, m.localKey(ctx, skey))
+	}
 
 	return err
 }
@@ -182,53 +266,107 @@ func (m *Cache) prefixKey(key interface{}) (string, error) {
 	return skey, nil
 }
 
-func (m *Cache) getValueFromCache(ctx context.Context, key, value interface{}) error {
-	fun := "Cache.getValueFromCache -->"
+// localKey 不同分组的数据在不同的 redis 实例中，本地缓存的 key 需要带上分组
+func (m *Cache) localKey(ctx context.Context, skey string) string {
+	return scontext.GetControlRouteGroupWithDefault(ctx, constants.DefaultRouteGroup) + "/" + skey
+}
 
-	skey, err := m.prefixKey(key)
+func (m *Cache) unmarshal(data []byte, value interface{}) error {
+	if isNotFoundData(data) {
+		return ErrNotFound
+	}
+	var err error
+	if codec.HasHeader(data) {
+		err = codec.Unmarshal(data, value)
+	} else {
+		// NOTE: 没有编码头的数据为 json 格式
+		err = json.Unmarshal(data, value)
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("unmarshal cache data err: %v", err)
 	}
+	return nil
+}
+
+func isNotFoundData(data []byte) bool {
+	return bytes.Equal(data, notFoundData)
+}
+
+// isStale 根据剩余的过期时间判断数据写入的时间是否超过了 softTTL
+func (m *Cache) isStale(data []byte, ttl time.Duration) bool {
+	// NOTE: 不存在的结果和加载失败的占位数据有单独的过期时间，不需要刷新
+	if isNotFoundData(data) || bytes.Equal(data, dirtyData) {
+		return false
+	}
+	return ttl < m.expire-m.opts.softTTL
+}
+
+// getDataFromCache 开启 softTTL 时同时返回数据是否已经过期
+func (m *Cache) getDataFromCache(ctx context.Context, skey string) (data []byte, stale bool, err error) {
+	fun := "Cache.getDataFromCache -->"
 
 	client, err := redis.DefaultInstanceManager.GetInstance(ctx, m.getInstanceConf(ctx))
 	if err != nil {
 		slog.Errorf(ctx, "%s get instance err, namespace: %s", fun, m.namespace)
-		return err
+		return nil, false, err
 	}
 
-	data, err := client.Get(ctx, skey).Bytes()
-	if err != nil {
-		return err
+	if !m.softTTLEnabled() {
+		data, err = client.Get(ctx, skey).Bytes()
+		return data, false, err
 	}
 
-	//slog.Infof(ctx, "%s key: %v data: %s", fun, key, string(data))
-
-	err = json.Unmarshal(data, value)
+	// NOTE: 通过剩余的过期时间判断数据写入的时间是否超过了 softTTL
+	pipe := client.Pipeline()
+	defer pipe.Close()
+	getCmd := pipe.Get(ctx, skey)
+	ttlCmd := pipe.TTL(ctx, skey)
+	_, _ = pipe.Exec(ctx)
+	data, err = getCmd.Bytes()
 	if err != nil {
-		return errors.New(string(data))
+		return nil, false, err
 	}
-
-	return nil
+	ttl, err := ttlCmd.Result()
+	if err != nil {
+		slog.Warnf(ctx, "%s ttl key: %s err: %v", fun, skey, err)
+		return data, false, nil
+	}
+	return data, m.isStale(data, ttl), nil
 }
 
-func (m *Cache) loadValueToCache(ctx context.Context, key interface{}) (data []byte, err error) {
-	fun := "Cache.loadValueToCache -->"
-	expire := m.expire
+// encodeValue 将加载的结果序列化为缓存的数据，并返回对应的过期时间
+func (m *Cache) encodeValue(ctx context.Context, key, value interface{}, err error) (data []byte, expire time.Duration) {
+	fun := "Cache.encodeValue -->"
+	expire = m.expire
 
-	value, err := m.load(ctx, key)
-	if err != nil {
+	if err == ErrNotFound {
+		data = notFoundData
+		expire = m.opts.notFoundExpire
+	} else if err != nil {
 		slog.Warnf(ctx, "%s load err, cache key:%v err:%v", fun, key, err)
-		data = []byte(`{}`)
+		data = dirtyData
 		expire = constants.CacheDirtyExpireTime
 
 	} else {
-		data, err = json.Marshal(value)
+		if m.opts.codec != nil {
+			data, err = m.opts.codec.Marshal(value)
+		} else {
+			data, err = json.Marshal(value)
+		}
 		if err != nil {
 			slog.Errorf(ctx, "%s marshal err, cache key:%v err:%v", fun, key, err)
-			data = []byte(`{}`)
+			data = dirtyData
 			expire = constants.CacheDirtyExpireTime
 		}
 	}
+	return
+}
+
+func (m *Cache) loadValueToCache(ctx context.Context, key interface{}) (data []byte, err error) {
+	fun := "Cache.loadValueToCache -->"
+
+	value, err := m.load(ctx, key)
+	data, expire := m.encodeValue(ctx, key, value, err)
 
 	skey, err := m.prefixKey(key)
 	if err != nil {
@@ -245,6 +383,8 @@ func (m *Cache) loadValueToCache(ctx context.Context, key interface{}) (data []b
 	rerr := client.Set(ctx, skey, data, expire).Err()
 	if rerr != nil {
 		slog.Errorf(ctx, "%s set err, cache key:%v rerr:%v", fun, key, rerr)
+	} else if m.local != nil {
+		m.local.set(m.localKey(ctx, skey), data, expire)
 	}
 
 	if err != nil {
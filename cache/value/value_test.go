@@ -2,6 +2,9 @@ package value
 
 import (
 	"context"
+	"github.com/shawnfeng/sutil/cache/codec"
+	"github.com/shawnfeng/sutil/cache/constants"
+	"github.com/shawnfeng/sutil/cache/redis"
 	"github.com/shawnfeng/sutil/trace"
 
 	//"fmt"
@@ -22,6 +25,26 @@ func load(ctx context.Context, key interface{}) (value interface{}, err error) {
 	}, nil
 }
 
+var memoryConfiger = redis.NewMemoryConfiger()
+
+func init() {
+	// 内存 redis 不能执行 lua，测试中注册 rebuildUnlockScript 的实现
+	redis.RegisterMemoryScript(rebuildUnlockScript, func(call func(args ...interface{}) (interface{}, error), keys, argv []string) (interface{}, error) {
+		v, err := call("GET", keys[0])
+		if err != nil || v != argv[0] {
+			return int64(0), err
+		}
+		return call("DEL", keys[0])
+	})
+}
+
+// useMemoryRedis 测试期间使用内存 redis，实例会被 DefaultInstanceManager 缓存，因此内存 redis 不关闭
+func useMemoryRedis() func() {
+	old := redis.DefaultConfiger
+	redis.DefaultConfiger = memoryConfiger
+	return func() { redis.DefaultConfiger = old }
+}
+
 func TestGet(t *testing.T) {
 	ctx := context.Background()
 	_ = trace.InitDefaultTracer("cache.test")
@@ -46,3 +69,97 @@ func TestGet(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 }
+
+func TestGetNotFound(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+
+	var loads int
+	c := NewCache("test/memory", "notfound", 60*time.Second, func(ctx context.Context, key interface{}) (value interface{}, err error) {
+		loads++
+		return nil, ErrNotFound
+	}, WithNotFoundExpire(time.Second))
+	defer c.Close()
+	c.Del(ctx, 8)
+
+	var test Test
+	err := c.Get(ctx, 8, &test)
+	if err != ErrNotFound {
+		t.Errorf("get err: %v, want ErrNotFound", err)
+	}
+	// 不存在的结果被缓存
+	err = c.Get(ctx, 8, &test)
+	if err != ErrNotFound {
+		t.Errorf("get err: %v, want ErrNotFound", err)
+	}
+	if loads != 1 {
+		t.Errorf("loads: %d, want 1", loads)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	c := &Cache{}
+	var test Test
+	if err := c.unmarshal(notFoundData, &test); err != ErrNotFound {
+		t.Errorf("unmarshal err: %v, want ErrNotFound", err)
+	}
+	if err := c.unmarshal([]byte("invalid"), &test); err == nil || err.Error() == "invalid" {
+		t.Errorf("unmarshal err: %v", err)
+	}
+	if err := c.unmarshal([]byte(`{"Id":2}`), &test); err != nil || test.Id != 2 {
+		t.Errorf("unmarshal err: %v test: %v", err, test)
+	}
+}
+
+func TestUnmarshalCodec(t *testing.T) {
+	c := &Cache{}
+	data, err := codec.Snappy(codec.JSON).Marshal(&Test{Id: 3})
+	if err != nil {
+		t.Fatalf("marshal err: %v", err)
+	}
+	var test Test
+	if err := c.unmarshal(data, &test); err != nil || test.Id != 3 {
+		t.Errorf("unmarshal err: %v test: %v", err, test)
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	c := &Cache{expire: time.Minute, opts: &cacheOptions{softTTL: 10 * time.Second}}
+	if c.isStale([]byte(`{"Id":1}`), 55*time.Second) {
+		t.Errorf("fresh data is stale")
+	}
+	if !c.isStale([]byte(`{"Id":1}`), 40*time.Second) {
+		t.Errorf("stale data is not stale")
+	}
+	// 不存在的结果和加载失败的占位数据的过期时间本来就很短，不需要刷新
+	if c.isStale(notFoundData, time.Second) {
+		t.Errorf("not found data is stale")
+	}
+	if c.isStale(dirtyData, constants.CacheDirtyExpireTime) {
+		t.Errorf("dirty data is stale")
+	}
+}
+
+func TestGetMemory(t *testing.T) {
+	ctx := context.Background()
+	defer useMemoryRedis()()
+
+	loads := 0
+	c := NewCache("test/memory", "test", 60*time.Second, func(ctx context.Context, key interface{}) (value interface{}, err error) {
+		loads++
+		return &Test{Id: key.(int64)}, nil
+	}, WithRebuildLock(time.Second, time.Second))
+	defer c.Close()
+
+	var test Test
+	if err := c.Get(ctx, int64(3), &test); err != nil || test.Id != 3 {
+		t.Errorf("get test:%v err: %v", test, err)
+	}
+	if err := c.Get(ctx, int64(3), &test); err != nil || loads != 1 {
+		t.Errorf("get loads:%d err: %v", loads, err)
+	}
+	c.Del(ctx, int64(3))
+	if err := c.Get(ctx, int64(3), &test); err != nil || loads != 2 {
+		t.Errorf("get loads:%d err: %v", loads, err)
+	}
+}
@@ -3,13 +3,20 @@ package dbrouter
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
 	"github.com/shawnfeng/sutil/sconf/center"
 	"github.com/shawnfeng/sutil/slog/slog"
+	"gopkg.in/mgo.v2"
 )
 
 var (
@@ -17,97 +24,444 @@ var (
 )
 
 const (
-	globalGranularityKey = "global.granularity" // 精度
-	globalThresholdKey   = "global.threshold"   // 精度内阈值
-	globalBreakerGapKey  = "global.breakergap"  // 触发熔断后的熔断间隔,单位: 秒
+	// 熔断配置的 key 为 <scope>.breaker.<field>，scope 依次为 <cluster>.<table>、<cluster>、global，使用最先找到的配置
+	globalScope             = "global"
+	breakerKeyPart          = ".breaker."
+	breakerWindowField      = "window"      // 统计窗口，如 10s
+	breakerBucketsField     = "buckets"     // 统计窗口内的桶数
+	breakerMinRequestsField = "minrequests" // 统计窗口内的请求数不小于该值时才计算错误率
+	breakerErrorRateField   = "errorrate"   // 错误率阈值，(0, 1]
+	breakerSlowCallField    = "slowcall"    // 慢请求的耗时，如 1s，为0时不统计慢请求
+	breakerSlowRateField    = "slowrate"    // 慢请求比例阈值，(0, 1]，为0时不因为慢请求熔断
+	breakerOpenTimeField    = "opentime"    // 熔断后经过该时间进入半开状态，如 10s
+	breakerHalfOpenField    = "halfopen"    // 半开状态放行的试探请求数，全部成功后恢复
 
-	checkTick         = time.Millisecond * 25
-	defaultThreshold  = 10
-	defaultBreakerGap = 10
+	// Deprecated: 兼容旧配置，没有配置 global.breaker.opentime 时使用，单位: 秒
+	globalBreakerGapKey = "global.breakergap"
 )
 
-// TOOD 简单计数法实现熔断操作，后续改为滑动窗口或三方组件的方式
-type BreakerManager struct {
-	lock     sync.Mutex
-	Breakers map[string]*Breaker
+// BreakerState 熔断器状态
+type BreakerState int32
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig 熔断器配置
+type BreakerConfig struct {
+	Window             time.Duration
+	Buckets            int
+	MinRequests        int
+	ErrorRateThreshold float64
+	SlowCallDuration   time.Duration
+	SlowRateThreshold  float64
+	OpenDuration       time.Duration
+	HalfOpenRequests   int
+}
+
+var defaultBreakerConfig = BreakerConfig{
+	Window:             10 * time.Second,
+	Buckets:            10,
+	MinRequests:        20,
+	ErrorRateThreshold: 0.5,
+	SlowCallDuration:   3 * time.Second,
+	SlowRateThreshold:  0.8,
+	OpenDuration:       10 * time.Second,
+	HalfOpenRequests:   5,
+}
+
+func (c BreakerConfig) bucketDuration() time.Duration {
+	d := c.Window / time.Duration(c.Buckets)
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// BreakerStateChangeFunc 熔断器状态变化的回调
+type BreakerStateChangeFunc func(cluster, table string, from, to BreakerState)
+
+type breakerBucket struct {
+	// 桶对应的时间段编号，用于判断桶是否过期
+	id       int64
+	total    int
+	failures int
+	slows    int
 }
 
+// Breaker 滑动窗口熔断器，按照统计窗口内的错误率和慢请求比例熔断，熔断一段时间后放行少量试探请求
 type Breaker struct {
-	Rejected      int32
-	RejectedStart int64
-	Count         int32
+	cluster string
+	table   string
+
+	mu      sync.Mutex
+	config  BreakerConfig
+	state   BreakerState
+	buckets []breakerBucket
+	// 进入当前状态的时间
+	stateAt time.Time
+	// 半开状态已经放行和已经成功的试探请求数
+	trials    int
+	successes int
+	// 每次状态变化或者重新放行试探请求时加1，半开状态只统计当前这一轮放行的试探请求
+	gen int64
+
+	now           func() time.Time
+	onStateChange BreakerStateChangeFunc
 }
 
-var bm *BreakerManager
+func newBreaker(cluster, table string, config BreakerConfig, onStateChange BreakerStateChangeFunc) *Breaker {
+	b := &Breaker{
+		cluster:       cluster,
+		table:         table,
+		now:           time.Now,
+		onStateChange: onStateChange,
+	}
+	b.config = config
+	b.buckets = make([]breakerBucket, config.Buckets)
+	b.stateAt = b.now()
+	return b
+}
 
-func statBreaker(cluster, table string, err error) {
-	if err != nil && (strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "invalid connection")) {
-		key := concat(cluster, "_", table)
-		bm.lock.Lock()
-		if _, ok := bm.Breakers[key]; !ok {
-			breaker := new(Breaker)
-			breaker.Run()
-			bm.Breakers[key] = breaker
+// State 返回熔断器当前的状态
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow 判断是否放行请求，放行的请求需要把 token 传给 Record 记录结果
+func (b *Breaker) Allow() (token int64, allowed bool) {
+	b.mu.Lock()
+	now := b.now()
+	from := b.state
+	allowed = true
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.stateAt) < b.config.OpenDuration {
+			allowed = false
+			break
+		}
+		b.setState(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		// NOTE: 试探请求可能没有记录结果，超过 OpenDuration 仍然没有结束时重新放行试探请求
+		if b.trials >= b.config.HalfOpenRequests && now.Sub(b.stateAt) >= b.config.OpenDuration {
+			b.trials, b.successes, b.stateAt = 0, 0, now
+			b.gen++
+		}
+		if b.trials < b.config.HalfOpenRequests {
+			b.trials++
+		} else {
+			allowed = false
 		}
-		breaker := bm.Breakers[key]
-		bm.lock.Unlock()
-		atomic.AddInt32(&breaker.Count, 1)
 	}
+	token = b.gen
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return token, allowed
 }
 
-func Entry(cluster, table string) bool {
+// Record 记录请求的结果和耗时，token 为放行请求时 Allow 的返回值
+func (b *Breaker) Record(token int64, err error, dur time.Duration) {
+	failure := isBreakerFailure(err)
+	b.mu.Lock()
+	now := b.now()
+	from := b.state
+	slow := b.config.SlowCallDuration > 0 && dur >= b.config.SlowCallDuration
+	switch b.state {
+	case BreakerHalfOpen:
+		// NOTE: 熔断前放行的请求和上一轮的试探请求不计入
+		if token != b.gen {
+			break
+		}
+		if failure || slow {
+			b.setState(BreakerOpen, now)
+		} else if b.successes++; b.successes >= b.config.HalfOpenRequests {
+			b.setState(BreakerClosed, now)
+		}
+	case BreakerClosed:
+		bucket := b.bucket(now)
+		bucket.total++
+		if failure {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slows++
+		}
+		if b.shouldOpen(now) {
+			b.setState(BreakerOpen, now)
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+func (b *Breaker) bucket(now time.Time) *breakerBucket {
+	id := now.UnixNano() / int64(b.config.bucketDuration())
+	bucket := &b.buckets[id%int64(len(b.buckets))]
+	if bucket.id != id {
+		*bucket = breakerBucket{id: id}
+	}
+	return bucket
+}
+
+func (b *Breaker) shouldOpen(now time.Time) bool {
+	id := now.UnixNano() / int64(b.config.bucketDuration())
+	var total, failures, slows int
+	for _, bucket := range b.buckets {
+		if id-bucket.id < int64(len(b.buckets)) {
+			total += bucket.total
+			failures += bucket.failures
+			slows += bucket.slows
+		}
+	}
+	if total == 0 || total < b.config.MinRequests {
+		return false
+	}
+	if b.config.ErrorRateThreshold > 0 && float64(failures)/float64(total) >= b.config.ErrorRateThreshold {
+		return true
+	}
+	return b.config.SlowRateThreshold > 0 && float64(slows)/float64(total) >= b.config.SlowRateThreshold
+}
+
+// setState 调用时需要持有锁
+func (b *Breaker) setState(state BreakerState, now time.Time) {
+	b.state = state
+	b.stateAt = now
+	b.trials, b.successes = 0, 0
+	b.gen++
+	if state == BreakerClosed {
+		b.buckets = make([]breakerBucket, b.config.Buckets)
+	}
+}
+
+func (b *Breaker) notify(from, to BreakerState) {
+	if from == to {
+		return
+	}
+	slog.Warnf(context.TODO(), "dbrouter: breaker cluster:%s table:%s state changed from %s to %s", b.cluster, b.table, from, to)
+	statBreakerState(b.cluster, b.table, to)
+	if b.onStateChange != nil {
+		b.onStateChange(b.cluster, b.table, from, to)
+	}
+}
+
+// setConfig 更新配置，统计窗口变化时重置统计
+func (b *Breaker) setConfig(config BreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if config.Window != b.config.Window || config.Buckets != b.config.Buckets {
+		b.buckets = make([]breakerBucket, config.Buckets)
+	}
+	b.config = config
+}
+
+// isBreakerFailure 只有超时和连接错误计入熔断的错误率，记录不存在等业务错误不计入
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, mgo.ErrNotFound) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// 1205: Lock wait timeout exceeded, 3024: Query execution was interrupted, maximum statement execution time exceeded
+		return mysqlErr.Number == 1205 || mysqlErr.Number == 3024
+	}
+	return false
+}
+
+// BreakerManager 按照 cluster 和 table 管理熔断器
+type BreakerManager struct {
+	lock          sync.Mutex
+	Breakers      map[string]*Breaker
+	onStateChange BreakerStateChangeFunc
+}
+
+var bm *BreakerManager
+
+func (m *BreakerManager) get(cluster, table string) *Breaker {
 	key := concat(cluster, "_", table)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	breaker, ok := m.Breakers[key]
+	if !ok {
+		breaker = newBreaker(cluster, table, loadBreakerConfig(context.TODO(), cluster, table), m.stateChanged)
+		m.Breakers[key] = breaker
+	}
+	return breaker
+}
+
+func (m *BreakerManager) stateChanged(cluster, table string, from, to BreakerState) {
+	m.lock.Lock()
+	fn := m.onStateChange
+	m.lock.Unlock()
+	if fn != nil {
+		fn(cluster, table, from, to)
+	}
+}
+
+// reload 重新加载所有熔断器的配置
+func (m *BreakerManager) reload(ctx context.Context) {
+	m.lock.Lock()
+	breakers := make([]*Breaker, 0, len(m.Breakers))
+	for _, b := range m.Breakers {
+		breakers = append(breakers, b)
+	}
+	m.lock.Unlock()
+	for _, b := range breakers {
+		b.setConfig(loadBreakerConfig(ctx, b.cluster, b.table))
+	}
+}
+
+// HandleChangeEvent 熔断配置变化时重新加载
+func (m *BreakerManager) HandleChangeEvent(event *center.ChangeEvent) {
+	fun := "BreakerManager.HandleChangeEvent -->"
+	if event.Namespace != center.DefaultApolloMysqlNamespace {
+		return
+	}
+	for key := range event.Changes {
+		if strings.Contains(key, breakerKeyPart) || key == globalBreakerGapKey {
+			slog.Infof(context.TODO(), "%s breaker config changed, key: %s", fun, key)
+			m.reload(context.TODO())
+			return
+		}
+	}
+}
+
+// SetBreakerStateChangeCallback 设置熔断器状态变化的回调
+func SetBreakerStateChangeCallback(fn BreakerStateChangeFunc) {
 	bm.lock.Lock()
-	breaker := bm.Breakers[key]
-	bm.lock.Unlock()
-	if breaker != nil {
-		return atomic.LoadInt32(&breaker.Rejected) != 1
+	defer bm.lock.Unlock()
+	bm.onStateChange = fn
+}
+
+// GetBreakerState 返回 cluster 和 table 对应熔断器的状态
+func GetBreakerState(cluster, table string) BreakerState {
+	return bm.get(cluster, table).State()
+}
+
+func statBreaker(cluster, table string, token int64, err error, dur time.Duration) {
+	bm.get(cluster, table).Record(token, err, dur)
+}
+
+// Entry 判断熔断器是否放行请求，放行后所有返回路径都需要通过 statBreaker 记录结果，否则半开状态的试探名额不会释放
+func Entry(cluster, table string) (token int64, ok bool) {
+	if token, ok = bm.get(cluster, table).Allow(); ok {
+		return
 	}
-	return true
+	statBreakerReject(cluster, table)
+	return
 }
 
-func (breaker *Breaker) Run() {
-	go func() {
-		granularityStr, exist := configCenter.GetStringWithNamespace(context.TODO(), center.DefaultApolloMysqlNamespace, globalGranularityKey)
-		if !exist {
-			slog.Warnf(context.TODO(), "dbrouter: get granularity from apollo failed, exist: %v", exist)
-			granularityStr = "1s"
+func getBreakerString(ctx context.Context, scopes []string, field string) (string, bool) {
+	if configCenter == nil {
+		return "", false
+	}
+	for _, scope := range scopes {
+		if v, ok := configCenter.GetStringWithNamespace(ctx, center.DefaultApolloMysqlNamespace, concat(scope, breakerKeyPart, field)); ok {
+			return v, true
 		}
-		granularity, err := time.ParseDuration(granularityStr)
-		if err != nil {
-			slog.Warnf(context.TODO(), "dbrouter: granularity in apollo is invalid, %s", granularityStr)
-			granularity = time.Second * 1
+	}
+	return "", false
+}
+
+// loadBreakerConfig 从 apollo 加载 cluster 和 table 的熔断配置，没有配置或者配置错误时使用默认值
+func loadBreakerConfig(ctx context.Context, cluster, table string) BreakerConfig {
+	fun := "loadBreakerConfig -->"
+	scopes := []string{concat(cluster, ".", table), cluster, globalScope}
+	config := defaultBreakerConfig
+
+	durations := map[string]*time.Duration{
+		breakerWindowField:   &config.Window,
+		breakerSlowCallField: &config.SlowCallDuration,
+		breakerOpenTimeField: &config.OpenDuration,
+	}
+	if configCenter != nil {
+		if gap, ok := configCenter.GetIntWithNamespace(ctx, center.DefaultApolloMysqlNamespace, globalBreakerGapKey); ok {
+			config.OpenDuration = time.Duration(gap) * time.Second
 		}
-		granularityTickC := time.Tick(granularity)
-		checkTickC := time.Tick(checkTick)
-		for {
-			select {
-			case <-granularityTickC:
-				atomic.StoreInt32(&breaker.Count, 0)
-				// check 1s/checkTick times in 1s
-			case <-checkTickC:
-				threshold, exist := configCenter.GetIntWithNamespace(context.TODO(), center.DefaultApolloMysqlNamespace, globalThresholdKey)
-				if !exist {
-					slog.Warnf(context.TODO(), "dbrouter: get threshold from apollo failed, exist: %v", exist)
-					threshold = defaultThreshold
-				}
-				breakerGap, exist := configCenter.GetIntWithNamespace(context.TODO(), center.DefaultApolloMysqlNamespace, globalBreakerGapKey)
-				if !exist {
-					slog.Warnf(context.TODO(), "dbrouter: get breakGap from apollo failed, exist: %v", exist)
-					breakerGap = defaultBreakerGap
-				}
-				if atomic.LoadInt32(&breaker.Count) > int32(threshold) {
-					atomic.StoreInt32(&breaker.Rejected, 1)
-					breaker.RejectedStart = time.Now().Unix()
-				} else {
-					now := time.Now().Unix()
-					if now-breaker.RejectedStart > int64(breakerGap) {
-						atomic.StoreInt32(&breaker.Rejected, 0)
-					}
-				}
+	}
+	for field, p := range durations {
+		if s, ok := getBreakerString(ctx, scopes, field); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				slog.Warnf(ctx, "%s invalid %s: %s, cluster: %s, table: %s", fun, field, s, cluster, table)
+				continue
 			}
+			*p = d
 		}
-	}()
+	}
+
+	ints := map[string]*int{
+		breakerBucketsField:     &config.Buckets,
+		breakerMinRequestsField: &config.MinRequests,
+		breakerHalfOpenField:    &config.HalfOpenRequests,
+	}
+	for field, p := range ints {
+		if s, ok := getBreakerString(ctx, scopes, field); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				slog.Warnf(ctx, "%s invalid %s: %s, cluster: %s, table: %s", fun, field, s, cluster, table)
+				continue
+			}
+			*p = n
+		}
+	}
+
+	floats := map[string]*float64{
+		breakerErrorRateField: &config.ErrorRateThreshold,
+		breakerSlowRateField:  &config.SlowRateThreshold,
+	}
+	for field, p := range floats {
+		if s, ok := getBreakerString(ctx, scopes, field); ok {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil || f < 0 || f > 1 {
+				slog.Warnf(ctx, "%s invalid %s: %s, cluster: %s, table: %s", fun, field, s, cluster, table)
+				continue
+			}
+			*p = f
+		}
+	}
+
+	if config.Window <= 0 {
+		config.Window = defaultBreakerConfig.Window
+	}
+	if config.Buckets <= 0 {
+		config.Buckets = defaultBreakerConfig.Buckets
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	return config
 }
 
 func initConfig() error {
@@ -120,6 +474,9 @@ func initConfig() error {
 	if err != nil {
 		return err
 	}
+	configCenter.StartWatchUpdate(context.TODO())
+	configCenter.RegisterObserver(context.TODO(), bm)
+	configCenter.RegisterObserver(context.TODO(), slowQuery)
 	return nil
 }
 
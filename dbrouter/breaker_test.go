@@ -1,24 +1,153 @@
 package dbrouter
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"testing"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/shawnfeng/sutil/stat"
+	"github.com/stretchr/testify/assert"
 )
 
-func TestEntry(t *testing.T) {
-	go func() {
-		for {
-			fmt.Printf("Entry: %v\n", Entry("group", "test"))
-			time.Sleep(time.Millisecond * 1000)
-		}
-	}()
-
-	go func() {
-		for i := 0; i < 20; i++ {
-			statBreaker("group", "test", errors.New("timeout"))
-		}
-	}()
-	select {}
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var changes []string
+	config := BreakerConfig{
+		Window:             10 * time.Second,
+		Buckets:            10,
+		MinRequests:        10,
+		ErrorRateThreshold: 0.5,
+		SlowCallDuration:   time.Second,
+		SlowRateThreshold:  0.8,
+		OpenDuration:       5 * time.Second,
+		HalfOpenRequests:   2,
+	}
+	b := newBreaker("group", "test", config, func(cluster, table string, from, to BreakerState) {
+		changes = append(changes, fmt.Sprintf("%s->%s", from, to))
+	})
+	b.now = func() time.Time { return now }
+
+	allow := func() bool {
+		_, ok := b.Allow()
+		return ok
+	}
+
+	// 请求数不足时不熔断
+	for i := 0; i < 9; i++ {
+		token, ok := b.Allow()
+		assert.True(t, ok)
+		b.Record(token, context.DeadlineExceeded, 0)
+	}
+	assert.Equal(t, BreakerClosed, b.State())
+
+	// 超出窗口的错误不计入
+	now = now.Add(11 * time.Second)
+	for i := 0; i < 9; i++ {
+		b.Record(0, nil, 0)
+	}
+	b.Record(0, driver.ErrBadConn, 0)
+	assert.Equal(t, BreakerClosed, b.State())
+	// 熔断前放行的请求，在半开状态时结束不计入试探结果
+	stale, ok := b.Allow()
+	assert.True(t, ok)
+	for i := 0; i < 9; i++ {
+		b.Record(0, driver.ErrBadConn, 0)
+	}
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, allow())
+
+	// 半开状态下试探请求失败重新熔断
+	now = now.Add(5 * time.Second)
+	token, ok := b.Allow()
+	assert.True(t, ok)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	b.Record(stale, driver.ErrBadConn, 0)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	b.Record(token, nil, 2*time.Second)
+	assert.Equal(t, BreakerOpen, b.State())
+
+	// 试探请求全部成功后恢复
+	now = now.Add(5 * time.Second)
+	token1, ok := b.Allow()
+	assert.True(t, ok)
+	token2, ok := b.Allow()
+	assert.True(t, ok)
+	assert.False(t, allow())
+	b.Record(token1, nil, 0)
+	b.Record(token2, nil, 0)
+	assert.Equal(t, BreakerClosed, b.State())
+
+	// 慢请求比例超过阈值时熔断
+	for i := 0; i < 10; i++ {
+		b.Record(0, nil, time.Second)
+	}
+	assert.Equal(t, BreakerOpen, b.State())
+
+	// 试探请求没有记录结果时，超过 OpenDuration 后重新放行，上一轮的试探结果不再计入
+	now = now.Add(5 * time.Second)
+	token1, ok = b.Allow()
+	assert.True(t, ok)
+	assert.True(t, allow())
+	assert.False(t, allow())
+	now = now.Add(5 * time.Second)
+	assert.True(t, allow())
+	b.Record(token1, driver.ErrBadConn, 0)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+
+	assert.Equal(t, []string{
+		"closed->open", "open->half_open", "half_open->open", "open->half_open", "half_open->closed",
+		"closed->open", "open->half_open",
+	}, changes)
+}
+
+func TestIsBreakerFailure(t *testing.T) {
+	assert.False(t, isBreakerFailure(nil))
+	assert.False(t, isBreakerFailure(sql.ErrNoRows))
+	assert.False(t, isBreakerFailure(errors.New("Duplicate entry")))
+	assert.False(t, isBreakerFailure(&mysql.MySQLError{Number: 1062}))
+	assert.True(t, isBreakerFailure(context.DeadlineExceeded))
+	assert.True(t, isBreakerFailure(fmt.Errorf("query: %w", driver.ErrBadConn)))
+	assert.True(t, isBreakerFailure(mysql.ErrInvalidConn))
+	assert.True(t, isBreakerFailure(&mysql.MySQLError{Number: 1205}))
+}
+
+func TestLoadBreakerConfig(t *testing.T) {
+	config := loadBreakerConfig(context.Background(), "group", "test")
+	assert.True(t, config.Window > 0)
+	assert.True(t, config.Buckets > 0)
+	assert.True(t, config.HalfOpenRequests > 0)
+}
+
+// 半开状态放行的试探请求在准备阶段失败时也要记录结果，否则试探名额一直被占用
+func TestBreakerTrialReleasedOnPrepareError(t *testing.T) {
+	ctx := context.Background()
+	configer, err := NewSimpleConfiger([]byte(`{
+		"cluster": {"breaker": [{"instance": "breakerA", "match": "full", "express": "trial"}]},
+		"instances": {"breakerA": {"dbtype": "mysql", "dbname": "breaker", "dbcfg": {"addrs": ["a:3306"]}}}
+	}`))
+	assert.NoError(t, err)
+	changes := make(chan dbConfigChange)
+	close(changes)
+	router := &Router{
+		configer: configer,
+		instances: NewInstanceManager(func(ctx context.Context, key, group string) (Instancer, error) {
+			return nil, errors.New("dial failed")
+		}, changes, nil),
+		report: stat.NewStat(),
+	}
+
+	b := bm.get("breaker", "trial")
+	b.mu.Lock()
+	b.config.HalfOpenRequests = 1
+	b.setState(BreakerOpen, b.now().Add(-b.config.OpenDuration))
+	b.mu.Unlock()
+
+	err = router.SqlExec(ctx, "breaker", func(db *DB, tables []interface{}) error { return nil }, "trial")
+	assert.Error(t, err)
+	assert.Equal(t, BreakerClosed, b.State())
 }
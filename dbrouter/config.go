@@ -33,6 +33,7 @@ type Config struct {
 	UserName string
 	PassWord string
 	TimeOut  time.Duration
+	Replicas ReplicaConfig
 }
 
 type Configer interface {
@@ -42,6 +43,14 @@ type Configer interface {
 	GetGroups(ctx context.Context) []string
 }
 
+// ShardConfiger 支持分表路由的 Configer，SimpleConfig 和 EtcdConfig 都实现了该接口，
+// 没有实现该接口的 Configer 调用分表相关的接口时返回错误
+type ShardConfiger interface {
+	Configer
+	GetShard(ctx context.Context, cluster, table string, key interface{}) (ShardRoute, error)
+	GetShards(ctx context.Context, cluster, table string) ([]ShardRoute, error)
+}
+
 func NewConfiger(configType int, data []byte, dbChangeChan chan dbConfigChange) (Configer, error) {
 
 	switch configType {
@@ -78,6 +87,7 @@ func (m *SimpleConfig) GetConfig(ctx context.Context, instance string) *Config {
 		UserName: info.UserName,
 		PassWord: info.PassWord,
 		TimeOut:  3 * time.Second,
+		Replicas: info.replicaConfig(),
 	}
 }
 
@@ -90,6 +100,7 @@ func (m *SimpleConfig) GetConfigByGroup(ctx context.Context, instance, group str
 		UserName: info.UserName,
 		PassWord: info.PassWord,
 		TimeOut:  3 * time.Second,
+		Replicas: info.replicaConfig(),
 	}
 }
 
@@ -98,6 +109,14 @@ func (m *SimpleConfig) GetInstance(ctx context.Context, cluster, table string) (
 	return instance
 }
 
+func (m *SimpleConfig) GetShard(ctx context.Context, cluster, table string, key interface{}) (ShardRoute, error) {
+	return m.parser.GetShard(cluster, table, key)
+}
+
+func (m *SimpleConfig) GetShards(ctx context.Context, cluster, table string) ([]ShardRoute, error) {
+	return m.parser.GetShards(cluster, table)
+}
+
 func (m *SimpleConfig) GetGroups(ctx context.Context) []string {
 	var groups []string
 	for group, _ := range m.parser.dbIns {
@@ -188,6 +207,7 @@ func (m *EtcdConfig) GetConfig(ctx context.Context, instance string) *Config {
 		UserName: info.UserName,
 		PassWord: info.PassWord,
 		TimeOut:  3 * time.Second,
+		Replicas: info.replicaConfig(),
 	}
 }
 
@@ -201,6 +221,7 @@ func (m *EtcdConfig) GetConfigByGroup(ctx context.Context, instance, group strin
 		UserName: info.UserName,
 		PassWord: info.PassWord,
 		TimeOut:  3 * time.Second,
+		Replicas: info.replicaConfig(),
 	}
 }
 
@@ -209,6 +230,16 @@ func (m *EtcdConfig) GetInstance(ctx context.Context, cluster, table string) (in
 	return parser.GetInstance(cluster, table)
 }
 
+func (m *EtcdConfig) GetShard(ctx context.Context, cluster, table string, key interface{}) (ShardRoute, error) {
+	parser := m.getParser(ctx)
+	return parser.GetShard(cluster, table, key)
+}
+
+func (m *EtcdConfig) GetShards(ctx context.Context, cluster, table string) ([]ShardRoute, error) {
+	parser := m.getParser(ctx)
+	return parser.GetShards(cluster, table)
+}
+
 func (m *EtcdConfig) GetGroups(ctx context.Context) []string {
 	var groups []string
 	parser := m.getParser(ctx)
@@ -8,6 +8,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"sync"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/log"
@@ -20,6 +22,8 @@ import (
 const (
 	spanLogKeyCluster = "cluster"
 	spanLogKeyTable   = "table"
+	spanLogKeyRole    = "role"
+	spanLogKeyShard   = "shard"
 )
 
 type Router struct {
@@ -58,12 +62,30 @@ func (m *Router) StatInfo() []*stat.QueryStat {
 	return m.report.StatInfo()
 }
 
-func (m *Router) sqlPrepare(ctx context.Context, cluster, table string) (db *DB, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "dbrouter.sqlPrepare")
+// selectSql 写请求以及需要读主库的读请求使用主库，其他读请求使用可用的从库
+func (m *Router) selectSql(ctx context.Context, span opentracing.Span, cluster, table, instance string, dbsql *Sql, read bool) *Sql {
+	if !read {
+		markWrite(ctx, instance)
+		return dbsql
+	}
+	sel, role := dbsql, RoleMaster
+	if !readFromMaster(ctx, instance, dbsql.maxReplicaLag()) {
+		sel, role = dbsql.getReadSql()
+	}
+	span.LogFields(log.String(spanLogKeyRole, role))
+	statReadRole(cluster, table, role)
+	return sel
+}
+
+// sqlPrepare instance 为空时根据 cluster 和 table 查找实例
+func (m *Router) sqlPrepare(ctx context.Context, cluster, table, instance string, read bool) (db *DB, err error) {
+	span, pctx := opentracing.StartSpanFromContext(ctx, "dbrouter.sqlPrepare")
 	defer span.Finish()
 
-	instance := m.configer.GetInstance(ctx, cluster, table)
-	in := m.instances.Get(ctx, generateKey(instance))
+	if len(instance) == 0 {
+		instance = m.configer.GetInstance(pctx, cluster, table)
+	}
+	in := m.instances.Get(pctx, generateKey(instance))
 	if in == nil {
 		err = fmt.Errorf("db instance not find: cluster:%s table:%s instance:%s", cluster, table, instance)
 		return
@@ -75,14 +97,28 @@ func (m *Router) sqlPrepare(ctx context.Context, cluster, table string) (db *DB,
 		return
 	}
 
-	db = dbsql.getDB()
+	// 语句的 span 挂在调用方的 span 下
+	db = m.selectSql(pctx, span, cluster, table, instance, dbsql, read).getDB().withTrace(newStmtTrace(ctx, cluster, table, instance))
 	return
 }
 
 func (m *Router) SqlExec(ctx context.Context, cluster string, query func(*DB, []interface{}) error, tables ...string) error {
-	fun := "Router.SqlExec -->"
+	return m.sqlExec(ctx, "dbrouter.SqlExec", cluster, nil, false, query, tables...)
+}
+
+// SqlExecRead 在可用的从库上执行只读的查询，没有配置从库、从库都不可用、ctx 使用了 WithMaster，
+// 或者 WithSession 的 ctx 中最近写过同一个实例时使用主库。
+// 只有 WithSession 的 ctx 才会记录写请求，没有使用 WithSession 时写之后立即读可能读到从库上的旧数据，
+// 需要读到自己的写时，写和读都要使用同一个 WithSession 返回的 ctx
+func (m *Router) SqlExecRead(ctx context.Context, cluster string, query func(*DB, []interface{}) error, tables ...string) error {
+	return m.sqlExec(ctx, "dbrouter.SqlExecRead", cluster, nil, true, query, tables...)
+}
+
+// sqlExec shard 不为空时 tables[0] 为分表的逻辑表名，使用 shard 的实例，传给 query 的 tables[0] 替换为物理表名
+func (m *Router) sqlExec(ctx context.Context, operation, cluster string, shard *ShardRoute, read bool, query func(*DB, []interface{}) error, tables ...string) (err error) {
+	fun := "Router.sqlExec -->"
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "dbrouter.SqlExec")
+	span, ctx := opentracing.StartSpanFromContext(ctx, operation)
 	defer span.Finish()
 
 	st := stime.NewTimeStat()
@@ -97,12 +133,19 @@ func (m *Router) SqlExec(ctx context.Context, cluster string, query func(*DB, []
 		log.String(spanLogKeyTable, table))
 
 	// check breaker
-	if !Entry(cluster, table){
+	token, ok := Entry(cluster, table)
+	if !ok {
 		slog.Errorf(ctx, "%s trigger tidb breaker, because too many timeout sqls, cluster: %s, table: %s", fun, cluster, table)
 		return errors.New("sql cause breaker, because too many timeout")
 	}
 
-	db, err := m.sqlPrepare(ctx, cluster, table)
+	// NOTE: 准备失败时也要记录结果，释放半开状态的试探名额
+	defer func() {
+		statBreaker(cluster, table, token, err, st.Duration())
+	}()
+
+	instance := shardLog(span, shard)
+	db, err := m.sqlPrepare(ctx, cluster, table, instance, read)
 	if err != nil {
 		return err
 	}
@@ -113,22 +156,19 @@ func (m *Router) SqlExec(ctx context.Context, cluster string, query func(*DB, []
 		slog.Tracef(ctx, "%s cls:%s table:%s dur:%d", fun, cluster, table, dur)
 	}()
 
-	var tmptables []interface{}
-	for _, item := range tables {
-		tmptables = append(tmptables, item)
-	}
+	tmptables := shardTables(shard, tables)
 	err = query(db, tmptables)
 	statReqErr(cluster, table, err)
-	// record breaker
-	statBreaker(cluster, table, err)
 	return err
 }
 
-func (m *Router) ormPrepare(ctx context.Context, cluster, table string) (db *GormDB, err error) {
+func (m *Router) ormPrepare(ctx context.Context, cluster, table, instance string, read bool) (db *GormDB, err error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "dbrouter.ormPrepare")
 	defer span.Finish()
 
-	instance := m.configer.GetInstance(ctx, cluster, table)
+	if len(instance) == 0 {
+		instance = m.configer.GetInstance(ctx, cluster, table)
+	}
 	in := m.instances.Get(ctx, generateKey(instance))
 	if in == nil {
 		err = fmt.Errorf("db instance not find: instance:%s", instance)
@@ -141,14 +181,23 @@ func (m *Router) ormPrepare(ctx context.Context, cluster, table string) (db *Gor
 		return
 	}
 
-	db = dbsql.getGormDB()
+	db = m.selectSql(ctx, span, cluster, table, instance, dbsql, read).getGormDB()
 	return
 }
 
 func (m *Router) OrmExec(ctx context.Context, cluster string, query func(*GormDB, []interface{}) error, tables ...string) error {
-	fun := "Router.OrmExec -->"
+	return m.ormExec(ctx, "dbrouter.OrmExec", cluster, nil, false, query, tables...)
+}
+
+// OrmExecRead 与 SqlExecRead 相同，在可用的从库上执行只读的查询
+func (m *Router) OrmExecRead(ctx context.Context, cluster string, query func(*GormDB, []interface{}) error, tables ...string) error {
+	return m.ormExec(ctx, "dbrouter.OrmExecRead", cluster, nil, true, query, tables...)
+}
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "dbrouter.OrmExec")
+func (m *Router) ormExec(ctx context.Context, operation, cluster string, shard *ShardRoute, read bool, query func(*GormDB, []interface{}) error, tables ...string) (err error) {
+	fun := "Router.ormExec -->"
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, operation)
 	defer span.Finish()
 
 	st := stime.NewTimeStat()
@@ -163,12 +212,19 @@ func (m *Router) OrmExec(ctx context.Context, cluster string, query func(*GormDB
 		log.String(spanLogKeyTable, table))
 
 	// check breaker
-	if !Entry(cluster, table){
+	token, ok := Entry(cluster, table)
+	if !ok {
 		slog.Errorf(ctx, "%s trigger tidb breaker, because too many timeout sqls, cluster: %s, table: %s", fun, cluster, table)
 		return errors.New("sql cause breaker, because too many timeout")
 	}
 
-	db, err := m.ormPrepare(ctx, cluster, table)
+	// NOTE: 准备失败时也要记录结果，释放半开状态的试探名额
+	defer func() {
+		statBreaker(cluster, table, token, err, st.Duration())
+	}()
+
+	instance := shardLog(span, shard)
+	db, err := m.ormPrepare(ctx, cluster, table, instance, read)
 	if err != nil {
 		return err
 	}
@@ -179,17 +235,172 @@ func (m *Router) OrmExec(ctx context.Context, cluster string, query func(*GormDB
 		slog.Tracef(ctx, "%s cls:%s table:%s dur:%d", fun, cluster, table, dur)
 	}()
 
-	var tmptables []interface{}
-	for _, item := range tables {
-		tmptables = append(tmptables, item)
-	}
+	tmptables := shardTables(shard, tables)
 	err = query(db, tmptables)
 	statReqErr(cluster, table, err)
-	// stat breaker
-	statBreaker(cluster, table, err)
 	return err
 }
 
+// SqlExecShard 根据 shardKey 路由到分表所在的实例，tables[0] 为分表的逻辑表名，
+// 传给 query 的 tables[0] 为物理表名，如 orders_0017
+func (m *Router) SqlExecShard(ctx context.Context, cluster string, shardKey interface{}, query func(*DB, []interface{}) error, tables ...string) error {
+	shard, err := m.getShard(ctx, cluster, shardKey, tables)
+	if err != nil {
+		return err
+	}
+	return m.sqlExec(ctx, "dbrouter.SqlExecShard", cluster, shard, false, query, tables...)
+}
+
+// SqlExecReadShard 与 SqlExecShard 相同，读请求路由规则同 SqlExecRead
+func (m *Router) SqlExecReadShard(ctx context.Context, cluster string, shardKey interface{}, query func(*DB, []interface{}) error, tables ...string) error {
+	shard, err := m.getShard(ctx, cluster, shardKey, tables)
+	if err != nil {
+		return err
+	}
+	return m.sqlExec(ctx, "dbrouter.SqlExecReadShard", cluster, shard, true, query, tables...)
+}
+
+func (m *Router) OrmExecShard(ctx context.Context, cluster string, shardKey interface{}, query func(*GormDB, []interface{}) error, tables ...string) error {
+	shard, err := m.getShard(ctx, cluster, shardKey, tables)
+	if err != nil {
+		return err
+	}
+	return m.ormExec(ctx, "dbrouter.OrmExecShard", cluster, shard, false, query, tables...)
+}
+
+func (m *Router) OrmExecReadShard(ctx context.Context, cluster string, shardKey interface{}, query func(*GormDB, []interface{}) error, tables ...string) error {
+	shard, err := m.getShard(ctx, cluster, shardKey, tables)
+	if err != nil {
+		return err
+	}
+	return m.ormExec(ctx, "dbrouter.OrmExecReadShard", cluster, shard, true, query, tables...)
+}
+
+func (m *Router) getShard(ctx context.Context, cluster string, shardKey interface{}, tables []string) (*ShardRoute, error) {
+	if len(tables) <= 0 {
+		return nil, fmt.Errorf("tables is empty")
+	}
+	configer, err := m.shardConfiger()
+	if err != nil {
+		return nil, err
+	}
+	shard, err := configer.GetShard(ctx, cluster, tables[0], shardKey)
+	if err != nil {
+		return nil, err
+	}
+	return &shard, nil
+}
+
+func (m *Router) shardConfiger() (ShardConfiger, error) {
+	configer, ok := m.configer.(ShardConfiger)
+	if !ok {
+		return nil, fmt.Errorf("configer %T does not support shards", m.configer)
+	}
+	return configer, nil
+}
+
+// SqlExecAllShards 在 tables[0] 的所有分表上并发执行 query，按照分表的顺序返回每个分表的结果，
+// 任意分表出错时不再执行还没有开始的分表并返回该错误，已经开始执行的语句不会被中断
+func (m *Router) SqlExecAllShards(ctx context.Context, cluster string, query func(*DB, []interface{}) (interface{}, error), tables ...string) ([]interface{}, error) {
+	return m.sqlExecAllShards(ctx, "dbrouter.SqlExecAllShards", cluster, false, query, tables...)
+}
+
+// SelectAllShards 在 table 的所有分表上执行查询，query 中使用 %s 表示物理表名，
+// dest 为 slice 的指针，按照分表的顺序合并所有分表的结果，读请求路由规则同 SqlExecRead
+func (m *Router) SelectAllShards(ctx context.Context, cluster, table string, dest interface{}, query string, args ...interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dest should be pointer of slice, got %T", dest)
+	}
+	sliceType := dv.Elem().Type()
+
+	results, err := m.sqlExecAllShards(ctx, "dbrouter.SelectAllShards", cluster, true, func(db *DB, tables []interface{}) (interface{}, error) {
+		part := reflect.New(sliceType)
+		if err := db.SelectWrapper(tables, part.Interface(), query, args...); err != nil {
+			return nil, err
+		}
+		return part.Elem(), nil
+	}, table)
+	if err != nil {
+		return err
+	}
+
+	merged := dv.Elem()
+	for _, r := range results {
+		merged = reflect.AppendSlice(merged, r.(reflect.Value))
+	}
+	dv.Elem().Set(merged)
+	return nil
+}
+
+func (m *Router) sqlExecAllShards(ctx context.Context, operation, cluster string, read bool, query func(*DB, []interface{}) (interface{}, error), tables ...string) ([]interface{}, error) {
+	fun := "Router.sqlExecAllShards -->"
+
+	if len(tables) <= 0 {
+		return nil, fmt.Errorf("tables is empty")
+	}
+	configer, err := m.shardConfiger()
+	if err != nil {
+		return nil, err
+	}
+	shards, err := configer.GetShards(ctx, cluster, tables[0])
+	if err != nil {
+		return nil, err
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, operation)
+	defer span.Finish()
+	span.LogFields(
+		log.String(spanLogKeyCluster, cluster),
+		log.String(spanLogKeyTable, tables[0]),
+		log.Int("shards", len(shards)))
+
+	// 第一个出错的分表通过取消 ctx 跳过还没有开始的分表，DB 的语句不使用 ctx，已经开始的分表会执行完
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failOnce sync.Once
+	var failErr error
+	failed := -1
+	fail := func(i int, err error) {
+		failOnce.Do(func() {
+			failErr, failed = err, i
+			cancel()
+		})
+	}
+
+	results := make([]interface{}, len(shards))
+	sem := make(chan struct{}, shardFanoutConcurrency)
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := ctx.Err(); err != nil {
+				fail(i, err)
+				return
+			}
+			err := m.sqlExec(ctx, operation, cluster, &shards[i], read, func(db *DB, tables []interface{}) (err error) {
+				results[i], err = query(db, tables)
+				return
+			}, tables...)
+			if err != nil {
+				fail(i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failErr != nil {
+		slog.Errorf(ctx, "%s cluster:%s shard:%s err:%v", fun, cluster, shards[failed].Table, failErr)
+		return nil, failErr
+	}
+	return results, nil
+}
+
 func (m *Router) MongoExecEventual(ctx context.Context, cluster, table string, query func(*mgo.Collection) error) error {
 	return m.mongoExec(ctx, eventual, cluster, table, query)
 }
@@ -233,9 +444,10 @@ func (m *Router) mongoPrepare(ctx context.Context, consistency mode, cluster, ta
 	return
 }
 
-func (m *Router) mongoExec(ctx context.Context, consistency mode, cluster, table string, query func(*mgo.Collection) error) error {
+func (m *Router) mongoExec(ctx context.Context, consistency mode, cluster, table string, query func(*mgo.Collection) error) (err error) {
 	fun := "Router.mongoExec -->"
-	if !Entry(cluster, table){
+	token, ok := Entry(cluster, table)
+	if !ok {
 		slog.Errorf(ctx, "%s trigger mongodb breaker, because too many timeout query, cluster: %s, table: %s", fun, cluster, table)
 		return errors.New("mongo query cause breaker, because too many timeout")
 	}
@@ -247,6 +459,10 @@ func (m *Router) mongoExec(ctx context.Context, consistency mode, cluster, table
 		log.String(spanLogKeyTable, table))
 
 	st := stime.NewTimeStat()
+	// NOTE: 准备失败时也要记录结果，释放半开状态的试探名额
+	defer func() {
+		statBreaker(cluster, table, token, err, st.Duration())
+	}()
 
 	sess, err := m.mongoPrepare(ctx, consistency, cluster, table)
 	if err != nil {
@@ -262,6 +478,5 @@ func (m *Router) mongoExec(ctx context.Context, consistency mode, cluster, table
 	}()
 	err = query(coll)
 	statReqErr(cluster, table, err)
-	statBreaker(cluster, table, err)
 	return err
 }
@@ -9,9 +9,15 @@ import (
 	"regexp"
 )
 
+const (
+	matchFull  = "full"
+	matchRegex = "regex"
+)
+
 type clsEntry struct {
-	full  map[string]*dbExpress
-	regex map[string]*dbExpress
+	full    map[string]*dbExpress
+	regex   map[string]*dbExpress
+	sharded map[string]*shardTable
 }
 
 type dbCluster struct {
@@ -30,20 +36,21 @@ func (m *dbExpress) String() string {
 func (m *dbCluster) addInstance(cluster string, lcfg *dbLookupCfg) error {
 	if _, ok := m.clusters[cluster]; !ok {
 		m.clusters[cluster] = &clsEntry{
-			full:  make(map[string]*dbExpress),
-			regex: make(map[string]*dbExpress),
+			full:    make(map[string]*dbExpress),
+			regex:   make(map[string]*dbExpress),
+			sharded: make(map[string]*shardTable),
 		}
 	}
 
 	match := lcfg.Match
-	if match == "full" {
+	if match == matchFull {
 		if m.clusters[cluster].full[lcfg.Express] != nil {
 			return fmt.Errorf("dup match full in cluster:%s express:%s", cluster, lcfg.Express)
 		}
 
 		m.clusters[cluster].full[lcfg.Express] = &dbExpress{lookup: lcfg}
 
-	} else if match == "regex" {
+	} else if match == matchRegex {
 		if m.clusters[cluster].regex[lcfg.Express] != nil {
 			return fmt.Errorf("dup match regex in cluster:%s express:%s", cluster, lcfg.Express)
 		}
@@ -55,6 +62,9 @@ func (m *dbCluster) addInstance(cluster string, lcfg *dbLookupCfg) error {
 
 		m.clusters[cluster].regex[lcfg.Express] = &dbExpress{lookup: lcfg, reg: reg}
 
+	} else if isShardMatch(match) {
+		return m.addShard(cluster, lcfg)
+
 	} else {
 		return fmt.Errorf("match type:%s not support", match)
 	}
@@ -44,7 +44,12 @@ func Factory(ctx context.Context, key, group string, configer Configer) (in Inst
 		fallthrough
 
 	case DB_TYPE_POSTGRES:
-		return NewSql(config.DBType, config.DBName, config.DBAddr[0], config.UserName, config.PassWord, config.TimeOut)
+		in, err := NewSql(config.DBType, config.DBName, config.DBAddr[0], config.UserName, config.PassWord, config.TimeOut)
+		if err != nil {
+			return nil, err
+		}
+		in.dialReplicas(ctx, config.Replicas)
+		return in, nil
 
 	default:
 		return nil, fmt.Errorf("dbType err, key: %s", key)
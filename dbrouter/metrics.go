@@ -1,6 +1,8 @@
 package dbrouter
 
 import (
+	"time"
+
 	"gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric/xprometheus"
 )
 
@@ -10,6 +12,17 @@ const (
 )
 
 var (
+	buckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+	_metricStmtDuration = xprometheus.NewHistogram(&xprometheus.HistogramVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "statement_duration_ms",
+		Help:       "db statement duration(ms)",
+		LabelNames: []string{"cluster", "table", "operation"},
+		Buckets:    buckets,
+	})
+
 	_metricReqErr = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
 		Namespace:  namespace,
 		Subsystem:  subsystem,
@@ -17,6 +30,38 @@ var (
 		Help:       "db request err total",
 		LabelNames: []string{xprometheus.LabelSource},
 	})
+
+	_metricBreakerState = xprometheus.NewGauge(&xprometheus.GaugeVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "breaker_state",
+		Help:       "db breaker state, 0: closed 1: open 2: half open",
+		LabelNames: []string{xprometheus.LabelSource},
+	})
+
+	_metricBreakerReject = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "breaker_reject_total",
+		Help:       "db requests rejected by breaker total",
+		LabelNames: []string{xprometheus.LabelSource},
+	})
+
+	_metricReadRole = xprometheus.NewCounter(&xprometheus.CounterVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "read_role_total",
+		Help:       "db read requests total by role",
+		LabelNames: []string{xprometheus.LabelSource, "role"},
+	})
+
+	_metricReplicaLag = xprometheus.NewGauge(&xprometheus.GaugeVecOpts{
+		Namespace:  namespace,
+		Subsystem:  subsystem,
+		Name:       "replica_lag_seconds",
+		Help:       "db replica lag(s)",
+		LabelNames: []string{"db", "addr"},
+	})
 )
 
 func statReqErr(cluster, table string, err error) {
@@ -26,3 +71,26 @@ func statReqErr(cluster, table string, err error) {
 	}
 	return
 }
+
+func statBreakerState(cluster, table string, state BreakerState) {
+	source := cluster + "." + table
+	_metricBreakerState.With(xprometheus.LabelSource, source).Set(float64(state))
+}
+
+func statBreakerReject(cluster, table string) {
+	source := cluster + "." + table
+	_metricBreakerReject.With(xprometheus.LabelSource, source).Inc()
+}
+
+func statReadRole(cluster, table, role string) {
+	source := cluster + "." + table
+	_metricReadRole.With(xprometheus.LabelSource, source, "role", role).Inc()
+}
+
+func statReplicaLag(db, addr string, lag time.Duration) {
+	_metricReplicaLag.With("db", db, "addr", addr).Set(lag.Seconds())
+}
+
+func statStmtDuration(cluster, table, operation string, dur time.Duration) {
+	_metricStmtDuration.With("cluster", cluster, "table", table, "operation", operation).Observe(float64(dur) / float64(time.Millisecond))
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/shawnfeng/sutil/slog/slog"
+	"time"
 )
 
 const(
@@ -20,6 +21,16 @@ type dbLookupCfg struct {
 	Instance string `json:"instance"`
 	Match    string `json:"match"`
 	Express  string `json:"express"`
+	// 分表配置，match 为 hash 时 shards 为分表数量，range 时 step 为每个分表的 key 范围
+	Shards int64 `json:"shards"`
+	Step   int64 `json:"step"`
+	// hash 和 range 物理表名后缀的位数，默认为4，如 orders_0017
+	Width int `json:"width"`
+	// 当前配置项覆盖的分表下标范围 [from, to]，month 和 day 的下标为 yyyymm 和 yyyymmdd，为空时不限制
+	From *int64 `json:"from"`
+	To   *int64 `json:"to"`
+	// month 和 day 计算分表时使用的时区，如 Asia/Shanghai，为空时使用 UTC
+	Location string `json:"location"`
 }
 
 func (m *dbLookupCfg) String() string {
@@ -47,6 +58,20 @@ type dbInsInfo struct {
 	DBAddr   []string `json:"addrs"`
 	UserName string   `json:"user"`
 	PassWord string   `json:"passwd"`
+	// 从库地址，读请求在可用的从库之间轮询
+	Replicas []string `json:"replicas"`
+	// 查询从库延迟(秒)的语句，为空时只检查连通性
+	LagProbe string `json:"lagprobe"`
+	// 从库的最大延迟，单位: 秒，延迟超过该值的从库不参与读路由，写之后该时间内同一个 session 的读请求使用主库
+	MaxLag float64 `json:"maxlag"`
+}
+
+func (m *dbInsInfo) replicaConfig() ReplicaConfig {
+	return ReplicaConfig{
+		Addrs:    m.Replicas,
+		LagProbe: m.LagProbe,
+		MaxLag:   time.Duration(m.MaxLag * float64(time.Second)),
+	}
 }
 
 type routeConfig struct {
@@ -68,6 +93,14 @@ func (m *Parser) GetInstance(cluster, table string) string {
 	return instance
 }
 
+func (m *Parser) GetShard(cluster, table string, key interface{}) (ShardRoute, error) {
+	return m.dbCls.getShard(cluster, table, key)
+}
+
+func (m *Parser) GetShards(cluster, table string) ([]ShardRoute, error) {
+	return m.dbCls.getShards(cluster, table)
+}
+
 func (m *Parser) getConfig(instance, group string) *dbInsInfo {
 	if infoMap, ok := m.dbIns[group]; ok {
 		if info, ok := infoMap[instance]; ok {
@@ -289,7 +322,9 @@ func compareDbInstances(originDbInstances map[string]*dbInsInfo, newDbInstances
 
 func compareDbInfo(dbInsInfo1 *dbInsInfo, dbInsInfo2 *dbInsInfo) bool {
 	return dbInsInfo1.DBName == dbInsInfo2.DBName && dbInsInfo1.UserName == dbInsInfo2.UserName &&
-		dbInsInfo1.PassWord == dbInsInfo2.PassWord && compareStringList(dbInsInfo1.DBAddr, dbInsInfo2.DBAddr)
+		dbInsInfo1.PassWord == dbInsInfo2.PassWord && compareStringList(dbInsInfo1.DBAddr, dbInsInfo2.DBAddr) &&
+		compareStringList(dbInsInfo1.Replicas, dbInsInfo2.Replicas) && dbInsInfo1.LagProbe == dbInsInfo2.LagProbe &&
+		dbInsInfo1.MaxLag == dbInsInfo2.MaxLag
 }
 
 func compareStringList(stringList1 []string, stringList2 []string) bool {
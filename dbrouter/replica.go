@@ -0,0 +1,274 @@
+package dbrouter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+const (
+	RoleMaster  = "master"
+	RoleReplica = "replica"
+
+	defaultMaxReplicaLag = 3 * time.Second
+	replicaCheckInterval = 5 * time.Second
+)
+
+// ReplicaConfig 从库配置，LagProbe 为查询从库延迟(秒)的语句，如 mysql 可以使用
+// "SELECT TIMESTAMPDIFF(SECOND, ts, NOW()) FROM heartbeat"，为空时只检查连通性
+type ReplicaConfig struct {
+	Addrs    []string
+	LagProbe string
+	MaxLag   time.Duration
+}
+
+type replica struct {
+	addr string
+	// 连接失败时为nil，由 check 重新连接，只在 healthy 为1时被读路由使用
+	sql     *Sql
+	healthy int32
+}
+
+func (m *replica) isHealthy() bool {
+	return atomic.LoadInt32(&m.healthy) == 1
+}
+
+// replicaSet 从库列表，后台定时检查从库的连通性和延迟，不可用或者延迟超过 MaxLag 的从库不参与读路由
+type replicaSet struct {
+	config   ReplicaConfig
+	replicas []*replica
+	next     uint32
+	dial     func(addr string) (*Sql, error)
+
+	// mu 保护 close 和 check 中对 replica.sql 的赋值
+	mu       sync.Mutex
+	closed   bool
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// dialReplicas 连接从库，从库连接失败时只打印日志并标记为不可用，由后台检查重新连接，读请求使用其他从库或者主库
+func (m *Sql) dialReplicas(ctx context.Context, config ReplicaConfig) {
+	if len(config.Addrs) == 0 {
+		return
+	}
+	m.replicas = newReplicaSet(ctx, config, m.dialReplica)
+}
+
+func (m *Sql) dialReplica(addr string) (*Sql, error) {
+	info := &Sql{
+		dbType:   m.dbType,
+		dbName:   m.dbName,
+		dbAddr:   addr,
+		timeOut:  m.timeOut,
+		userName: m.userName,
+		passWord: m.passWord,
+	}
+	var err error
+	info.db, info.gormdb, err = dial(info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func newReplicaSet(ctx context.Context, config ReplicaConfig, dial func(addr string) (*Sql, error)) *replicaSet {
+	if config.MaxLag <= 0 {
+		config.MaxLag = defaultMaxReplicaLag
+	}
+	rs := &replicaSet{
+		config: config,
+		dial:   dial,
+		stop:   make(chan struct{}),
+	}
+	for _, addr := range config.Addrs {
+		rs.replicas = append(rs.replicas, &replica{addr: addr})
+	}
+	rs.check(ctx)
+	go rs.watch()
+	return rs
+}
+
+// redial 重新连接之前连接失败的从库
+func (m *replicaSet) redial(ctx context.Context, r *replica) bool {
+	fun := "replicaSet.redial -->"
+	s, err := m.dial(r.addr)
+	if err != nil {
+		slog.Errorf(ctx, "%s dial replica:%s err:%v", fun, r.addr, err)
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		_ = s.Close()
+		return false
+	}
+	r.sql = s
+	return true
+}
+
+func (m *replicaSet) check(ctx context.Context) {
+	fun := "replicaSet.check -->"
+	for _, r := range m.replicas {
+		// NOTE: 只有 check 会给 r.sql 赋值，这里读取不需要加锁
+		if r.sql == nil && !m.redial(ctx, r) {
+			atomic.StoreInt32(&r.healthy, 0)
+			continue
+		}
+		var healthy int32
+		lag, err := m.probe(ctx, r)
+		if err != nil {
+			// NOTE: 探测失败时延迟未知，不上报，避免被当成没有延迟
+			slog.Warnf(ctx, "%s probe replica:%s err:%v", fun, r.addr, err)
+		} else {
+			if lag > m.config.MaxLag {
+				slog.Warnf(ctx, "%s replica:%s lag:%v exceeds max lag:%v", fun, r.addr, lag, m.config.MaxLag)
+			} else {
+				healthy = 1
+			}
+			statReplicaLag(r.sql.dbName, r.addr, lag)
+		}
+		atomic.StoreInt32(&r.healthy, healthy)
+	}
+}
+
+// probe 检查从库的连通性，配置了 LagProbe 时返回从库的延迟
+func (m *replicaSet) probe(ctx context.Context, r *replica) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.sql.timeOut)
+	defer cancel()
+	if err := r.sql.db.PingContext(ctx); err != nil {
+		return 0, err
+	}
+	if len(m.config.LagProbe) == 0 {
+		return 0, nil
+	}
+	var lag sql.NullFloat64
+	if err := r.sql.db.QueryRowContext(ctx, m.config.LagProbe).Scan(&lag); err != nil {
+		return 0, err
+	}
+	if !lag.Valid {
+		// NOTE: 复制没有运行时延迟通常为 NULL
+		return 0, fmt.Errorf("replica lag is null")
+	}
+	return time.Duration(lag.Float64 * float64(time.Second)), nil
+}
+
+func (m *replicaSet) watch() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check(context.Background())
+		}
+	}
+}
+
+// pick 轮询返回一个可用的从库，没有可用的从库时返回nil
+func (m *replicaSet) pick() *replica {
+	n := len(m.replicas)
+	start := atomic.AddUint32(&m.next, 1)
+	for i := 0; i < n; i++ {
+		r := m.replicas[(int(start)+i)%n]
+		if r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+// close 关闭所有已经连接的从库，返回所有从库关闭时的错误
+func (m *replicaSet) close() error {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	var errs []string
+	for _, r := range m.replicas {
+		if r.sql == nil {
+			continue
+		}
+		if err := r.sql.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("replica:%s err: %v", r.addr, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// getReadSql 返回读请求使用的实例，没有可用的从库时返回主库
+func (m *Sql) getReadSql() (*Sql, string) {
+	if m.replicas == nil {
+		return m, RoleMaster
+	}
+	if r := m.replicas.pick(); r != nil {
+		return r.sql, RoleReplica
+	}
+	return m, RoleMaster
+}
+
+func (m *Sql) maxReplicaLag() time.Duration {
+	if m.replicas == nil {
+		return 0
+	}
+	return m.replicas.config.MaxLag
+}
+
+type masterCtxKey struct{}
+
+type sessionCtxKey struct{}
+
+// session 记录同一个 ctx 中每个实例最近一次写的时间
+type session struct {
+	mu     sync.Mutex
+	writes map[string]time.Time
+}
+
+// WithMaster 返回的 ctx 中的读请求都使用主库，用于需要读到最新数据的场景
+func WithMaster(ctx context.Context) context.Context {
+	return context.WithValue(ctx, masterCtxKey{}, true)
+}
+
+// WithSession 返回的 ctx 会记录写请求，写之后 MaxLag 时间内同一个实例的读请求使用主库，保证读到自己的写
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionCtxKey{}, &session{writes: make(map[string]time.Time)})
+}
+
+// markWrite 记录 ctx 中对 instance 的写
+func markWrite(ctx context.Context, instance string) {
+	s, ok := ctx.Value(sessionCtxKey{}).(*session)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes[instance] = time.Now()
+}
+
+// readFromMaster 判断 ctx 中对 instance 的读请求是否需要使用主库
+func readFromMaster(ctx context.Context, instance string, maxLag time.Duration) bool {
+	if master, _ := ctx.Value(masterCtxKey{}).(bool); master {
+		return true
+	}
+	s, ok := ctx.Value(sessionCtxKey{}).(*session)
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.writes[instance]
+	return ok && time.Since(last) < maxLag
+}
@@ -0,0 +1,144 @@
+package dbrouter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver 不需要数据库的驱动，dsn 为 "closeerr" 的连接关闭时返回错误
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn(name), nil }
+
+type fakeConn string
+
+func (m fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (m fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+func (m fakeConn) Ping(ctx context.Context) error            { return nil }
+
+func (m fakeConn) Close() error {
+	if m == "closeerr" {
+		return errors.New("close")
+	}
+	return nil
+}
+
+func init() {
+	sql.Register("dbrouter_fake", fakeDriver{})
+}
+
+func newFakeSql(t *testing.T, dsn string) *Sql {
+	db, err := sql.Open("dbrouter_fake", dsn)
+	assert.NoError(t, err)
+	// 建立一个空闲连接，关闭时才会调用 fakeConn.Close
+	assert.NoError(t, db.Ping())
+	gormdb, err := gorm.Open(DB_TYPE_POSTGRES, db)
+	assert.NoError(t, err)
+	return &Sql{dbName: "fake", dbAddr: dsn, timeOut: time.Second, db: NewDB(sqlx.NewDb(db, "dbrouter_fake")), gormdb: NewGormDB(gormdb)}
+}
+
+func TestReadFromMaster(t *testing.T) {
+	ctx := context.Background()
+	markWrite(ctx, "ins")
+	assert.False(t, readFromMaster(ctx, "ins", time.Second))
+	assert.True(t, readFromMaster(WithMaster(ctx), "ins", time.Second))
+
+	ctx = WithSession(ctx)
+	assert.False(t, readFromMaster(ctx, "ins", time.Second))
+	markWrite(ctx, "ins")
+	assert.True(t, readFromMaster(ctx, "ins", time.Second))
+	assert.False(t, readFromMaster(ctx, "other", time.Second))
+	assert.False(t, readFromMaster(ctx, "ins", 0))
+}
+
+func TestReplicaSetPick(t *testing.T) {
+	master := &Sql{}
+	s, role := master.getReadSql()
+	assert.Same(t, master, s)
+	assert.Equal(t, RoleMaster, role)
+
+	r1, r2 := &replica{addr: "r1", sql: &Sql{}}, &replica{addr: "r2", sql: &Sql{}}
+	master.replicas = &replicaSet{replicas: []*replica{r1, r2}}
+	s, role = master.getReadSql()
+	assert.Same(t, master, s)
+	assert.Equal(t, RoleMaster, role)
+
+	r1.healthy = 1
+	r2.healthy = 1
+	seen := make(map[*Sql]bool)
+	for i := 0; i < 4; i++ {
+		s, role = master.getReadSql()
+		assert.Equal(t, RoleReplica, role)
+		seen[s] = true
+	}
+	assert.Len(t, seen, 2)
+
+	r1.healthy = 0
+	for i := 0; i < 4; i++ {
+		s, _ = master.getReadSql()
+		assert.Same(t, r2.sql, s)
+	}
+}
+
+func TestParseReplicas(t *testing.T) {
+	parser, err := NewParser([]byte(`{
+		"cluster": {"account": [{"instance": "account", "match": "full", "express": "user"}]},
+		"instances": {"account": {"dbtype": "mysql", "dbname": "account",
+			"dbcfg": {"addrs": ["m:3306"], "user": "u", "passwd": "p", "replicas": ["r1:3306", "r2:3306"], "lagprobe": "SELECT 0", "maxlag": 1.5}}}
+	}`))
+	assert.NoError(t, err)
+	info := parser.GetConfig("account", DefaultGroup)
+	assert.Equal(t, ReplicaConfig{
+		Addrs:    []string{"r1:3306", "r2:3306"},
+		LagProbe: "SELECT 0",
+		MaxLag:   1500 * time.Millisecond,
+	}, info.replicaConfig())
+
+	changed := *info
+	changed.Replicas = []string{"r1:3306"}
+	assert.False(t, compareDbInfo(info, &changed))
+}
+
+func TestReplicaSetRedial(t *testing.T) {
+	ctx := context.Background()
+	dials := 0
+	rs := newReplicaSet(ctx, ReplicaConfig{Addrs: []string{"r1"}}, func(addr string) (*Sql, error) {
+		dials++
+		if dials == 1 {
+			return nil, errors.New("dial")
+		}
+		return newFakeSql(t, addr), nil
+	})
+	// 连接失败的从库保留在列表中，标记为不可用
+	assert.Len(t, rs.replicas, 1)
+	assert.Nil(t, rs.pick())
+
+	rs.check(ctx)
+	assert.Equal(t, 2, dials)
+	assert.NotNil(t, rs.pick())
+	assert.NoError(t, rs.close())
+}
+
+func TestSqlClose(t *testing.T) {
+	ctx := context.Background()
+	master := newFakeSql(t, "closeerr")
+	master.replicas = newReplicaSet(ctx, ReplicaConfig{Addrs: []string{"r1", "closeerr"}}, func(addr string) (*Sql, error) {
+		return newFakeSql(t, addr), nil
+	})
+	r1 := master.replicas.replicas[0].sql
+
+	// 主库关闭失败时也会关闭从库，返回所有的错误
+	err := master.Close()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sqlx.Close err")
+	assert.Contains(t, err.Error(), "replica:closeerr")
+	assert.Error(t, r1.db.Ping())
+}
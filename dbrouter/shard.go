@@ -0,0 +1,349 @@
+package dbrouter
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	// 分表的匹配方式，express 为逻辑表名，物理表名为 <express>_<后缀>
+	// hash: 后缀为 shard key 的 hash 对 shards 取模，如 orders_0017
+	// range: 后缀为 shard key 除以 step，如 orders_0003
+	// month, day: 后缀为 shard key(time.Time) 所在的月份或者日期，如 orders_202001, orders_20200115
+	matchHash  = "hash"
+	matchRange = "range"
+	matchMonth = "month"
+	matchDay   = "day"
+
+	defaultShardWidth = 4
+	// 查询所有分表时的并发数
+	shardFanoutConcurrency = 16
+	monthLayout            = "200601"
+	dayLayout              = "20060102"
+)
+
+func isShardMatch(match string) bool {
+	switch match {
+	case matchHash, matchRange, matchMonth, matchDay:
+		return true
+	default:
+		return false
+	}
+}
+
+// ShardRoute 分表所在的实例和物理表名
+type ShardRoute struct {
+	Instance string
+	Table    string
+}
+
+// shardSpec 逻辑表的分表规则，同一个逻辑表的所有配置项需要相同
+type shardSpec struct {
+	match  string
+	shards int64
+	step   int64
+	width  int
+	// month 和 day 使用的时区，为空时使用 UTC
+	loc *time.Location
+}
+
+func newShardSpec(lcfg *dbLookupCfg) (shardSpec, error) {
+	spec := shardSpec{
+		match:  lcfg.Match,
+		shards: lcfg.Shards,
+		step:   lcfg.Step,
+		width:  lcfg.Width,
+	}
+	if spec.width <= 0 {
+		spec.width = defaultShardWidth
+	}
+	if len(lcfg.Location) > 0 {
+		loc, err := time.LoadLocation(lcfg.Location)
+		if err != nil {
+			return spec, fmt.Errorf("express:%s invalid location:%s err:%v", lcfg.Express, lcfg.Location, err)
+		}
+		spec.loc = loc
+	}
+	switch spec.match {
+	case matchHash:
+		if spec.shards <= 0 {
+			return spec, fmt.Errorf("hash match express:%s shards must be positive", lcfg.Express)
+		}
+	case matchRange:
+		if spec.step <= 0 {
+			return spec, fmt.Errorf("range match express:%s step must be positive", lcfg.Express)
+		}
+	}
+	return spec, nil
+}
+
+func (m shardSpec) location() *time.Location {
+	if m.loc == nil {
+		return time.UTC
+	}
+	return m.loc
+}
+
+func (m shardSpec) equal(o shardSpec) bool {
+	return m.match == o.match && m.shards == o.shards && m.step == o.step && m.width == o.width &&
+		m.location().String() == o.location().String()
+}
+
+// index 计算 shard key 对应的分表下标，month 和 day 的下标为 yyyymm 和 yyyymmdd
+func (m shardSpec) index(key interface{}) (int64, error) {
+	switch m.match {
+	case matchHash:
+		h, err := hashShardKey(key)
+		if err != nil {
+			return 0, err
+		}
+		return int64(h % uint64(m.shards)), nil
+	case matchRange:
+		n, err := intShardKey(key)
+		if err != nil {
+			return 0, err
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("range shard key:%d is negative", n)
+		}
+		return n / m.step, nil
+	case matchMonth, matchDay:
+		t, ok := key.(time.Time)
+		if !ok {
+			return 0, fmt.Errorf("%s shard key should be time.Time, got %T", m.match, key)
+		}
+		return m.timeIndex(t), nil
+	default:
+		return 0, fmt.Errorf("match type:%s is not shard", m.match)
+	}
+}
+
+func (m shardSpec) tableName(express string, index int64) string {
+	if m.match == matchMonth || m.match == matchDay {
+		return fmt.Sprintf("%s_%d", express, index)
+	}
+	return fmt.Sprintf("%s_%0*d", express, m.width, index)
+}
+
+// indexes 返回 [from, to] 范围内的所有分表下标，hash 的范围默认为所有分表，其他匹配方式需要配置 from 和 to
+func (m shardSpec) indexes(from, to *int64) ([]int64, error) {
+	if m.match == matchHash {
+		lo, hi := int64(0), m.shards-1
+		if from != nil && *from > lo {
+			lo = *from
+		}
+		if to != nil && *to < hi {
+			hi = *to
+		}
+		return intRange(lo, hi), nil
+	}
+	if from == nil || to == nil {
+		return nil, fmt.Errorf("%s match needs from and to to list shards", m.match)
+	}
+	if m.match == matchRange {
+		return intRange(*from, *to), nil
+	}
+
+	layout, next := monthLayout, func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	if m.match == matchDay {
+		layout, next = dayLayout, func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	}
+	start, err := time.ParseInLocation(layout, strconv.FormatInt(*from, 10), m.location())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s from:%d", m.match, *from)
+	}
+	end, err := time.ParseInLocation(layout, strconv.FormatInt(*to, 10), m.location())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s to:%d", m.match, *to)
+	}
+	var idxs []int64
+	for t := start; !t.After(end); t = next(t) {
+		idxs = append(idxs, m.timeIndex(t))
+	}
+	return idxs, nil
+}
+
+func intRange(lo, hi int64) []int64 {
+	var idxs []int64
+	for i := lo; i <= hi; i++ {
+		idxs = append(idxs, i)
+	}
+	return idxs
+}
+
+// timeIndex 先转换到配置的时区，同一个时刻不会因为调用方使用的时区不同而落到不同的分表
+func (m shardSpec) timeIndex(t time.Time) int64 {
+	t = t.In(m.location())
+	if m.match == matchMonth {
+		return int64(t.Year()*100 + int(t.Month()))
+	}
+	return int64(t.Year()*10000 + int(t.Month())*100 + t.Day())
+}
+
+// hashShardKey 整数直接取模，负数使用绝对值，字符串使用 crc32
+func hashShardKey(key interface{}) (uint64, error) {
+	switch k := key.(type) {
+	case string:
+		return uint64(crc32.ChecksumIEEE([]byte(k))), nil
+	case []byte:
+		return uint64(crc32.ChecksumIEEE(k)), nil
+	case uint:
+		return uint64(k), nil
+	case uint64:
+		return k, nil
+	default:
+		n, err := intShardKey(key)
+		if err != nil {
+			return 0, err
+		}
+		if n < 0 {
+			// NOTE: -n 在 n 为 MinInt64 时溢出
+			return uint64(-(n + 1)) + 1, nil
+		}
+		return uint64(n), nil
+	}
+}
+
+func intShardKey(key interface{}) (int64, error) {
+	switch k := key.(type) {
+	case int:
+		return int64(k), nil
+	case int8:
+		return int64(k), nil
+	case int16:
+		return int64(k), nil
+	case int32:
+		return int64(k), nil
+	case int64:
+		return k, nil
+	case uint:
+		return uintShardKey(uint64(k))
+	case uint8:
+		return int64(k), nil
+	case uint16:
+		return int64(k), nil
+	case uint32:
+		return int64(k), nil
+	case uint64:
+		return uintShardKey(k)
+	case string:
+		return strconv.ParseInt(k, 10, 64)
+	default:
+		return 0, fmt.Errorf("unsupported shard key type %T", key)
+	}
+}
+
+func uintShardKey(k uint64) (int64, error) {
+	if k > math.MaxInt64 {
+		return 0, fmt.Errorf("shard key:%d overflows int64", k)
+	}
+	return int64(k), nil
+}
+
+// shardEntry 逻辑表的一个分表配置项，覆盖 [from, to] 范围内的分表
+type shardEntry struct {
+	lookup *dbLookupCfg
+}
+
+func (m *shardEntry) covers(index int64) bool {
+	return (m.lookup.From == nil || index >= *m.lookup.From) && (m.lookup.To == nil || index <= *m.lookup.To)
+}
+
+// shardTable 逻辑表的分表规则和所有配置项
+type shardTable struct {
+	spec    shardSpec
+	entries []*shardEntry
+}
+
+func (m *dbCluster) addShard(cluster string, lcfg *dbLookupCfg) error {
+	spec, err := newShardSpec(lcfg)
+	if err != nil {
+		return err
+	}
+	entry := m.clusters[cluster]
+	st, ok := entry.sharded[lcfg.Express]
+	if !ok {
+		st = &shardTable{spec: spec}
+		entry.sharded[lcfg.Express] = st
+	} else if !st.spec.equal(spec) {
+		return fmt.Errorf("inconsistent shard config in cluster:%s express:%s", cluster, lcfg.Express)
+	}
+	st.entries = append(st.entries, &shardEntry{lookup: lcfg})
+	return nil
+}
+
+func (m *dbCluster) getShardTable(cluster, table string) (*shardTable, error) {
+	exp := m.clusters[cluster]
+	if exp == nil {
+		return nil, fmt.Errorf("cluster:%s not found", cluster)
+	}
+	st := exp.sharded[table]
+	if st == nil {
+		return nil, fmt.Errorf("table:%s in cluster:%s is not sharded", table, cluster)
+	}
+	return st, nil
+}
+
+// getShard 返回 shard key 对应的实例和物理表名
+func (m *dbCluster) getShard(cluster, table string, key interface{}) (ShardRoute, error) {
+	st, err := m.getShardTable(cluster, table)
+	if err != nil {
+		return ShardRoute{}, err
+	}
+	index, err := st.spec.index(key)
+	if err != nil {
+		return ShardRoute{}, err
+	}
+	for _, e := range st.entries {
+		if e.covers(index) {
+			return ShardRoute{Instance: e.lookup.Instance, Table: st.spec.tableName(table, index)}, nil
+		}
+	}
+	return ShardRoute{}, fmt.Errorf("no instance for table:%s shard:%d in cluster:%s", table, index, cluster)
+}
+
+// getShards 返回逻辑表的所有分表，按照配置项和分表下标的顺序
+func (m *dbCluster) getShards(cluster, table string) ([]ShardRoute, error) {
+	st, err := m.getShardTable(cluster, table)
+	if err != nil {
+		return nil, err
+	}
+	var routes []ShardRoute
+	for _, e := range st.entries {
+		idxs, err := st.spec.indexes(e.lookup.From, e.lookup.To)
+		if err != nil {
+			return nil, fmt.Errorf("table:%s in cluster:%s err:%v", table, cluster, err)
+		}
+		for _, idx := range idxs {
+			routes = append(routes, ShardRoute{Instance: e.lookup.Instance, Table: st.spec.tableName(table, idx)})
+		}
+	}
+	return routes, nil
+}
+
+// shardLog 返回分表所在的实例，非分表请求返回空
+func shardLog(span opentracing.Span, shard *ShardRoute) string {
+	if shard == nil {
+		return ""
+	}
+	span.LogFields(log.String(spanLogKeyShard, shard.Table))
+	return shard.Instance
+}
+
+// shardTables 分表请求将 tables[0] 替换为物理表名
+func shardTables(shard *ShardRoute, tables []string) []interface{} {
+	var tmptables []interface{}
+	for _, item := range tables {
+		tmptables = append(tmptables, item)
+	}
+	if shard != nil {
+		tmptables[0] = shard.Table
+	}
+	return tmptables
+}
@@ -0,0 +1,173 @@
+package dbrouter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/shawnfeng/sutil/stat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardSpec(t *testing.T) {
+	hash := shardSpec{match: matchHash, shards: 32, width: 4}
+	idx, err := hash.index(int64(81))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(17), idx)
+	assert.Equal(t, "orders_0017", hash.tableName("orders", idx))
+	idx, err = hash.index("user_1")
+	assert.NoError(t, err)
+	same, _ := hash.index([]byte("user_1"))
+	assert.Equal(t, idx, same)
+	_, err = hash.index(1.5)
+	assert.Error(t, err)
+	// 大于 MaxInt64 的 uint64 按照无符号数取模，MinInt64 取绝对值不溢出
+	idx, err = hash.index(uint64(math.MaxUint64))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(math.MaxUint64%32), idx)
+	idx, err = hash.index(int64(math.MinInt64))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), idx)
+	idx, err = hash.index(-81)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(17), idx)
+
+	rng := shardSpec{match: matchRange, step: 1000, width: 2}
+	idx, err = rng.index(uint32(3999))
+	assert.NoError(t, err)
+	assert.Equal(t, "orders_03", rng.tableName("orders", idx))
+	_, err = rng.index(-1)
+	assert.Error(t, err)
+	_, err = rng.index(uint64(math.MaxUint64))
+	assert.Error(t, err)
+
+	ts := time.Date(2020, 1, 5, 10, 0, 0, 0, time.UTC)
+	month := shardSpec{match: matchMonth}
+	idx, err = month.index(ts)
+	assert.NoError(t, err)
+	assert.Equal(t, "orders_202001", month.tableName("orders", idx))
+	day := shardSpec{match: matchDay}
+	idx, err = day.index(ts)
+	assert.NoError(t, err)
+	assert.Equal(t, "orders_20200105", day.tableName("orders", idx))
+	_, err = day.index(20200105)
+	assert.Error(t, err)
+	// 同一个时刻在不同时区表示时落到同一个分表，默认使用 UTC
+	shanghai := time.FixedZone("CST", 8*3600)
+	idx, err = day.index(time.Date(2020, 1, 6, 7, 0, 0, 0, shanghai))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20200105), idx)
+	spec, err := newShardSpec(&dbLookupCfg{Match: matchDay, Express: "orders", Location: "Asia/Shanghai"})
+	assert.NoError(t, err)
+	idx, err = spec.index(time.Date(2020, 1, 5, 23, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20200106), idx)
+	_, err = newShardSpec(&dbLookupCfg{Match: matchDay, Express: "orders", Location: "Nowhere/Invalid"})
+	assert.Error(t, err)
+
+	from, to := int64(201911), int64(202002)
+	idxs, err := month.indexes(&from, &to)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{201911, 201912, 202001, 202002}, idxs)
+	from, to = 20200227, 20200301
+	idxs, err = day.indexes(&from, &to)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{20200227, 20200228, 20200229, 20200301}, idxs)
+	_, err = day.indexes(nil, &to)
+	assert.Error(t, err)
+}
+
+func TestParseShards(t *testing.T) {
+	parser, err := NewParser([]byte(`{
+		"cluster": {"trade": [
+			{"instance": "tradeA", "match": "hash", "express": "orders", "shards": 4, "to": 1},
+			{"instance": "tradeB", "match": "hash", "express": "orders", "shards": 4, "from": 2},
+			{"instance": "tradeA", "match": "month", "express": "logs", "from": 201912, "to": 202001},
+			{"instance": "tradeA", "match": "full", "express": "user"}
+		]},
+		"instances": {
+			"tradeA": {"dbtype": "mysql", "dbname": "trade", "dbcfg": {"addrs": ["a:3306"]}},
+			"tradeB": {"dbtype": "mysql", "dbname": "trade", "dbcfg": {"addrs": ["b:3306"]}}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	route, err := parser.GetShard("trade", "orders", 6)
+	assert.NoError(t, err)
+	assert.Equal(t, ShardRoute{Instance: "tradeB", Table: "orders_0002"}, route)
+	route, err = parser.GetShard("trade", "orders", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, ShardRoute{Instance: "tradeA", Table: "orders_0001"}, route)
+	_, err = parser.GetShard("trade", "user", 5)
+	assert.Error(t, err)
+	_, err = parser.GetShard("trade", "logs", time.Date(2020, 3, 1, 0, 0, 0, 0, time.Local))
+	assert.Error(t, err)
+
+	routes, err := parser.GetShards("trade", "orders")
+	assert.NoError(t, err)
+	assert.Equal(t, []ShardRoute{
+		{Instance: "tradeA", Table: "orders_0000"},
+		{Instance: "tradeA", Table: "orders_0001"},
+		{Instance: "tradeB", Table: "orders_0002"},
+		{Instance: "tradeB", Table: "orders_0003"},
+	}, routes)
+	routes, err = parser.GetShards("trade", "logs")
+	assert.NoError(t, err)
+	assert.Equal(t, []ShardRoute{
+		{Instance: "tradeA", Table: "logs_201912"},
+		{Instance: "tradeA", Table: "logs_202001"},
+	}, routes)
+	assert.Equal(t, "tradeA", parser.GetInstance("trade", "user"))
+
+	_, err = NewParser([]byte(`{"cluster": {"trade": [
+		{"instance": "tradeA", "match": "hash", "express": "orders", "shards": 4},
+		{"instance": "tradeB", "match": "hash", "express": "orders", "shards": 8}
+	]}}`))
+	assert.Error(t, err)
+	_, err = NewParser([]byte(`{"cluster": {"trade": [{"instance": "tradeA", "match": "range", "express": "orders"}]}}`))
+	assert.Error(t, err)
+}
+
+func TestSqlExecAllShards(t *testing.T) {
+	ctx := context.Background()
+	configer, err := NewSimpleConfiger([]byte(`{
+		"cluster": {"trade": [{"instance": "tradeA", "match": "hash", "express": "orders", "shards": 4}]},
+		"instances": {"tradeA": {"dbtype": "mysql", "dbname": "trade", "dbcfg": {"addrs": ["a:3306"]}}}
+	}`))
+	assert.NoError(t, err)
+	changes := make(chan dbConfigChange)
+	close(changes)
+	router := &Router{
+		configer: configer,
+		instances: NewInstanceManager(func(ctx context.Context, key, group string) (Instancer, error) {
+			return newFakeSql(t, key), nil
+		}, changes, nil),
+		report: stat.NewStat(),
+	}
+
+	results, err := router.SqlExecAllShards(ctx, "trade", func(db *DB, tables []interface{}) (interface{}, error) {
+		return tables[0], nil
+	}, "orders")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"orders_0000", "orders_0001", "orders_0002", "orders_0003"}, results)
+
+	// 一个分表出错时返回出错分表的错误
+	errShard := errors.New("shard")
+	_, err = router.SqlExecAllShards(ctx, "trade", func(db *DB, tables []interface{}) (interface{}, error) {
+		if tables[0] == "orders_0002" {
+			return nil, errShard
+		}
+		return tables[0], nil
+	}, "orders")
+	assert.Equal(t, errShard, err)
+
+	// 不支持分表的 Configer 返回错误
+	router.configer = struct{ Configer }{configer}
+	_, err = router.SqlExecAllShards(ctx, "trade", func(db *DB, tables []interface{}) (interface{}, error) {
+		return nil, nil
+	}, "orders")
+	assert.Error(t, err)
+	assert.Error(t, router.SqlExecShard(ctx, "trade", 1, func(db *DB, tables []interface{}) error { return nil }, "orders"))
+}
@@ -7,10 +7,12 @@ package dbrouter
 import (
 	"context"
 	//	"database/sql"
+	"errors"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	"github.com/shawnfeng/sutil/slog/slog"
+	"strings"
 	"time"
 )
 
@@ -23,6 +25,8 @@ type Sql struct {
 	passWord string
 	db       *DB
 	gormdb   *GormDB
+	// 没有配置从库时为nil
+	replicas *replicaSet
 }
 
 func NewSql(dbtype, dbname, addr, userName, passWord string, timeout time.Duration) (*Sql, error) {
@@ -80,13 +84,23 @@ func (m *Sql) GetType() string {
 	return m.dbType
 }
 
+// Close 关闭主库和所有从库，主库关闭失败时也会关闭从库，返回所有的错误
 func (m *Sql) Close() error {
-	err1 := m.db.Close()
-	err2 := m.gormdb.Close()
-
-	if err1 != nil || err2 != nil {
-		return fmt.Errorf("sqlx.Close err: %v, gorm.Close err: %v", err1, err2)
+	var errs []string
+	if err := m.db.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("sqlx.Close err: %v", err))
+	}
+	if err := m.gormdb.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("gorm.Close err: %v", err))
+	}
+	if m.replicas != nil {
+		if err := m.replicas.close(); err != nil {
+			errs = append(errs, fmt.Sprintf("replicas.Close err: %v", err))
+		}
 	}
 
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
 	return nil
 }
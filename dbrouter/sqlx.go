@@ -17,15 +17,24 @@ import (
 
 type DB struct {
 	*sqlx.DB
+	// 通过 Router 获取时记录每条语句的 span 和耗时
+	trace *stmtTrace
 }
 
 func NewDB(sqlxdb *sqlx.DB) *DB {
 	db := &DB{
-		sqlxdb,
+		DB: sqlxdb,
 	}
 	return db
 }
 
+func (db *DB) withTrace(trace *stmtTrace) *DB {
+	return &DB{
+		DB:    db.DB,
+		trace: trace,
+	}
+}
+
 func dialBySqlx(info *Sql) (db *sqlx.DB, err error) {
 	fun := "dialBySqlx -->"
 
@@ -50,35 +59,64 @@ func dialBySqlx(info *Sql) (db *sqlx.DB, err error) {
 
 func (db *DB) NamedExecWrapper(tables []interface{}, query string, arg interface{}) (sql.Result, error) {
 	query = fmt.Sprintf(query, tables...)
-	return db.DB.NamedExec(query, arg)
+	var res sql.Result
+	err := db.trace.do(query, []interface{}{arg}, func() (err error) {
+		res, err = db.DB.NamedExec(query, arg)
+		return
+	})
+	return res, err
 }
 
 func (db *DB) NamedQueryWrapper(tables []interface{}, query string, arg interface{}) (*sqlx.Rows, error) {
 	query = fmt.Sprintf(query, tables...)
-	return db.DB.NamedQuery(query, arg)
+	var rows *sqlx.Rows
+	err := db.trace.do(query, []interface{}{arg}, func() (err error) {
+		rows, err = db.DB.NamedQuery(query, arg)
+		return
+	})
+	return rows, err
 }
 
 func (db *DB) SelectWrapper(tables []interface{}, dest interface{}, query string, args ...interface{}) error {
 	query = fmt.Sprintf(query, tables...)
-	return db.DB.Select(dest, query, args...)
+	return db.trace.do(query, args, func() error {
+		return db.DB.Select(dest, query, args...)
+	})
 }
 
 func (db *DB) ExecWrapper(tables []interface{}, query string, args ...interface{}) (sql.Result, error) {
 	query = fmt.Sprintf(query, tables...)
-	return db.DB.Exec(query, args...)
+	var res sql.Result
+	err := db.trace.do(query, args, func() (err error) {
+		res, err = db.DB.Exec(query, args...)
+		return
+	})
+	return res, err
 }
 
 func (db *DB) QueryRowxWrapper(tables []interface{}, query string, args ...interface{}) *sqlx.Row {
 	query = fmt.Sprintf(query, tables...)
-	return db.DB.QueryRowx(query, args...)
+	var row *sqlx.Row
+	db.trace.do(query, args, func() error {
+		row = db.DB.QueryRowx(query, args...)
+		return row.Err()
+	})
+	return row
 }
 
 func (db *DB) QueryxWrapper(tables []interface{}, query string, args ...interface{}) (*sqlx.Rows, error) {
 	query = fmt.Sprintf(query, tables...)
-	return db.DB.Queryx(query, args...)
+	var rows *sqlx.Rows
+	err := db.trace.do(query, args, func() (err error) {
+		rows, err = db.DB.Queryx(query, args...)
+		return
+	})
+	return rows, err
 }
 
 func (db *DB) GetWrapper(tables []interface{}, dest interface{}, query string, args ...interface{}) error {
 	query = fmt.Sprintf(query, tables...)
-	return db.DB.Get(dest, query, args...)
+	return db.trace.do(query, args, func() error {
+		return db.DB.Get(dest, query, args...)
+	})
 }
@@ -0,0 +1,256 @@
+package dbrouter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/shawnfeng/sutil/sconf/center"
+	"github.com/shawnfeng/sutil/slog/slog"
+)
+
+const (
+	// 慢查询阈值的 key 为 <cluster>.slowquery，没有配置时使用 global.slowquery，如 200ms，为0时不记录慢查询
+	slowQueryKeySuffix       = ".slowquery"
+	defaultSlowQueryDuration = 500 * time.Millisecond
+
+	// span 中的 sql 最大长度，超出部分截断
+	maxStatementLen = 2048
+
+	spanLogKeyOperation = "operation"
+
+	stmtSelect = "select"
+	stmtInsert = "insert"
+	stmtUpdate = "update"
+	stmtDelete = "delete"
+	stmtOther  = "other"
+)
+
+var slowQuery = &slowQueryConfig{}
+
+// slowQueryConfig 缓存每个 cluster 的慢查询阈值，apollo 配置变化时清空
+type slowQueryConfig struct {
+	thresholds sync.Map
+}
+
+func (m *slowQueryConfig) threshold(ctx context.Context, cluster string) time.Duration {
+	if v, ok := m.thresholds.Load(cluster); ok {
+		return v.(time.Duration)
+	}
+	d := loadSlowQueryThreshold(ctx, cluster)
+	m.thresholds.Store(cluster, d)
+	return d
+}
+
+// HandleChangeEvent 慢查询配置变化时清空缓存
+func (m *slowQueryConfig) HandleChangeEvent(event *center.ChangeEvent) {
+	fun := "slowQueryConfig.HandleChangeEvent -->"
+	if event.Namespace != center.DefaultApolloMysqlNamespace {
+		return
+	}
+	for key := range event.Changes {
+		if strings.HasSuffix(key, slowQueryKeySuffix) {
+			slog.Infof(context.TODO(), "%s slow query config changed, key: %s", fun, key)
+			m.thresholds.Range(func(k, _ interface{}) bool {
+				m.thresholds.Delete(k)
+				return true
+			})
+			return
+		}
+	}
+}
+
+func loadSlowQueryThreshold(ctx context.Context, cluster string) time.Duration {
+	fun := "loadSlowQueryThreshold -->"
+	if configCenter == nil {
+		return defaultSlowQueryDuration
+	}
+	for _, scope := range []string{cluster, globalScope} {
+		s, ok := configCenter.GetStringWithNamespace(ctx, center.DefaultApolloMysqlNamespace, concat(scope, slowQueryKeySuffix))
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			slog.Warnf(ctx, "%s invalid slow query threshold: %s, cluster: %s", fun, s, cluster)
+			continue
+		}
+		return d
+	}
+	return defaultSlowQueryDuration
+}
+
+// stmtTrace 记录单条语句的 span、耗时和慢查询，为 nil 时不记录
+type stmtTrace struct {
+	ctx      context.Context
+	cluster  string
+	table    string
+	instance string
+}
+
+func newStmtTrace(ctx context.Context, cluster, table, instance string) *stmtTrace {
+	return &stmtTrace{
+		ctx:      ctx,
+		cluster:  cluster,
+		table:    table,
+		instance: instance,
+	}
+}
+
+func (m *stmtTrace) do(query string, args []interface{}, f func() error) error {
+	if m == nil {
+		return f()
+	}
+	fun := "stmtTrace.do -->"
+
+	stmt := normalizeSQL(query)
+	op := sqlOperation(stmt)
+	span, _ := opentracing.StartSpanFromContext(m.ctx, "dbrouter.statement")
+	defer span.Finish()
+	ext.DBType.Set(span, "sql")
+	ext.DBInstance.Set(span, m.instance)
+	ext.DBStatement.Set(span, stmt)
+	span.SetTag(spanLogKeyCluster, m.cluster)
+	span.SetTag(spanLogKeyTable, m.table)
+	span.SetTag(spanLogKeyOperation, op)
+
+	start := time.Now()
+	err := f()
+	dur := time.Since(start)
+	statStmtDuration(m.cluster, m.table, op, dur)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.Error(err))
+	}
+
+	if threshold := slowQuery.threshold(m.ctx, m.cluster); threshold > 0 && dur >= threshold {
+		slog.Warnf(m.ctx, "%s slow sql cluster:%s table:%s instance:%s dur:%v sql:%s args:%s err:%v",
+			fun, m.cluster, m.table, m.instance, dur, stmt, redactArgs(args), err)
+	}
+	return err
+}
+
+// normalizeSQL 合并空白字符，将单引号、双引号的字符串和数字常量替换为 ?，反引号中的标识符保留，避免在 span 和日志中暴露参数
+func normalizeSQL(query string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			space = true
+			i++
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'' || c == '"':
+			// 单引号和双引号的字符串都是字面量
+			j := i + 1
+			for j < len(query) {
+				if query[j] == '\\' {
+					j += 2
+					continue
+				}
+				if query[j] == c {
+					if j+1 < len(query) && query[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			b.WriteByte('?')
+			i = j + 1
+		case c == '`':
+			// 反引号中的标识符整体保留
+			j := i + 1
+			for j < len(query) {
+				if query[j] == '`' {
+					if j+1 < len(query) && query[j+1] == '`' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j >= len(query) {
+				j = len(query) - 1
+			}
+			b.WriteString(query[i : j+1])
+			i = j + 1
+		case isIdentChar(c) && !isDigit(c):
+			// 标识符整体保留，如 orders_0017
+			j := i
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			b.WriteString(query[i:j])
+			i = j
+		case isDigit(c):
+			j := i
+			for j < len(query) && (isIdentChar(query[j]) || query[j] == '.') {
+				j++
+			}
+			b.WriteByte('?')
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	s := b.String()
+	if len(s) > maxStatementLen {
+		s = s[:maxStatementLen] + "..."
+	}
+	return s
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '$'
+}
+
+// sqlOperation 返回语句的类型，用于统计耗时
+func sqlOperation(stmt string) string {
+	stmt = strings.TrimLeft(stmt, "( ")
+	end := strings.IndexAny(stmt, " (")
+	if end < 0 {
+		end = len(stmt)
+	}
+	switch strings.ToLower(stmt[:end]) {
+	case "select":
+		return stmtSelect
+	case "insert", "replace":
+		return stmtInsert
+	case "update":
+		return stmtUpdate
+	case "delete":
+		return stmtDelete
+	default:
+		return stmtOther
+	}
+}
+
+// redactArgs 只记录参数的类型
+func redactArgs(args []interface{}) string {
+	types := make([]string, 0, len(args))
+	for _, arg := range args {
+		types = append(types, fmt.Sprintf("%T", arg))
+	}
+	return "[" + strings.Join(types, ", ") + "]"
+}
@@ -0,0 +1,59 @@
+package dbrouter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	assert.Equal(t, "SELECT id, name FROM orders_0017 WHERE uid = ? AND name = ? AND price > ?",
+		normalizeSQL("SELECT id, name\n\tFROM orders_0017  WHERE uid = 123 AND name = 'it''s \\'x' AND price > 1.5"))
+	assert.Equal(t, "INSERT INTO `user` (a, b) VALUES (?, ?)", normalizeSQL("  INSERT INTO `user` (a, b) VALUES (?, 0x1F)  "))
+	assert.Equal(t, "UPDATE t SET a = :a WHERE id IN (?, ?)", normalizeSQL("UPDATE t SET a = :a WHERE id IN (1, 2)"))
+	assert.Equal(t, "SELECT * FROM t WHERE name = ? AND note = ?", normalizeSQL(`SELECT * FROM t WHERE name = "it""s \"x" AND note = "a 'b' 1"`))
+	assert.Equal(t, "SELECT `order 1`, `a``b` FROM `t_0001` WHERE `id 2` = ?", normalizeSQL("SELECT `order 1`, `a``b` FROM `t_0001` WHERE `id 2` = 5"))
+	assert.Equal(t, "SELECT `unterminated", normalizeSQL("SELECT `unterminated"))
+}
+
+func TestSqlOperation(t *testing.T) {
+	assert.Equal(t, stmtSelect, sqlOperation("select * from t"))
+	assert.Equal(t, stmtSelect, sqlOperation("(SELECT a FROM t) UNION (SELECT a FROM t2)"))
+	assert.Equal(t, stmtInsert, sqlOperation("REPLACE INTO t VALUES (?)"))
+	assert.Equal(t, stmtUpdate, sqlOperation("UPDATE t SET a = ?"))
+	assert.Equal(t, stmtDelete, sqlOperation("DELETE FROM t"))
+	assert.Equal(t, stmtOther, sqlOperation("SHOW TABLES"))
+	assert.Equal(t, stmtOther, sqlOperation(""))
+}
+
+func TestStmtTrace(t *testing.T) {
+	var trace *stmtTrace
+	errQuery := errors.New("query")
+	assert.Equal(t, errQuery, trace.do("SELECT 1", nil, func() error { return errQuery }))
+
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	trace = newStmtTrace(context.Background(), "trade", "orders", "tradeA")
+	err := trace.do("DELETE FROM orders_0001 WHERE id = 5", []interface{}{int64(5)}, func() error { return errQuery })
+	assert.Equal(t, errQuery, err)
+
+	var spans []*mocktracer.MockSpan
+	for _, span := range tracer.FinishedSpans() {
+		if span.OperationName == "dbrouter.statement" {
+			spans = append(spans, span)
+		}
+	}
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "DELETE FROM orders_0001 WHERE id = ?", spans[0].Tag("db.statement"))
+	assert.Equal(t, "tradeA", spans[0].Tag("db.instance"))
+	assert.Equal(t, stmtDelete, spans[0].Tag(spanLogKeyOperation))
+	assert.Equal(t, true, spans[0].Tag("error"))
+
+	assert.Equal(t, "[int64, string]", redactArgs([]interface{}{int64(1), "secret"}))
+}
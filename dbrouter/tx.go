@@ -0,0 +1,347 @@
+package dbrouter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/shawnfeng/sutil/slog/slog"
+	"github.com/shawnfeng/sutil/stime"
+)
+
+const (
+	txKindSql = "sqlx"
+	txKindOrm = "gorm"
+
+	// 死锁或者序列化失败时事务的最大重试次数
+	maxTxRetries    = 3
+	txRetryBackoff  = 20 * time.Millisecond
+	maxTxBackoff    = 500 * time.Millisecond
+	txStatSuffix    = ".tx"
+	spanLogKeyDepth = "depth"
+)
+
+// errTxPanic fn panic 时用于统计的错误
+var errTxPanic = errors.New("dbrouter: tx panic")
+
+// Tx sqlx 事务，Wrapper 方法与 DB 相同
+type Tx struct {
+	*sqlx.Tx
+	trace *stmtTrace
+}
+
+func (tx *Tx) NamedExecWrapper(tables []interface{}, query string, arg interface{}) (sql.Result, error) {
+	query = fmt.Sprintf(query, tables...)
+	var res sql.Result
+	err := tx.trace.do(query, []interface{}{arg}, func() (err error) {
+		res, err = tx.Tx.NamedExec(query, arg)
+		return
+	})
+	return res, err
+}
+
+func (tx *Tx) NamedQueryWrapper(tables []interface{}, query string, arg interface{}) (*sqlx.Rows, error) {
+	query = fmt.Sprintf(query, tables...)
+	var rows *sqlx.Rows
+	err := tx.trace.do(query, []interface{}{arg}, func() (err error) {
+		rows, err = tx.Tx.NamedQuery(query, arg)
+		return
+	})
+	return rows, err
+}
+
+func (tx *Tx) SelectWrapper(tables []interface{}, dest interface{}, query string, args ...interface{}) error {
+	query = fmt.Sprintf(query, tables...)
+	return tx.trace.do(query, args, func() error {
+		return tx.Tx.Select(dest, query, args...)
+	})
+}
+
+func (tx *Tx) ExecWrapper(tables []interface{}, query string, args ...interface{}) (sql.Result, error) {
+	query = fmt.Sprintf(query, tables...)
+	var res sql.Result
+	err := tx.trace.do(query, args, func() (err error) {
+		res, err = tx.Tx.Exec(query, args...)
+		return
+	})
+	return res, err
+}
+
+func (tx *Tx) QueryRowxWrapper(tables []interface{}, query string, args ...interface{}) *sqlx.Row {
+	query = fmt.Sprintf(query, tables...)
+	var row *sqlx.Row
+	tx.trace.do(query, args, func() error {
+		row = tx.Tx.QueryRowx(query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+func (tx *Tx) QueryxWrapper(tables []interface{}, query string, args ...interface{}) (*sqlx.Rows, error) {
+	query = fmt.Sprintf(query, tables...)
+	var rows *sqlx.Rows
+	err := tx.trace.do(query, args, func() (err error) {
+		rows, err = tx.Tx.Queryx(query, args...)
+		return
+	})
+	return rows, err
+}
+
+func (tx *Tx) GetWrapper(tables []interface{}, dest interface{}, query string, args ...interface{}) error {
+	query = fmt.Sprintf(query, tables...)
+	return tx.trace.do(query, args, func() error {
+		return tx.Tx.Get(dest, query, args...)
+	})
+}
+
+// txConn 屏蔽 sqlx 和 gorm 事务的差异
+type txConn interface {
+	exec(ctx context.Context, query string) error
+	commit() error
+	rollback() error
+}
+
+type sqlTxConn struct {
+	tx *Tx
+}
+
+func (m *sqlTxConn) exec(ctx context.Context, query string) error {
+	_, err := m.tx.ExecContext(ctx, query)
+	return err
+}
+
+func (m *sqlTxConn) commit() error {
+	return m.tx.Commit()
+}
+
+func (m *sqlTxConn) rollback() error {
+	return m.tx.Rollback()
+}
+
+type ormTxConn struct {
+	tx *GormDB
+}
+
+func (m *ormTxConn) exec(ctx context.Context, query string) error {
+	return m.tx.Exec(query).Error
+}
+
+func (m *ormTxConn) commit() error {
+	return m.tx.Commit().Error
+}
+
+func (m *ormTxConn) rollback() error {
+	return m.tx.Rollback().Error
+}
+
+type txCtxKey struct{}
+
+// txState ctx 中正在执行的事务，同一个实例上嵌套的事务使用 savepoint
+type txState struct {
+	instance string
+	kind     string
+	conn     txConn
+	depth    int
+}
+
+// SqlTx 在 cluster 和 table 所在的实例上执行事务，fn 返回错误或者 panic 时回滚，否则提交。
+// fn 中使用传入的 ctx 再次调用 SqlTx 时，同一个实例上的事务嵌套为 savepoint，内层出错只回滚到 savepoint，
+// 不同实例上的事务无法保证原子性，直接返回错误，需要跨实例时在事务外分别执行。
+// 遇到死锁或者序列化失败时，最外层的事务会退避后重试，fn 需要保证可以重复执行
+func (m *Router) SqlTx(ctx context.Context, cluster, table string, fn func(ctx context.Context, tx *Tx, tables []interface{}) error) error {
+	begin := func(ctx context.Context, dbsql *Sql, instance string) (txConn, error) {
+		tx, err := dbsql.getDB().BeginTxx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlTxConn{tx: &Tx{Tx: tx, trace: newStmtTrace(ctx, cluster, table, instance)}}, nil
+	}
+	run := func(ctx context.Context, conn txConn) error {
+		return fn(ctx, conn.(*sqlTxConn).tx, []interface{}{table})
+	}
+	return m.runTx(ctx, "dbrouter.SqlTx", txKindSql, cluster, table, begin, run)
+}
+
+// OrmTx 与 SqlTx 相同，使用 gorm 执行事务。sqlx 和 gorm 使用不同的连接池，同一个实例上不能互相嵌套
+func (m *Router) OrmTx(ctx context.Context, cluster, table string, fn func(ctx context.Context, tx *GormDB, tables []interface{}) error) error {
+	begin := func(ctx context.Context, dbsql *Sql, instance string) (txConn, error) {
+		tx := dbsql.getGormDB().BeginTx(ctx, nil)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		return &ormTxConn{tx: NewGormDB(tx)}, nil
+	}
+	run := func(ctx context.Context, conn txConn) error {
+		return fn(ctx, conn.(*ormTxConn).tx, []interface{}{table})
+	}
+	return m.runTx(ctx, "dbrouter.OrmTx", txKindOrm, cluster, table, begin, run)
+}
+
+func (m *Router) runTx(ctx context.Context, operation, kind, cluster, table string,
+	begin func(context.Context, *Sql, string) (txConn, error), run func(context.Context, txConn) error) (err error) {
+	fun := "Router.runTx -->"
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, operation)
+	defer span.Finish()
+	span.LogFields(
+		log.String(spanLogKeyCluster, cluster),
+		log.String(spanLogKeyTable, table))
+
+	instance := m.configer.GetInstance(ctx, cluster, table)
+	if parent, ok := ctx.Value(txCtxKey{}).(*txState); ok {
+		if parent.instance != instance {
+			return fmt.Errorf("can not nest tx on instance:%s in tx on instance:%s", instance, parent.instance)
+		}
+		if parent.kind != kind {
+			return fmt.Errorf("can not nest %s tx in %s tx on instance:%s", kind, parent.kind, instance)
+		}
+		span.LogFields(log.Int(spanLogKeyDepth, parent.depth+1))
+		return parent.savepoint(ctx, run)
+	}
+
+	token, ok := Entry(cluster, table)
+	if !ok {
+		slog.Errorf(ctx, "%s trigger tidb breaker, because too many timeout sqls, cluster: %s, table: %s", fun, cluster, table)
+		return errors.New("sql cause breaker, because too many timeout")
+	}
+
+	st := stime.NewTimeStat()
+	attempt := 0
+	// NOTE: fn panic 时也要记录统计和熔断结果，释放半开状态的试探名额，panic 继续向上传递
+	panicked := true
+	defer func() {
+		if panicked {
+			err = errTxPanic
+		}
+		dur := st.Duration()
+		// 事务的耗时单独统计，避免与单条语句混在一起
+		m.report.IncQuery(cluster, table+txStatSuffix, dur)
+		span.LogFields(log.Int("attempts", attempt+1))
+		if err != nil {
+			span.LogFields(log.Error(err))
+		}
+		slog.Tracef(ctx, "%s cls:%s table:%s attempts:%d dur:%d", fun, cluster, table, attempt+1, dur)
+		statReqErr(cluster, table, err)
+		statBreaker(cluster, table, token, err, dur)
+	}()
+	for ; ; attempt++ {
+		err = m.txOnce(ctx, kind, cluster, table, instance, begin, run)
+		if attempt >= maxTxRetries || !isTxRetryable(err) {
+			break
+		}
+		backoff := txBackoff(attempt)
+		slog.Warnf(ctx, "%s cluster:%s table:%s attempt:%d retry after:%v err:%v", fun, cluster, table, attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(backoff):
+			continue
+		}
+		break
+	}
+	panicked = false
+	return err
+}
+
+func (m *Router) txOnce(ctx context.Context, kind, cluster, table, instance string,
+	begin func(context.Context, *Sql, string) (txConn, error), run func(context.Context, txConn) error) (err error) {
+	fun := "Router.txOnce -->"
+
+	in := m.instances.Get(ctx, generateKey(instance))
+	if in == nil {
+		return fmt.Errorf("db instance not find: cluster:%s table:%s instance:%s", cluster, table, instance)
+	}
+	dbsql, ok := in.(*Sql)
+	if !ok {
+		return fmt.Errorf("db instance type error: cluster:%s table:%s instance:%s, dbtype:%s", cluster, table, instance, in.GetType())
+	}
+
+	markWrite(ctx, instance)
+	conn, err := begin(ctx, dbsql, instance)
+	if err != nil {
+		return err
+	}
+
+	// 事务中的读请求都使用主库
+	ctx = context.WithValue(WithMaster(ctx), txCtxKey{}, &txState{instance: instance, kind: kind, conn: conn})
+	defer func() {
+		if p := recover(); p != nil {
+			if rerr := conn.rollback(); rerr != nil {
+				slog.Errorf(ctx, "%s rollback on panic cluster:%s table:%s err:%v", fun, cluster, table, rerr)
+			}
+			panic(p)
+		}
+	}()
+
+	if err = run(ctx, conn); err != nil {
+		if rerr := conn.rollback(); rerr != nil {
+			slog.Errorf(ctx, "%s rollback cluster:%s table:%s err:%v", fun, cluster, table, rerr)
+		}
+		return err
+	}
+	return conn.commit()
+}
+
+// savepoint 在当前事务中执行嵌套的事务，出错或者 panic 时回滚到 savepoint
+func (m *txState) savepoint(ctx context.Context, run func(context.Context, txConn) error) (err error) {
+	fun := "txState.savepoint -->"
+
+	m.depth++
+	defer func() { m.depth-- }()
+	name := fmt.Sprintf("sp_%d", m.depth)
+
+	if err = m.conn.exec(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			if rerr := m.conn.exec(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+				slog.Errorf(ctx, "%s rollback to %s on panic err:%v", fun, name, rerr)
+			}
+			panic(p)
+		}
+	}()
+
+	if err = run(ctx, m.conn); err != nil {
+		if rerr := m.conn.exec(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+			slog.Errorf(ctx, "%s rollback to %s err:%v", fun, name, rerr)
+		}
+		return err
+	}
+	return m.conn.exec(ctx, "RELEASE SAVEPOINT "+name)
+}
+
+// isTxRetryable 死锁和序列化失败时整个事务已经回滚，可以重试
+func isTxRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// 1213: ER_LOCK_DEADLOCK
+		return mysqlErr.Number == 1213
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 40001: serialization_failure, 40P01: deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return false
+}
+
+// txBackoff 指数退避，加上随机抖动避免冲突的事务同时重试
+func txBackoff(attempt int) time.Duration {
+	backoff := txRetryBackoff << uint(attempt)
+	if backoff > maxTxBackoff {
+		backoff = maxTxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
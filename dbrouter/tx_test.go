@@ -0,0 +1,203 @@
+package dbrouter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/shawnfeng/sutil/stat"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTxConn struct {
+	execs     []string
+	commits   int
+	rollbacks int
+}
+
+func (m *fakeTxConn) exec(ctx context.Context, query string) error {
+	m.execs = append(m.execs, query)
+	return nil
+}
+
+func (m *fakeTxConn) commit() error {
+	m.commits++
+	return nil
+}
+
+func (m *fakeTxConn) rollback() error {
+	m.rollbacks++
+	return nil
+}
+
+func TestTxSavepoint(t *testing.T) {
+	ctx := context.Background()
+	conn := &fakeTxConn{}
+	state := &txState{instance: "ins", kind: txKindSql, conn: conn}
+
+	errBiz := errors.New("biz")
+	err := state.savepoint(ctx, func(ctx context.Context, c txConn) error {
+		return state.savepoint(ctx, func(ctx context.Context, c txConn) error {
+			return errBiz
+		})
+	})
+	assert.Equal(t, errBiz, err)
+	assert.Equal(t, []string{"SAVEPOINT sp_1", "SAVEPOINT sp_2", "ROLLBACK TO SAVEPOINT sp_2", "ROLLBACK TO SAVEPOINT sp_1"}, conn.execs)
+
+	conn.execs = nil
+	assert.Panics(t, func() {
+		state.savepoint(ctx, func(ctx context.Context, c txConn) error {
+			panic("boom")
+		})
+	})
+	assert.NoError(t, state.savepoint(ctx, func(ctx context.Context, c txConn) error {
+		return nil
+	}))
+	assert.Equal(t, []string{"SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1", "SAVEPOINT sp_1", "RELEASE SAVEPOINT sp_1"}, conn.execs)
+	assert.Equal(t, 0, state.depth)
+}
+
+func TestTxNestOtherInstance(t *testing.T) {
+	configer, err := NewSimpleConfiger([]byte(`{
+		"cluster": {"trade": [
+			{"instance": "tradeA", "match": "full", "express": "orders"},
+			{"instance": "tradeB", "match": "full", "express": "user"}
+		]}
+	}`))
+	assert.NoError(t, err)
+	router := &Router{configer: configer}
+
+	// 其他实例上的事务中不能开启事务，也不会退化为独立的事务
+	ctx := context.WithValue(context.Background(), txCtxKey{}, &txState{instance: "tradeA", kind: txKindSql, conn: &fakeTxConn{}})
+	called := false
+	err = router.SqlTx(ctx, "trade", "user", func(ctx context.Context, tx *Tx, tables []interface{}) error {
+		called = true
+		return nil
+	})
+	assert.Error(t, err)
+	assert.False(t, called)
+}
+
+// newTxTestRouter 返回的 Router 中 trade.orders 在实例 tradeA 上，实例使用 fakeDriver
+func newTxTestRouter(t *testing.T) *Router {
+	configer, err := NewSimpleConfiger([]byte(`{
+		"cluster": {"trade": [{"instance": "tradeA", "match": "full", "express": "orders"}]},
+		"instances": {"tradeA": {"dbtype": "mysql", "dbname": "trade", "dbcfg": {"addrs": ["a:3306"]}}}
+	}`))
+	assert.NoError(t, err)
+	changes := make(chan dbConfigChange)
+	close(changes)
+	return &Router{
+		configer: configer,
+		instances: NewInstanceManager(func(ctx context.Context, key, group string) (Instancer, error) {
+			return newFakeSql(t, key), nil
+		}, changes, nil),
+		report: stat.NewStat(),
+	}
+}
+
+func TestRunTx(t *testing.T) {
+	ctx := context.Background()
+	router := newTxTestRouter(t)
+	var conns []*fakeTxConn
+	begin := func(ctx context.Context, dbsql *Sql, instance string) (txConn, error) {
+		conn := &fakeTxConn{}
+		conns = append(conns, conn)
+		return conn, nil
+	}
+	runTx := func(run func(context.Context, txConn) error) error {
+		conns = nil
+		return router.runTx(ctx, "dbrouter.SqlTx", txKindSql, "trade", "orders", begin, run)
+	}
+
+	// 成功时提交
+	err := runTx(func(ctx context.Context, conn txConn) error {
+		state, ok := ctx.Value(txCtxKey{}).(*txState)
+		assert.True(t, ok)
+		assert.Equal(t, "tradeA", state.instance)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(conns))
+	assert.Equal(t, 1, conns[0].commits)
+	assert.Equal(t, 0, conns[0].rollbacks)
+
+	// 出错时回滚，业务错误不重试
+	errBiz := errors.New("biz")
+	err = runTx(func(ctx context.Context, conn txConn) error {
+		return errBiz
+	})
+	assert.Equal(t, errBiz, err)
+	assert.Equal(t, 1, len(conns))
+	assert.Equal(t, 0, conns[0].commits)
+	assert.Equal(t, 1, conns[0].rollbacks)
+
+	// panic 时回滚后继续 panic
+	assert.PanicsWithValue(t, "boom", func() {
+		runTx(func(ctx context.Context, conn txConn) error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 1, len(conns))
+	assert.Equal(t, 0, conns[0].commits)
+	assert.Equal(t, 1, conns[0].rollbacks)
+
+	// 死锁和序列化失败时回滚后重试
+	for _, retryErr := range []error{&mysql.MySQLError{Number: 1213}, &pq.Error{Code: "40001"}} {
+		attempts := 0
+		err = runTx(func(ctx context.Context, conn txConn) error {
+			if attempts++; attempts == 1 {
+				return retryErr
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, 2, len(conns))
+		assert.Equal(t, 1, conns[0].rollbacks)
+		assert.Equal(t, 1, conns[1].commits)
+	}
+}
+
+// fn panic 时也要记录熔断结果，否则半开状态的试探名额一直被占用
+func TestRunTxPanicRecordsBreaker(t *testing.T) {
+	router := newTxTestRouter(t)
+	b := bm.get("trade", "orders")
+	b.mu.Lock()
+	b.config.HalfOpenRequests = 1
+	b.setState(BreakerOpen, b.now().Add(-b.config.OpenDuration))
+	b.mu.Unlock()
+
+	begin := func(ctx context.Context, dbsql *Sql, instance string) (txConn, error) {
+		return &fakeTxConn{}, nil
+	}
+	assert.Panics(t, func() {
+		router.runTx(context.Background(), "dbrouter.SqlTx", txKindSql, "trade", "orders", begin, func(ctx context.Context, conn txConn) error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, BreakerClosed, b.State())
+}
+
+func TestIsTxRetryable(t *testing.T) {
+	assert.False(t, isTxRetryable(nil))
+	assert.False(t, isTxRetryable(errors.New("biz")))
+	assert.False(t, isTxRetryable(&mysql.MySQLError{Number: 1062}))
+	assert.True(t, isTxRetryable(fmt.Errorf("exec: %w", &mysql.MySQLError{Number: 1213})))
+	assert.True(t, isTxRetryable(&pq.Error{Code: "40001"}))
+	assert.True(t, isTxRetryable(&pq.Error{Code: "40P01"}))
+}
+
+func TestTxBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		backoff := txBackoff(attempt)
+		assert.True(t, backoff >= txRetryBackoff/2)
+		assert.True(t, backoff <= maxTxBackoff)
+	}
+	assert.True(t, txBackoff(8) >= maxTxBackoff/2)
+	assert.True(t, txBackoff(0) <= 20*time.Millisecond)
+}